@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/myrashidi/rate-limiter-challenge/internal/limiter"
 )
@@ -27,21 +31,41 @@ func main() {
 	db := getenvInt("REDIS_DB", 0)
 	limiter.InitRedis(addr, pass, db)
 
-	http.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
-		user := r.URL.Query().Get("user")
-		if user == "" {
-			http.Error(w, "missing user parameter", http.StatusBadRequest)
-			return
-		}
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Request allowed for user %s\n", r.URL.Query().Get("user"))
+	})
 
-		// Default limit if user not configured
-		defaultLimit := 5
-		if !limiter.RateLimit(user, defaultLimit) {
-			http.Error(w, fmt.Sprintf("Rate limit exceeded for user %s", user), http.StatusTooManyRequests)
+	// Default limit if user not configured
+	defaultLimit := getenvInt("RATE_LIMIT_DEFAULT", 5)
+	limiter.SetDefaultLimit(defaultLimit)
+	log.Printf("Default limit: %d", defaultLimit)
+	// Anonymous requests are keyed per client IP, so one client cannot use up
+	// the quota of all of them. Behind a proxy or load balancer, list its
+	// addresses in TRUSTED_PROXIES (comma-separated CIDRs) so X-Forwarded-For
+	// is honored; otherwise every client would share the proxy's IP.
+	trustedProxies := parseCIDRs(getenv("TRUSTED_PROXIES", ""))
+	// RATE_LIMIT_ANONYMOUS caps all anonymous clients combined, per second
+	limiter.SetAnonymousLimit(getenvInt("RATE_LIMIT_ANONYMOUS", 0), time.Second)
+	// Throttled requests get a JSON 429 with Retry-After
+	http.Handle("/api", limiter.Middleware(limiter.MiddlewareOptions{
+		KeyFunc: func(r *http.Request) string {
+			if user := r.URL.Query().Get("user"); user != "" {
+				return user
+			}
+			return limiter.ClientIPKey(r, trustedProxies)
+		},
+		Limit: defaultLimit,
+	})(api))
+
+	// Readiness probe: 503 while the Redis backend is unreachable
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+		defer cancel()
+		if err := limiter.Ping(ctx); err != nil {
+			http.Error(w, "redis unavailable: "+err.Error(), http.StatusServiceUnavailable)
 			return
 		}
-
-		fmt.Fprintf(w, "Request allowed for user %s\n", user)
+		fmt.Fprintln(w, "ok")
 	})
 
 	log.Println("Rate limiter demo server running on :8080")
@@ -63,3 +87,18 @@ func getenvInt(key string, def int) int {
 	}
 	return def
 }
+
+func parseCIDRs(list string) []net.IPNet {
+	var nets []net.IPNet
+	for _, c := range strings.Split(list, ",") {
+		if c = strings.TrimSpace(c); c == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			log.Fatalf("TRUSTED_PROXIES: %v", err)
+		}
+		nets = append(nets, *n)
+	}
+	return nets
+}
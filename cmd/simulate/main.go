@@ -0,0 +1,143 @@
+// Command simulate drives synthetic traffic through the limiter and reports
+// how much of it was allowed, for capacity planning:
+//
+//	go run ./cmd/simulate -users 5 -qps 200 -limit 10 -duration 5s -mode sliding
+//
+// Requests are spread round-robin over the users at a steady total rate. With
+// -redis the Redis backend is used, otherwise the in-memory one. The command
+// exits with status 1 if the allowed throughput is far from what the limit
+// predicts.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/myrashidi/rate-limiter-challenge/internal/limiter"
+)
+
+// maxInFlight bounds concurrent RateLimit calls, so a slow backend lowers the
+// offered rate instead of piling up goroutines.
+const maxInFlight = 64
+
+type counts struct {
+	allowed atomic.Int64
+	denied  atomic.Int64
+}
+
+func main() {
+	users := flag.Int("users", 5, "number of simulated users")
+	qps := flag.Float64("qps", 100, "total requests per second across all users")
+	limit := flag.Int("limit", 10, "per-user limit (requests per second, or per day in daily mode)")
+	duration := flag.Duration("duration", 5*time.Second, "how long to send traffic")
+	mode := flag.String("mode", "sliding", "algorithm: sliding, leaky or daily")
+	redisAddr := flag.String("redis", "", "Redis address; in-memory if empty")
+	tolerance := flag.Float64("tolerance", 0.5, "allowed relative divergence from the expected throughput")
+	flag.Parse()
+
+	if *users <= 0 || *qps <= 0 || *limit <= 0 || *duration <= 0 {
+		log.Fatal("-users, -qps, -limit and -duration must be positive")
+	}
+	switch *mode {
+	case "sliding", "leaky", "daily":
+	default:
+		log.Fatalf("unknown mode %q", *mode)
+	}
+	limiter.SetMode(*mode)
+
+	if *redisAddr != "" {
+		limiter.InitRedis(*redisAddr, "", 0)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := limiter.Ping(ctx); err != nil {
+			log.Fatalf("redis unavailable: %v", err)
+		}
+	}
+
+	// a per-run prefix keeps Redis state from earlier runs out of the way
+	run := time.Now().UnixNano()
+	ids := make([]string, *users)
+	stats := make([]counts, *users)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("sim-%d-user-%d", run, i)
+	}
+
+	elapsed := drive(ids, stats, *qps, *limit, *duration)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "user\tsent\tallowed\tdenied\tallowed/s\t")
+	var sent, allowed int64
+	for i := range ids {
+		a, d := stats[i].allowed.Load(), stats[i].denied.Load()
+		sent += a + d
+		allowed += a
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%.1f\t\n", i, a+d, a, d, float64(a)/elapsed.Seconds())
+	}
+	fmt.Fprintf(w, "total\t%d\t%d\t%d\t%.1f\t\n", sent, allowed, sent-allowed, float64(allowed)/elapsed.Seconds())
+	w.Flush()
+
+	want := expectedAllowed(*mode, *users, *limit, sent, elapsed)
+	fmt.Printf("\nmode %s, %d users, limit %d, offered %.1f req/s over %v\n",
+		*mode, *users, *limit, float64(sent)/elapsed.Seconds(), elapsed.Round(time.Millisecond))
+	fmt.Printf("expected ~%.0f allowed, observed %d\n", want, allowed)
+
+	if want > 0 && math.Abs(float64(allowed)-want)/want > *tolerance {
+		fmt.Fprintf(os.Stderr, "allowed throughput diverges from the limit by more than %.0f%%\n", *tolerance*100)
+		os.Exit(1)
+	}
+}
+
+// drive sends requests round-robin over ids at qps for duration and returns
+// the time actually spent, including draining in-flight calls.
+func drive(ids []string, stats []counts, qps float64, limit int, duration time.Duration) time.Duration {
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, maxInFlight)
+	start := time.Now()
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+
+	var sent int64
+	for now := start; now.Sub(start) < duration; now = <-ticker.C {
+		// catch up to the schedule, so the rate holds whatever the tick rate
+		due := int64(now.Sub(start).Seconds() * qps)
+		for ; sent < due; sent++ {
+			i := int(sent % int64(len(ids)))
+			slots <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-slots }()
+				if limiter.RateLimit(ids[i], limit) {
+					stats[i].allowed.Add(1)
+				} else {
+					stats[i].denied.Add(1)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	return time.Since(start)
+}
+
+// expectedAllowed estimates how many of sent requests the limiter should allow:
+// each user gets the lesser of what they offered and what the limit permits,
+// plus, for leaky buckets, the initial burst of a full bucket.
+func expectedAllowed(mode string, users, limit int, sent int64, elapsed time.Duration) float64 {
+	perUser := float64(sent) / float64(users)
+	if mode == "daily" {
+		return float64(users) * math.Min(perUser, float64(limit))
+	}
+	permitted := float64(limit) * elapsed.Seconds()
+	if mode == "leaky" {
+		permitted += float64(limit)
+	}
+	return float64(users) * math.Min(perUser, permitted)
+}
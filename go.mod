@@ -2,7 +2,10 @@ module github.com/myrashidi/rate-limiter-challenge
 
 go 1.25.2
 
-require github.com/redis/go-redis/v9 v9.16.0
+require (
+	github.com/redis/go-redis/v9 v9.16.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
@@ -0,0 +1,80 @@
+package limiter
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// limitUpdate is the body accepted by PUT /limits/{user}.
+type limitUpdate struct {
+	Limit *int   `json:"limit"`
+	Mode  string `json:"mode"`
+}
+
+// AdminHandler returns an HTTP handler for inspecting and changing limits at runtime:
+//
+//	GET /limits/{user}  returns the user's configured limit, mode and current usage
+//	PUT /limits/{user}  body {"limit":N,"mode":"<mode>"} applies via SetUserLimit/SetUserMode
+//
+// The mode is any accepted by SetMode.
+//
+// Every request must pass auth; a nil auth rejects all requests.
+func AdminHandler(auth func(r *http.Request) bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /limits/{user}", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, Stats(r.PathValue("user"), 0))
+	})
+	mux.HandleFunc("PUT /limits/{user}", func(w http.ResponseWriter, r *http.Request) {
+		user := transformKey(r.PathValue("user"))
+		var body limitUpdate
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if body.Limit == nil && body.Mode == "" {
+			http.Error(w, "body must set limit and/or mode", http.StatusBadRequest)
+			return
+		}
+		if body.Limit != nil && *body.Limit < 0 {
+			http.Error(w, "limit must not be negative", http.StatusBadRequest)
+			return
+		}
+		if body.Mode != "" && !validMode(body.Mode) {
+			http.Error(w, "mode must be "+quotedModes(), http.StatusBadRequest)
+			return
+		}
+		if body.Limit != nil {
+			SetUserLimit(user, *body.Limit)
+		}
+		if body.Mode != "" {
+			SetUserMode(user, body.Mode)
+		}
+		writeJSON(w, http.StatusOK, stats(user, 0))
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth == nil || !auth(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// quotedModes lists the supported modes for an error message:
+// `"sliding", "leaky", ... or "decay"`.
+func quotedModes() string {
+	q := make([]string, len(modes))
+	for i, m := range modes {
+		q[i] = strconv.Quote(m)
+	}
+	return strings.Join(q[:len(q)-1], ", ") + " or " + q[len(q)-1]
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,125 @@
+package limiter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testAdminAuth(r *http.Request) bool {
+	return r.Header.Get("Authorization") == "Bearer secret"
+}
+
+func adminRequest(t *testing.T, h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminHandler_GetLimit(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	SetUserLimit("alice", 5)
+	RateLimit("alice", 5)
+	RateLimit("alice", 5)
+
+	rec := adminRequest(t, AdminHandler(testAdminAuth), http.MethodGet, "/limits/alice", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var got UserStats
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := UserStats{User: "alice", Limit: 5, Mode: "sliding", WindowMs: 1000, Used: 2, Remaining: 3}
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestAdminHandler_PutLimit(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	h := AdminHandler(testAdminAuth)
+	rec := adminRequest(t, h, http.MethodPut, "/limits/bob", `{"limit":2,"mode":"leaky"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got, _ := GetUserLimit("bob"); got != 2 {
+		t.Fatalf("expected limit 2, got %d", got)
+	}
+	if got := GetUserMode("bob"); got != "leaky" {
+		t.Fatalf("expected mode leaky, got %q", got)
+	}
+	assertAllowance(t, "bob", 2)
+}
+
+func TestAdminHandler_PutTransformsUser(t *testing.T) {
+	resetLimiterState()
+	SetKeyTransformer(strings.ToLower)
+	defer SetKeyTransformer(nil)
+
+	h := AdminHandler(testAdminAuth)
+	rec := adminRequest(t, h, http.MethodPut, "/limits/Bob", `{"limit":2}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got, _ := GetUserLimit("bob"); got != 2 {
+		t.Fatalf("the limit must be set under the transformed key, got %d", got)
+	}
+	var st UserStats
+	if err := json.NewDecoder(rec.Body).Decode(&st); err != nil || st.User != "bob" || st.Limit != 2 {
+		t.Fatalf("expected bob's stats, got %+v (%v)", st, err)
+	}
+}
+
+func TestAdminHandler_PutInvalidBody(t *testing.T) {
+	resetLimiterState()
+
+	h := AdminHandler(testAdminAuth)
+	for _, body := range []string{`{not json`, `{}`, `{"limit":-1}`, `{"mode":"fixed"}`} {
+		rec := adminRequest(t, h, http.MethodPut, "/limits/bob", body)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("body %s: expected 400, got %d", body, rec.Code)
+		}
+	}
+	if _, ok := GetUserLimit("bob"); ok {
+		t.Fatal("invalid requests must not change config")
+	}
+}
+
+func TestAdminHandler_PutEveryMode(t *testing.T) {
+	resetLimiterState()
+
+	h := AdminHandler(testAdminAuth)
+	for _, mode := range []string{"sliding", "leaky", "daily", "sliding-redis-compact", "decay"} {
+		rec := adminRequest(t, h, http.MethodPut, "/limits/bob", `{"mode":"`+mode+`"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("mode %s: expected 200, got %d: %s", mode, rec.Code, rec.Body.String())
+		}
+	}
+	rec := adminRequest(t, h, http.MethodPut, "/limits/bob", `{"mode":"fixed"}`)
+	if body := rec.Body.String(); !strings.Contains(body, `"sliding-redis-compact" or "decay"`) {
+		t.Fatalf("the error should list every mode, got %q", body)
+	}
+}
+
+func TestAdminHandler_RequiresAuth(t *testing.T) {
+	resetLimiterState()
+
+	req := httptest.NewRequest(http.MethodPut, "/limits/eve", strings.NewReader(`{"limit":1000}`))
+	rec := httptest.NewRecorder()
+	AdminHandler(testAdminAuth).ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rec.Code)
+	}
+	if _, ok := GetUserLimit("eve"); ok {
+		t.Fatal("unauthorized request must not change config")
+	}
+}
@@ -0,0 +1,88 @@
+package limiter
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// anonymousKey is the Redis sorted set shared by all anonymous requests. It
+// has no "rate:" prefix, so no client's own window can collide with it.
+const anonymousKey = "__anonymous__"
+
+var (
+	// anonymousLimit is the aggregate cap set with SetAnonymousLimit, if any.
+	anonymousLimit atomic.Pointer[globalLimit]
+	// anonymousCount counts anonymous requests against it without Redis.
+	anonymousCount windowCounter
+)
+
+// SetAnonymousLimit caps all anonymous requests combined at limit per window,
+// e.g. to protect a login endpoint from a botnet whose every address stays
+// under its own limit. A request is anonymous if it is keyed by ClientIPKey,
+// including per endpoint class (MiddlewareOptions.Classes).
+//
+// The per-client limit is checked first and the aggregate one only for
+// requests it allowed, so a client denied its own limit takes nothing from
+// the others. A request the aggregate cap denies gives its slot back to the
+// client's bucket. Allow-listed clients bypass the cap. The aggregate is a
+// sliding window kept in Redis if InitRedis has been called, so it is shared
+// by every instance; otherwise it is kept in memory, apart from users' state,
+// and estimated from per-window counts. limit <= 0 or window < 1ms removes
+// the cap.
+func SetAnonymousLimit(limit int, window time.Duration) {
+	if limit <= 0 || window < time.Millisecond {
+		anonymousLimit.Store(nil)
+		anonymousCount.reset()
+		return
+	}
+	anonymousLimit.Store(&globalLimit{limit: limit, window: window})
+}
+
+// GetAnonymousLimit returns the aggregate anonymous cap, if one is set.
+func GetAnonymousLimit() (limit int, window time.Duration, ok bool) {
+	a := anonymousLimit.Load()
+	if a == nil {
+		return 0, 0, false
+	}
+	return a.limit, a.window, true
+}
+
+// anonymousPrefixes start a ClientIPKey, alone or as the first part of a
+// CompositeKey such as the EndpointClassKey Middleware limits classes under.
+var anonymousPrefixes = []string{"ip" + string(keySeparator), CompositeKey("ip" + string(keySeparator))}
+
+// isAnonymous reports whether userID is a ClientIPKey, or a key built on one.
+func isAnonymous(userID string) bool {
+	for _, p := range anonymousPrefixes {
+		if strings.HasPrefix(userID, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAnonymous applies the aggregate anonymous cap to a request userID's
+// own limit allowed with rc, giving rc's slot back if the cap denies it.
+func checkAnonymous(ctx context.Context, userID string, rc receipt, at time.Time) (bool, receipt) {
+	a := anonymousLimit.Load()
+	// nothing was consumed (e.g. allow-listed client): the cap does not apply
+	if a == nil || rc.mode == "" || !isAnonymous(userID) {
+		return true, rc
+	}
+	ok, arc := true, receipt{}
+	if rdb != nil {
+		member := strconv.FormatInt(timeNow().UnixNano(), 10)
+		ok, arc = aggregateCall(anonymousKey, member, at, *a).runCtx(ctx)
+	} else {
+		ok = anonymousCount.take(a.limit, a.window, nowMsAt(at))
+	}
+	if ok {
+		return true, rc
+	}
+	refundReceipt(userID, rc)
+	// the denial was the aggregate's; report no client usage for it
+	return false, receipt{err: arc.err, reason: ReasonAnonymousCap}
+}
@@ -0,0 +1,113 @@
+package limiter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSetAnonymousLimit_CapsDistinctIPsCombined(t *testing.T) {
+	resetLimiterState()
+	SetAnonymousLimit(5, time.Minute)
+
+	h := Middleware(MiddlewareOptions{
+		KeyFunc: func(r *http.Request) string { return ClientIPKey(r, nil) },
+		Limit:   2,
+	})(okHandler)
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/login", nil)
+		r.RemoteAddr = fmt.Sprintf("203.0.113.%d:1000", i+1)
+		h.ServeHTTP(rec, r)
+		if rec.Code == http.StatusOK {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Fatalf("20 IPs each under their own limit must share the cap of 5, got %d allowed", allowed)
+	}
+	if !RateLimit("alice", 2) {
+		t.Fatal("authenticated users are not subject to the anonymous cap")
+	}
+}
+
+func TestSetAnonymousLimit_CapsEndpointClasses(t *testing.T) {
+	resetLimiterState()
+	SetAnonymousLimit(5, time.Minute)
+
+	h := Middleware(MiddlewareOptions{
+		KeyFunc: func(r *http.Request) string { return ClientIPKey(r, nil) },
+		Limit:   100,
+		Classes: map[string]int{"/login": 2},
+	})(okHandler)
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/login", nil)
+		r.RemoteAddr = fmt.Sprintf("[2001:db8::%d]:1000", i+1)
+		h.ServeHTTP(rec, r)
+		if rec.Code == http.StatusOK {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Fatalf("20 IPs limited per class must share the cap of 5, got %d allowed", allowed)
+	}
+}
+
+func TestSetAnonymousLimit_KeptApartFromUsers(t *testing.T) {
+	resetLimiterState()
+	SetAnonymousLimit(2, time.Minute)
+	SetMaxUsers(1)
+
+	if got := countAllowed(CompositeKey("ip", "203.0.113.1"), 5, 2); got != 2 {
+		t.Fatalf("expected the cap's 2 requests allowed, got %d", got)
+	}
+	n := 0
+	slidingStates.rangeAll(func(string, *slidingState) bool { n++; return true })
+	if n != 1 {
+		t.Fatalf("only the client should have user state, got %d users", n)
+	}
+	// evicting and resetting every user leaves the aggregate in place
+	RateLimit("alice", 5)
+	if err := ResetByPrefix(""); err != nil {
+		t.Fatal(err)
+	}
+	if RateLimit(CompositeKey("ip", "203.0.113.2"), 5) {
+		t.Fatal("the cap must survive eviction and resets of users")
+	}
+}
+
+func TestSetAnonymousLimit_InteractionOrder(t *testing.T) {
+	resetLimiterState()
+	SetAnonymousLimit(3, time.Minute)
+	a, b := CompositeKey("ip", "203.0.113.1"), CompositeKey("ip", "203.0.113.2")
+
+	// a's own limit denies its 3rd request, which takes nothing from the cap
+	if got := countAllowed(a, 2, 3); got != 2 {
+		t.Fatalf("expected 2 of a's requests allowed, got %d", got)
+	}
+	if got := countAllowed(b, 2, 2); got != 1 {
+		t.Fatalf("expected b to get the one slot left in the cap, got %d", got)
+	}
+	// b's request denied by the cap is given back to b's own bucket
+	if st := Stats(b, 2); st.Used != 1 {
+		t.Fatalf("a request denied by the cap must not consume b's limit, used %d", st.Used)
+	}
+
+	AllowList(b)
+	if !RateLimit(b, 2) {
+		t.Fatal("allow-listed clients bypass the anonymous cap")
+	}
+
+	SetAnonymousLimit(0, 0)
+	if _, _, ok := GetAnonymousLimit(); ok {
+		t.Fatal("expected the cap to be removed")
+	}
+	if !RateLimit(CompositeKey("ip", "203.0.113.3"), 2) {
+		t.Fatal("expected anonymous requests to be allowed without the cap")
+	}
+}
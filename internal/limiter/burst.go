@@ -0,0 +1,83 @@
+package limiter
+
+import (
+	"sync"
+	"time"
+)
+
+// burstGrant is extra allowance on top of a user's limit until it expires.
+type burstGrant struct {
+	extra     int
+	expiresAt time.Time
+}
+
+type userBurst struct {
+	mtx    sync.Mutex
+	grants []burstGrant
+}
+
+var userBursts = sync.Map{} // map[string]*userBurst
+
+// GrantBurst temporarily raises userID's limit by extra for the given
+// duration, e.g. a support agent's "extra 100 requests for the next hour".
+// Grants stack: each adds to the limit while it lasts and expires on its own.
+// The raise applies wherever EffectiveLimit does, at once: a sliding window
+// holds the user's base limit to the lowest used within it, but adds grants
+// on top. Like SetUserLimit, it does not resize an existing in-memory leaky
+// bucket. extra <= 0 or duration <= 0
+// grants nothing.
+func GrantBurst(userID string, extra int, duration time.Duration) {
+	if extra <= 0 || duration <= 0 {
+		return
+	}
+	g := burstGrant{extra: extra, expiresAt: timeNow().Add(duration)}
+	for {
+		v, _ := userBursts.LoadOrStore(userID, &userBurst{})
+		b := v.(*userBurst)
+		b.mtx.Lock()
+		// ActiveBurst may have dropped b once its grants expired
+		if cur, ok := userBursts.Load(userID); ok && cur == v {
+			b.grants = append(b.grants, g)
+			b.mtx.Unlock()
+			return
+		}
+		b.mtx.Unlock()
+	}
+}
+
+// RevokeBursts drops every active grant of userID.
+func RevokeBursts(userID string) {
+	userBursts.Delete(userID)
+}
+
+// pinExempt returns the part of limit that userID's grants make up, which a
+// sliding window adds after pinning its limit (see pinLimit).
+func pinExempt(userID string, limit int) int {
+	return max(min(ActiveBurst(userID), limit-1), 0)
+}
+
+// ActiveBurst returns the extra allowance userID's unexpired grants add to
+// their limit.
+func ActiveBurst(userID string) int {
+	v, ok := userBursts.Load(userID)
+	if !ok {
+		return 0
+	}
+	b := v.(*userBurst)
+	now := timeNow()
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	total := 0
+	live := b.grants[:0]
+	for _, g := range b.grants {
+		if now.Before(g.expiresAt) {
+			live = append(live, g)
+			total += g.extra
+		}
+	}
+	b.grants = live
+	if len(live) == 0 {
+		userBursts.CompareAndDelete(userID, b)
+	}
+	return total
+}
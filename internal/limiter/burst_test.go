@@ -0,0 +1,90 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGrantBurst_RaisesLimitUntilExpiry(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	SetUserLimit("boosted", 2)
+	GrantBurst("boosted", 3, time.Hour)
+	if got := EffectiveLimit("boosted", 0); got != 5 {
+		t.Fatalf("expected limit 2+3, got %d", got)
+	}
+	for i := 1; i <= 5; i++ {
+		if !RateLimit("boosted", 1) {
+			t.Fatalf("request %d should be allowed under the raised limit", i)
+		}
+	}
+	if RateLimit("boosted", 1) {
+		t.Fatal("the 6th request exceeds the raised limit")
+	}
+
+	now = now.Add(time.Hour)
+	if got := EffectiveLimit("boosted", 0); got != 2 {
+		t.Fatalf("expected the limit to revert to 2 after expiry, got %d", got)
+	}
+}
+
+func TestGrantBurst_StacksAndExpiresIndependently(t *testing.T) {
+	resetLimiterState()
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	GrantBurst("stack", 10, time.Minute)
+	GrantBurst("stack", 5, time.Hour)
+	GrantBurst("stack", -1, time.Hour) // ignored
+	if got := EffectiveLimit("stack", 4); got != 19 {
+		t.Fatalf("expected 4+10+5, got %d", got)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if got := EffectiveLimit("stack", 4); got != 9 {
+		t.Fatalf("expected only the hour-long grant left (4+5), got %d", got)
+	}
+
+	now = now.Add(time.Hour)
+	if got := ActiveBurst("stack"); got != 0 {
+		t.Fatalf("expected all grants expired, got %d", got)
+	}
+
+	GrantBurst("stack", 7, time.Hour)
+	RevokeBursts("stack")
+	if got := EffectiveLimit("stack", 4); got != 4 {
+		t.Fatalf("revoked grants must not count, got %d", got)
+	}
+}
+
+// assertGrantAppliesAtOnce grants a burst to a user whose long window is
+// already full at their base limit.
+func assertGrantAppliesAtOnce(t *testing.T, user string) {
+	t.Helper()
+	SetUserLimit(user, 5)
+	SetUserWindow(user, time.Hour)
+	if got := countAllowed(user, 5, 10); got != 5 {
+		t.Fatalf("expected the base limit of 5, got %d", got)
+	}
+
+	GrantBurst(user, 100, 30*time.Minute)
+	if got := countAllowed(user, 5, 50); got != 50 {
+		t.Fatalf("a grant must apply at once to a user with traffic, got %d of 50", got)
+	}
+
+	RevokeBursts(user)
+	if RateLimit(user, 5) {
+		t.Fatal("after the grant the base limit must hold again")
+	}
+}
+
+func TestGrantBurst_AppliesToUserWithTraffic(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	assertGrantAppliesAtOnce(t, "burst-busy")
+}
@@ -0,0 +1,73 @@
+package limiter
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIPKey returns a rate-limit key for the client that sent r, for
+// requests without an authenticated identity. Keying anonymous requests on a
+// shared literal such as "guest" lets one abusive client exhaust the quota of
+// every anonymous client; keying on the client IP isolates them.
+//
+// The client is the peer address (r.RemoteAddr) unless that peer is one of
+// trustedProxies. Only then is X-Forwarded-For consulted: its entries are
+// walked from the right, skipping trusted proxies, and the first untrusted
+// address is the client. Entries left of it were written by the client itself
+// and are ignored, so a client cannot pick its key by sending the header, nor
+// can anyone who reaches the server directly. If the header runs out, or holds
+// an entry that is not an IP, the last trusted hop stands in for the client.
+// With no trusted proxies the header is never read.
+//
+// The key is CompositeKey("ip", address); it is "" if RemoteAddr holds no IP,
+// which Middleware rejects with 400. SetAnonymousLimit caps the requests of
+// all such keys combined.
+func ClientIPKey(r *http.Request, trustedProxies []net.IPNet) string {
+	ip := parseAddr(r.RemoteAddr)
+	if ip == nil {
+		return ""
+	}
+	if trusted(ip, trustedProxies) {
+		ip = forwardedClient(ip, r.Header.Values("X-Forwarded-For"), trustedProxies)
+	}
+	return CompositeKey("ip", ip.String())
+}
+
+// forwardedClient walks X-Forwarded-For values right to left from the trusted
+// peer and returns the first address not in trustedProxies.
+func forwardedClient(peer net.IP, values []string, trustedProxies []net.IPNet) net.IP {
+	hops := strings.Split(strings.Join(values, ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ip := parseAddr(hop)
+		if ip == nil {
+			break
+		}
+		peer = ip
+		if !trusted(ip, trustedProxies) {
+			break
+		}
+	}
+	return peer
+}
+
+// parseAddr parses an IP with or without a port ("10.0.0.1:443", "[::1]:80").
+func parseAddr(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(addr)
+}
+
+func trusted(ip net.IP, proxies []net.IPNet) bool {
+	for _, n := range proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
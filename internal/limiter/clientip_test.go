@@ -0,0 +1,88 @@
+package limiter
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func mustCIDRs(t *testing.T, cidrs ...string) []net.IPNet {
+	t.Helper()
+	var nets []net.IPNet
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nets = append(nets, *n)
+	}
+	return nets
+}
+
+func TestClientIPKey(t *testing.T) {
+	proxies := mustCIDRs(t, "10.0.0.0/8", "fd00::/8")
+
+	cases := []struct {
+		name    string
+		remote  string
+		xff     []string
+		proxies []net.IPNet
+		want    string
+	}{
+		{"direct peer", "203.0.113.7:5000", nil, proxies, "203.0.113.7"},
+		{"header ignored without trusted proxies", "203.0.113.7:5000", []string{"198.51.100.1"}, nil, "203.0.113.7"},
+		{"spoofed header from an untrusted peer", "203.0.113.7:5000", []string{"198.51.100.1"}, proxies, "203.0.113.7"},
+		{"client behind a trusted proxy", "10.0.0.2:443", []string{"198.51.100.1"}, proxies, "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.2:443", []string{"198.51.100.1, 10.1.1.1"}, proxies, "198.51.100.1"},
+		{"client-forged entries left of the client", "10.0.0.2:443", []string{"1.2.3.4, 198.51.100.1"}, proxies, "198.51.100.1"},
+		{"forged entry claiming a trusted proxy", "10.0.0.2:443", []string{"10.9.9.9, 198.51.100.1"}, proxies, "198.51.100.1"},
+		{"multiple header lines", "10.0.0.2:443", []string{"1.2.3.4", "198.51.100.1"}, proxies, "198.51.100.1"},
+		{"garbage entry stops at the last trusted hop", "10.0.0.2:443", []string{"198.51.100.1, bogus, 10.1.1.1"}, proxies, "10.1.1.1"},
+		{"trusted proxy without header", "10.0.0.2:443", nil, proxies, "10.0.0.2"},
+		{"ipv6 peer", "[2001:db8::1]:443", nil, proxies, "2001:db8::1"},
+		{"ipv6 behind a trusted proxy", "[fd00::1]:443", []string{"2001:db8::2"}, proxies, "2001:db8::2"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = c.remote
+			for _, v := range c.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got, want := ClientIPKey(r, c.proxies), CompositeKey("ip", c.want); got != want {
+				t.Fatalf("got %q, want %q", got, want)
+			}
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "not-an-ip"
+	if got := ClientIPKey(r, proxies); got != "" {
+		t.Fatalf("expected no key without a peer IP, got %q", got)
+	}
+}
+
+func TestClientIPKey_IsolatesAnonymousClients(t *testing.T) {
+	resetLimiterState()
+
+	h := Middleware(MiddlewareOptions{
+		KeyFunc: func(r *http.Request) string { return ClientIPKey(r, nil) },
+		Limit:   1,
+	})(okHandler)
+	send := func(remote string) int {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api", nil)
+		r.RemoteAddr = remote
+		r.Header.Set("X-Forwarded-For", "198.51.100.1")
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+	send("203.0.113.7:1000")
+	if code := send("203.0.113.7:1001"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected the abusive client to be throttled, got %d", code)
+	}
+	if code := send("203.0.113.8:1000"); code != http.StatusOK {
+		t.Fatalf("another anonymous client must keep its own quota, got %d", code)
+	}
+}
@@ -0,0 +1,43 @@
+package limiter
+
+import "time"
+
+// wallNow reads the wall clock. The in-memory clock reads it only once, for
+// clockBase; tests step it to check that nothing else does.
+var wallNow = time.Now
+
+// clockBase anchors the in-memory clock: its wall reading is taken once, and
+// its monotonic reading measures everything after it. It also marks the
+// start of the process for SetWarmup.
+var clockBase = wallNow()
+
+// monoNowMs returns the current time in unix ms for the in-memory windows,
+// buckets and expiries. It advances with the monotonic clock from the wall
+// time at startup, so a step of the wall clock (e.g. by NTP) neither expires
+// a window early nor stalls or rewinds a bucket's refill. It drifts from the
+// wall clock by the steps made since startup. Calendar-aligned quotas
+// (timeNow) and Redis (the server clock, see redisNowMs) still follow wall
+// time.
+func monoNowMs() int64 {
+	return clockBase.Add(time.Since(clockBase)).UnixMilli()
+}
+
+// redisNowMs returns the Redis server's time in unix ms, the clock the
+// scripts record Redis state by, or monoNowMs if it cannot be read.
+func redisNowMs() int64 {
+	t, err := rdb.Time(ctx).Result()
+	if err != nil {
+		return monoNowMs()
+	}
+	return t.UnixMilli()
+}
+
+// stateNowMs returns the current time on the clock of the backend holding
+// limiter state: the Redis server's if InitRedis has been called, the
+// in-memory clock otherwise.
+func stateNowMs() int64 {
+	if rdb != nil {
+		return redisNowMs()
+	}
+	return monoNowMs()
+}
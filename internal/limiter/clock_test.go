@@ -0,0 +1,42 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMonoNowMs_IgnoresWallClockSteps(t *testing.T) {
+	resetLimiterState()
+	defer func() { wallNow, timeNow = time.Now, time.Now }()
+	SetUserLimit("alice", 3)
+	SetUserWindow("alice", time.Minute)
+	SetUserMode("bob", "leaky")
+	SetUserWindow("bob", time.Minute)
+
+	if got := countAllowed("alice", 3, 5); got != 3 {
+		t.Fatalf("expected 3 allowed, got %d", got)
+	}
+	if got := countAllowed("bob", 3, 5); got != 3 {
+		t.Fatalf("expected 3 allowed, got %d", got)
+	}
+
+	// NTP steps the wall clock an hour back, then two hours forward. Windows
+	// and buckets read from the wall clock on every call would follow it.
+	for _, step := range []time.Duration{-time.Hour, time.Hour} {
+		wallNow = func() time.Time { return time.Now().Add(step) }
+		setTestNow(wallNow())
+		if RateLimit("alice", 3) {
+			t.Fatalf("step %v: a full sliding window must stay full", step)
+		}
+		if RateLimit("bob", 3) {
+			t.Fatalf("step %v: an empty bucket must not refill", step)
+		}
+		if wait := TimeUntilReset("alice"); wait <= 0 || wait > time.Minute {
+			t.Fatalf("step %v: expected a wait within the window, got %v", step, wait)
+		}
+	}
+
+	if d := monoNowMs() - time.Now().UnixMilli(); d < -1000 || d > 1000 {
+		t.Fatalf("without steps the clock must follow wall time, off by %dms", d)
+	}
+}
@@ -0,0 +1,217 @@
+package limiter
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// compactMode is the mode of the compact Redis sliding window.
+const compactMode = "sliding-redis-compact"
+
+// defaultCompactResolution is the number of sub-windows per window.
+const defaultCompactResolution = 10
+
+var compactResolution atomic.Int64
+
+func init() { compactResolution.Store(defaultCompactResolution) }
+
+// SetCompactResolution sets how many sub-windows the "sliding-redis-compact"
+// mode splits a window into (default 10). More sub-windows track the exact
+// window more closely at the cost of a larger hash. n <= 0 restores the
+// default.
+//
+// In "sliding-redis-compact" mode, Redis keeps a user's window as a hash of
+// per-sub-window counters ("crate:<user>") instead of a sorted set with one
+// member per request, so its memory is bounded by the resolution whatever the
+// limit. A sub-window's requests all leave the window when its last moment
+// does, so the counted window reaches back up to one sub-window further than
+// the exact one: it never admits more than the exact sliding window, and
+// frees a slot at most one sub-window (window/n) later. Strict mode and limit
+// pinning do not apply. Without Redis the mode is the in-memory sliding
+// window.
+func SetCompactResolution(n int) {
+	if n <= 0 {
+		n = defaultCompactResolution
+	}
+	compactResolution.Store(int64(n))
+}
+
+// compactSubWindow returns the sub-window length for window, at least 1ms.
+func compactSubWindow(window time.Duration) int64 {
+	return max(window.Milliseconds()/compactResolution.Load(), 1)
+}
+
+func compactKey(userID string) string { return "crate:" + redisUserPart(userID) }
+
+// compactRedis reports whether userID's window is kept as compact counters.
+func compactRedis(userID string) bool {
+	return rdb != nil && GetUserMode(userID) == compactMode
+}
+
+// compactScript:
+// KEYS[1] = hash of sub-window index -> requests
+// ARGV[1] = window (ms), ARGV[2] = limit, ARGV[3] = sub-window (ms)
+// ARGV[4] = key expiry (ms)
+// ARGV[5] = caller-supplied now (ms), or "" to use the server time
+// Sub-windows ending at or before now - window are dropped. Returns
+// {allowed, current, frees, now} like slidingScript, where frees is when the
+// oldest counted sub-window leaves the window, less the window: the moment
+// retryFromOldest treats as the oldest request.
+var compactScript = newScript("compact", luaNowMsArg(5)+luaExpire+`
+	local window, limit, sub = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+	local first = math.floor((now - window) / sub)
+	local vals = redis.call("HGETALL", KEYS[1])
+	local current, oldest, stale = 0, -1, {}
+	for i = 1, #vals, 2 do
+		local b = tonumber(vals[i])
+		if b < first then
+			stale[#stale + 1] = vals[i]
+		else
+			current = current + tonumber(vals[i + 1])
+			if oldest < 0 or b < oldest then oldest = b end
+		end
+	end
+	if #stale > 0 then redis.call("HDEL", KEYS[1], unpack(stale)) end
+	local allowed = 0
+	if current < limit then
+		local b = math.floor(now / sub)
+		redis.call("HINCRBY", KEYS[1], b, 1)
+		pexpire(KEYS[1], ARGV[4])
+		current = current + 1
+		allowed = 1
+		if oldest < 0 then oldest = b end
+	end
+	local frees = 0
+	if oldest >= 0 then frees = (oldest + 1) * sub end
+	return {allowed, current, frees, now}
+`)
+
+// compactAdjustScript adds ARGV[1] requests to the current sub-window of
+// KEYS[1], or for a negative ARGV[1] takes them from the newest ones.
+// ARGV[2] = sub-window (ms), ARGV[3] = key expiry (ms)
+var compactAdjustScript = newScript("compact-adjust", luaNowMs+luaExpire+`
+	local delta = tonumber(ARGV[1])
+	if delta > 0 then
+		redis.call("HINCRBY", KEYS[1], math.floor(now / tonumber(ARGV[2])), delta)
+		pexpire(KEYS[1], ARGV[3])
+		return 1
+	end
+	local vals = redis.call("HGETALL", KEYS[1])
+	local buckets = {}
+	for i = 1, #vals, 2 do buckets[#buckets + 1] = tonumber(vals[i]) end
+	table.sort(buckets, function(a, b) return a > b end)
+	for _, b in ipairs(buckets) do
+		if delta == 0 then break end
+		local n = tonumber(redis.call("HGET", KEYS[1], b))
+		local take = math.min(n, -delta)
+		if take == n then
+			redis.call("HDEL", KEYS[1], b)
+		else
+			redis.call("HINCRBY", KEYS[1], b, -take)
+		end
+		delta = delta + take
+	end
+	return 1
+`)
+
+func rateLimitRedisCompact(ctx context.Context, userID string, limit int, window time.Duration, at time.Time) (bool, receipt) {
+	if rdb == nil || limit <= 0 {
+		return false, receipt{}
+	}
+	return compactCall(userID, limit, window, at).runCtx(ctx)
+}
+
+func compactCall(userID string, limit int, window time.Duration, at time.Time) scriptCall {
+	return scriptCall{
+		script: compactScript,
+		keys:   []string{compactKey(userID)},
+		args: []any{
+			strconv.FormatInt(window.Milliseconds(), 10),
+			strconv.Itoa(limit),
+			strconv.FormatInt(compactSubWindow(window), 10),
+			strconv.FormatInt(keyExpiry(window).Milliseconds(), 10),
+			atArg(at),
+		},
+	}
+}
+
+// adjustRedisCompact charges delta more requests to userID's compact window,
+// or gives -delta back.
+func adjustRedisCompact(userID string, delta int) error {
+	window := GetUserWindow(userID)
+	return compactAdjustScript.Run(ctx, rdb, []string{compactKey(userID)},
+		strconv.Itoa(delta),
+		strconv.FormatInt(compactSubWindow(window), 10),
+		strconv.FormatInt(keyExpiry(window).Milliseconds(), 10),
+	).Err()
+}
+
+// compactBucket is one sub-window's counter.
+type compactBucket struct {
+	index, count int64
+}
+
+// peekCompact returns the sub-windows counted in userID's window at nowMs,
+// oldest first, and the sub-window length.
+func peekCompact(userID string, window time.Duration, nowMs int64) ([]compactBucket, int64) {
+	sub := compactSubWindow(window)
+	vals, err := rdb.HGetAll(ctx, compactKey(userID)).Result()
+	if err != nil {
+		return nil, sub
+	}
+	first := floorDiv(nowMs-window.Milliseconds(), sub)
+	var buckets []compactBucket
+	for field, v := range vals {
+		b, err1 := strconv.ParseInt(field, 10, 64)
+		n, err2 := strconv.ParseInt(v, 10, 64)
+		if err1 == nil && err2 == nil && b >= first && n > 0 {
+			buckets = append(buckets, compactBucket{b, n})
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].index < buckets[j].index })
+	return buckets, sub
+}
+
+// floorDiv divides rounding toward negative infinity, as Lua's math.floor.
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+// compactCount returns how many requests userID's compact window holds.
+func compactCount(userID string, window time.Duration) int {
+	buckets, _ := peekCompact(userID, window, redisNowMs())
+	n := 0
+	for _, b := range buckets {
+		n += int(b.count)
+	}
+	return n
+}
+
+// compactTimeUntil returns how long until userID's compact window holds fewer
+// than limit requests (limit 1: until it is empty).
+func compactTimeUntil(userID string, limit int, window time.Duration) time.Duration {
+	nowMs := redisNowMs()
+	buckets, sub := peekCompact(userID, window, nowMs)
+	excess := -int64(limit)
+	for _, b := range buckets {
+		excess += b.count
+	}
+	for _, b := range buckets {
+		if excess < 0 {
+			break
+		}
+		excess -= b.count
+		if excess < 0 {
+			wait := (b.index+1)*sub + window.Milliseconds() - nowMs
+			return time.Duration(max(wait, 0)) * time.Millisecond
+		}
+	}
+	return 0
+}
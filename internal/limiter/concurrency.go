@@ -0,0 +1,130 @@
+package limiter
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultInFlightTTL bounds how long a Redis in-flight count outlives its last
+// Acquire when holders never call release.
+const defaultInFlightTTL = 10 * time.Minute
+
+type semaphore struct {
+	mtx  sync.Mutex
+	held int
+}
+
+var (
+	inFlight    = sync.Map{} // map[string]*semaphore
+	inFlightTTL atomic.Int64 // ms
+)
+
+func init() { inFlightTTL.Store(defaultInFlightTTL.Milliseconds()) }
+
+// acquireScript takes a slot of the counting semaphore KEYS[1]:
+// ARGV[1] = max holders, ARGV[2] = key expiry (ms)
+var acquireScript = newScript("acquire", `
+	local n = redis.call("INCR", KEYS[1])
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	if n > tonumber(ARGV[1]) then
+		redis.call("DECR", KEYS[1])
+		return 0
+	end
+	return 1
+`)
+
+// releaseScript gives a slot of KEYS[1] back, never going below zero (the
+// count may have expired while the slot was held).
+var releaseScript = newScript("release", `
+	local n = tonumber(redis.call("GET", KEYS[1]) or "0")
+	if n <= 1 then
+		redis.call("DEL", KEYS[1])
+	else
+		redis.call("DECR", KEYS[1])
+	end
+	return 1
+`)
+
+// SetInFlightTTL sets how long the Redis in-flight count of a user lives after
+// their last Acquire (default 10 minutes). It guards against slots leaked by
+// holders that never release, e.g. a crashed instance, at the cost of
+// forgetting every holder of a user once none has acquired for d; it should
+// exceed the longest operation being guarded. d < 1ms restores the default.
+func SetInFlightTTL(d time.Duration) {
+	if d < time.Millisecond {
+		d = defaultInFlightTTL
+	}
+	inFlightTTL.Store(d.Milliseconds())
+}
+
+// Acquire takes one of max concurrent slots for userID, limiting in-flight
+// operations rather than their rate. ok is false, and release nil, when max
+// holders already exist, when max <= 0, while draining (see Drain) or when
+// Redis cannot be reached.
+// Otherwise the caller must call release once the operation finishes; further
+// calls to release do nothing. Slots are counted in Redis if InitRedis has been
+// called, so max holds across instances, and in memory otherwise.
+func Acquire(userID string, max int) (release func(), ok bool) {
+	userID = transformKey(userID)
+	if max <= 0 || IsDraining(userID) {
+		return nil, false
+	}
+	if rdb != nil {
+		return acquireRedis(userID, max)
+	}
+	return acquireMemory(userID, max)
+}
+
+// InFlight returns how many slots userID currently holds.
+func InFlight(userID string) int {
+	userID = transformKey(userID)
+	if rdb != nil {
+		n, _ := rdb.Get(ctx, inFlightKey(userID)).Int()
+		return n
+	}
+	v, ok := inFlight.Load(userID)
+	if !ok {
+		return 0
+	}
+	s := v.(*semaphore)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.held
+}
+
+func inFlightKey(userID string) string { return "inflight:" + redisUserPart(userID) }
+
+func acquireMemory(userID string, max int) (func(), bool) {
+	v, _ := inFlight.LoadOrStore(userID, &semaphore{})
+	s := v.(*semaphore)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.held >= max {
+		return nil, false
+	}
+	s.held++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mtx.Lock()
+			s.held--
+			s.mtx.Unlock()
+		})
+	}, true
+}
+
+func acquireRedis(userID string, max int) (func(), bool) {
+	key := inFlightKey(userID)
+	n, err := acquireScript.Run(ctx, rdb, []string{key},
+		strconv.Itoa(max), strconv.FormatInt(inFlightTTL.Load(), 10),
+	).Int()
+	if err != nil || n != 1 {
+		return nil, false
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() { releaseScript.Run(ctx, rdb, []string{key}) })
+	}, true
+}
@@ -0,0 +1,63 @@
+package limiter
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestAcquire_CapsConcurrentHolders(t *testing.T) {
+	resetLimiterState()
+
+	var releases []func()
+	for i := 1; i <= 3; i++ {
+		release, ok := Acquire("jobs", 3)
+		if !ok {
+			t.Fatalf("acquire %d of 3 should succeed", i)
+		}
+		releases = append(releases, release)
+	}
+	if release, ok := Acquire("jobs", 3); ok || release != nil {
+		t.Fatal("a 4th concurrent holder must be refused")
+	}
+	if _, ok := Acquire("other", 3); !ok {
+		t.Fatal("users must not share slots")
+	}
+
+	releases[0]()
+	releases[0]() // a second release is a no-op
+	if got := InFlight("jobs"); got != 2 {
+		t.Fatalf("expected 2 in flight after one release, got %d", got)
+	}
+	if _, ok := Acquire("jobs", 3); !ok {
+		t.Fatal("acquire should succeed again after a release")
+	}
+	if _, ok := Acquire("jobs", 3); ok {
+		t.Fatal("the released slot was taken; the next acquire must fail")
+	}
+	if _, ok := Acquire("jobs", 0); ok {
+		t.Fatal("max <= 0 must never admit")
+	}
+}
+
+func TestAcquire_Concurrent(t *testing.T) {
+	resetLimiterState()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	granted := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := Acquire("racy", 5); ok {
+				mu.Lock()
+				granted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if granted != 5 {
+		t.Fatalf("expected exactly 5 holders, got %d", granted)
+	}
+}
@@ -0,0 +1,187 @@
+package limiter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// userEntry is one per-user config value. It accepts either the simple form
+// (a bare integer limit per second, or a "<count>/<duration>" string such as
+// "5/10s") or the extended form (an object with named fields).
+type userEntry struct {
+	Limit     int    `json:"limit" yaml:"limit"`
+	Unlimited bool   `json:"unlimited" yaml:"unlimited"` // allow-list the user
+	Tier      string `json:"tier" yaml:"tier"`           // assign the user to a tier
+
+	rate string // unparsed "<count>/<duration>" form, checked by applyUserConfig
+}
+
+// userEntryFields mirrors userEntry without its custom unmarshalers.
+type userEntryFields userEntry
+
+func (e *userEntry) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		var f userEntryFields
+		if err := json.Unmarshal(data, &f); err != nil {
+			return err
+		}
+		*e = userEntry(f)
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var rate string
+		if err := json.Unmarshal(data, &rate); err != nil {
+			return err
+		}
+		*e = userEntry{rate: rate}
+		return nil
+	}
+	var limit int
+	if err := json.Unmarshal(data, &limit); err != nil {
+		return err
+	}
+	*e = userEntry{Limit: limit}
+	return nil
+}
+
+func (e *userEntry) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		var f userEntryFields
+		if err := node.Decode(&f); err != nil {
+			return err
+		}
+		*e = userEntry(f)
+		return nil
+	}
+	if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!str" {
+		*e = userEntry{rate: node.Value}
+		return nil
+	}
+	var limit int
+	if err := node.Decode(&limit); err != nil {
+		return err
+	}
+	*e = userEntry{Limit: limit}
+	return nil
+}
+
+// parseRate parses the "<count>/<duration>" limit shorthand, e.g. "5/10s" or
+// "100/1m"; a bare unit means one of it, so "5/s" is "5/1s".
+func parseRate(s string) (limit int, window time.Duration, err error) {
+	count, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf(`want "<count>/<duration>", e.g. "5/10s"`)
+	}
+	limit, err = strconv.Atoi(strings.TrimSpace(count))
+	if err != nil || limit <= 0 {
+		return 0, 0, fmt.Errorf("count %q is not a positive integer", count)
+	}
+	per = strings.TrimSpace(per)
+	if per != "" && (per[0] < '0' || per[0] > '9') && per[0] != '.' {
+		per = "1" + per
+	}
+	window, err = time.ParseDuration(per)
+	if err != nil {
+		return 0, 0, err
+	}
+	if window < time.Millisecond {
+		return 0, 0, fmt.Errorf("duration %v is shorter than 1ms", window)
+	}
+	return limit, window, nil
+}
+
+// LoadUserConfigFromYAML loads per-user limits from a YAML file.
+// It accepts the same schema as LoadUserConfigFromJSON.
+func LoadUserConfigFromYAML(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cfg map[string]userEntry
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return err
+	}
+	return applyUserConfig(cfg)
+}
+
+var (
+	// configUnlimitedMu serializes loads, which reconcile configUnlimited.
+	configUnlimitedMu sync.Mutex
+	// configUnlimited holds the users the last load allow-listed.
+	configUnlimited = map[string]struct{}{}
+)
+
+// applyUserConfig validates parsed entries and stores them.
+// Shared by all config loaders so their behavior cannot diverge.
+// Loading is all-or-nothing: every entry is validated before any is stored,
+// so an invalid entry leaves the previous configuration untouched. Users an
+// earlier load allow-listed are removed from the allow-list unless still
+// marked unlimited.
+func applyUserConfig(cfg map[string]userEntry) error {
+	windows := make(map[string]time.Duration)
+	for user, entry := range cfg {
+		if user == "" {
+			return fmt.Errorf("config: empty user id")
+		}
+		if entry.rate != "" {
+			limit, window, err := parseRate(entry.rate)
+			if err != nil {
+				return fmt.Errorf("config: user %q: invalid limit %q: %v", user, entry.rate, err)
+			}
+			entry.Limit = limit
+			cfg[user] = entry
+			windows[user] = window
+			continue
+		}
+		if entry.Limit < 0 {
+			return fmt.Errorf("config: user %q: negative limit %d", user, entry.Limit)
+		}
+	}
+
+	configUnlimitedMu.Lock()
+	defer configUnlimitedMu.Unlock()
+	unlimited := make(map[string]struct{})
+	limits := make(map[string]int, len(cfg))
+	for user, entry := range cfg {
+		if _, ok := windows[user]; ok {
+			limits[user] = entry.Limit
+			continue
+		}
+		if entry.Unlimited {
+			AllowList(user)
+			unlimited[user] = struct{}{}
+			continue
+		}
+		if entry.Tier != "" {
+			AssignTier(user, entry.Tier)
+			if entry.Limit == 0 {
+				// the tier supplies the limit, over the default window
+				clearUserLimit(user)
+				windows[user] = 0
+				continue
+			}
+		}
+		limits[user] = entry.Limit
+		windows[user] = 0 // a bare limit is per second, even on reload
+	}
+	SetUserLimitsBulk(limits)
+	for user, window := range windows {
+		SetUserWindow(user, window)
+	}
+	for user := range configUnlimited {
+		if _, ok := unlimited[user]; !ok {
+			RemoveFromAllowList(user)
+		}
+	}
+	configUnlimited = unlimited
+	return nil
+}
@@ -0,0 +1,171 @@
+package limiter
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTempConfig(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write tmp config: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(name) })
+}
+
+func assertAllowance(t *testing.T, user string, want int) {
+	t.Helper()
+	for i := 1; i <= want; i++ {
+		if !RateLimit(user, 100) {
+			t.Fatalf("%s request %d should be allowed", user, i)
+		}
+	}
+	if RateLimit(user, 100) {
+		t.Fatalf("%s request %d should be denied", user, want+1)
+	}
+}
+
+func TestLoadUserConfigFromYAML(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	writeTempConfig(t, "test_users.yaml", "alice: 2\nbob: 4\n")
+	if err := LoadUserConfigFromYAML("test_users.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	assertAllowance(t, "alice", 2)
+	assertAllowance(t, "bob", 4)
+}
+
+func TestLoadUserConfig_ExtendedFormMatchesAcrossFormats(t *testing.T) {
+	writeTempConfig(t, "test_users_ext.json", `{"alice":{"limit":2},"bob":3}`)
+	writeTempConfig(t, "test_users_ext.yaml", "alice:\n  limit: 2\nbob: 3\n")
+
+	loaders := map[string]func() error{
+		"json": func() error { return LoadUserConfigFromJSON("test_users_ext.json") },
+		"yaml": func() error { return LoadUserConfigFromYAML("test_users_ext.yaml") },
+	}
+	for name, load := range loaders {
+		resetLimiterState()
+		SetMode("sliding")
+		if err := load(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got, _ := GetUserLimit("alice"); got != 2 {
+			t.Fatalf("%s: alice limit = %d, want 2", name, got)
+		}
+		assertAllowance(t, "alice", 2)
+		assertAllowance(t, "bob", 3)
+	}
+}
+
+func TestLoadUserConfig_RejectsNegativeLimit(t *testing.T) {
+	resetLimiterState()
+
+	writeTempConfig(t, "test_users_bad.yaml", "alice: -1\n")
+	if err := LoadUserConfigFromYAML("test_users_bad.yaml"); err == nil {
+		t.Fatal("expected error for negative limit")
+	}
+}
+
+func TestLoadUserConfig_RateShorthand(t *testing.T) {
+	writeTempConfig(t, "test_users_rate.json", `{"alice":"2/10s","bob":3,"carol":{"limit":1},"dave":"4/s"}`)
+	writeTempConfig(t, "test_users_rate.yaml", "alice: 2/10s\nbob: 3\ncarol:\n  limit: 1\ndave: \"4/s\"\n")
+
+	loaders := map[string]func() error{
+		"json": func() error { return LoadUserConfigFromJSON("test_users_rate.json") },
+		"yaml": func() error { return LoadUserConfigFromYAML("test_users_rate.yaml") },
+	}
+	for name, load := range loaders {
+		resetLimiterState()
+		SetMode("sliding")
+		if err := load(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got, _ := GetUserLimit("alice"); got != 2 || GetUserWindow("alice") != 10*time.Second {
+			t.Fatalf("%s: alice = %d per %v, want 2 per 10s", name, got, GetUserWindow("alice"))
+		}
+		if got, _ := GetUserLimit("dave"); got != 4 || GetUserWindow("dave") != time.Second {
+			t.Fatalf("%s: dave = %d per %v, want 4 per 1s", name, got, GetUserWindow("dave"))
+		}
+		// integers still mean per second
+		if GetUserWindow("bob") != time.Second {
+			t.Fatalf("%s: bob window = %v, want 1s", name, GetUserWindow("bob"))
+		}
+		assertAllowance(t, "alice", 2)
+		assertAllowance(t, "bob", 3)
+		assertAllowance(t, "carol", 1)
+	}
+}
+
+func TestLoadUserConfig_ReloadedIntegerRestoresDefaultWindow(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	writeTempConfig(t, "test_users_reload.json", `{"alice":"5/10s"}`)
+	if err := LoadUserConfigFromJSON("test_users_reload.json"); err != nil {
+		t.Fatal(err)
+	}
+	writeTempConfig(t, "test_users_reload.json", `{"alice":5}`)
+	if err := LoadUserConfigFromJSON("test_users_reload.json"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := GetUserLimit("alice"); got != 5 || GetUserWindow("alice") != time.Second {
+		t.Fatalf("alice = %d per %v, want 5 per 1s", got, GetUserWindow("alice"))
+	}
+}
+
+func TestLoadUserConfig_RateReplacesRefill(t *testing.T) {
+	resetLimiterState()
+	SetUserRefill("alice", 20, 500*time.Millisecond)
+	writeTempConfig(t, "test_users_refill.json", `{"alice":"5/10s"}`)
+	if err := LoadUserConfigFromJSON("test_users_refill.json"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := GetUserRefill("alice"); ok {
+		t.Fatal("the loaded rate should replace the refill")
+	}
+	if got, _ := GetUserLimit("alice"); got != 5 || GetUserWindow("alice") != 10*time.Second {
+		t.Fatalf("alice = %d per %v, want 5 per 10s", got, GetUserWindow("alice"))
+	}
+}
+
+func TestLoadUserConfig_RejectsMalformedRate(t *testing.T) {
+	for _, bad := range []string{"5", "five/10s", "0/10s", "-1/s", "5/ten", "5/0s", "5/100us"} {
+		resetLimiterState()
+		writeTempConfig(t, "test_users_badrate.json", `{"mallory":"`+bad+`"}`)
+		err := LoadUserConfigFromJSON("test_users_badrate.json")
+		if err == nil {
+			t.Fatalf("%q: expected an error", bad)
+		}
+		if !strings.Contains(err.Error(), `"mallory"`) || !strings.Contains(err.Error(), bad) {
+			t.Fatalf("%q: error should name the user and value, got %v", bad, err)
+		}
+	}
+}
+
+func TestLoadUserConfig_InvalidEntryAppliesNothing(t *testing.T) {
+	resetLimiterState()
+	SetUserLimit("alice", 7)
+
+	writeTempConfig(t, "test_users_partial.json",
+		`{"alice":2,"bob":"3/10s","carol":{"unlimited":true},"dave":{"tier":"gold"},"mallory":-1}`)
+	if err := LoadUserConfigFromJSON("test_users_partial.json"); err == nil {
+		t.Fatal("expected an error for mallory's negative limit")
+	}
+
+	if got, _ := GetUserLimit("alice"); got != 7 {
+		t.Fatalf("alice's previous limit must be kept, got %d", got)
+	}
+	if _, ok := GetUserLimit("bob"); ok || GetUserWindow("bob") != time.Second {
+		t.Fatal("bob must not be configured")
+	}
+	if IsAllowListed("carol") {
+		t.Fatal("carol must not be allow-listed")
+	}
+	if _, ok := GetUserTier("dave"); ok {
+		t.Fatal("dave must not be assigned a tier")
+	}
+}
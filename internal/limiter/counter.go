@@ -0,0 +1,56 @@
+package limiter
+
+import (
+	"sync"
+	"time"
+)
+
+// windowCounter is an in-memory sliding-window counter for the limiter's own
+// aggregates (the anonymous cap, the cap on Redis checks), kept apart from
+// users' state so that it is never listed, evicted or reset as a user. It
+// counts requests per fixed window and estimates the sliding window from the
+// current count and the previous one, weighted by how much of the previous
+// window the sliding window still covers, so deciding a request takes
+// constant time and memory.
+type windowCounter struct {
+	mtx     sync.Mutex
+	window  int64 // ms; a change of window starts afresh
+	startMs int64 // start of the current fixed window
+	prev    int   // requests in the previous fixed window
+	cur     int   // requests in the current fixed window
+}
+
+// take counts a request at nowMs if fewer than limit are estimated within
+// the window ending then, reporting whether it did.
+func (c *windowCounter) take(limit int, window time.Duration, nowMs int64) bool {
+	w := max(window.Milliseconds(), 1)
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.window != w {
+		c.window, c.startMs, c.prev, c.cur = w, nowMs-nowMs%w, 0, 0
+	}
+	if elapsed := nowMs - c.startMs; elapsed >= w {
+		if elapsed < 2*w {
+			c.prev = c.cur
+		} else {
+			c.prev = 0
+		}
+		c.cur = 0
+		c.startMs = nowMs - nowMs%w
+	}
+	// a replayed request from before the current window counts in it
+	nowMs = max(nowMs, c.startMs)
+	covered := float64(w-(nowMs-c.startMs)) / float64(w)
+	if float64(c.prev)*covered+float64(c.cur) >= float64(limit) {
+		return false
+	}
+	c.cur++
+	return true
+}
+
+// reset discards the counts.
+func (c *windowCounter) reset() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.window, c.startMs, c.prev, c.cur = 0, 0, 0, 0
+}
@@ -0,0 +1,36 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowCounter_EstimatesSlidingWindow(t *testing.T) {
+	var c windowCounter
+	count := func(nowMs int64, attempts int) int {
+		n := 0
+		for i := 0; i < attempts; i++ {
+			if c.take(10, time.Second, nowMs) {
+				n++
+			}
+		}
+		return n
+	}
+
+	if got := count(10_000, 15); got != 10 {
+		t.Fatalf("expected the limit of 10 within one window, got %d", got)
+	}
+	// a quarter into the next window, three quarters of the previous 10 count
+	if got := count(11_250, 5); got != 3 {
+		t.Fatalf("expected 3 allowed on top of 7.5 estimated, got %d", got)
+	}
+	// two windows on, nothing is left
+	if got := count(13_000, 15); got != 10 {
+		t.Fatalf("expected a fresh window, got %d", got)
+	}
+
+	c.reset()
+	if got := count(13_000, 15); got != 10 {
+		t.Fatalf("expected a fresh window after reset, got %d", got)
+	}
+}
@@ -0,0 +1,120 @@
+package limiter
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+var maxBankedCredit atomic.Int64 // 0: credit banking off
+
+// EnableCredit turns on credit banking for daily quotas: allowance left
+// unused when a day ends rolls over into the next day's limit, up to
+// maxBanked extra requests, like rollover data. A day's unused allowance
+// includes the credit it was given, so days using less than their limit let
+// credit build up to the cap. A day without any requests (including the day
+// before a user's first) banks just its limit, however much credit it had. Credit is figured from the user's limit on the day
+// it is used. With Redis, each day's count is kept a day longer so the next
+// day can read it. maxBanked <= 0 turns banking off; credit already granted
+// for today is then ignored.
+func EnableCredit(maxBanked int) {
+	maxBankedCredit.Store(int64(max(maxBanked, 0)))
+}
+
+// BankedCredit returns the credit added to userID's daily quota today, or,
+// if they have not made a request yet today, the credit they would be given
+// under their configured (or tier, or default) limit.
+func BankedCredit(userID string) int {
+	return bankedCredit(transformKey(userID))
+}
+
+// bankedCredit is BankedCredit for a key already transformed (see
+// SetKeyTransformer).
+func bankedCredit(userID string) int {
+	maxBanked := int(maxBankedCredit.Load())
+	if maxBanked <= 0 {
+		return 0
+	}
+	now := timeNow()
+	limit := EffectiveLimit(userID, 0)
+	if rdb != nil {
+		if n, err := rdb.Get(ctx, dailyCreditKey(userID, now)).Int(); err == nil {
+			return n
+		}
+		if limit <= 0 {
+			return 0
+		}
+		yesterday := previousDay(now)
+		count, err := rdb.Get(ctx, dailyKey(userID, yesterday)).Int()
+		if err != nil {
+			return min(limit, maxBanked)
+		}
+		credit, _ := rdb.Get(ctx, dailyCreditKey(userID, yesterday)).Int()
+		return rolledCredit(limit+credit-count, maxBanked)
+	}
+	today := dayKey(now)
+	st, ok := dailyStates.load(userID)
+	if !ok {
+		return min(max(limit, 0), maxBanked)
+	}
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	if st.day == today {
+		return st.credit
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return st.rollover(today, limit, maxBanked)
+}
+
+// rollover returns the credit a day following the state's tracked one starts
+// with: the tracked day's unused allowance if it was the day before, or a
+// quiet day's whole limit otherwise.
+func (st *dailyState) rollover(day string, limit, maxBanked int) int {
+	if st.day == "" || st.day != dayBefore(day) {
+		return min(limit, maxBanked)
+	}
+	return rolledCredit(limit+st.credit-st.count, maxBanked)
+}
+
+// roll starts tracking day, banking credit for it if enabled.
+func (st *dailyState) roll(day string, limit int) {
+	st.credit = 0
+	if maxBanked := int(maxBankedCredit.Load()); maxBanked > 0 && limit > 0 {
+		st.credit = st.rollover(day, limit, maxBanked)
+	}
+	st.day, st.count = day, 0
+}
+
+// allowance returns today's limit plus banked credit, if banking is on.
+func (st *dailyState) allowance(limit int) int {
+	if maxBankedCredit.Load() > 0 {
+		return limit + st.credit
+	}
+	return limit
+}
+
+func rolledCredit(unused, maxBanked int) int {
+	return min(max(unused, 0), maxBanked)
+}
+
+// dayBefore returns the YYYY-MM-DD label of the day before day.
+func dayBefore(day string) string {
+	t, err := time.Parse("2006-01-02", day)
+	if err != nil {
+		return ""
+	}
+	return t.AddDate(0, 0, -1).Format("2006-01-02")
+}
+
+// previousDay returns a time within the calendar day before t's in the quota
+// timezone. Subtracting 24 hours would not do after a 23-hour DST day.
+func previousDay(t time.Time) time.Time {
+	start, _ := dayBounds(t)
+	return start.Add(-time.Nanosecond)
+}
+
+// dailyCreditKey holds the credit banked for a user's day.
+func dailyCreditKey(userID string, now time.Time) string {
+	return "quotacredit:" + redisUserPart(userID) + ":" + dayKey(now)
+}
@@ -0,0 +1,78 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEnableCredit_QuietDayBanksCredit(t *testing.T) {
+	resetLimiterState()
+	SetMode("daily")
+	defer SetMode("sliding")
+	defer func() { timeNow = time.Now }()
+	EnableCredit(3)
+	defer EnableCredit(0)
+
+	user := "banker"
+	SetUserLimit(user, 5)
+	day := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	setTestNow(day)
+	// use 1 of 5: 4 unused, banked up to the cap of 3
+	if !RateLimit(user, 5) {
+		t.Fatal("first request should be allowed")
+	}
+
+	setTestNow(day.AddDate(0, 0, 1))
+	if got := BankedCredit(user); got != 3 {
+		t.Fatalf("expected 3 credits banked (capped), got %d", got)
+	}
+	for i := 1; i <= 8; i++ {
+		if !RateLimit(user, 5) {
+			t.Fatalf("request %d of limit 5 + 3 credits should be allowed", i)
+		}
+	}
+	if RateLimit(user, 5) {
+		t.Fatal("the burst is capped at limit + maxBanked")
+	}
+	if st := Stats(user, 5); st.Limit != 8 || st.Remaining != 0 {
+		t.Fatalf("stats should include the credit, got %+v", st)
+	}
+
+	// a day using its whole allowance banks nothing
+	setTestNow(day.AddDate(0, 0, 2))
+	if got := BankedCredit(user); got != 0 {
+		t.Fatalf("a fully used day should bank nothing, got %d", got)
+	}
+	for i := 1; i <= 5; i++ {
+		RateLimit(user, 5)
+	}
+	if RateLimit(user, 5) {
+		t.Fatal("without credit the plain limit applies")
+	}
+
+	// a day with no requests at all banks its whole limit, capped
+	setTestNow(day.AddDate(0, 0, 4))
+	if got := BankedCredit(user); got != 3 {
+		t.Fatalf("a quiet day should bank up to the cap, got %d", got)
+	}
+}
+
+func TestEnableCredit_OffByDefault(t *testing.T) {
+	resetLimiterState()
+	SetMode("daily")
+	defer SetMode("sliding")
+	defer func() { timeNow = time.Now }()
+
+	day := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	setTestNow(day)
+	RateLimit("saver", 2)
+	setTestNow(day.AddDate(0, 0, 1))
+	RateLimit("saver", 2)
+	RateLimit("saver", 2)
+	if RateLimit("saver", 2) {
+		t.Fatal("unused allowance must not roll over without EnableCredit")
+	}
+	if got := BankedCredit("saver"); got != 0 {
+		t.Fatalf("no credit without EnableCredit, got %d", got)
+	}
+}
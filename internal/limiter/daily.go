@@ -0,0 +1,230 @@
+package limiter
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// daily (calendar) mode: quotas reset at midnight in quotaLocation
+	quotaLocationMu sync.RWMutex
+	quotaLocation   = time.UTC
+
+	dailyStates = newShardedMap[*dailyState](defaultShardCount)
+
+	// timeNow is the clock used by calendar-aligned windows (replaceable in tests)
+	timeNow = time.Now
+)
+
+// dailyState holds a user's in-memory count for the current calendar day
+type dailyState struct {
+	mtx    sync.Mutex
+	day    string // YYYY-MM-DD in quotaLocation
+	count  int
+	credit int // banked for day (see EnableCredit)
+}
+
+// SetTimezone sets the timezone whose midnight resets "daily" quotas (default UTC).
+// A nil location restores UTC.
+func SetTimezone(loc *time.Location) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	quotaLocationMu.Lock()
+	quotaLocation = loc
+	quotaLocationMu.Unlock()
+}
+
+// GetTimezone returns the timezone used for "daily" quotas.
+func GetTimezone() *time.Location {
+	quotaLocationMu.RLock()
+	defer quotaLocationMu.RUnlock()
+	return quotaLocation
+}
+
+// dayBounds returns the start of t's calendar day and the start of the next one
+// in the quota timezone. Days are 23 or 25 hours long across DST transitions.
+func dayBounds(t time.Time) (start, end time.Time) {
+	t = t.In(GetTimezone())
+	y, m, d := t.Date()
+	start = time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	end = time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+	return start, end
+}
+
+// dayKey returns the YYYY-MM-DD label of t's calendar day in the quota timezone.
+func dayKey(t time.Time) string {
+	return t.In(GetTimezone()).Format("2006-01-02")
+}
+
+// ---------- Daily quota (in-memory) ----------
+func rateLimitMemoryDaily(userID string, limit, n int, at time.Time) (bool, receipt) {
+	touchUser(userID)
+	day := dayKey(clockAt(at))
+	st := dailyStates.loadOrStore(userID, func() *dailyState { return &dailyState{} })
+
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	if day < st.day {
+		// a replayed request from a day whose count is gone: allow it
+		// without disturbing the day being tracked
+		return true, receipt{}
+	}
+	if st.day != day {
+		st.roll(day, limit)
+	}
+	if st.count+n > st.allowance(limit) {
+		return false, receipt{}
+	}
+	st.count += n
+	return true, receipt{member: day, cost: n}
+}
+
+// ---------- Daily quota (Redis) ----------
+// dailyScript:
+// KEYS[1] = quota:<user>:<YYYY-MM-DD>
+// ARGV[1] = daily limit
+// ARGV[2] = when the keys expire (unix ms; "0": never)
+// ARGV[3] = units the request costs
+// With credit banking (EnableCredit):
+// KEYS[2] = yesterday's count, KEYS[3] = today's credit, KEYS[4] = yesterday's
+// ARGV[4] = most credit banked
+// Today's credit is figured once, by the day's first request.
+var dailyScript = newScript("daily", luaExpire+`
+	local limit = tonumber(ARGV[1])
+	if #KEYS == 4 then
+		local credit = redis.call("GET", KEYS[3])
+		if not credit then
+			local used = redis.call("GET", KEYS[2])
+			if used then
+				credit = limit + tonumber(redis.call("GET", KEYS[4]) or "0") - tonumber(used)
+			else
+				credit = limit
+			end
+			credit = math.min(math.max(credit, 0), tonumber(ARGV[4]))
+			redis.call("SET", KEYS[3], credit)
+			pexpireat(KEYS[3], ARGV[2])
+		end
+		limit = limit + tonumber(credit)
+	end
+	local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+	if current + tonumber(ARGV[3]) <= limit then
+		redis.call("INCRBY", KEYS[1], ARGV[3])
+		pexpireat(KEYS[1], ARGV[2])
+		return 1
+	end
+	return 0
+`)
+
+func rateLimitRedisDaily(ctx context.Context, userID string, limit, n int, at time.Time) (bool, receipt) {
+	if rdb == nil || limit <= 0 {
+		return false, receipt{}
+	}
+	return dailyCall(userID, limit, n, at).runCtx(ctx)
+}
+
+func dailyCall(userID string, limit, n int, at time.Time) scriptCall {
+	now := clockAt(at)
+	start, end := dayBounds(now)
+	key := dailyKey(userID, now)
+	if !at.IsZero() {
+		// a replayed day may already be over; keep its key for a day from now
+		end = timeNow().Add(end.Sub(start))
+	}
+	maxBanked := maxBankedCredit.Load()
+	if maxBanked > 0 {
+		// tomorrow's first request reads today's count
+		end = end.AddDate(0, 0, 1)
+	}
+	expireAt := strconv.FormatInt(end.UnixMilli(), 10)
+	if persistentKeys() {
+		expireAt = "0"
+	}
+
+	c := scriptCall{
+		script: dailyScript,
+		keys:   []string{key},
+		args: []any{
+			strconv.Itoa(limit),
+			expireAt,
+			strconv.Itoa(n),
+		},
+		rc: receipt{member: key, cost: n},
+	}
+	if maxBanked > 0 {
+		yesterday := previousDay(now)
+		c.keys = append(c.keys, dailyKey(userID, yesterday), dailyCreditKey(userID, now), dailyCreditKey(userID, yesterday))
+		c.args = append(c.args, strconv.FormatInt(maxBanked, 10))
+	}
+	return c
+}
+
+// RemainingQuota reports how many requests userID has left of today's daily
+// quota, without consuming any, and when the quota resets: at midnight in the
+// quota timezone (SetTimezone), when remaining returns to the full limit. The
+// limit is resolved as in RateLimit with no fallback and includes banked
+// credit (EnableCredit). With Redis the count is read from the day's counter;
+// the reset time is figured from the calendar rather than the key's TTL, which
+// SetKeyExpiry and credit banking stretch past midnight.
+func RemainingQuota(userID string) (remaining int, resetAt time.Time) {
+	userID = transformKey(userID)
+	_, resetAt = dayBounds(timeNow())
+	limit := EffectiveLimit(userID, 0)
+	if limit <= 0 {
+		return 0, resetAt
+	}
+	return max(limit+bankedCredit(userID)-peekDailyCount(userID), 0), resetAt
+}
+
+// peekDailyCount returns how much of today's quota the user has used.
+func peekDailyCount(userID string) int {
+	now := timeNow()
+	if rdb != nil {
+		n, err := rdb.Get(ctx, dailyKey(userID, now)).Int()
+		if err != nil {
+			return 0
+		}
+		return n
+	}
+	st, ok := dailyStates.load(userID)
+	if !ok {
+		return 0
+	}
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	if st.day != dayKey(now) {
+		return 0
+	}
+	return st.count
+}
+
+// refundMemoryDaily gives back one request, if the day it was counted in is still current.
+func refundMemoryDaily(userID string, day string, n int) {
+	st, ok := dailyStates.load(userID)
+	if !ok {
+		return
+	}
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	if st.day == day {
+		st.count = max(st.count-n, 0)
+	}
+}
+
+// refundDailyScript decrements quota counter KEYS[1] by ARGV[1] (default 1),
+// never below zero.
+var refundDailyScript = newScript("refund-daily", `
+	local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+	local n = math.min(tonumber(ARGV[1] or "1"), current)
+	if n > 0 then
+		redis.call("DECRBY", KEYS[1], n)
+	end
+	return 0
+`)
+
+// refundRedisDaily gives back n requests to a day's quota key, if it still exists.
+func refundRedisDaily(key string, n int) {
+	refundDailyScript.Run(ctx, rdb, []string{key}, n)
+}
@@ -0,0 +1,129 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func setTestNow(t time.Time) {
+	timeNow = func() time.Time { return t }
+}
+
+func loadNewYork(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("timezone data not available: %v", err)
+	}
+	return loc
+}
+
+func TestDaily_ResetsAtMidnightInTimezone(t *testing.T) {
+	resetLimiterState()
+	SetMode("daily")
+	loc := loadNewYork(t)
+	SetTimezone(loc)
+
+	user := "daily-user"
+	quota := 2
+
+	setTestNow(time.Date(2026, 6, 10, 23, 58, 0, 0, loc))
+	for i := 1; i <= quota; i++ {
+		if !RateLimit(user, quota) {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+	if RateLimit(user, quota) {
+		t.Fatal("quota exhausted: request should be denied")
+	}
+
+	// still the same local day (already the next day in UTC)
+	setTestNow(time.Date(2026, 6, 10, 23, 59, 59, 0, loc))
+	if RateLimit(user, quota) {
+		t.Fatal("request before local midnight should still be denied")
+	}
+
+	setTestNow(time.Date(2026, 6, 11, 0, 0, 1, 0, loc))
+	if !RateLimit(user, quota) {
+		t.Fatal("request after local midnight should be allowed")
+	}
+}
+
+func TestDaily_DSTDayLengths(t *testing.T) {
+	loc := loadNewYork(t)
+	resetLimiterState()
+	SetTimezone(loc)
+
+	// spring forward: 23-hour day
+	start, end := dayBounds(time.Date(2026, 3, 8, 12, 0, 0, 0, loc))
+	if got := end.Sub(start); got != 23*time.Hour {
+		t.Fatalf("spring-forward day: expected 23h, got %v", got)
+	}
+	// fall back: 25-hour day
+	start, end = dayBounds(time.Date(2026, 11, 1, 12, 0, 0, 0, loc))
+	if got := end.Sub(start); got != 25*time.Hour {
+		t.Fatalf("fall-back day: expected 25h, got %v", got)
+	}
+}
+
+func TestDaily_QuotaHoldsAcrossFallBackDay(t *testing.T) {
+	resetLimiterState()
+	SetMode("daily")
+	loc := loadNewYork(t)
+	SetTimezone(loc)
+
+	user := "dst-user"
+	dayStart := time.Date(2026, 11, 1, 0, 0, 0, 0, loc)
+
+	setTestNow(dayStart.Add(time.Minute))
+	if !RateLimit(user, 1) {
+		t.Fatal("first request of the day should be allowed")
+	}
+	// 24h later is still 23:01 local on the 25-hour day
+	setTestNow(dayStart.Add(24*time.Hour + time.Minute))
+	if RateLimit(user, 1) {
+		t.Fatal("24h into a 25h day the quota should still be exhausted")
+	}
+	setTestNow(dayStart.Add(25*time.Hour + time.Minute))
+	if !RateLimit(user, 1) {
+		t.Fatal("quota should reset at the next local midnight")
+	}
+}
+
+func TestRemainingQuota_ResetsAtBoundary(t *testing.T) {
+	resetLimiterState()
+	SetMode("daily")
+	loc := loadNewYork(t)
+	SetTimezone(loc)
+	SetUserLimit("quota-user", 3)
+
+	setTestNow(time.Date(2026, 6, 10, 23, 59, 0, 0, loc))
+	midnight := time.Date(2026, 6, 11, 0, 0, 0, 0, loc)
+	if got, reset := RemainingQuota("quota-user"); got != 3 || !reset.Equal(midnight) {
+		t.Fatalf("fresh user: expected 3 remaining until %v, got %d until %v", midnight, got, reset)
+	}
+	RateLimit("quota-user", 3)
+	RateLimit("quota-user", 3)
+	for i := 0; i < 2; i++ { // peeking consumes nothing
+		if got, _ := RemainingQuota("quota-user"); got != 1 {
+			t.Fatalf("expected 1 remaining, got %d", got)
+		}
+	}
+
+	setTestNow(midnight.Add(-time.Nanosecond))
+	if got, _ := RemainingQuota("quota-user"); got != 1 {
+		t.Fatalf("just before midnight expected 1 remaining, got %d", got)
+	}
+	setTestNow(midnight)
+	got, reset := RemainingQuota("quota-user")
+	if got != 3 {
+		t.Fatalf("at midnight the quota resets to the full limit, got %d", got)
+	}
+	if want := time.Date(2026, 6, 12, 0, 0, 0, 0, loc); !reset.Equal(want) {
+		t.Fatalf("expected the next reset at %v, got %v", want, reset)
+	}
+
+	if got, _ := RemainingQuota("unconfigured"); got != 0 {
+		t.Fatalf("a user without a limit has no quota, got %d", got)
+	}
+}
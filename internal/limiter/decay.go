@@ -0,0 +1,204 @@
+package limiter
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// decayMode is the mode of the exponentially decaying request score.
+const decayMode = "decay"
+
+// decayHalfLifeNs is the half-life set with SetDecayHalfLife; 0 uses the
+// user's window.
+var decayHalfLifeNs atomic.Int64
+
+// decayStates holds the in-memory scores of "decay" users.
+var decayStates = newShardedMap[*decayState](defaultShardCount)
+
+// decayState is one user's decayed request count.
+type decayState struct {
+	mtx    sync.Mutex
+	score  float64 // as of lastMs
+	lastMs int64
+}
+
+// SetDecayHalfLife sets how fast requests stop counting in "decay" mode.
+//
+// In "decay" mode each request adds 1 to the user's score, and the score
+// halves every half-life, so a request counts less the older it is, however
+// long ago it was. A request is denied while the score is at or above the
+// limit, so a user can burst up to the limit and then earns requests back as
+// the score decays: a user quiet for one half-life after a full burst may
+// send limit/2 more. Compared with the leaky bucket, which refills at a steady
+// rate, the score falls fastest right after a burst. The score is kept as one
+// number and its last update, in Redis if InitRedis has been called and in
+// memory otherwise; a Store does not implement it.
+//
+// d <= 0 (the default) uses each user's window as the half-life.
+func SetDecayHalfLife(d time.Duration) {
+	decayHalfLifeNs.Store(int64(max(d, 0)))
+}
+
+// decayHalfLife returns userID's half-life, at least 1ms.
+func decayHalfLife(userID string) time.Duration {
+	d := time.Duration(decayHalfLifeNs.Load())
+	if d == 0 {
+		d = GetUserWindow(userID)
+	}
+	return max(d, time.Millisecond)
+}
+
+// decayed returns score decayed over elapsedMs at halfLife.
+func decayed(score float64, elapsedMs int64, halfLife time.Duration) float64 {
+	if elapsedMs <= 0 {
+		return score
+	}
+	return score * math.Exp2(-float64(elapsedMs)/float64(halfLife.Milliseconds()))
+}
+
+func rateLimitMemoryDecay(userID string, limit int, now int64) (bool, receipt) {
+	touchUser(userID)
+	st := decayStates.loadOrStore(userID, func() *decayState { return &decayState{lastMs: now} })
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	st.advance(now, decayHalfLife(userID))
+	if st.score >= float64(limit) {
+		return false, receipt{}
+	}
+	st.score++
+	return true, receipt{tsMs: now}
+}
+
+// advance decays the score to now; an earlier now (a replay) changes
+// nothing. Caller holds st.mtx.
+func (st *decayState) advance(now int64, halfLife time.Duration) {
+	if now <= st.lastMs {
+		return
+	}
+	st.score = decayed(st.score, now-st.lastMs, halfLife)
+	st.lastMs = now
+}
+
+// adjustMemoryDecay adds delta to userID's score, never below zero.
+func adjustMemoryDecay(userID string, delta float64) {
+	now := monoNowMs()
+	st := decayStates.loadOrStore(userID, func() *decayState { return &decayState{lastMs: now} })
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	st.advance(now, decayHalfLife(userID))
+	st.score = math.Max(st.score+delta, 0)
+}
+
+// decayScript:
+// KEYS[1] = hash {score, last}
+// ARGV[1] = limit, ARGV[2] = half-life (ms), ARGV[3] = key expiry (ms)
+// ARGV[4] = caller-supplied now (ms), or "" to use the server time
+// Returns 1 or 0.
+var decayScript = newScript("decay", luaNowMsArg(4)+luaExpire+`
+	local data = redis.call("HMGET", KEYS[1], "score", "last")
+	local score = tonumber(data[1]) or 0
+	local last = tonumber(data[2]) or now
+	if now > last then
+		score = score * math.pow(2, -(now - last) / tonumber(ARGV[2]))
+		last = now
+	end
+	local allowed = 0
+	if score < tonumber(ARGV[1]) then
+		score = score + 1
+		allowed = 1
+	end
+	redis.call("HSET", KEYS[1], "score", tostring(score), "last", tostring(last))
+	pexpire(KEYS[1], ARGV[3])
+	return allowed
+`)
+
+// decayAdjustScript adds ARGV[1] to the score of KEYS[1], never below zero.
+// ARGV[2] = half-life (ms), ARGV[3] = key expiry (ms)
+var decayAdjustScript = newScript("decay-adjust", luaNowMs+luaExpire+`
+	local data = redis.call("HMGET", KEYS[1], "score", "last")
+	local score = tonumber(data[1]) or 0
+	local last = tonumber(data[2]) or now
+	if now > last then
+		score = score * math.pow(2, -(now - last) / tonumber(ARGV[2]))
+		last = now
+	end
+	score = math.max(score + tonumber(ARGV[1]), 0)
+	redis.call("HSET", KEYS[1], "score", tostring(score), "last", tostring(last))
+	pexpire(KEYS[1], ARGV[3])
+	return 1
+`)
+
+// decayExpiry is how long a score is kept after its last update: until it
+// has halved 20 times, to under a millionth of what it was.
+func decayExpiry(halfLife time.Duration) time.Duration {
+	return keyExpiry(20 * halfLife)
+}
+
+func rateLimitRedisDecay(ctx context.Context, userID string, limit int, at time.Time) (bool, receipt) {
+	if rdb == nil || limit <= 0 {
+		return false, receipt{}
+	}
+	return decayCall(userID, limit, at).runCtx(ctx)
+}
+
+func decayCall(userID string, limit int, at time.Time) scriptCall {
+	halfLife := decayHalfLife(userID)
+	return scriptCall{
+		script: decayScript,
+		keys:   []string{decayKey(userID)},
+		args: []any{
+			strconv.Itoa(limit),
+			strconv.FormatInt(halfLife.Milliseconds(), 10),
+			strconv.FormatInt(decayExpiry(halfLife).Milliseconds(), 10),
+			atArg(at),
+		},
+	}
+}
+
+// adjustRedisDecay adds delta to userID's score in Redis, never below zero.
+func adjustRedisDecay(userID string, delta float64) error {
+	halfLife := decayHalfLife(userID)
+	return decayAdjustScript.Run(ctx, rdb, []string{decayKey(userID)},
+		strconv.FormatFloat(delta, 'f', -1, 64),
+		strconv.FormatInt(halfLife.Milliseconds(), 10),
+		strconv.FormatInt(decayExpiry(halfLife).Milliseconds(), 10),
+	).Err()
+}
+
+// peekDecayScore returns userID's current score, without writing anything.
+func peekDecayScore(userID string) float64 {
+	now := stateNowMs()
+	halfLife := decayHalfLife(userID)
+	if rdb != nil {
+		vals, err := rdb.HMGet(ctx, decayKey(userID), "score", "last").Result()
+		if err != nil || vals[0] == nil || vals[1] == nil {
+			return 0
+		}
+		score, _ := strconv.ParseFloat(vals[0].(string), 64)
+		last, _ := strconv.ParseFloat(vals[1].(string), 64)
+		return decayed(score, now-int64(last), halfLife)
+	}
+	st, ok := decayStates.load(userID)
+	if !ok {
+		return 0
+	}
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	return decayed(st.score, now-st.lastMs, halfLife)
+}
+
+// decayTimeUntilBelow returns how long until userID's score drops below
+// threshold (> 0).
+func decayTimeUntilBelow(userID string, threshold float64) time.Duration {
+	score := peekDecayScore(userID)
+	if score < threshold {
+		return 0
+	}
+	halfLives := math.Log2(score / threshold)
+	ms := math.Floor(halfLives*float64(decayHalfLife(userID).Milliseconds())) + 1
+	return time.Duration(ms) * time.Millisecond
+}
@@ -0,0 +1,77 @@
+package limiter
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDecay_BurstThenQuietDecaysAtHalfLife(t *testing.T) {
+	resetLimiterState()
+	SetMode(decayMode)
+	SetDecayHalfLife(200 * time.Millisecond)
+
+	// replayed timestamps keep the clock still within each burst
+	t0 := time.Now()
+	allowedAt := func(at time.Time) int {
+		n := 0
+		for i := 0; i < 20; i++ {
+			if AllowAt("alice", 10, at) {
+				n++
+			}
+		}
+		return n
+	}
+	if got := allowedAt(t0); got != 10 {
+		t.Fatalf("expected a burst of 10 to fit, got %d", got)
+	}
+
+	// One quiet half-life halves the score from 10 to 5, freeing 5 requests.
+	if got := allowedAt(t0.Add(200 * time.Millisecond)); got != 5 {
+		t.Fatalf("expected 5 after one half-life, got %d", got)
+	}
+
+	// Two more half-lives leave a quarter of the score, 2.5: the next 8
+	// requests bring it to 10.5.
+	if got := allowedAt(t0.Add(600 * time.Millisecond)); got != 8 {
+		t.Fatalf("expected 8 after two more half-lives, got %d", got)
+	}
+}
+
+func TestDecay_TimeUntilReset(t *testing.T) {
+	resetLimiterState()
+	SetMode(decayMode)
+	SetDecayHalfLife(time.Second)
+	SetUserLimit("alice", 8)
+
+	countAllowed("alice", 8, 8)
+	Settle("alice", 9) // the last request cost 9, doubling the score
+	if got := Stats("alice", 0).Used; got < 15 || got > 16 {
+		t.Fatalf("expected a score of about 16 after settling, got %d", got)
+	}
+
+	// The score must halve once to drop below the limit.
+	want := time.Duration(math.Log2(16.0/8) * float64(time.Second))
+	if got := TimeUntilReset("alice"); got < want-50*time.Millisecond || got > want+time.Millisecond {
+		t.Fatalf("expected about %v until the score drops below the limit, got %v", want, got)
+	}
+}
+
+func TestDecay_RefundLowersScore(t *testing.T) {
+	resetLimiterState()
+	SetMode(decayMode)
+	SetDecayHalfLife(time.Hour)
+
+	countAllowed("alice", 3, 2)
+	allowed, refund := RateLimitRefundable("alice", 3)
+	if !allowed {
+		t.Fatal("expected the third request to be allowed")
+	}
+	refund()
+	if got := peekDecayScore("alice"); got < 1.99 || got > 2 {
+		t.Fatalf("expected the refund to take the score back to 2, got %.4f", got)
+	}
+	if !RateLimit("alice", 3) {
+		t.Fatal("the refunded request should leave room for another")
+	}
+}
@@ -0,0 +1,54 @@
+package limiter
+
+import "fmt"
+
+// Description is a snapshot of the limiter's package-wide configuration and
+// of how many users it tracks, as returned by Describe.
+type Description struct {
+	Mode         string `json:"mode"`          // global algorithm (SetMode)
+	Redis        bool   `json:"redis"`         // InitRedis has been called
+	KeyHashing   bool   `json:"key_hashing"`   // Redis keys hash user IDs (SetKeyHashing)
+	DefaultLimit int    `json:"default_limit"` // SetDefaultLimit, 0 if unset
+
+	ConfiguredUsers int `json:"configured_users"` // users with a configured limit
+	SlidingUsers    int `json:"sliding_users"`    // users with in-memory sliding state
+	LeakyUsers      int `json:"leaky_users"`      // users with an in-memory leaky bucket
+	DailyUsers      int `json:"daily_users"`      // users with an in-memory daily count
+}
+
+// Describe reports the limiter's current configuration and the users it
+// tracks, e.g. for a debug endpoint. With Redis, per-user state lives there
+// and the in-memory counts only cover what this instance keeps locally.
+// Nothing is read from Redis.
+func Describe() Description {
+	d := Description{
+		Mode:         GetMode(),
+		Redis:        rdb != nil,
+		KeyHashing:   IsKeyHashing(),
+		DefaultLimit: DefaultLimit(),
+	}
+	ForEachUser(func(string, int) bool {
+		d.ConfiguredUsers++
+		return true
+	})
+	slidingStates.rangeAll(func(string, *slidingState) bool {
+		d.SlidingUsers++
+		return true
+	})
+	leakyBuckets.rangeAll(func(string, *leakyState) bool {
+		d.LeakyUsers++
+		return true
+	})
+	dailyStates.rangeAll(func(string, *dailyState) bool {
+		d.DailyUsers++
+		return true
+	})
+	return d
+}
+
+// String summarizes d on one line.
+func (d Description) String() string {
+	return fmt.Sprintf("mode=%s redis=%t key_hashing=%t default_limit=%d users: configured=%d sliding=%d leaky=%d daily=%d",
+		d.Mode, d.Redis, d.KeyHashing, d.DefaultLimit,
+		d.ConfiguredUsers, d.SlidingUsers, d.LeakyUsers, d.DailyUsers)
+}
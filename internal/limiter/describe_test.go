@@ -0,0 +1,42 @@
+package limiter
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDescribe_ReflectsState(t *testing.T) {
+	resetLimiterState()
+
+	d := Describe()
+	if d.Mode != "sliding" || d.Redis || d.ConfiguredUsers != 0 || d.SlidingUsers != 0 {
+		t.Fatalf("unexpected initial description %+v", d)
+	}
+
+	SetMode("leaky")
+	SetDefaultLimit(7)
+	writeTempConfig(t, "test_users_describe.json", `{"alice":2,"bob":3}`)
+	if err := LoadUserConfigFromJSON("test_users_describe.json"); err != nil {
+		t.Fatal(err)
+	}
+	RateLimit("alice", 2)
+	SetUserMode("bob", "sliding")
+	RateLimit("bob", 3)
+	RateLimit("carol", 3)
+
+	d = Describe()
+	want := Description{Mode: "leaky", DefaultLimit: 7, ConfiguredUsers: 2, SlidingUsers: 1, LeakyUsers: 2}
+	if d != want {
+		t.Fatalf("expected %+v, got %+v", want, d)
+	}
+	if s := d.String(); !strings.Contains(s, "mode=leaky") || !strings.Contains(s, "configured=2") {
+		t.Fatalf("unexpected summary %q", s)
+	}
+
+	InitRedis("127.0.0.1:1", "", 0, WithDialTimeout(100*time.Millisecond))
+	defer func() { rdb = nil }()
+	if !Describe().Redis {
+		t.Fatal("expected Redis to be reported after InitRedis")
+	}
+}
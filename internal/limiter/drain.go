@@ -0,0 +1,39 @@
+package limiter
+
+import "sync/atomic"
+
+// draining selects the users denied while draining; nil when not draining.
+var draining atomic.Pointer[func(userID string) bool]
+
+// Drain stops admitting requests, e.g. on a shutdown signal: until Undrain,
+// RateLimit denies every user regardless of their quota, Allow and
+// AllowResult report ErrDraining (which Middleware answers with 503), Wait
+// returns ErrDraining and Acquire fails. Requests already admitted are
+// unaffected, and Redis stays connected. Unlike turning enforcement off, it
+// applies whatever the quotas say, and draining denials are not counted by
+// DeniedTotal or OnDeny.
+func Drain() {
+	DrainMatching(func(string) bool { return true })
+}
+
+// DrainMatching drains only the users for which match returns true, e.g. one
+// tenant's. It replaces any earlier Drain or DrainMatching; nil is Undrain.
+// match runs on the request path, so it should be fast.
+func DrainMatching(match func(userID string) bool) {
+	if match == nil {
+		draining.Store(nil)
+		return
+	}
+	draining.Store(&match)
+}
+
+// Undrain resumes normal admission after Drain.
+func Undrain() {
+	draining.Store(nil)
+}
+
+// IsDraining reports whether requests of userID are currently denied by Drain.
+func IsDraining(userID string) bool {
+	match := draining.Load()
+	return match != nil && (*match)(userID)
+}
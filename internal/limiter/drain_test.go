@@ -0,0 +1,62 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDrain_DeniesEveryoneUntilUndrain(t *testing.T) {
+	resetLimiterState()
+	AllowList("vip")
+	SetEnforcement(false)
+	defer SetEnforcement(true)
+
+	denied := DeniedTotal()
+	Drain()
+	if RateLimit("fresh-user", 100) {
+		t.Fatal("a fresh user must be denied while draining")
+	}
+	if RateLimit("vip", 100) {
+		t.Fatal("draining must deny allow-listed users too")
+	}
+	if _, err := Allow(context.Background(), "fresh-user", 100); !errors.Is(err, ErrDraining) {
+		t.Fatalf("expected ErrDraining, got %v", err)
+	}
+	if err := Wait(context.Background(), "fresh-user", 100); !errors.Is(err, ErrDraining) {
+		t.Fatalf("expected Wait to return ErrDraining, got %v", err)
+	}
+	if _, ok := Acquire("fresh-user", 1); ok {
+		t.Fatal("Acquire must fail while draining")
+	}
+	if res := AllowResult("fresh-user", 100); HTTPStatusFor(res.Allowed, res.Err) != http.StatusServiceUnavailable {
+		t.Fatalf("expected a draining denial to map to 503, got %+v", res)
+	}
+	if DeniedTotal() != denied {
+		t.Fatal("draining denials are not quota denials")
+	}
+
+	Undrain()
+	SetEnforcement(true)
+	if got := countAllowed("fresh-user", 3, 4); got != 3 {
+		t.Fatalf("after Undrain expected the usual 3 of 4 allowed, got %d", got)
+	}
+}
+
+func TestDrainMatching_DrainsSubset(t *testing.T) {
+	resetLimiterState()
+
+	DrainMatching(func(userID string) bool { return strings.HasPrefix(userID, "tenant-a:") })
+	if RateLimit("tenant-a:alice", 100) {
+		t.Fatal("tenant-a must be drained")
+	}
+	if !RateLimit("tenant-b:bob", 100) {
+		t.Fatal("other tenants keep being admitted")
+	}
+	DrainMatching(nil)
+	if IsDraining("tenant-a:alice") {
+		t.Fatal("DrainMatching(nil) undrains")
+	}
+}
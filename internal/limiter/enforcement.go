@@ -0,0 +1,55 @@
+package limiter
+
+import "sync/atomic"
+
+var (
+	// enforcementOff puts the limiter in observe-only mode (zero value enforces).
+	enforcementOff atomic.Bool
+
+	deniedTotal atomic.Int64
+	onDeny      atomic.Pointer[func(userID string)]
+)
+
+// SetEnforcement turns enforcement on (the default) or off. With enforcement
+// off the limiter runs in observe-only mode: state is updated and would-be
+// denials are counted (DeniedTotal) and reported to the OnDeny callback as
+// usual, but every request is allowed. This lets new limits be measured before
+// they are enforced.
+func SetEnforcement(enabled bool) {
+	enforcementOff.Store(!enabled)
+}
+
+// IsEnforcing reports whether denials are enforced.
+func IsEnforcing() bool {
+	return !enforcementOff.Load()
+}
+
+// OnDeny registers fn to be called with the user ID of every denied request,
+// including would-be denials in observe-only mode. It runs synchronously on
+// the request path, so it should be fast. nil removes the callback.
+func OnDeny(fn func(userID string)) {
+	if fn == nil {
+		onDeny.Store(nil)
+		return
+	}
+	onDeny.Store(&fn)
+}
+
+// DeniedTotal returns how many requests have been denied (or, in observe-only
+// mode, would have been) since the process started.
+func DeniedTotal() int64 {
+	return deniedTotal.Load()
+}
+
+// enforce records a denial and returns the decision to hand back to the
+// caller: allowed, unless enforcement is off.
+func enforce(userID string, allowed bool) bool {
+	if allowed {
+		return true
+	}
+	deniedTotal.Add(1)
+	if fn := onDeny.Load(); fn != nil {
+		(*fn)(userID)
+	}
+	return enforcementOff.Load()
+}
@@ -0,0 +1,62 @@
+package limiter
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestSetEnforcement_ObserveOnly(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	var denials atomic.Int64
+	OnDeny(func(userID string) {
+		if userID == "observed" {
+			denials.Add(1)
+		}
+	})
+
+	SetEnforcement(false)
+	defer SetEnforcement(true)
+	defer OnDeny(nil)
+	before := DeniedTotal()
+	for i := 0; i < 5; i++ {
+		if !RateLimit("observed", 2) {
+			t.Fatalf("request %d: observe-only mode must always allow", i+1)
+		}
+	}
+	if got := DeniedTotal() - before; got != 3 {
+		t.Fatalf("expected 3 would-be denials counted, got %d", got)
+	}
+	if denials.Load() != 3 {
+		t.Fatalf("expected OnDeny for each would-be denial, got %d", denials.Load())
+	}
+	if res := AllowResult("observed", 2); !res.Allowed || res.RetryAfter != 0 {
+		t.Fatalf("observe-only result should be allowed, got %+v", res)
+	}
+
+	SetEnforcement(true)
+	if RateLimit("observed", 2) {
+		t.Fatal("state kept updating while observing, so enforcement should deny at once")
+	}
+	if denials.Load() != 5 {
+		t.Fatalf("enforced denials should be reported too, got %d", denials.Load())
+	}
+}
+
+func TestSetEnforcement_AllowMulti(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	SetEnforcement(false)
+	defer SetEnforcement(true)
+
+	checks := []MultiKey{{Key: "obs-global", Limit: 1}, {Key: "obs-user", Limit: 5}}
+	AllowMulti(checks)
+	before := DeniedTotal()
+	allowed, results := AllowMulti(checks)
+	if !allowed || !results[0] || !results[1] {
+		t.Fatalf("observe-only AllowMulti must allow, got %v %v", allowed, results)
+	}
+	if DeniedTotal()-before != 1 {
+		t.Fatalf("expected the global key's would-be denial to be counted, got %d", DeniedTotal()-before)
+	}
+}
@@ -0,0 +1,42 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Errors returned by Allow and Wait, for use with errors.Is. The underlying
+// cause, if any, is wrapped alongside them.
+var (
+	// ErrBackendUnavailable reports that Redis could not be reached or failed
+	// to evaluate the check; callers may want to degrade or retry.
+	ErrBackendUnavailable = errors.New("limiter: backend unavailable")
+	// ErrInvalidLimit reports a non-positive limit that no default limit
+	// (SetDefaultLimit) or zero-limit policy turns into a usable one.
+	ErrInvalidLimit = errors.New("limiter: invalid limit")
+	// ErrContextCanceled reports that the caller's context ended first. The
+	// context's own error (context.Canceled or DeadlineExceeded) is wrapped too.
+	ErrContextCanceled = errors.New("limiter: context canceled")
+	// ErrDraining reports that the limiter is draining (see Drain) and admits
+	// no new requests.
+	ErrDraining = errors.New("limiter: draining")
+)
+
+// contextError wraps a context's error in ErrContextCanceled.
+func contextError(err error) error {
+	return fmt.Errorf("%w: %w", ErrContextCanceled, err)
+}
+
+// backendError classifies a Redis failure under ctx; a draining denial passes
+// through. Network timeouts also match context.DeadlineExceeded, so only ctx
+// itself decides that the caller gave up.
+func backendError(ctx context.Context, err error) error {
+	if errors.Is(err, ErrDraining) {
+		return err
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return contextError(ctxErr)
+	}
+	return fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+}
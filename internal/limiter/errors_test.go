@@ -0,0 +1,71 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestAllow_InvalidLimit(t *testing.T) {
+	resetLimiterState()
+
+	if _, err := Allow(context.Background(), "err-user", 0); !errors.Is(err, ErrInvalidLimit) {
+		t.Fatalf("limit 0 should fail with ErrInvalidLimit, got %v", err)
+	}
+	SetDefaultLimit(2)
+	if ok, err := Allow(context.Background(), "err-user", 0); err != nil || !ok {
+		t.Fatalf("a default limit makes limit 0 valid, got %v %v", ok, err)
+	}
+	SetDefaultLimit(0)
+	SetZeroLimitPolicy(AllowAll)
+	if ok, err := Allow(context.Background(), "err-user", -1); err != nil || !ok {
+		t.Fatalf("AllowAll makes limit <= 0 unlimited, got %v %v", ok, err)
+	}
+}
+
+func TestAllow_ContextCanceled(t *testing.T) {
+	resetLimiterState()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := Allow(ctx, "err-user", 5)
+	if !errors.Is(err, ErrContextCanceled) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("a done context should fail with ErrContextCanceled, got %v", err)
+	}
+}
+
+func TestAllow_BackendUnavailable(t *testing.T) {
+	resetLimiterState()
+	InitRedis("127.0.0.1:1", "", 0, WithDialTimeout(100*time.Millisecond), func(o *redis.Options) { o.MaxRetries = -1 })
+	defer func() { rdb = nil }()
+
+	ok, err := Allow(context.Background(), "err-user", 5)
+	if ok || !errors.Is(err, ErrBackendUnavailable) {
+		t.Fatalf("an unreachable Redis should deny with ErrBackendUnavailable, got %v %v", ok, err)
+	}
+	if RateLimit("err-user", 5) {
+		t.Fatal("RateLimit should keep failing closed")
+	}
+}
+
+func TestWait_Errors(t *testing.T) {
+	resetLimiterState()
+
+	if err := Wait(context.Background(), "wait-err", 0); !errors.Is(err, ErrInvalidLimit) {
+		t.Fatalf("limit 0 should fail with ErrInvalidLimit, got %v", err)
+	}
+
+	SetUserWindow("wait-err", 10*time.Second)
+	if err := Wait(context.Background(), "wait-err", 1); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := Wait(ctx, "wait-err", 1)
+	if !errors.Is(err, ErrContextCanceled) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("an expired context should fail with ErrContextCanceled, got %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package limiter
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// FailMode is what happens to a request the limiter could not decide because
+// Redis failed or timed out.
+type FailMode int32
+
+const (
+	// FailClosed denies the request (the default), e.g. to keep a login
+	// endpoint shut to brute force while Redis is down.
+	FailClosed FailMode = iota
+	// FailOpen allows the request, e.g. for a read API that should stay up.
+	FailOpen
+)
+
+var failMode atomic.Int32
+
+// SetFailMode sets what happens to requests the limiter could not decide
+// because of a Redis error. Either way the error is still reported (Allow,
+// Result.Err), and the request is not counted as a denial unless it is
+// denied. Draining (see Drain) is not a failure and denies regardless.
+// AllowMulti always fails closed.
+func SetFailMode(mode FailMode) {
+	failMode.Store(int32(mode))
+}
+
+// GetFailMode returns the package-wide fail mode.
+func GetFailMode() FailMode {
+	return FailMode(failMode.Load())
+}
+
+// AllowFailMode is RateLimit with mode, instead of the package-wide one
+// (SetFailMode), applying if Redis fails.
+func AllowFailMode(userID string, limit int, mode FailMode) bool {
+	allowed, _ := rateLimitFail(ctx, transformKey(userID), limit, time.Time{}, mode)
+	return allowed
+}
+
+// failVerdict applies mode to a decision that ended with a backend error.
+func failVerdict(allowed bool, rc receipt, mode FailMode) bool {
+	if rc.err == nil || errors.Is(rc.err, ErrDraining) {
+		return allowed
+	}
+	return mode == FailOpen
+}
@@ -0,0 +1,46 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestFailMode_OnRedisError(t *testing.T) {
+	resetLimiterState()
+	InitRedis("127.0.0.1:1", "", 0, WithDialTimeout(100*time.Millisecond), func(o *redis.Options) { o.MaxRetries = -1 })
+	defer func() { rdb = nil }()
+
+	if RateLimit("login", 5) {
+		t.Fatal("the default is to fail closed")
+	}
+	if !AllowFailMode("reads", 5, FailOpen) {
+		t.Fatal("a fail-open call should be allowed while Redis fails")
+	}
+
+	SetFailMode(FailOpen)
+	if !RateLimit("reads", 5) {
+		t.Fatal("with fail-open set globally, requests should be allowed")
+	}
+	ok, err := Allow(context.Background(), "reads", 5)
+	if !ok || !errors.Is(err, ErrBackendUnavailable) {
+		t.Fatalf("expected an allowed request that still reports the failure, got %v %v", ok, err)
+	}
+	if AllowFailMode("login", 5, FailClosed) {
+		t.Fatal("a fail-closed call should be denied despite the global fail-open")
+	}
+	h := Middleware(MiddlewareOptions{KeyFunc: userKey, Limit: 5})(okHandler)
+	if rec := serve(h, "/?user=reads"); rec.Code != http.StatusOK {
+		t.Fatalf("expected fail-open to let the request through, got %d", rec.Code)
+	}
+
+	Drain()
+	defer Undrain()
+	if RateLimit("reads", 5) {
+		t.Fatal("draining is not a failure and must deny under fail-open")
+	}
+}
@@ -0,0 +1,211 @@
+package limiter
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// globalKey is the sorted set holding every instance's requests for the
+// cluster-wide limit. It has no "rate:" prefix, so no user's key can collide.
+const globalKey = "__global__"
+
+// Fair sharing of the global limit keeps, next to globalKey, each user's
+// requests within the global window, the users active in it (scored by their
+// last request) and their weights.
+const (
+	globalUserKeyPrefix = "__global__:u:"
+	globalActiveKey     = "__global__:active"
+	globalWeightsKey    = "__global__:weights"
+)
+
+// globalLimit is a sliding-window cap on all users' requests combined.
+type globalLimit struct {
+	limit  int
+	window time.Duration
+}
+
+var (
+	globalRedisLimit atomic.Pointer[globalLimit]
+	globalFairness   atomic.Bool
+	globalWeights    = sync.Map{} // map[string]float64
+)
+
+// SetGlobalLimitRedis caps requests from all users combined, across every
+// instance sharing the Redis backend, at limit per window, e.g. to protect a
+// downstream's total capacity. A request must pass both its user's limit and
+// the global one; it is denied when the global window is full even if the user
+// is under their own limit, and a request denied by either limit consumes
+// nothing from the other. Both checks go out in one pipelined round trip; a
+// slot taken from a limit that ends up unused is given back in a second one.
+// The global limit applies only with Redis, and not to AllowMulti. limit <= 0
+// or window < 1ms removes it.
+func SetGlobalLimitRedis(limit int, window time.Duration) {
+	if limit <= 0 || window < time.Millisecond {
+		globalRedisLimit.Store(nil)
+		return
+	}
+	globalRedisLimit.Store(&globalLimit{limit: limit, window: window})
+}
+
+// GetGlobalLimitRedis returns the cluster-wide limit, if one is set.
+func GetGlobalLimitRedis() (limit int, window time.Duration, ok bool) {
+	g := globalRedisLimit.Load()
+	if g == nil {
+		return 0, 0, false
+	}
+	return g.limit, g.window, true
+}
+
+// SetGlobalFairness divides the global limit (SetGlobalLimitRedis) among the
+// users active in its window in proportion to their weights (SetGlobalWeight),
+// instead of first come, first served: a user is denied once they have used
+// their share of the window, even if the global budget is not spent yet. A
+// user alone in the window may use all of it. Deciding a request reads the
+// weights of every active user, so its cost grows with the number of users
+// active within one global window.
+func SetGlobalFairness(enabled bool) {
+	globalFairness.Store(enabled)
+}
+
+// SetGlobalWeight sets userID's weight in the fair division of the global
+// limit (default 1): a user of weight 2 gets twice the share of a user of
+// weight 1. A non-positive weight restores the default.
+func SetGlobalWeight(userID string, weight float64) {
+	if !(weight > 0) || math.IsInf(weight, 1) {
+		globalWeights.Delete(userID)
+		return
+	}
+	globalWeights.Store(userID, weight)
+}
+
+// GetGlobalWeight returns userID's weight in the global limit's fair division.
+func GetGlobalWeight(userID string) float64 {
+	if v, ok := globalWeights.Load(userID); ok {
+		return v.(float64)
+	}
+	return 1
+}
+
+// fairGlobalScript is the global check with fair sharing:
+// KEYS[1] = global requests, KEYS[2] = the user's requests in the global window
+// KEYS[3] = active users (member = user, score = last request ms)
+// KEYS[4] = weights of active users
+// ARGV[1] = window (ms), ARGV[2] = global limit, ARGV[3] = unique member
+// ARGV[4] = key expiry (ms), ARGV[5] = user, ARGV[6] = user's weight
+// ARGV[7] = caller-supplied now (ms), or "" to use the server time
+// A denied request still marks the user active, claiming a share. KEYS[4]
+// holds exactly the active users, so their weights are read whole with
+// HVALS; stale users are dropped in chunks, since Lua cannot unpack an
+// unbounded list into one call's arguments.
+var fairGlobalScript = newScript("fair-global", luaNowMsArg(7)+luaExpire+`
+	local cutoff = now - tonumber(ARGV[1])
+	local limit = tonumber(ARGV[2])
+	redis.call("ZREMRANGEBYSCORE", KEYS[1], 0, cutoff)
+	redis.call("ZREMRANGEBYSCORE", KEYS[2], 0, cutoff)
+	local stale = redis.call("ZRANGEBYSCORE", KEYS[3], 0, cutoff)
+	for i = 1, #stale, 1000 do
+		local j = math.min(i + 999, #stale)
+		redis.call("ZREM", KEYS[3], unpack(stale, i, j))
+		redis.call("HDEL", KEYS[4], unpack(stale, i, j))
+	end
+	redis.call("ZADD", KEYS[3], now, ARGV[5])
+	redis.call("HSET", KEYS[4], ARGV[5], ARGV[6])
+	pexpire(KEYS[3], ARGV[4])
+	pexpire(KEYS[4], ARGV[4])
+
+	local sum = 0
+	for _, w in ipairs(redis.call("HVALS", KEYS[4])) do sum = sum + tonumber(w) end
+	local share = math.max(1, math.floor(limit * tonumber(ARGV[6]) / sum))
+
+	if redis.call("ZCARD", KEYS[1]) >= limit or redis.call("ZCARD", KEYS[2]) >= share then
+		return 0
+	end
+	redis.call("ZADD", KEYS[1], now, ARGV[3])
+	redis.call("ZADD", KEYS[2], now, ARGV[3])
+	pexpire(KEYS[1], ARGV[4])
+	pexpire(KEYS[2], ARGV[4])
+	return 1
+`)
+
+// globalCall prepares the global check for userID's request.
+func globalCall(userID, member string, at time.Time, g globalLimit) scriptCall {
+	if !globalFairness.Load() {
+		return aggregateCall(globalKey, member, at, g)
+	}
+	window := strconv.FormatInt(g.window.Milliseconds(), 10)
+	expiry := strconv.FormatInt(keyExpiry(g.window).Milliseconds(), 10)
+	user := redisUserPart(userID)
+	return scriptCall{
+		script: fairGlobalScript,
+		keys:   []string{globalKey, globalUserKeyPrefix + user, globalActiveKey, globalWeightsKey},
+		args: []any{
+			window, strconv.Itoa(g.limit), member, expiry,
+			user, strconv.FormatFloat(GetGlobalWeight(userID), 'f', -1, 64), atArg(at),
+		},
+	}
+}
+
+// aggregateCall prepares a check of the sliding window at key, shared by all
+// requests the limit g applies to.
+func aggregateCall(key, member string, at time.Time, g globalLimit) scriptCall {
+	return scriptCall{
+		script: slidingScript,
+		keys:   []string{key},
+		args: []any{
+			strconv.FormatInt(g.window.Milliseconds(), 10),
+			strconv.Itoa(g.limit),
+			member,
+			strconv.FormatInt(keyExpiry(g.window).Milliseconds(), 10),
+			"0",
+			atArg(at),
+		},
+		rc: receipt{member: member},
+	}
+}
+
+// rateLimitRedisGlobal checks the user's limit and the global limit together.
+func rateLimitRedisGlobal(ctx context.Context, userID string, limit, n int, mode string, at time.Time, g globalLimit) (bool, receipt) {
+	user := redisCall(userID, limit, n, mode, at)
+	member := strconv.FormatInt(timeNow().UnixNano(), 10)
+	global := globalCall(userID, member, at, g)
+
+	pipe := rdb.Pipeline()
+	userCmd := user.script.EvalSha(ctx, pipe, user.keys, user.args...)
+	globalCmd := global.script.EvalSha(ctx, pipe, global.keys, global.args...)
+	pipe.Exec(ctx)
+	userOK, rc := pipelinedResult(ctx, user, userCmd)
+	globalOK, grc := pipelinedResult(ctx, global, globalCmd)
+
+	switch {
+	case userOK && !globalOK:
+		rc.mode, rc.limit, rc.redis = mode, limit, true
+		refundReceipt(userID, rc)
+		// the denial was the global window's; report no user usage for it
+		return false, receipt{err: grc.err, reason: ReasonGlobalCap}
+	case !userOK && globalOK:
+		// give back the global slot and, with fair sharing, the user's slice
+		for _, key := range global.keys[:min(len(global.keys), 2)] {
+			rdb.ZRem(ctx, key, member)
+		}
+	}
+	return userOK && globalOK, rc
+}
+
+// pipelinedResult decodes a call's pipelined reply, evaluating it on its own
+// if the script was not loaded.
+func pipelinedResult(ctx context.Context, c scriptCall, cmd *redis.Cmd) (bool, receipt) {
+	res, err := cmd.Result()
+	switch {
+	case redis.HasErrorPrefix(err, "NOSCRIPT"):
+		return c.runCtx(ctx)
+	case err != nil:
+		return false, receipt{err: err}
+	}
+	return c.decode(res)
+}
@@ -0,0 +1,48 @@
+package limiter
+
+import (
+	"math"
+	"sync"
+)
+
+var userGrace = sync.Map{} // map[string]int (extra requests allowed past the limit)
+
+// SetUserGrace gives userID a soft limit: up to grace requests beyond their
+// limit are still allowed, so the hard cap becomes limit + grace. Requests
+// served from the grace allowance are flagged in Result.Grace, e.g. to log
+// overage for upsell prompts. Grace does not apply to multi-window rules.
+// grace <= 0 removes it.
+func SetUserGrace(userID string, grace int) {
+	if grace <= 0 {
+		userGrace.Delete(userID)
+		return
+	}
+	userGrace.Store(userID, grace)
+}
+
+// GetUserGrace returns the user's grace allowance (0 if none).
+func GetUserGrace(userID string) int {
+	v, ok := userGrace.Load(userID)
+	if !ok {
+		return 0
+	}
+	return v.(int)
+}
+
+// usedAfter returns how much of a window (of size limit) is used after a
+// decision: reported by the decision itself for sliding, peeked for
+// the other algorithms.
+func usedAfter(userID, mode string, limit int, rc receipt) int {
+	if rc.usageKnown {
+		return rc.used
+	}
+	switch mode {
+	case "leaky":
+		return limit - int(math.Floor(peekLeakyTokens(userID, limit, GetUserWindow(userID))))
+	case "daily":
+		return peekDailyCount(userID)
+	case decayMode:
+		return int(math.Floor(peekDecayScore(userID)))
+	}
+	return 0
+}
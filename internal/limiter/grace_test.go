@@ -0,0 +1,46 @@
+package limiter
+
+import "testing"
+
+func TestSetUserGrace_WithinThenGraceThenDenied(t *testing.T) {
+	for _, mode := range []string{"sliding", "leaky", "daily"} {
+		resetLimiterState()
+		SetMode(mode)
+
+		user := "grace-" + mode
+		SetUserGrace(user, 2)
+		for i := 1; i <= 3; i++ {
+			res := AllowResult(user, 3)
+			if !res.Allowed || res.Grace {
+				t.Fatalf("%s: request %d should be within the limit: %+v", mode, i, res)
+			}
+		}
+		for i := 1; i <= 2; i++ {
+			res := AllowResult(user, 3)
+			if !res.Allowed || !res.Grace {
+				t.Fatalf("%s: grace request %d should be allowed as grace: %+v", mode, i, res)
+			}
+			if res.Limit != 3 || res.Remaining != 0 {
+				t.Fatalf("%s: grace request should report the base limit with nothing remaining: %+v", mode, res)
+			}
+		}
+		if res := AllowResult(user, 3); res.Allowed {
+			t.Fatalf("%s: request past limit+grace should be denied: %+v", mode, res)
+		}
+	}
+}
+
+func TestSetUserGrace_RemoveRestoresHardLimit(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	SetUserGrace("g", 2)
+	if GetUserGrace("g") != 2 {
+		t.Fatal("grace should be stored")
+	}
+	SetUserGrace("g", 0)
+	if GetUserGrace("g") != 0 {
+		t.Fatal("zero grace should remove it")
+	}
+	assertAllowance(t, "g", 100)
+}
@@ -0,0 +1,111 @@
+package limiter
+
+import (
+	"math"
+	"slices"
+	"sync/atomic"
+)
+
+// historySize is the per-user request history capacity; 0 disables history.
+var historySize atomic.Int64
+
+// SetHistorySize keeps a rolling history of the last n request timestamps
+// (unix ms) per user, for debugging bursty traffic. Every request that
+// reaches the in-memory sliding-window algorithm is recorded, allowed or
+// denied; allow/deny-listed users and the Redis and leaky backends are not.
+// Resizing keeps the newest entries that still fit. n <= 0 disables history
+// and releases it (the default).
+func SetHistorySize(n int) {
+	if n < 0 {
+		n = 0
+	}
+	historySize.Store(int64(n))
+}
+
+// History returns the user's recorded request timestamps (unix ms), oldest
+// first. It returns nil if history is disabled or nothing was recorded.
+func History(userID string) []int64 {
+	return historyOf(transformKey(userID))
+}
+
+// historyOf is History for a key already transformed (see SetKeyTransformer).
+func historyOf(userID string) []int64 {
+	st, ok := slidingStates.load(userID)
+	if !ok {
+		return nil
+	}
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	return st.historyOrdered()
+}
+
+// recordHistory appends now to the state's history ring, resizing it if
+// SetHistorySize changed. Caller holds st.mtx.
+func (st *slidingState) recordHistory(now int64) {
+	size := int(historySize.Load())
+	if size == 0 {
+		st.hist, st.histNext, st.histLen = nil, 0, 0
+		return
+	}
+	if len(st.hist) != size {
+		kept := st.historyOrdered()
+		if len(kept) > size {
+			kept = kept[len(kept)-size:]
+		}
+		st.hist = make([]int64, size)
+		st.histLen = copy(st.hist, kept)
+		st.histNext = st.histLen % size
+	}
+	st.hist[st.histNext] = now
+	st.histNext = (st.histNext + 1) % size
+	if st.histLen < size {
+		st.histLen++
+	}
+}
+
+// historyOrdered copies the history ring out oldest first. Caller holds st.mtx.
+func (st *slidingState) historyOrdered() []int64 {
+	if st.histLen == 0 {
+		return nil
+	}
+	out := make([]int64, st.histLen)
+	start := st.histNext - st.histLen
+	if start < 0 {
+		start += len(st.hist)
+	}
+	for i := range out {
+		out[i] = st.hist[(start+i)%len(st.hist)]
+	}
+	return out
+}
+
+// suggestHeadroom is the margin SuggestLimit adds over the observed rate.
+const suggestHeadroom = 1.25
+
+// SuggestLimit suggests a limit for userID from their recorded history (see
+// SetHistorySize): the history is cut into consecutive windows of the user's
+// window length, and the suggestion is the given percentile (in (0, 100],
+// e.g. 99) of the requests per window, plus 25% headroom, rounded up. Windows
+// without requests between the first and last recorded one count as zero.
+// The suggestion is advisory: it reflects only the requests the history
+// holds, denied ones included, and is not applied. It returns 0 without
+// history or for a percentile out of range.
+func SuggestLimit(userID string, percentile float64) int {
+	if !(percentile > 0 && percentile <= 100) {
+		return 0
+	}
+	userID = transformKey(userID)
+	hist := historyOf(userID)
+	if len(hist) == 0 {
+		return 0
+	}
+	window := GetUserWindow(userID).Milliseconds()
+	counts := make([]int, (hist[len(hist)-1]-hist[0])/window+1)
+	for _, ts := range hist {
+		counts[(ts-hist[0])/window]++
+	}
+	slices.Sort(counts)
+	// nearest rank
+	rank := int(math.Ceil(percentile / 100 * float64(len(counts))))
+	return int(math.Ceil(float64(counts[rank-1]) * suggestHeadroom))
+}
@@ -0,0 +1,143 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistory_RecordsAllowedAndDenied(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	SetHistorySize(10)
+	defer SetHistorySize(0)
+
+	user := "hist-user"
+	before := time.Now().UnixMilli()
+	RateLimit(user, 2)
+	RateLimit(user, 2)
+	RateLimit(user, 2) // denied, still recorded
+	after := time.Now().UnixMilli()
+
+	h := History(user)
+	if len(h) != 3 {
+		t.Fatalf("expected 3 recorded requests, got %d: %v", len(h), h)
+	}
+	for i, ts := range h {
+		if ts < before || ts > after {
+			t.Fatalf("entry %d = %d outside [%d, %d]", i, ts, before, after)
+		}
+		if i > 0 && ts < h[i-1] {
+			t.Fatalf("history not oldest-first: %v", h)
+		}
+	}
+}
+
+func TestHistory_WrapsAtCapacity(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	SetHistorySize(3)
+	defer SetHistorySize(0)
+
+	user := "hist-wrap"
+	var stamps []int64
+	for i := 0; i < 5; i++ {
+		stamps = append(stamps, time.Now().UnixMilli())
+		RateLimit(user, 100)
+		time.Sleep(3 * time.Millisecond)
+	}
+
+	h := History(user)
+	if len(h) != 3 {
+		t.Fatalf("expected history capped at 3, got %d: %v", len(h), h)
+	}
+	// the two oldest requests were overwritten
+	if h[0] < stamps[2] || h[2] < stamps[4] {
+		t.Fatalf("expected the last 3 requests %v, got %v", stamps[2:], h)
+	}
+	for i := 1; i < len(h); i++ {
+		if h[i] <= h[i-1] {
+			t.Fatalf("history not strictly oldest-first: %v", h)
+		}
+	}
+
+	// shrinking keeps the newest entries
+	SetHistorySize(2)
+	RateLimit(user, 100)
+	if got := History(user); len(got) != 2 || got[0] != h[2] {
+		t.Fatalf("after resize expected [%d, <new>], got %v", h[2], got)
+	}
+}
+
+func TestHistory_OffByDefault(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	RateLimit("quiet", 5)
+	if h := History("quiet"); h != nil {
+		t.Fatalf("history should be disabled by default, got %v", h)
+	}
+	if h := History("unknown"); h != nil {
+		t.Fatalf("unknown user should have no history, got %v", h)
+	}
+}
+
+func TestSuggestLimit_PercentileOfWindowCounts(t *testing.T) {
+	resetLimiterState()
+	SetHistorySize(6000)
+	defer SetHistorySize(0)
+	SetUserWindow("alice", time.Second)
+
+	// window i (0-based) of 100 holds i+1 requests
+	st := slidingStates.loadOrStore("alice", newSlidingState)
+	st.mtx.Lock()
+	for i := int64(0); i < 100; i++ {
+		for j := int64(0); j <= i; j++ {
+			st.recordHistory(1_000_000 + i*1000 + j)
+		}
+	}
+	st.mtx.Unlock()
+
+	cases := []struct {
+		percentile float64
+		want       int
+	}{
+		{99, 124}, // p99 = 99, plus 25%
+		{50, 63},  // p50 = 50, plus 25%, rounded up
+		{100, 125},
+		{0.5, 2},
+	}
+	for _, c := range cases {
+		if got := SuggestLimit("alice", c.percentile); got != c.want {
+			t.Fatalf("p%v: expected %d, got %d", c.percentile, c.want, got)
+		}
+	}
+	if got := SuggestLimit("alice", 0); got != 0 {
+		t.Fatalf("an out-of-range percentile should suggest nothing, got %d", got)
+	}
+	if got := SuggestLimit("bob", 99); got != 0 {
+		t.Fatalf("no history should suggest nothing, got %d", got)
+	}
+}
+
+func TestSuggestLimit_CountsIdleWindows(t *testing.T) {
+	resetLimiterState()
+	SetHistorySize(100)
+	defer SetHistorySize(0)
+
+	st := slidingStates.loadOrStore("alice", newSlidingState)
+	st.mtx.Lock()
+	// a burst of 10 in the first second, then one request 9s later
+	for j := int64(0); j < 10; j++ {
+		st.recordHistory(5000 + j)
+	}
+	st.recordHistory(14_000)
+	st.mtx.Unlock()
+
+	// counts are 10, 0 x8, 1: the median window is idle
+	if got := SuggestLimit("alice", 50); got != 0 {
+		t.Fatalf("expected p50 of 0, got %d", got)
+	}
+	if got := SuggestLimit("alice", 100); got != 13 {
+		t.Fatalf("expected p100 of 10 plus headroom, got %d", got)
+	}
+}
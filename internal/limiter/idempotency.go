@@ -0,0 +1,138 @@
+package limiter
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// defaultIdempotencyTTL is how long a request ID's decision is remembered.
+	defaultIdempotencyTTL = time.Minute
+	// maxIdempotencyKeys bounds the request IDs remembered in memory.
+	maxIdempotencyKeys = 100_000
+)
+
+// idemEntry is a remembered decision; done is closed once allowed is set.
+type idemEntry struct {
+	key       string
+	allowed   bool
+	expiresMs int64
+	done      chan struct{}
+}
+
+var (
+	idempotencyTTL atomic.Int64 // ms
+
+	idemMu    sync.Mutex
+	idemList  = list.New()                 // front = most recently seen
+	idemIndex = map[string]*list.Element{} // CompositeKey(userID, requestID) -> element
+)
+
+func init() { idempotencyTTL.Store(defaultIdempotencyTTL.Milliseconds()) }
+
+// SetIdempotencyTTL sets how long AllowIdempotent remembers a request ID
+// (default 1 minute). It should cover the longest a client keeps retrying one
+// request. d < 1ms restores the default.
+func SetIdempotencyTTL(d time.Duration) {
+	if d < time.Millisecond {
+		d = defaultIdempotencyTTL
+	}
+	idempotencyTTL.Store(d.Milliseconds())
+}
+
+// AllowIdempotent is RateLimit for a request the client identifies with
+// requestID, so retries of it are charged once: the first call with a given
+// userID and requestID decides and consumes as RateLimit does, and repeats
+// within the TTL (SetIdempotencyTTL) return that decision without consuming
+// anything. A repeat arriving while the first call is still being decided
+// waits for it in memory; with Redis it is denied rather than charged. A
+// decision that failed (e.g. Redis was unreachable) is not remembered, so a
+// retry is decided afresh.
+//
+// Request IDs are remembered in Redis if InitRedis has been called, so
+// retries landing on another instance are recognized too, and otherwise in
+// memory, where only the most recently seen 100,000 are kept.
+func AllowIdempotent(userID, requestID string, limit int) bool {
+	userID = transformKey(userID)
+	if rdb != nil {
+		return allowIdempotentRedis(userID, requestID, limit)
+	}
+	return allowIdempotentMemory(userID, requestID, limit)
+}
+
+func allowIdempotentMemory(userID, requestID string, limit int) bool {
+	key := CompositeKey(userID, requestID)
+	now := monoNowMs()
+
+	idemMu.Lock()
+	if el, ok := idemIndex[key]; ok {
+		e := el.Value.(*idemEntry)
+		if now < e.expiresMs {
+			idemList.MoveToFront(el)
+			idemMu.Unlock()
+			<-e.done
+			return e.allowed
+		}
+		idemList.Remove(el)
+		delete(idemIndex, key)
+	}
+	e := &idemEntry{key: key, expiresMs: now + idempotencyTTL.Load(), done: make(chan struct{})}
+	el := idemList.PushFront(e)
+	idemIndex[key] = el
+	for idemList.Len() > maxIdempotencyKeys {
+		oldest := idemList.Back()
+		idemList.Remove(oldest)
+		delete(idemIndex, oldest.Value.(*idemEntry).key)
+	}
+	idemMu.Unlock()
+
+	allowed, rc := rateLimit(userID, limit)
+	e.allowed = allowed
+	close(e.done)
+	if rc.err != nil {
+		idemMu.Lock()
+		if idemIndex[key] == el {
+			idemList.Remove(el)
+			delete(idemIndex, key)
+		}
+		idemMu.Unlock()
+	}
+	return allowed
+}
+
+// idemPending marks a request ID whose first call is still being decided.
+const idemPending = "pending"
+
+// idemKey is the Redis key remembering userID's requestID. The pair is
+// joined as by CompositeKey, so no other pair shares it, and hashed like a
+// user ID when too long.
+func idemKey(userID, requestID string) string {
+	return "idem:" + redisUserPart(CompositeKey(userID, requestID))
+}
+
+func allowIdempotentRedis(userID, requestID string, limit int) bool {
+	key := idemKey(userID, requestID)
+	ttl := time.Duration(idempotencyTTL.Load()) * time.Millisecond
+	first, err := rdb.SetNX(ctx, key, idemPending, ttl).Result()
+	if err != nil {
+		allowed, _ := rateLimit(userID, limit)
+		return allowed
+	}
+	if !first {
+		return rdb.Get(ctx, key).Val() == "1"
+	}
+	allowed, rc := rateLimit(userID, limit)
+	switch {
+	case rc.err != nil:
+		rdb.Del(ctx, key)
+	case allowed:
+		rdb.SetArgs(ctx, key, "1", redis.SetArgs{Mode: "XX", KeepTTL: true})
+	default:
+		rdb.SetArgs(ctx, key, "0", redis.SetArgs{Mode: "XX", KeepTTL: true})
+	}
+	return allowed
+}
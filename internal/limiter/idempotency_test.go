@@ -0,0 +1,44 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllowIdempotent_RepeatConsumesOnce(t *testing.T) {
+	resetLimiterState()
+
+	if !AllowIdempotent("idem-user", "req-1", 2) {
+		t.Fatal("first request should be allowed")
+	}
+	if !AllowIdempotent("idem-user", "req-1", 2) {
+		t.Fatal("a retry must return the original decision")
+	}
+	if st := Stats("idem-user", 2); st.Used != 1 {
+		t.Fatalf("expected one slot consumed, got %d", st.Used)
+	}
+	if !AllowIdempotent("idem-user", "req-2", 2) {
+		t.Fatal("a new request ID takes the second slot")
+	}
+	if AllowIdempotent("idem-user", "req-3", 2) {
+		t.Fatal("the limit applies to distinct request IDs")
+	}
+	if AllowIdempotent("idem-user", "req-3", 2) {
+		t.Fatal("a retry of a denied request stays denied")
+	}
+	if !AllowIdempotent("other-user", "req-1", 2) {
+		t.Fatal("request IDs are per user")
+	}
+}
+
+func TestAllowIdempotent_ForgetsAfterTTL(t *testing.T) {
+	resetLimiterState()
+	SetIdempotencyTTL(20 * time.Millisecond)
+
+	AllowIdempotent("idem-ttl", "req-1", 5)
+	time.Sleep(30 * time.Millisecond)
+	AllowIdempotent("idem-ttl", "req-1", 5)
+	if st := Stats("idem-ttl", 5); st.Used != 2 {
+		t.Fatalf("a repeat after the TTL is a new request, expected 2 used, got %d", st.Used)
+	}
+}
@@ -0,0 +1,41 @@
+package limiter
+
+import "sync/atomic"
+
+// instanceCount is the fleet size declared with SetInstanceCount (0 or 1: a
+// single instance).
+var instanceCount atomic.Int64
+
+// SetInstanceCount declares that n instances of the application share the
+// traffic while each limits in memory. Every instance then enforces its own
+// copy of each user's limit, so a user spread over the fleet is admitted up to
+// n times their limit; with the count set, per-user limits are divided by n
+// (rounded up, at least 1), so the fleet approximates the intended limit
+// without Redis. The approximation holds when a user's requests are spread
+// evenly; a user pinned to one instance gets only limit/n. Limits are not
+// divided once InitRedis or SetStore is used, since the state is then shared.
+// n <= 1 restores single-instance behavior.
+func SetInstanceCount(n int) {
+	instanceCount.Store(int64(max(n, 0)))
+}
+
+// InstanceFactor returns the number per-user limits are currently divided by:
+// the count from SetInstanceCount while limiting in memory, and 1 otherwise.
+// Multiplied by EffectiveLimit, it approximates the capacity a user has
+// across the fleet.
+func InstanceFactor() int {
+	n := int(instanceCount.Load())
+	if n <= 1 || rdb != nil || store.Load() != nil {
+		return 1
+	}
+	return n
+}
+
+// perInstance divides limit by InstanceFactor, rounding up.
+func perInstance(limit int) int {
+	n := InstanceFactor()
+	if n == 1 {
+		return limit
+	}
+	return (limit + n - 1) / n
+}
@@ -0,0 +1,33 @@
+package limiter
+
+import "testing"
+
+func TestSetInstanceCount_QuartersLimit(t *testing.T) {
+	resetLimiterState()
+	SetUserLimit("fleet-user", 100)
+
+	SetInstanceCount(4)
+	if got := InstanceFactor(); got != 4 {
+		t.Fatalf("expected instance factor 4, got %d", got)
+	}
+	if got := EffectiveLimit("fleet-user", 0); got != 25 {
+		t.Fatalf("expected the effective limit quartered to 25, got %d", got)
+	}
+	if got := countAllowed("fleet-user", 100, 100); got != 25 {
+		t.Fatalf("expected 25 allowed on this instance, got %d", got)
+	}
+	if got := EffectiveLimit("small", 3); got != 1 {
+		t.Fatalf("divided limits round up to at least 1, got %d", got)
+	}
+
+	SetStore(MemoryStore())
+	if InstanceFactor() != 1 || EffectiveLimit("fleet-user", 0) != 100 {
+		t.Fatal("limits are not divided when state is shared through a store")
+	}
+	SetStore(nil)
+
+	SetInstanceCount(1)
+	if got := EffectiveLimit("fleet-user", 0); got != 100 {
+		t.Fatalf("a single instance enforces the full limit, got %d", got)
+	}
+}
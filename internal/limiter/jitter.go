@@ -0,0 +1,50 @@
+package limiter
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// retryJitter holds the jitter fraction as float64 bits; 0 disables jitter.
+var retryJitter atomic.Uint64
+
+// SetRetryJitter spreads out retries from clients throttled at the same
+// moment by adding up to fraction*RetryAfter of extra delay to the reported
+// RetryAfter. The jitter is pseudo-random but seeded by the key and the
+// current window, so a key sees a stable value within a window. Jitter only
+// ever adds delay, so the reported wait is never shorter than the true one.
+// fraction <= 0 disables jitter (the default).
+func SetRetryJitter(fraction float64) {
+	if !(fraction > 0) || math.IsInf(fraction, 1) {
+		fraction = 0
+	}
+	retryJitter.Store(math.Float64bits(fraction))
+}
+
+// jitterRetryAfter adds the key's jitter to base.
+func jitterRetryAfter(userID string, base time.Duration) time.Duration {
+	fraction := math.Float64frombits(retryJitter.Load())
+	if fraction == 0 || base <= 0 {
+		return base
+	}
+	slot := monoNowMs() / GetUserWindow(userID).Milliseconds()
+	return base + time.Duration(jitterUnit(userID, slot)*fraction*float64(base))
+}
+
+// jitterUnit maps (key, slot) to a value in [0, 1) with FNV-1a and a
+// splitmix64 finalizer.
+func jitterUnit(key string, slot int64) float64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	h ^= uint64(slot)
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return float64(h>>11) / (1 << 53)
+}
@@ -0,0 +1,60 @@
+package limiter
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRetryJitter_StaysWithinBounds(t *testing.T) {
+	resetLimiterState()
+	SetRetryJitter(0.5)
+	defer SetRetryJitter(0)
+
+	base := 2 * time.Second
+	upper := base + base/2
+	distinct := map[time.Duration]bool{}
+	for i := 0; i < 200; i++ {
+		user := "jitter-" + strconv.Itoa(i)
+		SetUserWindow(user, time.Hour) // same window slot for the whole test
+		got := jitterRetryAfter(user, base)
+		if got < base || got > upper {
+			t.Fatalf("%s: jittered %v outside [%v, %v]", user, got, base, upper)
+		}
+		if again := jitterRetryAfter(user, base); again != got {
+			t.Fatalf("%s: jitter not stable within a window: %v then %v", user, got, again)
+		}
+		distinct[got] = true
+	}
+	if len(distinct) < 100 {
+		t.Fatalf("jitter should spread keys out, got only %d distinct values", len(distinct))
+	}
+}
+
+func TestRetryJitter_DisabledByDefault(t *testing.T) {
+	resetLimiterState()
+
+	if got := jitterRetryAfter("u", time.Second); got != time.Second {
+		t.Fatalf("expected no jitter by default, got %v", got)
+	}
+	SetRetryJitter(-1)
+	if got := jitterRetryAfter("u", time.Second); got != time.Second {
+		t.Fatalf("negative fraction should disable jitter, got %v", got)
+	}
+}
+
+func TestAllowResult_JitterNeverUnderReports(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	SetRetryJitter(1)
+
+	user := "jitter-result"
+	AllowResult(user, 1)
+	res := AllowResult(user, 1)
+	if res.Allowed {
+		t.Fatal("second request should be denied")
+	}
+	if base := timeUntilAvailable(user, 1); res.RetryAfter < base || res.RetryAfter > 2*time.Second {
+		t.Fatalf("jittered retry %v should lie in [%v, 2s]", res.RetryAfter, base)
+	}
+}
@@ -0,0 +1,208 @@
+package limiter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	keySeparator = ':'
+	keyEscape    = '\\'
+)
+
+// CompositeKey joins identity parts (e.g. tenant, user, endpoint) into a single
+// key suitable for use as the userID argument of RateLimit.
+// Separators and escape characters inside parts are escaped, so distinct part
+// lists never produce the same key: CompositeKey("a:b", "c") != CompositeKey("a", "b:c").
+func CompositeKey(parts ...string) string {
+	var b strings.Builder
+	for i, p := range parts {
+		if i > 0 {
+			b.WriteByte(keySeparator)
+		}
+		for j := 0; j < len(p); j++ {
+			if p[j] == keySeparator || p[j] == keyEscape {
+				b.WriteByte(keyEscape)
+			}
+			b.WriteByte(p[j])
+		}
+	}
+	return b.String()
+}
+
+// EndpointClassKey is the key of user's bucket for a class of endpoints: the
+// requests whose path starts with pathPrefix, and whose method is method unless
+// method is empty. Requests of the same class share one bucket, separate from
+// the user's other classes. It is a CompositeKey, so classes of distinct users
+// never share a key; it can still equal a raw key containing a separator, so
+// build plain user keys with CompositeKey too when users choose their IDs.
+func EndpointClassKey(user, method, pathPrefix string) string {
+	return CompositeKey(user, method, pathPrefix)
+}
+
+// keyTransformer is the function set with SetKeyTransformer, if any.
+var keyTransformer atomic.Pointer[func(userID string) string]
+
+// SetKeyTransformer makes the limiter key each request by fn(userID) instead
+// of userID, e.g. to prepend a tenant or fold case. It applies on entry to
+// every call that decides requests (RateLimit, Allow, AllowResult, Wait,
+// EnqueueLeaky, Acquire, AllowMulti, ...) or reads or adjusts usage (Stats,
+// TimeUntilReset, Settle, InspectLeaky, WarmLeaky, ResetByPrefix, ...), and
+// to the users named in AdminHandler paths, so they all see the same state;
+// lists, callbacks and per-user settings (SetUserLimit, SetUserMode, ...)
+// then apply to the transformed key, under which they must be set. fn runs
+// on every request and must be deterministic and consistent across
+// instances. nil removes it.
+func SetKeyTransformer(fn func(userID string) string) {
+	if fn == nil {
+		keyTransformer.Store(nil)
+		return
+	}
+	keyTransformer.Store(&fn)
+}
+
+// transformKey applies the SetKeyTransformer function to userID.
+func transformKey(userID string) string {
+	if fn := keyTransformer.Load(); fn != nil {
+		return (*fn)(userID)
+	}
+	return userID
+}
+
+// ParseCompositeKey reverses CompositeKey, returning the original parts.
+// A key without separators yields a single part (an empty key yields [""]).
+func ParseCompositeKey(key string) []string {
+	var parts []string
+	var cur strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case c == keyEscape && i+1 < len(key):
+			i++
+			cur.WriteByte(key[i])
+		case c == keySeparator:
+			parts = append(parts, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	return append(parts, cur.String())
+}
+
+// Redis keys embed the user ID after a fixed prefix ("rate:", "bucket:",
+// "quota:", "rules:"). Colons in IDs are harmless there, since prefixes are
+// fixed and the suffixes have fixed formats: the daily quota's date, and a
+// rule's window, under a prefix of its own and after an escaped ID (see
+// CompositeKey). Very long IDs or IDs with control characters make awkward
+// keys. Such IDs are replaced by a hash of the ID; SetKeyHashing hashes every
+// ID.
+const (
+	defaultMaxKeyLength = 256
+	hashedKeyMarker     = '#'
+)
+
+var (
+	keyHashing   atomic.Bool
+	maxKeyLength atomic.Int64
+	keySalt      atomic.Pointer[[]byte]
+)
+
+func init() { maxKeyLength.Store(defaultMaxKeyLength) }
+
+// SetKeyHashing controls whether user IDs are hashed with HashKey before being
+// embedded in Redis keys, so arbitrary IDs produce fixed-length safe keys and
+// raw IDs never reach Redis. MetricsJSON then reports users by their hashed
+// key as well. The trade-off is that keys no longer reveal the ID: prefix
+// scans such as SCAN rate:tenant:* stop matching, and resetting a user means
+// recomputing its key. Switching this on or off orphans existing Redis state.
+func SetKeyHashing(enabled bool) { keyHashing.Store(enabled) }
+
+// IsKeyHashing reports whether user IDs are hashed in Redis keys.
+func IsKeyHashing() bool { return keyHashing.Load() }
+
+// SetMaxKeyLength sets the longest user ID (in bytes) used verbatim in Redis
+// keys; longer IDs are hashed. n <= 0 restores the default of 256.
+func SetMaxKeyLength(n int) {
+	if n <= 0 {
+		n = defaultMaxKeyLength
+	}
+	maxKeyLength.Store(int64(n))
+}
+
+// SetKeySalt sets the secret HashKey mixes into every hash, so hashed keys
+// cannot be recovered by hashing guessed IDs without it. Every instance
+// sharing a Redis backend must use the same salt, and changing it orphans
+// existing hashed state. The empty salt is the default.
+func SetKeySalt(salt string) {
+	b := []byte(salt)
+	keySalt.Store(&b)
+}
+
+// HashKey returns the hex HMAC-SHA256 of userID keyed by the salt set with
+// SetKeySalt: the form in which hashed IDs appear in Redis keys, metrics and
+// traces. It is one-way; the limiter keeps no map back to the ID, so an
+// application that needs to find the user behind a hashed key (say, to reset
+// them from an admin tool) must keep its own map of HashKey(id) to id.
+func HashKey(userID string) string {
+	var salt []byte
+	if p := keySalt.Load(); p != nil {
+		salt = *p
+	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(userID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// redisUserPart returns the form of userID embedded in Redis keys: the ID
+// itself, or '#' followed by HashKey(userID) when hashing is on or the ID is
+// too long, contains control characters or could be mistaken for a hash.
+func redisUserPart(userID string) string {
+	if !keyHashing.Load() && !needsHashing(userID) {
+		return userID
+	}
+	return string(hashedKeyMarker) + HashKey(userID)
+}
+
+// reportedUser returns how userID appears in metrics: hashed when key hashing
+// is on, so raw IDs stay out of dumps too.
+func reportedUser(userID string) string {
+	if keyHashing.Load() {
+		return HashKey(userID)
+	}
+	return userID
+}
+
+func needsHashing(userID string) bool {
+	if int64(len(userID)) > maxKeyLength.Load() {
+		return true
+	}
+	if len(userID) > 0 && userID[0] == hashedKeyMarker {
+		return true
+	}
+	for i := 0; i < len(userID); i++ {
+		if c := userID[i]; c < 0x20 || c == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// slidingKey, leakyKey and dailyKey name a user's Redis state.
+func slidingKey(userID string) string { return "rate:" + redisUserPart(userID) }
+
+// slidingLimitsKey holds the limits a user's window was recently decided
+// with; its prefix keeps it apart from every user's "rate:" key.
+func slidingLimitsKey(userID string) string { return "ratelimits:" + redisUserPart(userID) }
+
+func leakyKey(userID string) string { return "bucket:" + redisUserPart(userID) }
+
+func decayKey(userID string) string { return "decay:" + redisUserPart(userID) }
+
+func dailyKey(userID string, now time.Time) string {
+	return "quota:" + redisUserPart(userID) + ":" + dayKey(now)
+}
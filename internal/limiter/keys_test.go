@@ -0,0 +1,241 @@
+package limiter
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompositeKey_RoundTrip(t *testing.T) {
+	cases := [][]string{
+		{"tenant", "user", "endpoint"},
+		{"a:b", "c"},
+		{"a", "b:c"},
+		{`back\slash`, `trailing\`, "x"},
+		{"", "user", ""},
+		{""},
+	}
+	for _, parts := range cases {
+		key := CompositeKey(parts...)
+		if got := ParseCompositeKey(key); !reflect.DeepEqual(got, parts) {
+			t.Fatalf("round trip %q -> %q -> %q", parts, key, got)
+		}
+	}
+}
+
+func TestCompositeKey_NoCollisions(t *testing.T) {
+	if CompositeKey("a:b", "c") == CompositeKey("a", "b:c") {
+		t.Fatal("parts containing the separator must not collide")
+	}
+	if CompositeKey("a", "") == CompositeKey("a") {
+		t.Fatal("an empty trailing part must change the key")
+	}
+	if CompositeKey(`a\`, "b") == CompositeKey("a", `\b`) {
+		t.Fatal("parts containing the escape character must not collide")
+	}
+}
+
+func TestCompositeKey_UsableAsUserID(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	k1 := CompositeKey("acme", "alice", "/search")
+	k2 := CompositeKey("acme", "alice", "/upload")
+	if !RateLimit(k1, 1) || !RateLimit(k2, 1) {
+		t.Fatal("first request on each composite key should be allowed")
+	}
+	if RateLimit(k1, 1) {
+		t.Fatal("composite keys should be limited independently")
+	}
+}
+
+func TestRedisUserPart_HashesLongAndUnsafeIDs(t *testing.T) {
+	resetLimiterState()
+
+	if got := redisUserPart("alice:42"); got != "alice:42" {
+		t.Fatalf("plain IDs should be used verbatim, got %q", got)
+	}
+
+	long := strings.Repeat("x", 10000)
+	for _, id := range []string{long, "evil\r\nFLUSHALL", "#" + strings.Repeat("a", 64)} {
+		got := redisUserPart(id)
+		if len(got) != 65 || got[0] != '#' {
+			t.Fatalf("ID %.20q should be hashed to a fixed-length key, got %q", id, got)
+		}
+	}
+	if redisUserPart("a\nb") == redisUserPart("a\nc") {
+		t.Fatal("distinct IDs must hash to distinct keys")
+	}
+
+	SetMaxKeyLength(4)
+	defer SetMaxKeyLength(0)
+	if got := redisUserPart("alice"); got[0] != '#' {
+		t.Fatalf("IDs over the configured max length should be hashed, got %q", got)
+	}
+}
+
+func TestRedisUserPart_KeyHashing(t *testing.T) {
+	resetLimiterState()
+	SetKeyHashing(true)
+	defer SetKeyHashing(false)
+
+	got := slidingKey("alice")
+	if !strings.HasPrefix(got, "rate:#") || strings.Contains(got, "alice") {
+		t.Fatalf("hashing should hide the ID, got %q", got)
+	}
+	if slidingKey("alice") != got {
+		t.Fatal("hashing must be deterministic")
+	}
+}
+
+func TestHashKey_SaltedHMAC(t *testing.T) {
+	resetLimiterState()
+	defer SetKeySalt("")
+
+	a := HashKey("alice")
+	if len(a) != 64 || strings.Contains(a, "alice") {
+		t.Fatalf("expected a hex SHA-256 HMAC, got %q", a)
+	}
+	if HashKey("alice") != a {
+		t.Fatal("the same input must yield the same key")
+	}
+	if HashKey("bob") == a {
+		t.Fatal("distinct IDs must yield distinct keys")
+	}
+
+	SetKeySalt("pepper")
+	salted := HashKey("alice")
+	if salted == a {
+		t.Fatal("a different salt must yield a different key")
+	}
+	SetKeySalt("paprika")
+	if HashKey("alice") == salted {
+		t.Fatal("a different salt must yield a different key")
+	}
+	SetKeySalt("pepper")
+	if HashKey("alice") != salted {
+		t.Fatal("the same salt and input must yield the same key")
+	}
+
+	SetKeyHashing(true)
+	defer SetKeyHashing(false)
+	if got := slidingKey("alice"); got != "rate:#"+salted {
+		t.Fatalf("Redis keys should embed HashKey, got %q", got)
+	}
+}
+
+func TestMetricsJSON_ReportsHashedUsers(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	SetUserLimit("alice", 5)
+	RateLimit("alice", 5)
+
+	SetKeySalt("pepper")
+	defer SetKeySalt("")
+	SetKeyHashing(true)
+	defer SetKeyHashing(false)
+
+	out, err := MetricsJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "alice") || !strings.Contains(string(out), HashKey("alice")) {
+		t.Fatalf("metrics should name users by their hashed key, got %s", out)
+	}
+}
+
+func TestSetKeyTransformer_LowerCaseSharesBucket(t *testing.T) {
+	resetLimiterState()
+	SetKeyTransformer(strings.ToLower)
+	defer SetKeyTransformer(nil)
+	SetUserLimit("alice", 2)
+
+	if !RateLimit("Alice", 2) || !RateLimit("alice", 2) {
+		t.Fatal("the first two requests should be allowed")
+	}
+	if RateLimit("ALICE", 2) {
+		t.Fatal(`"Alice", "alice" and "ALICE" must share one bucket`)
+	}
+	if st := Stats("Alice", 2); st.User != "alice" || st.Used != 2 {
+		t.Fatalf("stats must read the transformed key, got %+v", st)
+	}
+	if TimeUntilReset("ALICE") == 0 {
+		t.Fatal("TimeUntilReset must see the shared, full bucket")
+	}
+	if err := Settle("Alice", 0); err != nil {
+		t.Fatal(err)
+	}
+	if !RateLimit("alice", 2) {
+		t.Fatal("settling through another spelling must refund the shared bucket")
+	}
+
+	SetKeyTransformer(nil)
+	if !RateLimit("Alice", 2) {
+		t.Fatal("without the transformer \"Alice\" is a separate key")
+	}
+}
+
+func TestSetKeyTransformer_AppliesAtEveryEntryPoint(t *testing.T) {
+	resetLimiterState()
+	SetMode("leaky")
+	SetKeyTransformer(func(userID string) string { return CompositeKey("tenant-a", userID) })
+	defer SetKeyTransformer(nil)
+	key := CompositeKey("tenant-a", "bob")
+
+	if err := Wait(context.Background(), "bob", 2); err != nil {
+		t.Fatal(err)
+	}
+	if !EnqueueLeaky(context.Background(), "bob", 2, 0) {
+		t.Fatal("the second token should be taken at once")
+	}
+	if st := stats(key, 2); st.Used != 2 {
+		t.Fatalf("Wait and EnqueueLeaky must draw on the transformed bucket, used %d", st.Used)
+	}
+	if ok, wait := ReserveLeaky("bob", 2); !ok || wait == 0 {
+		t.Fatalf("the transformed bucket is empty, so a reservation must wait, got %v %v", ok, wait)
+	}
+	if n := LeakyQueueLen("bob"); n != 1 {
+		t.Fatalf("expected the reservation queued, got %d", n)
+	}
+	CancelReservation("bob")
+	WarmLeaky("bob", 2)
+	if st := stats(key, 2); st.Used != 0 {
+		t.Fatalf("WarmLeaky must fill the transformed bucket, used %d", st.Used)
+	}
+
+	release, ok := Acquire("bob", 1)
+	if !ok || InFlight("bob") != 1 {
+		t.Fatal("Acquire must hold a slot under the transformed key")
+	}
+	if _, ok := inFlight.Load(key); !ok {
+		t.Fatal("the slot must be counted under the transformed key")
+	}
+	release()
+
+	RateLimit("bob", 2)
+	if err := ResetByPrefix("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := leakyBuckets.load(key); ok {
+		t.Fatal("ResetByPrefix must match the transformed prefix")
+	}
+}
+
+func TestSetKeyTransformer_TenantPrefixAppliedOnce(t *testing.T) {
+	resetLimiterState()
+	SetKeyTransformer(func(userID string) string { return CompositeKey("tenant-a", userID) })
+	defer SetKeyTransformer(nil)
+	SetUserLimit(CompositeKey("tenant-a", "bob"), 1)
+
+	if !RateLimit("bob", 5) || RateLimit("bob", 5) {
+		t.Fatal("the limit configured under the transformed key must apply")
+	}
+	got, err := MetricsJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), `tenant-a:bob"`) || strings.Contains(string(got), "tenant-a:tenant-a") {
+		t.Fatalf("metrics must report the transformed key once, got %s", got)
+	}
+}
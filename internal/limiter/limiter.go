@@ -3,9 +3,13 @@ package limiter
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"math"
 	"os"
+	"slices"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -13,12 +17,13 @@ import (
 
 var (
 	// in-memory structures
-	userBuckets = sync.Map{} // map[string]*sync.Mutex
-	userSlices  = sync.Map{} // map[string]*[]int64 (for sliding)
-	userConfig  = sync.Map{} // map[string]int
+	slidingStates = newShardedMap[*slidingState](defaultShardCount)
+	userConfig    = sync.Map{} // map[string]int
+	userWindows   = sync.Map{} // map[string]time.Duration (window length)
+	userModes     = sync.Map{} // map[string]string (per-user algorithm override)
 
 	// leaky-bucket in-memory: per-user state
-	leakyBuckets = sync.Map{} // map[userID]*leakyState
+	leakyBuckets = newShardedMap[*leakyState](defaultShardCount)
 
 	// redis
 	rdb *redis.Client
@@ -29,6 +34,20 @@ var (
 	globalMode   = "sliding"
 )
 
+// slidingState holds in-memory sliding-window state
+type slidingState struct {
+	mtx   sync.Mutex
+	ts    []int64       // request timestamps (ms) within the window
+	epoch atomic.Uint64 // mode epoch this state was last reconciled at
+
+	limits []limitUse // limits decided with within the window (see pinLimit)
+
+	// optional request history ring (see SetHistorySize)
+	hist     []int64
+	histNext int // index of the next write
+	histLen  int // number of valid entries
+}
+
 // leakyState holds in-memory leaky bucket state
 type leakyState struct {
 	mtx        sync.Mutex
@@ -36,18 +55,39 @@ type leakyState struct {
 	lastMillis int64   // last updated timestamp in ms
 	capacity   float64 // bucket capacity (max tokens)
 	ratePerMs  float64 // refill rate in tokens per millisecond
+
+	reservations []int64 // due times (ms) of outstanding future reservations
+
+	epoch atomic.Uint64 // mode epoch this state was last reconciled at
 }
 
 // ----------------------------
 // Mode control
 // ----------------------------
 
-// SetMode sets the global algorithm mode: "sliding" or "leaky"
+// modes are the supported algorithms.
+var modes = []string{"sliding", "leaky", "daily", compactMode, decayMode}
+
+// validMode reports whether mode names a supported algorithm.
+func validMode(mode string) bool {
+	return slices.Contains(modes, mode)
+}
+
+// SetMode sets the global algorithm mode: "sliding", "leaky", "daily",
+// "sliding-redis-compact" (see SetCompactResolution) or "decay" (see
+// SetDecayHalfLife).
+//
+// Each RateLimit call reads the mode once, so an in-flight request is decided
+// entirely by one algorithm. Switching between "sliding" and "leaky" carries
+// each user's in-memory usage over to the new algorithm on their next request,
+// so a switch cannot be used to double an allowance. Redis keys are not
+// reconciled; they expire one window plus a second after their last write.
 func SetMode(mode string) {
 	globalModeMu.Lock()
 	defer globalModeMu.Unlock()
-	if mode == "sliding" || mode == "leaky" {
+	if validMode(mode) && mode != globalMode {
 		globalMode = mode
+		modeEpoch.Add(1)
 	}
 }
 
@@ -58,15 +98,87 @@ func GetMode() string {
 	return globalMode
 }
 
+// SetUserMode overrides the algorithm for one user: "sliding", "leaky", "daily",
+// "sliding-redis-compact" or "decay".
+// An empty mode removes the override so the global mode applies again.
+// State is per algorithm and is neither migrated nor cleared: the user's state
+// under the old mode is left to expire (and is used again if they switch
+// back), and the new mode starts from whatever state it holds for them, often
+// none, so a user near their limit may get a fresh allowance. Use
+// SetUserModePreservingUsage to carry their usage over.
+func SetUserMode(userID string, mode string) {
+	if mode == "" {
+		userModes.Delete(userID)
+		return
+	}
+	if validMode(mode) {
+		userModes.Store(userID, mode)
+	}
+}
+
+// GetUserMode returns the algorithm applied to the user: their override if
+// set, otherwise their tier's mode, otherwise the global mode.
+func GetUserMode(userID string) string {
+	if v, ok := userModes.Load(userID); ok {
+		return v.(string)
+	}
+	if t, ok := userTier(userID); ok && t.mode != "" {
+		return t.mode
+	}
+	return GetMode()
+}
+
 // ----------------------------
 // Config management
 // ----------------------------
 
+// defaultWindow is the window used for users without a configured window.
+const defaultWindow = time.Second
+
 // SetUserLimit sets per-user configured limit (requests per second).
+// It replaces any fractional rate set with SetUserRate, and any refill set
+// with SetUserRefill along with the window derived from it.
 func SetUserLimit(userID string, limit int) {
+	userRates.Delete(userID)
+	dropRefill(userID)
 	userConfig.Store(userID, limit)
 }
 
+// clearUserLimit removes userID's own limit, rate and refill, so their tier's
+// (or the caller's) limit applies.
+func clearUserLimit(userID string) {
+	userRates.Delete(userID)
+	dropRefill(userID)
+	userConfig.Delete(userID)
+}
+
+// SetUserLimitsBulk sets the configured limits of many users in one pass, as
+// if by SetUserLimit for each, e.g. when importing them. It skips clearing
+// fractional rates when none are set, so a bulk import costs one map store per
+// user.
+func SetUserLimitsBulk(limits map[string]int) {
+	rates, refills := anyStored(&userRates), anyStored(&userRefills)
+	for userID, limit := range limits {
+		if rates {
+			userRates.Delete(userID)
+		}
+		if refills {
+			dropRefill(userID)
+		}
+		userConfig.Store(userID, limit)
+	}
+}
+
+// anyStored reports whether m has any entry.
+func anyStored(m *sync.Map) bool {
+	found := false
+	m.Range(func(_, _ any) bool {
+		found = true
+		return false
+	})
+	return found
+}
+
 // GetUserLimit returns configured per-user limit.
 func GetUserLimit(userID string) (int, bool) {
 	v, ok := userConfig.Load(userID)
@@ -76,209 +188,647 @@ func GetUserLimit(userID string) (int, bool) {
 	return v.(int), true
 }
 
+// ForEachUser calls fn for every user with a configured limit, stopping early
+// if fn returns false. It is safe to call while limits are being changed: each
+// user is visited at most once, with the limit current at that moment, and
+// users added or removed during the iteration may or may not be visited.
+// fn may itself call SetUserLimit.
+func ForEachUser(fn func(userID string, limit int) bool) {
+	userConfig.Range(func(k, v any) bool {
+		return fn(k.(string), v.(int))
+	})
+}
+
+// SetUserWindow sets the per-user window length, so the user's limit means
+// "requests per window" (in leaky mode the bucket refills fully over one window).
+// Windows have millisecond resolution and may be shorter than a second
+// (e.g. 50 per 100ms). A window < 1ms restores the default (1s).
+func SetUserWindow(userID string, window time.Duration) {
+	if window < time.Millisecond {
+		userWindows.Delete(userID)
+		return
+	}
+	userWindows.Store(userID, window.Truncate(time.Millisecond))
+}
+
+// GetUserWindow returns the user's window length (default 1s).
+func GetUserWindow(userID string) time.Duration {
+	v, ok := userWindows.Load(userID)
+	if !ok {
+		return defaultWindow
+	}
+	return v.(time.Duration)
+}
+
+// keyExpiry returns how long a Redis key must outlive its last write so that
+// state for a window of the given length is never dropped while still relevant:
+// the window plus one second of slack, plus a random extra of up to a tenth of
+// that. The jitter keeps a cohort of users who started together from expiring
+// (and regaining a full allowance) at the same instant; it only ever
+// lengthens the expiry, so a key never expires before its window ends.
+//
+// With SetKeyExpiry, a fixed expiry is used instead where it is longer, and
+// persistent keys report 0.
+func keyExpiry(window time.Duration) time.Duration {
+	fixed := time.Duration(fixedKeyExpiry.Load())
+	if fixed < 0 {
+		return 0
+	}
+	base := window + time.Second
+	if fixed > base {
+		return fixed
+	}
+	return base + time.Duration(randInt63n(int64(base/10+1)))
+}
+
+// fixedKeyExpiry is the expiry set with SetKeyExpiry: 0 derives it from the
+// window, -1 means none.
+var fixedKeyExpiry atomic.Int64
+
+// SetKeyExpiry overrides how long Redis rate-limit keys outlive their last
+// write. d > 0 fixes the expiry, though never below what the key's window
+// needs. d == 0 stops expiring keys: they persist until deleted, e.g. by a
+// job managing monthly quotas externally. d < 0 restores the default, derived
+// from each window.
+//
+// Persistent keys are never reclaimed by Redis. Every user ever seen keeps
+// their keys, and the daily mode adds a new key per user per day, so memory
+// grows without bound unless something deletes them. Keys written before the
+// change keep their expiry until next written; the in-flight counts of
+// Acquire always expire (see SetInFlightTTL).
+func SetKeyExpiry(d time.Duration) {
+	switch {
+	case d < 0:
+		fixedKeyExpiry.Store(0)
+	case d == 0:
+		fixedKeyExpiry.Store(-1)
+	default:
+		fixedKeyExpiry.Store(int64(d))
+	}
+}
+
+// persistentKeys reports whether SetKeyExpiry(0) is in effect.
+func persistentKeys() bool { return fixedKeyExpiry.Load() < 0 }
+
+// EffectiveLimit returns the limit RateLimit enforces for userID: the configured
+// per-user limit if present and positive, otherwise the limit of the user's
+// tier if positive, otherwise fallback (or the default limit if fallback <= 0),
+// raised by any active GrantBurst, held down during warmup (SetWarmup) and
+// divided by InstanceFactor.
+func EffectiveLimit(userID string, fallback int) int {
+	limit := baseLimit(userID, fallback)
+	if limit <= 0 {
+		return limit
+	}
+	return perInstance(warmedUp(limit + ActiveBurst(userID)))
+}
+
+func baseLimit(userID string, fallback int) int {
+	if cfg, ok := GetUserLimit(userID); ok && cfg > 0 {
+		return cfg
+	}
+	if t, ok := userTier(userID); ok && t.limit > 0 {
+		return t.limit
+	}
+	if fallback <= 0 {
+		return DefaultLimit()
+	}
+	return fallback
+}
+
 // LoadUserConfigFromJSON loads per-user limits from a JSON file.
 func LoadUserConfigFromJSON(path string) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return err
 	}
-	// support both simple map[string]int and extended map[string]struct (not required now)
-	var cfg map[string]int
+	// support both simple map[string]int and extended map[string]struct
+	var cfg map[string]userEntry
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return err
 	}
-	for user, limit := range cfg {
-		SetUserLimit(user, limit)
-	}
-	return nil
+	return applyUserConfig(cfg)
 }
 
 // ----------------------------
 // Redis init
 // ----------------------------
 
-func InitRedis(addr string, password string, db int) {
-	rdb = redis.NewClient(&redis.Options{
+// RedisOption tunes the Redis client created by InitRedis.
+type RedisOption func(*redis.Options)
+
+// WithDialTimeout bounds how long connecting to Redis may take, so a bad
+// address fails fast instead of stalling the first request.
+func WithDialTimeout(d time.Duration) RedisOption {
+	return func(o *redis.Options) { o.DialTimeout = d }
+}
+
+// WithReadTimeout bounds how long a Redis reply may take.
+func WithReadTimeout(d time.Duration) RedisOption {
+	return func(o *redis.Options) { o.ReadTimeout = d }
+}
+
+// WithPoolSize sets the maximum number of pooled Redis connections.
+func WithPoolSize(n int) RedisOption {
+	return func(o *redis.Options) { o.PoolSize = n }
+}
+
+// InitRedis switches the limiter to the Redis backend. Options left unset
+// keep the go-redis defaults.
+func InitRedis(addr string, password string, db int, opts ...RedisOption) {
+	o := &redis.Options{
 		Addr:     addr,
 		Password: password,
 		DB:       db,
-	})
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	rdb = redis.NewClient(o)
+}
+
+// Ping checks that the Redis backend is reachable, e.g. for a readiness probe.
+// It does not touch limiter state, and returns nil in pure in-memory mode.
+func Ping(ctx context.Context) error {
+	if rdb == nil {
+		return nil
+	}
+	return rdb.Ping(ctx).Err()
 }
 
 // ----------------------------
 // Internal implementations
 // ----------------------------
 
+// receipt records what an allowed request consumed, so it can be refunded.
+type receipt struct {
+	tsMs   int64  // in-memory sliding: timestamp appended to the window
+	member string // Redis sliding: ZSET member added to the window
+	cost   int    // units consumed, if more than one (AllowN)
+
+	mode  string // algorithm that made the decision
+	limit int    // limit that was enforced
+	redis bool   // whether the Redis backend made the decision
+	store bool   // whether a Store set with SetStore made it (nothing to refund)
+	local bool   // repeated a sampled decision (SetSampleRate): nothing consumed
+
+	// window usage observed by the decision (sliding), allowed or denied
+	usageKnown bool
+	used       int   // requests in the window after the decision
+	oldestMs   int64 // oldest request still in the window (0 if empty)
+	decidedMs  int64 // clock of the decision (Redis server time for Redis)
+
+	grace bool // allowed only thanks to the user's grace allowance
+
+	reason Reason // why the request was denied short of its own limit, if it was
+
+	// rules: the 1-based index of the rule that denied the request (the one
+	// freeing a slot last) and how long until it does or, for an allowed
+	// request, of the rule with the fewest requests left, holding used;
+	// Redis sliding: the wait reported with a denial, if retryKnown
+	rule       int
+	retryMs    int64
+	retryKnown bool
+
+	err error // backend failure behind a denial
+}
+
+// charged returns how many units an allowed request consumed.
+func (rc receipt) charged() int {
+	return max(rc.cost, 1)
+}
+
+// slidingMembers returns the ZSET members an allowed Redis sliding request
+// added: its member and, for each unit past the first, the member suffixed
+// with the unit's number (see slidingScript).
+func slidingMembers(rc receipt) []any {
+	members := []any{rc.member}
+	for i := 2; i <= rc.charged(); i++ {
+		members = append(members, rc.member+":"+strconv.Itoa(i))
+	}
+	return members
+}
+
+// scriptCall is one prepared Lua rate-limit check, so single checks and
+// pipelined batches (AllowMulti) share the same script arguments.
+type scriptCall struct {
+	script *redis.Script
+	keys   []string
+	args   []any
+	rc     receipt // what an allowed call consumed
+}
+
+// run evaluates the call on its own round trip.
+func (c scriptCall) run() (bool, receipt) {
+	return c.runCtx(ctx)
+}
+
+// runCtx is run under the caller's context.
+func (c scriptCall) runCtx(ctx context.Context) (bool, receipt) {
+	res, err := c.script.Run(ctx, rdb, c.keys, c.args...).Result()
+	if err != nil {
+		return false, receipt{err: err}
+	}
+	return c.decode(res)
+}
+
+// decode interprets a script reply: 1 or 0, {allowed, current, oldest, now,
+// retry} from slidingScript ({allowed, current, oldest, now} from
+// compactScript), or {0, rule, wait} from rulesScript. A denied call
+// consumed nothing, so its receipt only carries the observed usage.
+func (c scriptCall) decode(res any) (bool, receipt) {
+	rc := c.rc
+	if vals, ok := res.([]any); ok && len(vals) == 3 {
+		// rules: {1, binding rule, used} or {0, denying rule, wait}
+		rule, _ := vals[1].(int64)
+		n, _ := vals[2].(int64)
+		if ok, _ := vals[0].(int64); ok == 1 {
+			rc.rule, rc.used = int(rule), int(n)
+			return true, rc
+		}
+		return false, receipt{rule: int(rule), retryMs: n}
+	}
+	if vals, ok := res.([]any); ok && len(vals) >= 4 {
+		res = vals[0]
+		used, _ := vals[1].(int64)
+		rc.oldestMs, _ = vals[2].(int64)
+		rc.decidedMs, _ = vals[3].(int64)
+		rc.usageKnown, rc.used = true, int(used)
+		if len(vals) == 5 {
+			rc.retryMs, _ = vals[4].(int64)
+			rc.retryKnown = true
+		}
+	}
+	if n, _ := res.(int64); n != 1 {
+		return false, receipt{usageKnown: rc.usageKnown, used: rc.used, oldestMs: rc.oldestMs, decidedMs: rc.decidedMs,
+			retryMs: rc.retryMs, retryKnown: rc.retryKnown}
+	}
+	return true, rc
+}
+
 // ---------- Sliding-window (in-memory) ----------
-func rateLimitMemorySliding(userID string, limit int) bool {
-	// get mutex for user
-	val, _ := userBuckets.LoadOrStore(userID, &sync.Mutex{})
-	mtx := val.(*sync.Mutex)
-
-	// get slice pointer for timestamps
-	rawSlice, _ := userSlices.LoadOrStore(userID, &[]int64{})
-	tsSlice := rawSlice.(*[]int64)
-
-	now := time.Now().UnixMilli()
-
-	mtx.Lock()
-	defer mtx.Unlock()
-
-	// prune timestamps older than 1s
-	cutoff := now - 1000
-	// reuse slice backing if possible
-	newSlice := (*tsSlice)[:0]
-	for _, ts := range *tsSlice {
-		if ts > cutoff {
-			newSlice = append(newSlice, ts)
+func rateLimitMemorySliding(userID string, limit, n int, window time.Duration, now int64) (bool, receipt) {
+	touchUser(userID)
+	st := slidingStates.loadOrStore(userID, newSlidingState)
+
+	syncSlidingFromLeaky(userID, st, now, window)
+
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+
+	// prune timestamps older than the window. Timestamps are kept in order
+	// (see insertTimestamp), so expired entries form a prefix; when there is none the slice is
+	// left untouched, otherwise survivors are shifted down in place so the
+	// backing array is reused and the allow path does not allocate.
+	cutoff := now - window.Milliseconds()
+	expired := 0
+	for expired < len(st.ts) && st.ts[expired] <= cutoff {
+		expired++
+	}
+	if expired > 0 {
+		n := copy(st.ts, st.ts[expired:])
+		st.ts = st.ts[:n]
+	}
+	st.recordHistory(now)
+	extra := pinExempt(userID, limit)
+	limit = st.pinLimit(limit-extra, now, window) + extra
+	if len(st.ts)+n > limit {
+		if strictMode.Load() {
+			// charge the denied request: keep the newest limit-1 entries plus now
+			keep := max(min(len(st.ts), limit-1), 0)
+			kept := copy(st.ts, st.ts[len(st.ts)-keep:])
+			st.ts = insertTimestamp(st.ts[:kept], now)
 		}
+		return false, st.usage(now)
 	}
-	if len(newSlice) >= limit {
-		*tsSlice = newSlice
-		return false
+	for i := 0; i < n; i++ {
+		st.ts = insertTimestamp(st.ts, now)
 	}
-	newSlice = append(newSlice, now)
-	*tsSlice = newSlice
-	return true
+	rc := st.usage(now)
+	rc.tsMs, rc.cost = now, n
+	return true, rc
+}
+
+// limitUse records the last time (ms) a limit was decided with.
+type limitUse struct {
+	limit int
+	atMs  int64
+}
+
+// pinLimit returns the limit to enforce at now: the lowest of limit and every
+// limit decided with in the past window. A decision thus uses the limit it
+// was called with, yet while calls with different limits overlap (e.g. during
+// a config reload) the window is held to the lowest of them; a raised limit
+// takes full effect one window after the last call with a lower one. Burst
+// grants are added after pinning (see pinExempt), so they apply at once.
+// Caller holds st.mtx.
+func (st *slidingState) pinLimit(limit int, now int64, window time.Duration) int {
+	cutoff := now - window.Milliseconds()
+	live, found := st.limits[:0], false
+	for _, u := range st.limits {
+		if u.limit == limit {
+			u.atMs, found = max(u.atMs, now), true
+		}
+		if u.atMs > cutoff {
+			live = append(live, u)
+		}
+	}
+	if !found {
+		live = append(live, limitUse{limit: limit, atMs: now})
+	}
+	st.limits = live
+	for _, u := range live {
+		limit = min(limit, u.limit)
+	}
+	return limit
+}
+
+// insertTimestamp adds now to the ordered timestamps ts. Live requests arrive
+// in order and are appended; replayed ones (AllowAt) may arrive out of order.
+func insertTimestamp(ts []int64, now int64) []int64 {
+	i := len(ts)
+	for i > 0 && ts[i-1] > now {
+		i--
+	}
+	ts = append(ts, 0)
+	copy(ts[i+1:], ts[i:])
+	ts[i] = now
+	return ts
+}
+
+// usage reports the window as observed by a decision at now. Caller holds st.mtx.
+func (st *slidingState) usage(now int64) receipt {
+	rc := receipt{usageKnown: true, used: len(st.ts), decidedMs: now}
+	if len(st.ts) > 0 {
+		rc.oldestMs = st.ts[0]
+	}
+	return rc
+}
+
+func newSlidingState() *slidingState {
+	return &slidingState{}
 }
 
 // ---------- Sliding-window (Redis) ----------
-func rateLimitRedisSliding(userID string, limit int) bool {
+// Scripts are created once so their SHA is reused (EVALSHA) across calls.
+//
+// Scripts that depend on time read the Redis server clock (luaNowMs) rather
+// than taking the caller's clock, so skew between application nodes cannot
+// over- or under-count.
+
+// luaNowMs sets the Lua local "now" to the Redis server time in ms.
+const luaNowMs = `
+	local t = redis.call("TIME")
+	local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+`
+
+// luaExpire defines pexpire and pexpireat, which set a key's expiry unless
+// the time given is "0", the expiry of persistent keys (SetKeyExpiry(0)).
+const luaExpire = `
+	local function pexpire(key, ms)
+		if ms ~= "0" then redis.call("PEXPIRE", key, ms) end
+	end
+	local function pexpireat(key, ms)
+		if ms ~= "0" then redis.call("PEXPIREAT", key, ms) end
+	end
+`
+
+// luaNowMsArg is luaNowMs, except that a non-empty ARGV[i] (see atArg)
+// supplies "now" instead, for replays through AllowAt.
+func luaNowMsArg(i int) string {
+	return luaNowMs + `
+	if ARGV[` + strconv.Itoa(i) + `] ~= "" then now = tonumber(ARGV[` + strconv.Itoa(i) + `]) end
+`
+}
+
+// slidingScript:
+// KEYS[1] = key
+// KEYS[2] = optional: limits used within the window (member = limit, score =
+// last use); the lowest of them and ARGV[2] is enforced
+// ARGV[1] = window (ms); timestamps at or before now - window are removed
+// ARGV[2] = limit
+// ARGV[3] = unique member (caller's nowNs)
+// ARGV[4] = key expiry (ms)
+// ARGV[5] = strict ("1" records denied requests too, keeping the newest limit)
+// ARGV[6] = caller-supplied now (ms), or "" to use the server time
+// ARGV[7] = optional: the part of ARGV[2] added after pinning (see pinExempt)
+// ARGV[8] = optional: units the request costs (default 1), all added at now,
+// the first as ARGV[3] and the others as ARGV[3]:2, ARGV[3]:3, ...
+// Returns {allowed, current, oldest, now, retry}: 1 or 0, the window count
+// after the decision, the oldest timestamp (ms) still in the window (0 if
+// empty), the server time and, when denied, how long (ms) until enough
+// requests leave the window for the request to fit under the enforced limit, so
+// callers can report remaining and Retry-After without another round trip.
+var slidingScript = newScript("sliding", luaNowMsArg(6)+luaExpire+`
+	-- remove timestamps older than cutoff
+	redis.call("ZREMRANGEBYSCORE", KEYS[1], 0, now - tonumber(ARGV[1]))
+	local current = tonumber(redis.call("ZCARD", KEYS[1]))
+	local extra = tonumber(ARGV[7] or "0")
+	local limit = tonumber(ARGV[2]) - extra
+	if KEYS[2] then
+		redis.call("ZREMRANGEBYSCORE", KEYS[2], 0, now - tonumber(ARGV[1]))
+		redis.call("ZADD", KEYS[2], now, tostring(limit))
+		pexpire(KEYS[2], ARGV[4])
+		for _, l in ipairs(redis.call("ZRANGE", KEYS[2], 0, -1)) do
+			limit = math.min(limit, tonumber(l))
+		end
+	end
+	limit = limit + extra
+	local cost = tonumber(ARGV[8] or "1")
+	local allowed = 0
+	if current + cost <= limit then
+		redis.call("ZADD", KEYS[1], now, ARGV[3])
+		for i = 2, cost do
+			redis.call("ZADD", KEYS[1], now, ARGV[3] .. ":" .. i)
+		end
+		pexpire(KEYS[1], ARGV[4])
+		current = current + cost
+		allowed = 1
+	elseif ARGV[5] == "1" then
+		local drop = current - limit + 1
+		if drop > 0 then
+			redis.call("ZPOPMIN", KEYS[1], drop)
+			current = current - drop
+		end
+		redis.call("ZADD", KEYS[1], now, ARGV[3])
+		pexpire(KEYS[1], ARGV[4])
+		current = current + 1
+	end
+	local oldest = 0
+	local first = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+	if first[2] then oldest = tonumber(first[2]) end
+	local retry = 0
+	if allowed == 0 and current + cost > limit then
+		local i = current + cost - limit - 1
+		local pivot = redis.call("ZRANGE", KEYS[1], i, i, "WITHSCORES")
+		if pivot[2] then retry = math.max(tonumber(pivot[2]) + tonumber(ARGV[1]) - now, 0) end
+	end
+	return {allowed, current, oldest, now, retry}
+`)
+
+func rateLimitRedisSliding(ctx context.Context, userID string, limit, n int, window time.Duration, at time.Time) (bool, receipt) {
 	if rdb == nil || limit <= 0 {
-		return false
+		return false, receipt{}
 	}
-	t := time.Now()
-	nowMs := t.UnixMilli()
-	nowNs := t.UnixNano()
-	oneSecondAgoMs := nowMs - 1000
-	key := "rate:" + userID
-
-	const lua = `
-		-- remove timestamps older than cutoff
-		redis.call("ZREMRANGEBYSCORE", KEYS[1], 0, ARGV[1])
-		local current = redis.call("ZCARD", KEYS[1])
-		if tonumber(current) < tonumber(ARGV[2]) then
-			redis.call("ZADD", KEYS[1], ARGV[3], ARGV[4])
-			redis.call("PEXPIRE", KEYS[1], 2000)
-			return 1
-		else
-			return 0
-		end
-	`
-	res, err := redis.NewScript(lua).Run(ctx, rdb, []string{key},
-		strconv.FormatInt(oneSecondAgoMs, 10),
-		strconv.Itoa(limit),
-		strconv.FormatInt(nowMs, 10),
-		strconv.FormatInt(nowNs, 10),
-	).Int()
-	if err != nil {
-		return false
+	return slidingCall(userID, limit, n, window, at).runCtx(ctx)
+}
+
+func slidingCall(userID string, limit, n int, window time.Duration, at time.Time) scriptCall {
+	key := slidingKey(userID)
+	member := strconv.FormatInt(timeNow().UnixNano(), 10)
+	return scriptCall{
+		script: slidingScript,
+		keys:   []string{key, slidingLimitsKey(userID)},
+		args: []any{
+			strconv.FormatInt(window.Milliseconds(), 10),
+			strconv.Itoa(limit),
+			member,
+			strconv.FormatInt(keyExpiry(window).Milliseconds(), 10),
+			strictArg(),
+			atArg(at),
+			strconv.Itoa(pinExempt(userID, limit)),
+			strconv.Itoa(n),
+		},
+		rc: receipt{member: member, cost: n},
 	}
-	return res == 1
 }
 
 // ---------- Leaky-bucket (in-memory) ----------
-func rateLimitMemoryLeaky(userID string, limit int) bool {
-	// config: capacity = limit (requests), leak rate = limit tokens / 1000ms
-	capacity := float64(limit)
-	ratePerMs := float64(limit) / 1000.0 // tokens per millisecond
-
-	val, _ := leakyBuckets.LoadOrStore(userID, &leakyState{
-		tokens:     capacity,
-		lastMillis: time.Now().UnixMilli(),
-		capacity:   capacity,
-		ratePerMs:  ratePerMs,
-	})
-	st := val.(*leakyState)
+func rateLimitMemoryLeaky(userID string, limit, n int, window time.Duration, now int64) bool {
+	st := getLeakyState(userID, limit, window, now)
+
+	syncLeakyFromSliding(userID, st, now)
 
-	now := time.Now().UnixMilli()
 	st.mtx.Lock()
 	defer st.mtx.Unlock()
 
-	// refill tokens
-	elapsed := float64(now - st.lastMillis)
-	if elapsed < 0 {
-		elapsed = 0
+	st.refill(now)
+
+	// consume a token per unit
+	if st.tokens >= float64(n) {
+		st.tokens -= float64(n)
+		return true
+	}
+	// not enough tokens; strict mode drains what has leaked back
+	if strictMode.Load() && st.tokens > 0 {
+		st.tokens = max(st.tokens-1.0, 0)
+	}
+	return false
+}
+
+// getLeakyState returns the user's bucket, creating a full one as of now if
+// missing, sized for limit per window.
+func getLeakyState(userID string, limit int, window time.Duration, now int64) *leakyState {
+	touchUser(userID)
+	// config: capacity = limit (requests), leak rate = limit tokens / window
+	return leakyBuckets.loadOrStore(userID, func() *leakyState {
+		capacity, ratePerMs := leakyParams(userID, limit, window)
+		return &leakyState{
+			tokens:     capacity,
+			lastMillis: now,
+			capacity:   capacity,
+			ratePerMs:  ratePerMs, // tokens per millisecond
+		}
+	})
+}
+
+// refill adds tokens accrued since the last update. Caller holds st.mtx.
+// A now before the last update (an out-of-order replay) adds nothing and
+// does not move the update time back.
+func (st *leakyState) refill(now int64) {
+	if now <= st.lastMillis {
+		return
 	}
-	refill := elapsed * st.ratePerMs
-	st.tokens += refill
+	st.tokens += float64(now-st.lastMillis) * st.ratePerMs
 	if st.tokens > st.capacity {
 		st.tokens = st.capacity
 	}
 	st.lastMillis = now
-
-	// consume one token
-	if st.tokens >= 1.0 {
-		st.tokens -= 1.0
-		return true
-	}
-	// not enough tokens
-	return false
 }
 
 // ---------- Leaky-bucket (Redis) ----------
-func rateLimitRedisLeaky(userID string, limit int) bool {
+// leakyScript:
+// KEYS[1] = key
+// ARGV[1] = capacity (number)
+// ARGV[2] = ratePerMs (tokens per ms, as number)
+// ARGV[3] = key expiry (ms)
+// ARGV[4] = strict ("1" drains up to one token on denial, never below zero)
+// ARGV[5] = caller-supplied now (ms), or "" to use the server time
+// ARGV[6] = optional: tokens the request costs (default 1)
+// Behavior (now is the Redis server time unless supplied):
+// - read tokens,last; a due ScheduleRefill refill makes the bucket full
+// - compute leaked = (now-last)*ratePerMs
+// - tokens = min(capacity, tokens + leaked)
+// - if tokens >= cost: tokens -= cost; store tokens,last=now,capacity; PEXPIRE; return 1
+// - else store tokens,last=now,capacity; return 0
+var leakyScript = newScript("leaky", luaNowMsArg(5)+luaExpire+`
+	local key = KEYS[1]
+	local capacity = tonumber(ARGV[1])
+	local rate = tonumber(ARGV[2])
+	local cost = tonumber(ARGV[6] or "1")
+
+	local data = redis.call("HMGET", key, "tokens", "last", "refill_at")
+	local tokens = tonumber(data[1])
+	local last = tonumber(data[2])
+	if tokens == nil then tokens = capacity end
+	if last == nil then last = now end
+	local refillAt = tonumber(data[3])
+	if refillAt ~= nil and now >= refillAt then
+		tokens, last = capacity, math.max(last, now)
+		redis.call("HDEL", key, "refill_at")
+	end
+
+	-- an out-of-order replay neither refills nor moves last back
+	if now < last then now = last end
+	local leaked = (now - last) * rate
+	tokens = tokens + leaked
+	if tokens > capacity then tokens = capacity end
+
+	if tokens >= cost then
+		tokens = tokens - cost
+		redis.call("HMSET", key, "tokens", tostring(tokens), "last", tostring(now), "capacity", ARGV[1])
+		pexpire(key, ARGV[3])
+		return 1
+	else
+		if ARGV[4] == "1" and tokens > 0 then
+			tokens = math.max(tokens - 1, 0)
+		end
+		redis.call("HMSET", key, "tokens", tostring(tokens), "last", tostring(now), "capacity", ARGV[1])
+		pexpire(key, ARGV[3])
+		return 0
+	end
+`)
+
+func rateLimitRedisLeaky(ctx context.Context, userID string, limit, n int, window time.Duration, at time.Time) (bool, receipt) {
 	if rdb == nil || limit <= 0 {
-		return false
+		return false, receipt{}
 	}
-	// capacity = limit tokens; rate per ms = limit/1000
-	t := time.Now()
-	nowMs := t.UnixMilli()
-	key := "bucket:" + userID
-
-	// Lua script:
-	// KEYS[1] = key
-	// ARGV[1] = nowMs
-	// ARGV[2] = capacity (number)
-	// ARGV[3] = ratePerMs (tokens per ms, as number)
-	// Behavior:
-	// - read tokens,last
-	// - compute leaked = (now-last)*ratePerMs
-	// - tokens = min(capacity, tokens + leaked)
-	// - if tokens >= 1: tokens -= 1; store tokens,last=now; PEXPIRE; return 1
-	// - else store tokens,last=now; return 0
-	const lua = `
-		local key = KEYS[1]
-		local now = tonumber(ARGV[1])
-		local capacity = tonumber(ARGV[2])
-		local rate = tonumber(ARGV[3])
-
-		local data = redis.call("HMGET", key, "tokens", "last")
-		local tokens = tonumber(data[1])
-		local last = tonumber(data[2])
-		if tokens == nil then tokens = capacity end
-		if last == nil then last = now end
-
-		local elapsed = now - last
-		if elapsed < 0 then elapsed = 0 end
-		local leaked = elapsed * rate
-		tokens = tokens + leaked
-		if tokens > capacity then tokens = capacity end
-
-		if tokens >= 1 then
-			tokens = tokens - 1
-			redis.call("HMSET", key, "tokens", tostring(tokens), "last", tostring(now))
-			redis.call("PEXPIRE", key, 2000)
-			return 1
-		else
-			redis.call("HMSET", key, "tokens", tostring(tokens), "last", tostring(now))
-			redis.call("PEXPIRE", key, 2000)
-			return 0
-		end
-	`
+	return leakyCall(userID, limit, n, window, at).runCtx(ctx)
+}
 
-	capacityStr := strconv.FormatFloat(float64(limit), 'f', -1, 64)
-	rateStr := strconv.FormatFloat(float64(limit)/1000.0, 'f', -8, 64)
+func leakyCall(userID string, limit, n int, window time.Duration, at time.Time) scriptCall {
+	// capacity = limit tokens; rate per ms = limit/window
+	key := leakyKey(userID)
 
-	res, err := redis.NewScript(lua).Run(ctx, rdb, []string{key},
-		strconv.FormatInt(nowMs, 10),
-		capacityStr,
-		rateStr,
-	).Int()
-	if err != nil {
-		return false
+	capacity, ratePerMs := leakyParams(userID, limit, window)
+	capacityStr := strconv.FormatFloat(capacity, 'f', -1, 64)
+	rateStr := strconv.FormatFloat(ratePerMs, 'f', -8, 64)
+	// an idle bucket matters until it has refilled from empty
+	refill := time.Duration(math.Ceil(capacity/ratePerMs)) * time.Millisecond
+
+	return scriptCall{
+		script: leakyScript,
+		keys:   []string{key},
+		args: []any{
+			capacityStr,
+			rateStr,
+			strconv.FormatInt(keyExpiry(refill).Milliseconds(), 10),
+			strictArg(),
+			atArg(at),
+			strconv.Itoa(n),
+		},
+		rc: receipt{cost: n},
 	}
-	return res == 1
 }
 
 // ----------------------------
@@ -292,27 +842,220 @@ func rateLimitRedisLeaky(userID string, limit int) bool {
 // If InitRedis has been called, Redis-backed implementation is used (distributed).
 // The algorithm used (sliding or leaky) is determined by global mode (SetMode/GetMode).
 func RateLimit(userID string, limit int) bool {
+	allowed, _ := rateLimit(transformKey(userID), limit)
+	return allowed
+}
+
+// RateLimitCtx is RateLimit under ctx: Redis calls honor its cancellation
+// and, with a Tracer set, are traced as children of the span it carries.
+func RateLimitCtx(ctx context.Context, userID string, limit int) bool {
+	allowed, _ := rateLimitAt(ctx, transformKey(userID), limit, time.Time{})
+	return allowed
+}
+
+// Allow is RateLimitCtx reporting why a request could not be decided
+// normally: ErrInvalidLimit for a limit <= 0 that neither a default limit nor
+// the AllowAll zero-limit policy resolves, ErrContextCanceled if ctx is done,
+// ErrDraining while draining (see Drain) and ErrBackendUnavailable if Redis
+// fails. On a backend failure allowed is
+// what RateLimit would return (a denial, unless enforcement is off).
+func Allow(ctx context.Context, userID string, limit int) (allowed bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return false, contextError(err)
+	}
+	if limit <= 0 && DefaultLimit() <= 0 && GetZeroLimitPolicy() == DenyAll {
+		return false, fmt.Errorf("%w: %d", ErrInvalidLimit, limit)
+	}
+	allowed, rc := rateLimitAt(ctx, transformKey(userID), limit, time.Time{})
+	if rc.err != nil {
+		return allowed, backendError(ctx, rc.err)
+	}
+	return allowed, nil
+}
+
+// rateLimit resolves the user's limit and applies it, returning what an
+// allowed request consumed.
+func rateLimit(userID string, limit int) (bool, receipt) {
+	return rateLimitAt(ctx, userID, limit, time.Time{})
+}
+
+// rateLimitAt is rateLimit under the caller's context, deciding as of at; the
+// zero time means now.
+func rateLimitAt(ctx context.Context, userID string, limit int, at time.Time) (bool, receipt) {
+	return rateLimitFail(ctx, userID, limit, at, GetFailMode())
+}
+
+// rateLimitFail is rateLimitAt with fail mode fm applying to backend errors.
+func rateLimitFail(ctx context.Context, userID string, limit int, at time.Time, fm FailMode) (bool, receipt) {
+	return rateLimitN(ctx, userID, limit, 1, at, fm)
+}
+
+// rateLimitN is rateLimitFail for a request costing n units (see AllowN).
+func rateLimitN(ctx context.Context, userID string, limit, n int, at time.Time, fm FailMode) (bool, receipt) {
+	if IsDraining(userID) {
+		return false, receipt{err: ErrDraining}
+	}
+	allowed, rc := decide(ctx, userID, limit, n, at)
+	if allowed && rc.err == nil {
+		allowed, rc = checkAnonymous(ctx, userID, rc, at)
+	}
+	allowed = failVerdict(allowed, rc, fm)
+	return enforce(userID, allowed), rc
+}
+
+// decide makes the rate-limit decision for a request costing n units, before
+// enforcement (SetEnforcement).
+func decide(ctx context.Context, userID string, limit, n int, at time.Time) (bool, receipt) {
+	nowMs := nowMsAt(at)
+	limit, decided, allowed := admit(userID, limit, nowMs)
+	if decided {
+		if allowed {
+			return true, receipt{}
+		}
+		switch {
+		case IsDenyListed(userID):
+			return false, receipt{reason: ReasonDenyList}
+		case rejectUnknown(userID):
+			return false, receipt{reason: ReasonUnknownUser}
+		}
+		return false, receipt{reason: ReasonZeroLimit}
+	}
+	mode := algorithmFor(userID)
+	n = costFor(mode, n)
+	grace := GetUserGrace(userID)
+	if at.IsZero() && shedEarly(userID, mode, limit+grace) {
+		shadowDecide(userID, limit+grace, false, nowMs, at)
+		return false, receipt{reason: ReasonSoftThrottle}
+	}
+	allowed, rc := rateLimitWithMode(ctx, userID, limit+grace, n, mode, at)
+	if rc.err == nil {
+		shadowDecide(userID, limit+grace, allowed, nowMs, at)
+	}
+	if allowed && grace > 0 {
+		rc.grace = usedAfter(userID, mode, limit+grace, rc) > limit
+	}
+	recordPenalty(userID, allowed, nowMs)
+	trackThrottled(userID, mode, limit+grace, allowed, nowMs)
+	return allowed, rc
+}
+
+// admit applies everything that runs before an algorithm: the deny and allow
+// lists, the unknown-user policy, limit validation, the configured limit and
+// any penalty. decided reports that the request was settled without touching
+// bucket state; otherwise limit is the limit to enforce.
+func admit(userID string, limit int, nowMs int64) (effective int, decided, allowed bool) {
+	// deny-listed and allow-listed users never touch bucket state
+	if IsDenyListed(userID) {
+		return 0, true, false
+	}
+	if IsAllowListed(userID) {
+		return 0, true, true
+	}
+	if rejectUnknown(userID) {
+		return 0, true, false
+	}
 	if limit <= 0 {
-		return false
+		limit = DefaultLimit()
+	}
+	if limit <= 0 {
+		return 0, true, GetZeroLimitPolicy() == AllowAll
 	}
 
 	// override with config if exists
-	if cfg, ok := GetUserLimit(userID); ok && cfg > 0 {
-		limit = cfg
+	limit = EffectiveLimit(userID, limit)
+	return applyPenalty(userID, limit, nowMs), false, false
+}
+
+// algorithmFor returns the algorithm deciding the user's requests: "rules"
+// if SetUserLimits is in effect, otherwise their mode.
+func algorithmFor(userID string) string {
+	if _, ok := userRules.Load(userID); ok {
+		return "rules"
 	}
+	return GetUserMode(userID)
+}
 
-	mode := GetMode()
-	// prefer Redis if initialized
+// costFor returns how many units a request costing n is charged under
+// algorithm mode: n by the sliding, leaky and daily algorithms, one by the
+// others and by a Store (SetStore), which decide a request at a time.
+func costFor(mode string, n int) int {
+	if _, ok := storeFor(mode); ok || n < 1 {
+		return 1
+	}
+	switch mode {
+	case "sliding", "leaky", "daily":
+		return n
+	}
+	return 1
+}
+
+// rateLimitWithMode dispatches a request costing n units to the backend and
+// algorithm for mode. Redis is preferred if initialized; otherwise the
+// in-memory fallback is used.
+func rateLimitWithMode(ctx context.Context, userID string, limit, n int, mode string, at time.Time) (allowed bool, rc receipt) {
+	if s, ok := storeFor(mode); ok {
+		allowed, rc = rateLimitStore(ctx, s, userID, limit, mode)
+		rc.mode, rc.limit, rc.store = mode, limit, true
+		return allowed, rc
+	}
 	if rdb != nil {
-		if mode == "leaky" {
-			return rateLimitRedisLeaky(userID, limit)
+		scaled, consult, verdict := sampled(userID, mode, limit)
+		if !consult {
+			return verdict, receipt{mode: mode, limit: limit, local: true}
+		}
+		if !redisOpAllowed() {
+			allowed, rc = rateLimitMemoryWithMode(userID, redisShedLimit(limit), n, mode, at)
+			rc.mode, rc.limit = mode, limit
+			return allowed, rc
+		}
+		if scaled != limit {
+			// a sampled request stands for 1/rate requests, each charged its share
+			n = max(int(math.Round(float64(n)*float64(scaled)/float64(limit))), 1)
 		}
-		return rateLimitRedisSliding(userID, limit)
+		allowed, rc = traceRedis(ctx, userID, mode, func(ctx context.Context) (bool, receipt) {
+			return rateLimitRedisWithMode(ctx, userID, scaled, n, mode, at)
+		})
+		if rc.err == nil {
+			recordSample(userID, allowed)
+		}
+	} else {
+		allowed, rc = rateLimitMemoryWithMode(userID, limit, n, mode, at)
+	}
+	rc.mode, rc.limit, rc.redis = mode, limit, rdb != nil
+	return allowed, rc
+}
+
+func rateLimitRedisWithMode(ctx context.Context, userID string, limit, n int, mode string, at time.Time) (bool, receipt) {
+	if g := globalRedisLimit.Load(); g != nil && limit > 0 {
+		return rateLimitRedisGlobal(ctx, userID, limit, n, mode, at, *g)
+	}
+	switch mode {
+	case "rules":
+		return rateLimitRedisRules(ctx, userID, GetUserLimits(userID))
+	case "leaky":
+		return rateLimitRedisLeaky(ctx, userID, limit, n, GetUserWindow(userID), at)
+	case "daily":
+		return rateLimitRedisDaily(ctx, userID, limit, n, at)
+	case compactMode:
+		return rateLimitRedisCompact(ctx, userID, limit, GetUserWindow(userID), at)
+	case decayMode:
+		return rateLimitRedisDecay(ctx, userID, limit, at)
+	default:
+		return rateLimitRedisSliding(ctx, userID, limit, n, GetUserWindow(userID), at)
 	}
+}
 
-	// in-memory fallback
-	if mode == "leaky" {
-		return rateLimitMemoryLeaky(userID, limit)
+func rateLimitMemoryWithMode(userID string, limit, n int, mode string, at time.Time) (bool, receipt) {
+	switch mode {
+	case "rules":
+		return rateLimitMemoryRules(userID, GetUserLimits(userID))
+	case "leaky":
+		return rateLimitMemoryLeaky(userID, limit, n, GetUserWindow(userID), nowMsAt(at)), receipt{cost: n}
+	case "daily":
+		return rateLimitMemoryDaily(userID, limit, n, at)
+	case decayMode:
+		return rateLimitMemoryDecay(userID, limit, nowMsAt(at))
+	default:
+		return rateLimitMemorySliding(userID, limit, n, GetUserWindow(userID), nowMsAt(at))
 	}
-	return rateLimitMemorySliding(userID, limit)
 }
@@ -13,6 +13,7 @@ func BenchmarkRateLimit_SingleUser(b *testing.B) {
 	user := "bench-user"
 	limit := 1000
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		_ = RateLimit(user, limit)
@@ -77,3 +78,96 @@ func BenchmarkRateLimit_LeakyBucket(b *testing.B) {
 		_ = RateLimit(user, limit)
 	}
 }
+
+// State-map benchmarks: sharded map vs a single sync.Map at 10k users / 64 goroutines
+const (
+	contentionUsers      = 10000
+	contentionGoroutines = 64
+)
+
+func contentionKeys() []string {
+	keys := make([]string, contentionUsers)
+	for i := range keys {
+		keys[i] = "user-" + strconv.Itoa(i)
+	}
+	return keys
+}
+
+func runContention(b *testing.B, keys []string, op func(key string)) {
+	opsPerGoroutine := b.N/contentionGoroutines + 1
+
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	wg.Add(contentionGoroutines)
+	for g := 0; g < contentionGoroutines; g++ {
+		go func(offset int) {
+			defer wg.Done()
+			for i := 0; i < opsPerGoroutine; i++ {
+				op(keys[(offset+i*7)%len(keys)])
+			}
+		}(g * 131)
+	}
+	wg.Wait()
+}
+
+func BenchmarkStateMap_SyncMap(b *testing.B) {
+	var m sync.Map
+	keys := contentionKeys()
+	runContention(b, keys, func(key string) {
+		v, ok := m.Load(key)
+		if !ok {
+			v, _ = m.LoadOrStore(key, &slidingState{})
+		}
+		st := v.(*slidingState)
+		st.mtx.Lock()
+		st.mtx.Unlock()
+	})
+}
+
+func BenchmarkStateMap_Sharded(b *testing.B) {
+	m := newShardedMap[*slidingState](defaultShardCount)
+	keys := contentionKeys()
+	runContention(b, keys, func(key string) {
+		st := m.loadOrStore(key, newSlidingState)
+		st.mtx.Lock()
+		st.mtx.Unlock()
+	})
+}
+
+func BenchmarkRateLimit_ManyUsersSharded(b *testing.B) {
+	resetLimiterState()
+	SetMode("sliding")
+	keys := contentionKeys()
+	runContention(b, keys, func(key string) {
+		_ = RateLimit(key, 1000)
+	})
+}
+
+func bulkLimits(n int) map[string]int {
+	limits := make(map[string]int, n)
+	for i := 0; i < n; i++ {
+		limits["bulk-user-"+strconv.Itoa(i)] = 10 + i%100
+	}
+	return limits
+}
+
+// Importing 100k user limits one call at a time vs in bulk
+func BenchmarkSetUserLimit_Repeated100k(b *testing.B) {
+	limits := bulkLimits(100_000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resetLimiterState()
+		for user, limit := range limits {
+			SetUserLimit(user, limit)
+		}
+	}
+}
+
+func BenchmarkSetUserLimitsBulk_100k(b *testing.B) {
+	limits := bulkLimits(100_000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resetLimiterState()
+		SetUserLimitsBulk(limits)
+	}
+}
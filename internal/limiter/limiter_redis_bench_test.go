@@ -17,6 +17,7 @@ func BenchmarkRateLimitRedis_SingleUser(b *testing.B) {
 	user := "bench-redis-single"
 	limit := 1000
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		_ = RateLimit(user, limit)
@@ -24,13 +25,22 @@ func BenchmarkRateLimitRedis_SingleUser(b *testing.B) {
 }
 
 func BenchmarkRateLimitRedis_ManyUsers(b *testing.B) {
+	benchmarkRedisManyUsers(b, "sliding")
+}
+
+func BenchmarkRateLimitRedis_ManyUsersLeaky(b *testing.B) {
+	benchmarkRedisManyUsers(b, "leaky")
+	SetMode("sliding")
+}
+
+func benchmarkRedisManyUsers(b *testing.B, mode string) {
 	InitRedis("localhost:6379", "", 0)
 	if rdb == nil {
 		b.Skip("redis not available")
 	}
 	_ = rdb.FlushDB(ctx).Err()
 
-	SetMode("sliding")
+	SetMode(mode)
 	numUsers := 200
 	limit := 20
 	users := make([]string, numUsers)
@@ -1,11 +1,16 @@
 package limiter
 
 import (
+	"context"
+	"math"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/redis/go-redis/v9"
 )
 
 // each redis test ensures a clean DB
@@ -138,3 +143,1686 @@ func TestRateLimitRedis_MultiUserParallel(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestRateLimitRedis_RefundableSliding(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+
+	user := "redis-refund"
+	limit := 1
+
+	allowed, refund := RateLimitRefundable(user, limit)
+	if !allowed {
+		t.Fatal("first request should be allowed")
+	}
+	if RateLimit(user, limit) {
+		t.Fatal("window should be full before refund")
+	}
+	refund()
+	refund()
+	if !RateLimit(user, limit) {
+		t.Fatal("refunded slot should be available again")
+	}
+	if RateLimit(user, limit) {
+		t.Fatal("double refund must not free more than one slot")
+	}
+}
+
+func TestRateLimitRedis_SubSecondWindow(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	defer SetUserWindow("redis-fast", 0)
+
+	user := "redis-fast"
+	limit := 5
+	SetUserWindow(user, 100*time.Millisecond)
+
+	for i := 1; i <= limit; i++ {
+		if !RateLimit(user, limit) {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+	if RateLimit(user, limit) {
+		t.Fatal("request exceeding limit should be denied")
+	}
+	time.Sleep(120 * time.Millisecond)
+	if !RateLimit(user, limit) {
+		t.Fatal("request after 100ms window should be allowed")
+	}
+}
+
+func TestRateLimitRedis_KeyExpiryTracksWindow(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	defer SetUserWindow("redis-long", 0)
+
+	user := "redis-long"
+	limit := 2
+	SetUserWindow(user, 3*time.Second)
+
+	for i := 1; i <= limit; i++ {
+		if !RateLimit(user, limit) {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+	ttl, err := rdb.PTTL(ctx, "rate:"+user).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 3*time.Second {
+		t.Fatalf("key expiry %v should outlive the 3s window", ttl)
+	}
+
+	// well past the old fixed 2000ms expiry, the window still holds
+	time.Sleep(2200 * time.Millisecond)
+	if RateLimit(user, limit) {
+		t.Fatal("state should survive beyond 2s and keep enforcing the limit")
+	}
+}
+
+func TestRateLimitRedis_LeakyKeyExpiryTracksWindow(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("leaky")
+	defer SetUserWindow("redis-leaky-long", 0)
+
+	user := "redis-leaky-long"
+	SetUserWindow(user, 3*time.Second)
+	RateLimit(user, 3)
+
+	ttl, err := rdb.PTTL(ctx, "bucket:"+user).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 3*time.Second {
+		t.Fatalf("bucket expiry %v should outlive the 3s refill window", ttl)
+	}
+}
+
+func TestRateLimitRedis_DailyQuotaKey(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("daily")
+	defer SetMode("sliding")
+	defer func() { timeNow = time.Now }()
+
+	// keys expire at the real end of day, so the simulated day must lie in the future
+	y, m, d := time.Now().UTC().AddDate(0, 0, 2).Date()
+	now := time.Date(y, m, d, 22, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+
+	user := "redis-daily"
+	for i := 1; i <= 2; i++ {
+		if !RateLimit(user, 2) {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+	if RateLimit(user, 2) {
+		t.Fatal("daily quota exhausted: request should be denied")
+	}
+	n, err := rdb.Get(ctx, "quota:"+user+":"+now.Format("2006-01-02")).Int()
+	if err != nil || n != 2 {
+		t.Fatalf("expected date-keyed counter of 2, got %d (%v)", n, err)
+	}
+
+	now = time.Date(y, m, d+1, 0, 0, 1, 0, time.UTC)
+	if !RateLimit(user, 2) {
+		t.Fatal("request on the next day should be allowed")
+	}
+}
+
+func TestRateLimitRedis_StrictSliding(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	SetStrictMode(true)
+	defer SetStrictMode(false)
+	defer SetUserWindow("redis-strict", 0)
+
+	user := "redis-strict"
+	SetUserWindow(user, 100*time.Millisecond)
+	RateLimit(user, 2)
+	RateLimit(user, 2)
+	if got := hammer(user, 2, 300*time.Millisecond); got != 0 {
+		t.Fatalf("strict mode: expected no recovery while hammering, %d allowed", got)
+	}
+	if n := rdb.ZCard(ctx, "rate:"+user).Val(); n != 2 {
+		t.Fatalf("strict window should hold at most limit entries, got %d", n)
+	}
+	time.Sleep(120 * time.Millisecond)
+	if !RateLimit(user, 2) {
+		t.Fatal("request after traffic stopped should be allowed")
+	}
+}
+
+// roundTripCounter is a go-redis hook counting network round trips: one per
+// command, one per pipeline.
+type roundTripCounter struct{ n atomic.Int32 }
+
+func (c *roundTripCounter) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (c *roundTripCounter) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		c.n.Add(1)
+		return next(ctx, cmd)
+	}
+}
+
+func (c *roundTripCounter) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		c.n.Add(1)
+		return next(ctx, cmds)
+	}
+}
+
+func TestRateLimitRedis_AllowMultiSingleRoundTrip(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	RateLimit("warm-up", 1) // load the script so the pipeline can use EVALSHA
+
+	counter := &roundTripCounter{}
+	rdb.AddHook(counter)
+
+	checks := []MultiKey{{"global", 100}, {"ip:10.0.0.1", 100}, {"user:bob", 1}}
+	ok, results := AllowMulti(checks)
+	if !ok || len(results) != 3 {
+		t.Fatalf("first request should be allowed on all keys: %v", results)
+	}
+	if n := counter.n.Load(); n != 1 {
+		t.Fatalf("allowed request: expected 1 round trip, got %d", n)
+	}
+
+	counter.n.Store(0)
+	ok, results = AllowMulti(checks)
+	if ok || !results[0] || !results[1] || results[2] {
+		t.Fatalf("per-user key should deny: ok=%v results=%v", ok, results)
+	}
+	if n := counter.n.Load(); n != 2 {
+		t.Fatalf("denied request: expected check + refund round trips, got %d", n)
+	}
+	if n := rdb.ZCard(ctx, "rate:global").Val(); n != 1 {
+		t.Fatalf("denied request should be refunded on the global key, got %d entries", n)
+	}
+}
+
+func TestRateLimitRedis_AllowMultiDeniesDrainingKeys(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	SetEnforcement(false)
+	defer SetEnforcement(true)
+	DrainMatching(func(userID string) bool { return userID == "user:drained" })
+	defer Undrain()
+
+	ok, results := AllowMulti([]MultiKey{{"global", 100}, {"user:drained", 100}})
+	if ok || !results[0] || results[1] {
+		t.Fatalf("a draining key must deny even unenforced: ok=%v results=%v", ok, results)
+	}
+	if n := rdb.ZCard(ctx, "rate:global").Val(); n != 0 {
+		t.Fatalf("the denied request should be refunded on the global key, got %d entries", n)
+	}
+	if n := rdb.Exists(ctx, "rate:user:drained").Val(); n != 0 {
+		t.Fatal("a draining key must not touch Redis")
+	}
+}
+
+func TestRateLimitRedis_AllowMultiReloadsFlushedScripts(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	if err := rdb.ScriptFlush(ctx).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []MultiKey{{"flushed-a", 1}, {"flushed-b", 1}}
+	if ok, _ := AllowMulti(checks); !ok {
+		t.Fatal("request should be allowed once scripts are reloaded")
+	}
+	if ok, _ := AllowMulti(checks); ok {
+		t.Fatal("second request should be denied")
+	}
+}
+
+func TestPing(t *testing.T) {
+	ensureRedisClean(t)
+	if err := Ping(ctx); err != nil {
+		t.Fatalf("ping against live redis: %v", err)
+	}
+
+	InitRedis("127.0.0.1:1", "", 0) // nothing listens on port 1
+	defer func() { rdb = nil }()
+	short, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if err := Ping(short); err == nil {
+		t.Fatal("ping against a dead address should fail")
+	}
+
+	rdb = nil
+	if err := Ping(ctx); err != nil {
+		t.Fatalf("in-memory mode should report healthy, got %v", err)
+	}
+}
+
+func TestRateLimitRedis_MultiRule(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	defer SetUserLimits("redis-rules", nil)
+
+	user := "redis-rules"
+	SetUserLimits(user, []Rule{
+		{Limit: 2, Window: 100 * time.Millisecond},
+		{Limit: 3, Window: 5 * time.Second},
+	})
+
+	for i := 1; i <= 2; i++ {
+		if !RateLimit(user, 100) {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+	if RateLimit(user, 100) {
+		t.Fatal("short window exhausted: request should be denied")
+	}
+	time.Sleep(120 * time.Millisecond)
+	if !RateLimit(user, 100) {
+		t.Fatal("short window recovered: request should be allowed")
+	}
+	if RateLimit(user, 100) {
+		t.Fatal("long window exhausted: request should be denied")
+	}
+	if n := rdb.ZCard(ctx, ruleKey(user, 5*time.Second)).Val(); n != 3 {
+		t.Fatalf("denied requests must not count against the long rule, got %d", n)
+	}
+}
+
+func TestAllowResultRedis_RulesReportBindingRule(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	defer SetUserLimits("redis-rules-remaining", nil)
+	assertRuleRemaining(t, "redis-rules-remaining")
+}
+
+func TestRateLimitRedisRules_KeyApartFromSlidingKeys(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	user := "redis-rules-collide"
+	if err := SetUserLimits(user, []Rule{{Limit: 3, Window: time.Second}}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetUserLimits(user, nil)
+
+	// the sliding key of user:1000ms must not be the rule's window
+	for i := 0; i < 3; i++ {
+		RateLimitOp(user, "1000ms", 10)
+	}
+	if !RateLimit(user, 10) {
+		t.Fatal("another key's requests used up the user's rule")
+	}
+}
+
+func TestRateLimitRedis_ZeroLimitPolicy(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	defer SetZeroLimitPolicy(DenyAll)
+
+	if RateLimit("redis-zero", 0) {
+		t.Fatal("limit 0 should deny under DenyAll")
+	}
+	SetZeroLimitPolicy(AllowAll)
+	for _, limit := range []int{0, -1} {
+		if !RateLimit("redis-zero", limit) {
+			t.Fatalf("limit %d should be allowed under AllowAll", limit)
+		}
+	}
+	if n := rdb.Exists(ctx, "rate:redis-zero").Val(); n != 0 {
+		t.Fatal("unlimited requests should not write Redis state")
+	}
+}
+
+func TestRateLimitRedis_SlidingScriptReportsUsage(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+
+	user := "redis-usage"
+	limit := 3
+	start := time.Now().UnixMilli()
+	for i := 1; i <= limit+1; i++ {
+		allowed, rc := rateLimitRedisSliding(ctx, user, limit, 1, time.Second, time.Time{})
+		if allowed != (i <= limit) {
+			t.Fatalf("request %d: allowed=%v", i, allowed)
+		}
+		want := min(i, limit)
+		if !rc.usageKnown || rc.used != want {
+			t.Fatalf("request %d: script reported %d in window, want %d", i, rc.used, want)
+		}
+		if rc.oldestMs < start || rc.oldestMs > time.Now().UnixMilli() {
+			t.Fatalf("request %d: oldest %d is not the first request", i, rc.oldestMs)
+		}
+	}
+	if n := rdb.ZCard(ctx, "rate:"+user).Val(); n != int64(limit) {
+		t.Fatalf("window holds %d entries, want %d", n, limit)
+	}
+
+	res := AllowResult("redis-usage-result", 2)
+	if !res.Allowed || res.Remaining != 1 {
+		t.Fatalf("first request: expected 1 remaining, got %+v", res)
+	}
+	AllowResult("redis-usage-result", 2)
+	res = AllowResult("redis-usage-result", 2)
+	if res.Allowed || res.Remaining != 0 || res.RetryAfter <= 0 || res.RetryAfter > time.Second {
+		t.Fatalf("denied request: expected 0 remaining and retry within 1s, got %+v", res)
+	}
+}
+
+func TestRateLimitRedis_ServerClockIgnoresClientSkew(t *testing.T) {
+	ensureRedisClean(t)
+	defer func() { timeNow = time.Now }()
+
+	// two application nodes whose clocks disagree by two hours
+	skews := []time.Duration{-time.Hour, time.Hour}
+	for _, mode := range []string{"sliding", "leaky"} {
+		SetMode(mode)
+		user := "redis-skew-" + mode
+		limit := 3
+		for i := 0; i < limit+2; i++ {
+			skew := skews[i%2]
+			timeNow = func() time.Time { return time.Now().Add(skew) }
+			if got, want := RateLimit(user, limit), i < limit; got != want {
+				t.Fatalf("%s request %d (client skew %v): allowed=%v, want %v", mode, i+1, skew, got, want)
+			}
+		}
+	}
+	SetMode("sliding")
+}
+
+func TestRateLimitRedis_Settle(t *testing.T) {
+	ensureRedisClean(t)
+	defer SetMode("sliding")
+
+	for _, mode := range []string{"sliding", "leaky"} {
+		SetMode(mode)
+		user := "redis-settle-" + mode
+		RateLimit(user, 4)
+		if err := Settle(user, 3); err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		if !RateLimit(user, 4) {
+			t.Fatalf("%s: one request should remain after settling", mode)
+		}
+		if RateLimit(user, 4) {
+			t.Fatalf("%s: settled cost should have been charged", mode)
+		}
+		if err := Settle(user, 0); err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		if !RateLimit(user, 4) {
+			t.Fatalf("%s: zero-cost settle should refund a unit", mode)
+		}
+	}
+}
+
+func TestInitRedis_DialTimeoutFailsFast(t *testing.T) {
+	defer func() { rdb = nil }()
+	InitRedis("10.255.255.1:6379", "", 0, // unroutable
+		WithDialTimeout(100*time.Millisecond),
+		WithReadTimeout(100*time.Millisecond),
+		WithPoolSize(2),
+	)
+	if opts := rdb.Options(); opts.DialTimeout != 100*time.Millisecond || opts.PoolSize != 2 {
+		t.Fatalf("options not applied: dial=%v pool=%d", opts.DialTimeout, opts.PoolSize)
+	}
+
+	start := time.Now()
+	if err := Ping(ctx); err == nil {
+		t.Fatal("ping against an unroutable address should fail")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("short dial timeout should fail fast, took %v", elapsed)
+	}
+}
+
+func TestRateLimitRedis_UnsafeUserIDs(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+
+	long := strings.Repeat("u", 5000)
+	for _, user := range []string{long, "tenant:alice\nrate:bob"} {
+		if !RateLimit(user, 1) {
+			t.Fatalf("first request for %.20q should be allowed", user)
+		}
+		if RateLimit(user, 1) {
+			t.Fatalf("second request for %.20q should be denied", user)
+		}
+	}
+
+	keys, err := rdb.Keys(ctx, "rate:*").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected one window key per user, got %q", keys)
+	}
+	for _, k := range keys {
+		if len(k) > 100 || strings.ContainsAny(k, "\r\n") {
+			t.Fatalf("unsafe key written to redis: %.40q", k)
+		}
+	}
+	if !RateLimit("rate:bob", 1) {
+		t.Fatal("an unrelated user must not share the injected ID's key")
+	}
+}
+
+func TestAllowAtRedis_UsesSuppliedTime(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	defer SetMode("sliding")
+
+	base := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	offsets := []int64{0, 100, 200, 300, 950, 1001, 1050, 1150, 1999, 2500}
+	want := []bool{true, true, true, false, false, true, false, true, true, true}
+	got := replay("redis-replay", 3, base, offsets)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sliding replay against redis = %v, want %v", got, want)
+		}
+	}
+
+	SetMode("leaky")
+	got = replay("redis-replay-leaky", 2, base, []int64{1000, 1000, 0, 1000, 1500, 1500})
+	want = []bool{true, true, false, false, true, false}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("leaky replay against redis = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRateLimitRedis_TracesEachCall(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	defer SetMode("sliding")
+	rec := &recordingTracer{}
+	SetTracer(rec)
+	defer SetTracer(nil)
+
+	parent := &recordedSpan{name: "request"}
+	reqCtx := context.WithValue(context.Background(), ctxKey{}, parent)
+	RateLimitCtx(reqCtx, "trace-user", 1)
+	RateLimitCtx(reqCtx, "trace-user", 1)
+	SetMode("leaky")
+	RateLimitCtx(reqCtx, "trace-leaky", 1)
+
+	if len(rec.spans) != 3 {
+		t.Fatalf("expected one span per redis call, got %d", len(rec.spans))
+	}
+	wantMode := []string{"sliding", "sliding", "leaky"}
+	wantAllowed := []bool{true, false, true}
+	for i, s := range rec.spans {
+		if s.name != RedisSpanName || !s.ended || s.parent != parent {
+			t.Fatalf("span %d: name=%q ended=%v parented=%v", i, s.name, s.ended, s.parent == parent)
+		}
+		if s.attrs[AttrMode] != wantMode[i] || s.attrs[AttrAllowed] != wantAllowed[i] {
+			t.Fatalf("span %d attributes = %v", i, s.attrs)
+		}
+		if user, _ := s.attrs[AttrUser].(string); len(user) != 16 || strings.Contains(user, "trace") {
+			t.Fatalf("span %d should carry a hashed user, got %q", i, user)
+		}
+	}
+}
+
+func TestRateLimitRedis_LeakyRefillInterval(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("leaky")
+	defer SetMode("sliding")
+
+	user := "redis-refill"
+	SetUserRefill(user, 20, 200*time.Millisecond)
+	defer SetUserWindow(user, 0)
+	defer SetUserRefill(user, 0, 0)
+
+	allowed := 0
+	for i := 0; i < 25; i++ {
+		if RateLimit(user, 1) {
+			allowed++
+		}
+	}
+	if allowed != 20 {
+		t.Fatalf("burst should be the full capacity of 20, got %d", allowed)
+	}
+
+	ttl, err := rdb.PTTL(ctx, "bucket:"+user).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 4*time.Second {
+		t.Fatalf("bucket expiry %v should cover a full refill (20 x 200ms)", ttl)
+	}
+
+	// tokens come back one per 200ms, not a full bucket per second
+	for i := 0; i < 3; i++ {
+		time.Sleep(220 * time.Millisecond)
+		if !RateLimit(user, 1) {
+			t.Fatalf("tick %d: a token should have refilled", i+1)
+		}
+		if RateLimit(user, 1) {
+			t.Fatalf("tick %d: only one token should have refilled", i+1)
+		}
+	}
+}
+
+func TestThrottledUsersRedis(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+
+	for i := 0; i < 3; i++ {
+		RateLimit("redis-thr", 2)
+	}
+	RateLimit("redis-thr-ok", 2)
+	got := ThrottledUsers()
+	found := false
+	for _, u := range got {
+		if u == "redis-thr-ok" {
+			t.Fatalf("user under the limit listed as throttled: %v", got)
+		}
+		found = found || u == "redis-thr"
+	}
+	if !found {
+		t.Fatalf("redis-thr should be listed as throttled, got %v", got)
+	}
+}
+
+func TestRateLimitRedis_KeyExpiriesSpread(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+
+	ttls := map[time.Duration]bool{}
+	for i := 0; i < 50; i++ {
+		user := "redis-cohort-" + strconv.Itoa(i)
+		RateLimit(user, 5)
+		ttl, err := rdb.PTTL(ctx, "rate:"+user).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl <= time.Second {
+			t.Fatalf("key for %s expires in %v, before its 1s window ends", user, ttl)
+		}
+		ttls[ttl.Truncate(10*time.Millisecond)] = true
+	}
+	if len(ttls) < 5 {
+		t.Fatalf("a cohort's keys should expire at spread-out times, got %d distinct TTLs", len(ttls))
+	}
+}
+
+func TestGlobalLimitRedis_AggregateCapAcrossInstances(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	SetGlobalLimitRedis(30, 5*time.Second)
+	defer SetGlobalLimitRedis(0, 0)
+
+	// several "instances" share one Redis, each serving its own users
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for inst := 0; inst < 5; inst++ {
+		wg.Add(1)
+		go func(inst int) {
+			defer wg.Done()
+			for i := 0; i < 40; i++ {
+				user := "global-" + strconv.Itoa(inst) + "-" + strconv.Itoa(i%8)
+				if RateLimit(user, 100) {
+					allowed.Add(1)
+				}
+			}
+		}(inst)
+	}
+	wg.Wait()
+
+	if allowed.Load() != 30 {
+		t.Fatalf("global cap of 30 should hold across instances, allowed %d", allowed.Load())
+	}
+	if n := rdb.ZCard(ctx, globalKey).Val(); n != 30 {
+		t.Fatalf("global window should hold exactly the allowed requests, has %d", n)
+	}
+}
+
+func TestGlobalLimitRedis_DenialsConsumeNothing(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	SetGlobalLimitRedis(2, 5*time.Second)
+	defer SetGlobalLimitRedis(0, 0)
+
+	if !RateLimit("g-user", 1) {
+		t.Fatal("first request should pass both limits")
+	}
+	if RateLimit("g-user", 1) {
+		t.Fatal("user limit should deny")
+	}
+	if n := rdb.ZCard(ctx, globalKey).Val(); n != 1 {
+		t.Fatalf("a request denied by the user limit must not use the global window, has %d", n)
+	}
+	if !RateLimit("g-other", 5) {
+		t.Fatal("second global slot should be available")
+	}
+	if RateLimit("g-third", 5) {
+		t.Fatal("global window is full: a user under their own limit should be denied")
+	}
+	if n := rdb.ZCard(ctx, slidingKey("g-third")).Val(); n != 0 {
+		t.Fatalf("a request denied by the global limit must not use the user's window, has %d", n)
+	}
+}
+
+func TestGlobalLimitRedis_FairShareByWeight(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	SetGlobalLimitRedis(30, 5*time.Second)
+	defer SetGlobalLimitRedis(0, 0)
+	SetGlobalFairness(true)
+	defer SetGlobalFairness(false)
+	SetGlobalWeight("fair-heavy", 2)
+	defer SetGlobalWeight("fair-heavy", 0)
+
+	got := map[string]int{}
+	for i := 0; i < 40; i++ {
+		for _, u := range []string{"fair-heavy", "fair-light"} {
+			if RateLimit(u, 1000) {
+				got[u]++
+			}
+		}
+	}
+	if got["fair-heavy"] != 20 || got["fair-light"] != 10 {
+		t.Fatalf("global cap of 30 should split 2:1 by weight, got %v", got)
+	}
+}
+
+func TestGlobalLimitRedis_FairShareDeniesBeforeBudgetSpent(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	SetGlobalLimitRedis(10, 5*time.Second)
+	defer SetGlobalLimitRedis(0, 0)
+	SetGlobalFairness(true)
+	defer SetGlobalFairness(false)
+
+	RateLimit("fair-a", 1000)
+	RateLimit("fair-b", 1000)
+	allowed := 1
+	for i := 0; i < 10; i++ {
+		if RateLimit("fair-a", 1000) {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Fatalf("with two equal users fair-a should get half of 10, got %d", allowed)
+	}
+	if n := rdb.ZCard(ctx, globalKey).Val(); n != 6 {
+		t.Fatalf("global budget should not be spent by fair-a's denials, has %d", n)
+	}
+}
+
+func TestGlobalLimitRedis_FairShareManyActiveUsers(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	SetGlobalLimitRedis(100000, 5*time.Second)
+	defer SetGlobalLimitRedis(0, 0)
+	SetGlobalFairness(true)
+	defer SetGlobalFairness(false)
+
+	// more active and stale users than Lua can unpack into one call
+	now := time.Now().UnixMilli()
+	active := make([]redis.Z, 0, 20000)
+	weights := make([]any, 0, 2*cap(active))
+	for i := 0; i < cap(active); i++ {
+		score := now
+		if i%2 == 0 {
+			score = now - time.Minute.Milliseconds()
+		}
+		user := "fair-many-" + strconv.Itoa(i)
+		active = append(active, redis.Z{Score: float64(score), Member: user})
+		weights = append(weights, user, "1")
+	}
+	rdb.ZAdd(ctx, globalActiveKey, active...)
+	rdb.HSet(ctx, globalWeightsKey, weights...)
+
+	res := AllowResult("fair-many", 1000)
+	if !res.Allowed || res.Err != nil {
+		t.Fatalf("a request among many active users should be allowed, got %+v", res)
+	}
+	if n := rdb.ZCard(ctx, globalActiveKey).Val(); n != int64(cap(active)/2+1) {
+		t.Fatalf("stale users should be dropped, %d active remain", n)
+	}
+}
+
+func TestWarmLeakyRedis(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("leaky")
+	defer SetMode("sliding")
+	SetUserLimit("redis-warm", 4)
+	defer SetUserLimit("redis-warm", 0)
+
+	WarmLeaky("redis-warm", 0)
+	if RateLimit("redis-warm", 4) {
+		t.Fatal("a bucket warmed to 0 tokens should deny the first request")
+	}
+
+	WarmLeaky("redis-warm", 10)
+	for i := 1; i <= 4; i++ {
+		if !RateLimit("redis-warm", 4) {
+			t.Fatalf("request %d of a full burst should be allowed", i)
+		}
+	}
+	if RateLimit("redis-warm", 4) {
+		t.Fatal("tokens above capacity must be clamped")
+	}
+}
+
+func TestRateLimitRedis_ConcurrentDifferingLimitsEnforceLower(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	user := "redis-reload"
+
+	if !RateLimit(user, 3) {
+		t.Fatal("first request should be allowed")
+	}
+	var allowed atomic.Int64
+	allowed.Add(1)
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(limit int) {
+			defer wg.Done()
+			if RateLimit(user, limit) {
+				allowed.Add(1)
+			}
+		}([]int{10, 3}[i%2])
+	}
+	wg.Wait()
+	if allowed.Load() != 3 {
+		t.Fatalf("overlapping limits 10 and 3 should admit 3, admitted %d", allowed.Load())
+	}
+}
+
+func TestAcquireRedis(t *testing.T) {
+	ensureRedisClean(t)
+
+	var releases []func()
+	for i := 1; i <= 2; i++ {
+		release, ok := Acquire("redis-jobs", 2)
+		if !ok {
+			t.Fatalf("acquire %d of 2 should succeed", i)
+		}
+		releases = append(releases, release)
+	}
+	if _, ok := Acquire("redis-jobs", 2); ok {
+		t.Fatal("a 3rd concurrent holder must be refused")
+	}
+	if got := InFlight("redis-jobs"); got != 2 {
+		t.Fatalf("a refused acquire must not leave a slot taken, got %d in flight", got)
+	}
+	if ttl := rdb.PTTL(ctx, inFlightKey("redis-jobs")).Val(); ttl <= 0 {
+		t.Fatalf("the in-flight count must expire in case holders leak, got TTL %v", ttl)
+	}
+
+	releases[0]()
+	releases[0]()
+	if _, ok := Acquire("redis-jobs", 2); !ok {
+		t.Fatal("acquire should succeed again after a release")
+	}
+	releases[1]()
+	if got := InFlight("redis-jobs"); got != 1 {
+		t.Fatalf("expected 1 in flight, got %d", got)
+	}
+}
+
+func TestScheduleRefillRedis(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("leaky")
+	defer SetMode("sliding")
+	SetUserWindow("redis-hourly", time.Hour)
+	defer SetUserWindow("redis-hourly", 0)
+
+	for i := 0; i < 2; i++ {
+		RateLimit("redis-hourly", 2)
+	}
+	if RateLimit("redis-hourly", 2) {
+		t.Fatal("bucket should be empty")
+	}
+
+	ScheduleRefill("redis-hourly", time.Now().Add(100*time.Millisecond))
+	if RateLimit("redis-hourly", 2) {
+		t.Fatal("the bucket must not refill before the scheduled time")
+	}
+	time.Sleep(150 * time.Millisecond)
+	for i := 1; i <= 2; i++ {
+		if !RateLimit("redis-hourly", 2) {
+			t.Fatalf("request %d should be allowed after the scheduled refill", i)
+		}
+	}
+	if RateLimit("redis-hourly", 2) {
+		t.Fatal("the refill happens once")
+	}
+
+	// scheduling on a fresh bucket must not leave a key without expiry
+	ScheduleRefill("redis-fresh", time.Now().Add(time.Minute))
+	if ttl := rdb.PTTL(ctx, leakyKey("redis-fresh")).Val(); ttl <= 0 {
+		t.Fatalf("a scheduled refill key must expire, got TTL %v", ttl)
+	}
+}
+
+// countingHook records the name of every command a client sends.
+type countingHook struct {
+	mu   sync.Mutex
+	cmds []string
+}
+
+func (h *countingHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h *countingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		h.mu.Lock()
+		h.cmds = append(h.cmds, cmd.Name())
+		h.mu.Unlock()
+		return next(ctx, cmd)
+	}
+}
+
+func (h *countingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		h.mu.Lock()
+		for _, cmd := range cmds {
+			h.cmds = append(h.cmds, cmd.Name())
+		}
+		h.mu.Unlock()
+		return next(ctx, cmds)
+	}
+}
+
+// countCommands swaps in a client that records commands for the rest of the
+// test.
+func countCommands(t *testing.T) *countingHook {
+	t.Helper()
+	saved := rdb
+	c := redis.NewClient(saved.Options())
+	h := &countingHook{}
+	c.AddHook(h)
+	c.Ping(ctx) // keep the connection handshake out of the count
+	h.cmds = nil
+	rdb = c
+	t.Cleanup(func() {
+		rdb = saved
+		c.Close()
+	})
+	return h
+}
+
+// The leaky bucket's reads and writes all run inside one script, so a decision
+// costs a single EVALSHA however the bucket is encoded.
+func TestRateLimitRedisLeaky_OneRoundTripPerDecision(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("leaky")
+	defer SetMode("sliding")
+	RateLimit("redis-leaky-ops", 5) // loads the script
+
+	h := countCommands(t)
+	for i := 0; i < 10; i++ {
+		RateLimit("redis-leaky-ops", 5)
+	}
+	if len(h.cmds) != 10 {
+		t.Fatalf("expected 10 commands for 10 decisions, got %d: %v", len(h.cmds), h.cmds)
+	}
+	for _, name := range h.cmds {
+		if name != "evalsha" {
+			t.Fatalf("expected only evalsha, got %v", h.cmds)
+		}
+	}
+}
+
+func TestAllowNRedis_ChargesInOneScript(t *testing.T) {
+	for _, mode := range []string{"sliding", "leaky", "daily"} {
+		t.Run(mode, func(t *testing.T) {
+			ensureRedisClean(t)
+			SetMode(mode)
+			defer SetMode("sliding")
+			user := "redis-batch-" + mode
+			SetUserWindow(user, time.Minute)
+			defer SetUserWindow(user, 0)
+
+			var wg sync.WaitGroup
+			var allowed atomic.Int32
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if AllowN(user, 3, 10).Allowed {
+						allowed.Add(1)
+					}
+				}()
+			}
+			wg.Wait()
+			if n := allowed.Load(); n != 3 {
+				t.Fatalf("expected 3 requests costing 3 to fit a limit of 10, got %d", n)
+			}
+			if AllowN(user, 2, 10).Allowed {
+				t.Fatal("a cost of 2 must not fit the 1 unit left")
+			}
+
+			h := countCommands(t)
+			if res := AllowN(user, 1, 10); !res.Allowed || res.Remaining != 0 {
+				t.Fatalf("expected the last unit allowed, got %+v", res)
+			}
+			// past the script, only the reads reporting Remaining
+			scripts := 0
+			for _, name := range h.cmds {
+				switch name {
+				case "evalsha":
+					scripts++
+				case "time", "hmget", "get":
+				default:
+					t.Fatalf("expected one script and reads, got %v", h.cmds)
+				}
+			}
+			if scripts != 1 {
+				t.Fatalf("expected a single evalsha, got %v", h.cmds)
+			}
+		})
+	}
+}
+
+func TestAllowNRedis_GlobalDenialGivesBackEveryUnit(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	SetGlobalLimitRedis(1, 5*time.Second)
+	defer SetGlobalLimitRedis(0, 0)
+
+	if !RateLimit("g-first", 5) {
+		t.Fatal("the first request should take the global slot")
+	}
+	if AllowN("g-batch", 3, 5).Allowed {
+		t.Fatal("the global window is full")
+	}
+	if n := rdb.ZCard(ctx, slidingKey("g-batch")).Val(); n != 0 {
+		t.Fatalf("all 3 units must be given back, the window holds %d", n)
+	}
+}
+
+func TestSetSpikeAndSustainedRedis(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	defer SetUserLimits("redis-spike", nil)
+	defer SetUserLimits("redis-trickle", nil)
+
+	// a burst trips the spike limit while the sustained quota has room
+	if err := SetSpikeAndSustained("redis-spike", 3, 100*time.Millisecond, 50, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		if !RateLimit("redis-spike", 100) {
+			t.Fatalf("burst request %d should be allowed", i)
+		}
+	}
+	if RateLimit("redis-spike", 100) {
+		t.Fatal("the 4th request of the burst should trip the spike limit")
+	}
+
+	// a trickle never trips the spike limit but exhausts the sustained one
+	if err := SetSpikeAndSustained("redis-trickle", 2, 50*time.Millisecond, 4, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 4; i++ {
+		if !RateLimit("redis-trickle", 100) {
+			t.Fatalf("trickle request %d should be allowed", i)
+		}
+		time.Sleep(60 * time.Millisecond)
+	}
+	if RateLimit("redis-trickle", 100) {
+		t.Fatal("a slow trickle should trip the sustained limit")
+	}
+}
+
+func TestAllowResultRedis_ExplainsBindingRule(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	defer SetUserLimits("redis-explain", nil)
+
+	SetUserLimits("redis-explain", []Rule{
+		{Limit: 2, Window: 100 * time.Millisecond},
+		{Limit: 3, Window: 5 * time.Second},
+	})
+	AllowResult("redis-explain", 100)
+	AllowResult("redis-explain", 100)
+	res := AllowResult("redis-explain", 100)
+	if res.Allowed || res.Rule != "2/100ms" || res.RetryAfter <= 0 || res.RetryAfter > 100*time.Millisecond {
+		t.Fatalf("a burst should be denied by 2/100ms, got %+v", res)
+	}
+
+	time.Sleep(120 * time.Millisecond)
+	if res := AllowResult("redis-explain", 100); !res.Allowed {
+		t.Fatalf("the short window recovered, got %+v", res)
+	}
+	res = AllowResult("redis-explain", 100)
+	if res.Allowed || res.Rule != "3/5s" || res.RetryAfter < 4*time.Second {
+		t.Fatalf("the long rule should deny now, got %+v", res)
+	}
+}
+
+func TestSetKeyExpiryRedis_PersistentKeys(t *testing.T) {
+	ensureRedisClean(t)
+	SetKeyExpiry(0)
+	defer SetKeyExpiry(-1)
+
+	RateLimit("redis-persist", 5)
+	SetMode("leaky")
+	RateLimit("redis-persist", 5)
+	SetMode("daily")
+	RateLimit("redis-persist", 5)
+	SetMode("sliding")
+
+	keys := []string{slidingKey("redis-persist"), leakyKey("redis-persist"), dailyKey("redis-persist", timeNow())}
+	for _, key := range keys {
+		if ttl := rdb.PTTL(ctx, key).Val(); ttl != -1 {
+			t.Fatalf("%s should have no expiry, got TTL %v", key, ttl)
+		}
+	}
+	time.Sleep(2500 * time.Millisecond)
+	if n := rdb.Exists(ctx, keys...).Val(); n != int64(len(keys)) {
+		t.Fatalf("persistent keys should survive past 2s, %d of %d left", n, len(keys))
+	}
+	if got := rdb.Get(ctx, dailyKey("redis-persist", timeNow())).Val(); got != "1" {
+		t.Fatalf("the daily count should persist, got %q", got)
+	}
+
+	SetKeyExpiry(-1)
+	RateLimit("redis-persist", 5)
+	if ttl := rdb.PTTL(ctx, slidingKey("redis-persist")).Val(); ttl <= 0 {
+		t.Fatalf("restoring the default should expire keys again, got TTL %v", ttl)
+	}
+}
+
+func TestTimeUntilResetRedis_Sliding(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	SetUserLimit("redis-reset", 2)
+	defer SetUserLimit("redis-reset", 0)
+	SetUserWindow("redis-reset", 200*time.Millisecond)
+	defer SetUserWindow("redis-reset", 0)
+
+	RateLimit("redis-reset", 100)
+	if d := TimeUntilReset("redis-reset"); d != 0 {
+		t.Fatalf("under the limit the reset should be 0, got %v", d)
+	}
+	RateLimit("redis-reset", 100)
+	first := TimeUntilReset("redis-reset")
+	if first <= 0 || first > 200*time.Millisecond {
+		t.Fatalf("a full window should reset within 200ms, got %v", first)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if second := TimeUntilReset("redis-reset"); second >= first || second <= 0 {
+		t.Fatalf("the reset should shrink as time passes: %v then %v", first, second)
+	}
+	if n := rdb.ZCard(ctx, slidingKey("redis-reset")).Val(); n != 2 {
+		t.Fatalf("querying must not consume, got %d entries", n)
+	}
+	time.Sleep(170 * time.Millisecond)
+	if d := TimeUntilReset("redis-reset"); d != 0 {
+		t.Fatalf("the window cleared, but reset is %v", d)
+	}
+}
+
+func TestEnableCreditRedis(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("daily")
+	defer SetMode("sliding")
+	defer func() { timeNow = time.Now }()
+	EnableCredit(3)
+	defer EnableCredit(0)
+
+	user := "redis-banker"
+	SetUserLimit(user, 5)
+	defer SetUserLimit(user, 0)
+	day := time.Now().UTC()
+	setTestNow(day)
+	RateLimit(user, 5) // 4 unused, capped at 3
+	if ttl := rdb.PTTL(ctx, dailyKey(user, day)).Val(); ttl < 24*time.Hour {
+		t.Fatalf("today's count must outlive the day for tomorrow's credit, got TTL %v", ttl)
+	}
+
+	setTestNow(day.AddDate(0, 0, 1))
+	if got := BankedCredit(user); got != 3 {
+		t.Fatalf("expected 3 credits banked, got %d", got)
+	}
+	for i := 1; i <= 8; i++ {
+		if !RateLimit(user, 5) {
+			t.Fatalf("request %d of limit 5 + 3 credits should be allowed", i)
+		}
+	}
+	if RateLimit(user, 5) {
+		t.Fatal("the burst is capped at limit + maxBanked")
+	}
+
+	setTestNow(day.AddDate(0, 0, 2))
+	if got := BankedCredit(user); got != 0 {
+		t.Fatalf("a fully used day should bank nothing, got %d", got)
+	}
+}
+
+func TestEnableCreditRedis_AfterShortDSTDay(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("daily")
+	defer SetMode("sliding")
+	defer func() { timeNow = time.Now }()
+	SetTimezone(loadNewYork(t))
+	defer SetTimezone(nil)
+	EnableCredit(10)
+	defer EnableCredit(0)
+
+	user := "redis-dst-banker"
+	SetUserLimit(user, 5)
+	defer SetUserLimit(user, 0)
+	// 2027-03-14 is 23 hours long in New York; the day before it banks 5
+	setTestNow(time.Date(2027, 3, 14, 12, 0, 0, 0, time.UTC))
+	countAllowed(user, 5, 4) // 5 + 5 - 4 unused
+
+	// 00:30 on the 15th, read on a clock in UTC
+	setTestNow(time.Date(2027, 3, 15, 4, 30, 0, 0, time.UTC))
+	if got := BankedCredit(user); got != 6 {
+		t.Fatalf("expected the 14th's 6 unused banked, got %d", got)
+	}
+	RateLimit(user, 5)
+	if got := BankedCredit(user); got != 6 {
+		t.Fatalf("the day's first request should bank the 14th's 6 unused, got %d", got)
+	}
+}
+
+func TestRedisStore_HonorsWindow(t *testing.T) {
+	ensureRedisClean(t)
+	assertStoreHonorsWindow(t, RedisStore(), "redis-store-window")
+}
+
+func TestSetStoreRedis_SameBehaviorAsBuiltin(t *testing.T) {
+	ensureRedisClean(t)
+	want := decisions("redis-builtin")
+
+	SetStore(RedisStore())
+	defer SetStore(nil)
+	got := decisions("redis-store")
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("RedisStore decisions %v, built-in %v", got, want)
+		}
+	}
+	if n := rdb.ZCard(ctx, slidingKey("redis-store-sliding")).Val(); n != 3 {
+		t.Fatalf("RedisStore should write the built-in keys, got %d entries", n)
+	}
+
+	// the memory store keeps its own state even while Redis is initialized
+	SetStore(MemoryStore())
+	RateLimit("redis-memstore", 3)
+	if n := rdb.Exists(ctx, slidingKey("redis-memstore")).Val(); n != 0 {
+		t.Fatal("MemoryStore must not write to Redis")
+	}
+}
+
+func TestSetSampleRateRedis_LongRunRateNearLimit(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	SetSampleRate(0.2)
+	defer SetSampleRate(0)
+	SetUserWindow("redis-sampled", 100*time.Millisecond)
+	defer SetUserWindow("redis-sampled", 0)
+
+	// offer far more than the limit for 10 windows
+	const limit, windows = 100, 10
+	allowed, sent := 0, 0
+	deadline := time.Now().Add(windows * 100 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		sent++
+		if RateLimit("redis-sampled", limit) {
+			allowed++
+		}
+	}
+	if sent < 5*limit*windows {
+		t.Skipf("only %d requests offered; too few to overload the limit", sent)
+	}
+	// expected limit*windows; sampling error is about sqrt(limit/fraction) per window
+	want := float64(limit * windows)
+	tolerance := 4*math.Sqrt(limit/0.2)*math.Sqrt(windows) + 2*limit // plus window-edge slack
+	if math.Abs(float64(allowed)-want) > tolerance {
+		t.Fatalf("allowed %d of %d over %d windows, want %.0f ± %.0f", allowed, sent, windows, want, tolerance)
+	}
+	t.Logf("allowed %d of %d (want ~%.0f)", allowed, sent, want)
+}
+
+func TestRemainingQuotaRedis(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("daily")
+	defer SetMode("sliding")
+	defer func() { timeNow = time.Now }()
+	SetUserLimit("redis-remaining", 3)
+	defer userConfig.Delete("redis-remaining")
+
+	// keys expire at the real end of day, so the simulated day must lie in the future
+	y, m, d := time.Now().UTC().AddDate(0, 0, 2).Date()
+	now := time.Date(y, m, d, 23, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+
+	RateLimit("redis-remaining", 3)
+	RateLimit("redis-remaining", 3)
+	got, reset := RemainingQuota("redis-remaining")
+	if want := time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC); got != 1 || !reset.Equal(want) {
+		t.Fatalf("expected 1 remaining until %v, got %d until %v", want, got, reset)
+	}
+	if n := rdb.Get(ctx, "quota:redis-remaining:"+now.Format("2006-01-02")).Val(); n != "2" {
+		t.Fatalf("RemainingQuota must not consume, counter is %s", n)
+	}
+
+	now = time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+	if got, _ := RemainingQuota("redis-remaining"); got != 3 {
+		t.Fatalf("expected the full quota after midnight, got %d", got)
+	}
+}
+
+func TestAllowIdempotentRedis_RepeatConsumesOnce(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+
+	for i := 0; i < 3; i++ {
+		if !AllowIdempotent("redis-idem", "req-1", 2) {
+			t.Fatalf("call %d: the request and its retries should be allowed", i+1)
+		}
+	}
+	if n := rdb.ZCard(ctx, "rate:redis-idem").Val(); n != 1 {
+		t.Fatalf("expected one slot consumed, got %d", n)
+	}
+	if ttl := rdb.PTTL(ctx, "idem:redis-idem:req-1").Val(); ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("expected the request ID remembered for the TTL, got %v", ttl)
+	}
+	AllowIdempotent("redis-idem", "req-2", 2)
+	if AllowIdempotent("redis-idem", "req-3", 2) || AllowIdempotent("redis-idem", "req-3", 2) {
+		t.Fatal("a denied request and its retry should be denied")
+	}
+	if n := rdb.ZCard(ctx, "rate:redis-idem").Val(); n != 2 {
+		t.Fatalf("expected two slots consumed, got %d", n)
+	}
+}
+
+func TestAllowIdempotentRedis_KeysDistinctPairs(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+
+	// both pairs would read "a:b:c" joined with plain colons
+	AllowIdempotent("a", "b:c", 2)
+	AllowIdempotent("a:b", "c", 2)
+	if n := rdb.ZCard(ctx, "rate:a:b").Val(); n != 1 {
+		t.Fatalf("the second user's request must be decided and charged, got %d slots", n)
+	}
+	long := strings.Repeat("r", 2*defaultMaxKeyLength)
+	AllowIdempotent("a", long, 2)
+	if key := idemKey("a", long); len(key) > defaultMaxKeyLength {
+		t.Fatalf("a long request ID should be hashed, got a %d-byte key", len(key))
+	}
+}
+
+func TestCompactSlidingRedis_BoundedMemory(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode(compactMode)
+	defer SetMode("sliding")
+	SetUserMode("redis-exact", "sliding")
+	defer SetUserMode("redis-exact", "")
+
+	for i := 0; i < 500; i++ {
+		RateLimit("redis-compact", 500)
+		RateLimit("redis-exact", 500)
+	}
+	if n := rdb.ZCard(ctx, "rate:redis-exact").Val(); n != 500 {
+		t.Fatalf("the exact window keeps one member per request, got %d", n)
+	}
+	if n := rdb.HLen(ctx, "crate:redis-compact").Val(); n > defaultCompactResolution+1 {
+		t.Fatalf("the compact window keeps at most one counter per sub-window, got %d", n)
+	}
+	if got := Stats("redis-compact", 500).Used; got != 500 {
+		t.Fatalf("expected 500 used in the compact window, got %d", got)
+	}
+	compact, err1 := rdb.MemoryUsage(ctx, "crate:redis-compact").Result()
+	exact, err2 := rdb.MemoryUsage(ctx, "rate:redis-exact").Result()
+	if err1 == nil && err2 == nil && compact >= exact {
+		t.Fatalf("expected the compact window to use less memory: %d vs %d bytes", compact, exact)
+	}
+
+	h := countCommands(t)
+	for i := 0; i < 10; i++ {
+		RateLimit("redis-compact", 500)
+	}
+	if len(h.cmds) != 10 {
+		t.Fatalf("expected one command per decision, got %v", h.cmds)
+	}
+}
+
+func TestCompactSlidingRedis_RateWithinOneSubWindow(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode(compactMode)
+	defer SetMode("sliding")
+	SetUserMode("redis-exact-rate", "sliding")
+	defer SetUserMode("redis-exact-rate", "")
+	const window, limit = 200 * time.Millisecond, 5
+	for _, u := range []string{"redis-compact-rate", "redis-exact-rate"} {
+		SetUserWindow(u, window)
+		defer SetUserWindow(u, 0)
+	}
+
+	var compact, exact int
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); compact = hammer("redis-compact-rate", limit, 1500*time.Millisecond) }()
+	go func() { defer wg.Done(); exact = hammer("redis-exact-rate", limit, 1500*time.Millisecond) }()
+	wg.Wait()
+
+	// counting up to one sub-window further back never admits more, and at
+	// worst paces slots by window+sub instead of window
+	sub := window / defaultCompactResolution
+	floor := float64(exact)*float64(window)/float64(window+sub) - limit
+	if compact > exact+1 || float64(compact) < floor {
+		t.Fatalf("compact admitted %d, exact %d: want within [%.0f, %d]", compact, exact, floor, exact+1)
+	}
+
+	if wait := TimeUntilReset("redis-compact-rate"); wait > window+sub {
+		t.Fatalf("a full compact window frees a slot within window+sub, got %v", wait)
+	}
+	time.Sleep(window + sub)
+	allowed, refund := RateLimitRefundable("redis-compact-rate", limit)
+	if !allowed {
+		t.Fatal("the window should have drained")
+	}
+	before := Stats("redis-compact-rate", limit).Used
+	refund()
+	if after := Stats("redis-compact-rate", limit).Used; after != before-1 {
+		t.Fatalf("refund should give the slot back: %d -> %d", before, after)
+	}
+}
+
+func TestSetLeakyDefaultsRedis(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("leaky")
+	defer SetMode("sliding")
+	SetLeakyDefaults(2, 0)
+	defer SetLeakyDefaults(0, 0)
+
+	allowed := 0
+	for i := 0; i < 30; i++ {
+		if RateLimit("redis-leaky-defaults", 10) {
+			allowed++
+		}
+	}
+	if allowed != 20 {
+		t.Fatalf("capacity multiplier 2 should allow a burst of 20, got %d", allowed)
+	}
+}
+
+func TestAllowResultRedis_RetryAfterFromScript(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	user := "redis-retry"
+	SetUserWindow(user, time.Second)
+	defer SetUserWindow(user, 0)
+
+	AllowResult(user, 3)
+	time.Sleep(100 * time.Millisecond)
+	AllowResult(user, 3)
+	AllowResult(user, 3)
+
+	h := countCommands(t)
+	res := AllowResult(user, 3)
+	if res.Allowed {
+		t.Fatal("the window is full: request should be denied")
+	}
+	if len(h.cmds) != 1 {
+		t.Fatalf("Retry-After should come from the decision's round trip, got %v", h.cmds)
+	}
+	oldest := rdb.ZRangeWithScores(ctx, "rate:"+user, 0, 0).Val()[0].Score
+	want := time.Duration(int64(oldest)+1000-time.Now().UnixMilli()) * time.Millisecond
+	if diff := res.RetryAfter - want; diff < -20*time.Millisecond || diff > 20*time.Millisecond {
+		t.Fatalf("expected Retry-After ~%v (until the oldest request leaves), got %v", want, res.RetryAfter)
+	}
+
+	// over the limit (e.g. after it was lowered) the wait is until enough
+	// requests leave, still without a second read
+	h.cmds = nil
+	res = AllowResult(user, 2)
+	if res.Allowed || len(h.cmds) != 1 {
+		t.Fatalf("expected a denial in one round trip, got %+v, %v", res, h.cmds)
+	}
+	pivot := rdb.ZRangeWithScores(ctx, "rate:"+user, 1, 1).Val()[0].Score
+	want = time.Duration(int64(pivot)+1000-time.Now().UnixMilli()) * time.Millisecond
+	if diff := res.RetryAfter - want; diff < -20*time.Millisecond || diff > 20*time.Millisecond {
+		t.Fatalf("expected Retry-After ~%v (until the second request leaves), got %v", want, res.RetryAfter)
+	}
+}
+
+func TestInspectLeakyRedis(t *testing.T) {
+	ensureRedisClean(t)
+	user := "redis-inspect"
+	SetUserMode(user, "leaky")
+	defer SetUserMode(user, "")
+	SetUserLimit(user, 5)
+	defer userConfig.Delete(user)
+	SetUserWindow(user, 10*time.Second)
+	defer SetUserWindow(user, 0)
+
+	if tokens, last, capacity, err := InspectLeaky(user); err != nil || tokens != 5 || !last.IsZero() || capacity != 5 {
+		t.Fatalf("a new bucket should be full: %v, %v, %v, %v", tokens, last, capacity, err)
+	}
+
+	before := time.Now()
+	if !RateLimit(user, 5) {
+		t.Fatal("first request should be allowed")
+	}
+	h := countCommands(t)
+	tokens, last, capacity, err := InspectLeaky(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.cmds) != 1 {
+		t.Fatalf("expected one HGETALL, got %v", h.cmds)
+	}
+	if tokens != 4 || capacity != 5 {
+		t.Fatalf("expected 4 of 5 tokens after one request, got %v of %v", tokens, capacity)
+	}
+	if d := last.Sub(before); d < -time.Second || d > time.Second {
+		t.Fatalf("last update %v should be the request's time, ~%v", last, before)
+	}
+	if tokens, _, _, _ := InspectLeaky(user); tokens != 4 {
+		t.Fatalf("inspecting must not consume or refill, got %v tokens", tokens)
+	}
+}
+
+func TestWaitCtxRedis_ConcurrentWaitersAllProceed(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	user := "redis-waiters"
+	SetUserWindow(user, 300*time.Millisecond)
+	defer SetUserWindow(user, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, 6)
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- WaitCtx(ctx, user, 2)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("every waiter should proceed in time, got %v", err)
+		}
+	}
+	// 6 requests at 2 per 300ms take two further windows
+	if elapsed := time.Since(start); elapsed < 550*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("expected the waiters to be paced over ~600ms, took %v", elapsed)
+	}
+}
+
+func TestRefundLeakyRedis_CappedAtCapacity(t *testing.T) {
+	ensureRedisClean(t)
+	user := "redis-refund-leaky"
+	SetUserMode(user, "leaky")
+	defer SetUserMode(user, "")
+	SetUserWindow(user, 10*time.Second)
+	defer SetUserWindow(user, 0)
+
+	RateLimit(user, 5)
+	RateLimit(user, 5)
+	if err := RefundLeaky(user, 1); err != nil {
+		t.Fatal(err)
+	}
+	if tokens, _, _, _ := InspectLeaky(user); tokens < 4 || tokens > 4.01 {
+		t.Fatalf("expected ~3 tokens plus the refund, got %v", tokens)
+	}
+	// the user has no configured limit: the capacity stored by the request caps it
+	if err := RefundLeaky(user, 10); err != nil {
+		t.Fatal(err)
+	}
+	if tokens := rdb.HGet(ctx, "bucket:"+user, "tokens").Val(); tokens != "5" {
+		t.Fatalf("a refund must not fill the bucket past its capacity of 5, got %s", tokens)
+	}
+	if got := countAllowed(user, 5, 10); got != 5 {
+		t.Fatalf("expected exactly the capacity to be usable after refunds, got %d", got)
+	}
+
+	// a capacity larger than the limit is restored in full
+	SetLeakyDefaults(2, 0)
+	defer SetLeakyDefaults(0, 0)
+	rdb.Del(ctx, "bucket:"+user)
+	allowed, refund := RateLimitRefundable(user, 5)
+	if !allowed {
+		t.Fatal("request should be allowed")
+	}
+	refund()
+	if tokens := rdb.HGet(ctx, "bucket:"+user, "tokens").Val(); tokens != "10" {
+		t.Fatalf("expected the refund to restore the capacity of 10, got %s", tokens)
+	}
+}
+
+func TestPreloadScriptsRedis(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	if err := rdb.ScriptFlush(ctx).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := PreloadScripts(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var shas []string
+	for _, s := range Scripts() {
+		shas = append(shas, s.SHA)
+	}
+	exists, err := rdb.ScriptExists(ctx, shas...).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ok := range exists {
+		if !ok {
+			t.Fatalf("script %s was not loaded", Scripts()[i].Name)
+		}
+	}
+
+	h := countCommands(t)
+	RateLimit("redis-preloaded", 5)
+	if len(h.cmds) != 1 || h.cmds[0] != "evalsha" {
+		t.Fatalf("a preloaded script should run by EVALSHA alone, got %v", h.cmds)
+	}
+}
+
+func TestDecayRedis_BurstThenQuiet(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode(decayMode)
+	defer SetMode("sliding")
+	SetDecayHalfLife(200 * time.Millisecond)
+	defer SetDecayHalfLife(0)
+
+	user := "redis-decay"
+	// Round trips let the score decay a little during the burst.
+	if got := countAllowed(user, 10, 20); got < 10 || got > 11 {
+		t.Fatalf("expected a burst of about 10 to fit, got %d", got)
+	}
+	if ttl := rdb.PTTL(ctx, "decay:"+user).Val(); ttl <= 0 {
+		t.Fatalf("expected the score to expire, got TTL %v", ttl)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if got := countAllowed(user, 10, 20); got < 4 || got > 6 {
+		t.Fatalf("expected about 5 after one half-life, got %d", got)
+	}
+}
+
+func TestResetByPrefixRedis(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	SetUserMode("acme:*bob", "leaky")
+	defer SetUserMode("acme:*bob", "")
+
+	SetUserLimits("acme:carol", []Rule{{Limit: 3, Window: time.Minute}})
+	defer SetUserLimits("acme:carol", nil)
+
+	users := []string{"acme:alice", "acme:*bob", "acme:carol", "acmecorp:alice", "globex:alice"}
+	for _, u := range users {
+		countAllowed(u, 3, 3)
+	}
+	if n := len(rdb.Keys(ctx, "rules:*").Val()); n != 1 {
+		t.Fatalf("expected one rule window key, got %d", n)
+	}
+	// more keys than one SCAN batch
+	for i := 0; i < 2*resetScanCount; i++ {
+		rdb.Set(ctx, "rate:acme:bulk-"+strconv.Itoa(i), 1, time.Minute)
+	}
+
+	if err := ResetByPrefix("acme:"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(rdb.Keys(ctx, "*acme:*").Val()); n != 0 {
+		t.Fatalf("expected every acme: key to be deleted, %d left", n)
+	}
+	if n := len(rdb.Keys(ctx, "rules:*").Val()); n != 0 {
+		t.Fatalf("expected the rule window to be deleted, %d left", n)
+	}
+	for _, key := range []string{"rate:acmecorp:alice", "rate:globex:alice"} {
+		if rdb.Exists(ctx, key).Val() != 1 {
+			t.Fatalf("expected %s to be kept", key)
+		}
+	}
+	if got := countAllowed("acme:*bob", 3, 3); got != 3 {
+		t.Fatalf("expected a fresh bucket after the reset, got %d", got)
+	}
+}
+
+func TestMaxRedisOpsPerSecRedis_BoundsRedisChecks(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	SetMaxRedisOpsPerSec(20)
+	defer SetMaxRedisOpsPerSec(0)
+	RateLimit("redis-guard-warm", 1000) // loads the script
+
+	h := countCommands(t)
+	allowed := 0
+	for i := 0; i < 200; i++ {
+		if RateLimit("redis-guard", 1000) {
+			allowed++
+		}
+	}
+	if n := len(h.cmds); n > 20 {
+		t.Fatalf("expected at most 20 Redis checks within the second, got %d", n)
+	}
+	if allowed != 200 {
+		t.Fatalf("requests past the cap should still be decided in memory, got %d of 200 allowed", allowed)
+	}
+
+	// the in-memory estimate still enforces the limit
+	for i := 0; i < 50; i++ {
+		RateLimit("redis-guard-small", 5)
+	}
+	if RateLimit("redis-guard-small", 5) {
+		t.Fatal("expected the limit to hold for requests decided in memory")
+	}
+}
+
+func TestGrantBurstRedis_AppliesToUserWithTraffic(t *testing.T) {
+	ensureRedisClean(t)
+	SetMode("sliding")
+	defer SetUserLimit("redis-burst-busy", 0)
+	defer SetUserWindow("redis-burst-busy", 0)
+	assertGrantAppliesAtOnce(t, "redis-burst-busy")
+}
@@ -1,8 +1,10 @@
 package limiter
 
 import (
+	"container/list"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -11,10 +13,60 @@ import (
 
 func resetLimiterState() {
 	// reset maps used by package
-	userBuckets = sync.Map{}
-	userSlices = sync.Map{}
+	SetShardCount(defaultShardCount)
+	SetTimezone(nil)
+	timeNow = time.Now
 	userConfig = sync.Map{}
-	leakyBuckets = sync.Map{}
+	userWindows = sync.Map{}
+	userModes = sync.Map{}
+	tiers = sync.Map{}
+	userTiers = sync.Map{}
+	userRules = sync.Map{}
+	userGrace = sync.Map{}
+	userRates = sync.Map{}
+	userRefills = sync.Map{}
+	throttled = sync.Map{}
+	globalWeights = sync.Map{}
+	inFlight = sync.Map{}
+	scheduledRefills = sync.Map{}
+	userBursts = sync.Map{}
+	allowList = sync.Map{}
+	configUnlimited = map[string]struct{}{}
+	denyList = sync.Map{}
+	DisablePenalty()
+	SetMaxUsers(0)
+	SetHistorySize(0)
+	SetStrictMode(false)
+	SetRetryJitter(0)
+	SetZeroLimitPolicy(DenyAll)
+	SetDefaultLimit(0)
+	SetKeyExpiry(-1)
+	EnableCredit(0)
+	SetStore(nil)
+	SetSampleRate(0)
+	SetShadowMode("")
+	Undrain()
+	SetInstanceCount(0)
+	SetCompactResolution(0)
+	SetKeyTransformer(nil)
+	SetLeakyDefaults(0, 0)
+	SetAnonymousLimit(0, 0)
+	SetFailMode(FailClosed)
+	SetWarmup(0)
+	SetDecayHalfLife(0)
+	SetMaxRedisOpsPerSec(0)
+	SetUnknownUserPolicy(AllowWithDefault)
+	SetIdempotencyTTL(0)
+	idemList.Init()
+	idemIndex = map[string]*list.Element{}
+	OnShadowDivergence(nil)
+	sampledVerdict = sync.Map{}
+	SetKeyHashing(false)
+	SetKeySalt("")
+	SetSoftThreshold(0)
+	SetEnforcement(true)
+	OnDeny(nil)
+	SetMaxKeyLength(0)
 	// default mode
 	SetMode("sliding")
 	// disable redis by default in unit tests
@@ -256,3 +308,285 @@ func TestRateLimit_LeakyBucketConcurrent(t *testing.T) {
 		t.Fatalf("leaky concurrent: unexpected allowed requests: %d", allowed)
 	}
 }
+
+func TestEffectiveLimit(t *testing.T) {
+	resetLimiterState()
+
+	SetUserLimit("configured", 7)
+	SetUserLimit("zeroed", 0)
+
+	if got := EffectiveLimit("configured", 3); got != 7 {
+		t.Fatalf("configured user: expected 7, got %d", got)
+	}
+	if got := EffectiveLimit("unconfigured", 3); got != 3 {
+		t.Fatalf("unconfigured user: expected fallback 3, got %d", got)
+	}
+	if got := EffectiveLimit("zeroed", 3); got != 3 {
+		t.Fatalf("zero-configured user: expected fallback 3, got %d", got)
+	}
+}
+
+func TestRateLimit_SubSecondWindow(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	user := "fast-user"
+	limit := 5
+	SetUserWindow(user, 100*time.Millisecond)
+
+	for i := 1; i <= limit; i++ {
+		if !RateLimit(user, limit) {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+	if RateLimit(user, limit) {
+		t.Fatal("request exceeding limit should be denied")
+	}
+
+	time.Sleep(120 * time.Millisecond)
+	for i := 1; i <= limit; i++ {
+		if !RateLimit(user, limit) {
+			t.Fatalf("after 100ms window cleared, request %d should be allowed", i)
+		}
+	}
+}
+
+func TestGetUserWindow_DefaultsToOneSecond(t *testing.T) {
+	resetLimiterState()
+
+	if got := GetUserWindow("nobody"); got != time.Second {
+		t.Fatalf("expected default window of 1s, got %v", got)
+	}
+	SetUserWindow("u", 250*time.Millisecond)
+	if got := GetUserWindow("u"); got != 250*time.Millisecond {
+		t.Fatalf("expected 250ms, got %v", got)
+	}
+	SetUserWindow("u", 0)
+	if got := GetUserWindow("u"); got != time.Second {
+		t.Fatalf("expected reset to 1s, got %v", got)
+	}
+}
+
+func TestRateLimit_LeakyHonorsWindow(t *testing.T) {
+	resetLimiterState()
+	SetMode("leaky")
+
+	user := "slow-leaky"
+	limit := 2
+	SetUserWindow(user, 2*time.Second) // one token per second
+
+	RateLimit(user, limit)
+	RateLimit(user, limit)
+	time.Sleep(600 * time.Millisecond)
+	if RateLimit(user, limit) {
+		t.Fatal("token should not have refilled within 600ms of a 2s window")
+	}
+	time.Sleep(500 * time.Millisecond)
+	if !RateLimit(user, limit) {
+		t.Fatal("one token should have refilled after ~1.1s")
+	}
+}
+
+func TestSetUserMode_OverridesGlobalMode(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	SetUserMode("leaky-user", "leaky")
+	if got := GetUserMode("leaky-user"); got != "leaky" {
+		t.Fatalf("expected leaky override, got %q", got)
+	}
+	if got := GetUserMode("other"); got != "sliding" {
+		t.Fatalf("expected global mode for other users, got %q", got)
+	}
+	RateLimit("leaky-user", 3)
+	if _, ok := leakyBuckets.load("leaky-user"); !ok {
+		t.Fatal("override should route the user to the leaky algorithm")
+	}
+
+	SetUserMode("leaky-user", "")
+	if got := GetUserMode("leaky-user"); got != "sliding" {
+		t.Fatalf("cleared override should fall back to global mode, got %q", got)
+	}
+}
+
+func TestRateLimit_SlidingAllowPathZeroAllocs(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	user := "alloc-user"
+	limit := 1_000_000
+	// warm up: create state and grow the backing array
+	for i := 0; i < 2000; i++ {
+		RateLimit(user, limit)
+	}
+	if allocs := testing.AllocsPerRun(1000, func() { RateLimit(user, limit) }); allocs != 0 {
+		t.Fatalf("allow path: expected 0 allocs per call, got %v", allocs)
+	}
+
+	// steady state with continuous pruning
+	pruned := "alloc-pruned"
+	SetUserWindow(pruned, time.Millisecond)
+	for i := 0; i < 2000; i++ {
+		RateLimit(pruned, limit)
+	}
+	if allocs := testing.AllocsPerRun(1000, func() { RateLimit(pruned, limit) }); allocs != 0 {
+		t.Fatalf("pruning path: expected 0 allocs per call, got %v", allocs)
+	}
+}
+
+func TestKeyExpiry_JitteredNeverShort(t *testing.T) {
+	window := 2 * time.Second
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 200; i++ {
+		d := keyExpiry(window)
+		if d < window+time.Second || d > window+time.Second+300*time.Millisecond {
+			t.Fatalf("expiry %v outside [window+1s, +10%%]", d)
+		}
+		seen[d.Truncate(time.Millisecond)] = true
+	}
+	if len(seen) < 50 {
+		t.Fatalf("expiries should be spread, got only %d distinct values", len(seen))
+	}
+}
+
+func TestKeyExpiry_Override(t *testing.T) {
+	defer SetKeyExpiry(-1)
+
+	SetKeyExpiry(time.Hour)
+	if d := keyExpiry(2 * time.Second); d != time.Hour {
+		t.Fatalf("expected the fixed expiry, got %v", d)
+	}
+	if d := keyExpiry(2 * time.Hour); d < 2*time.Hour+time.Second {
+		t.Fatalf("a fixed expiry must not cut a longer window short, got %v", d)
+	}
+	SetKeyExpiry(0)
+	if d := keyExpiry(2 * time.Second); d != 0 || !persistentKeys() {
+		t.Fatalf("persistent keys should report no expiry, got %v", d)
+	}
+	SetKeyExpiry(-1)
+	EnableCredit(0)
+	SetStore(nil)
+	SetSampleRate(0)
+	sampledVerdict = sync.Map{}
+	if d := keyExpiry(2 * time.Second); d < 3*time.Second || persistentKeys() {
+		t.Fatalf("expected the derived default, got %v", d)
+	}
+}
+
+func TestForEachUser(t *testing.T) {
+	resetLimiterState()
+	SetUserLimit("each-a", 1)
+	SetUserLimit("each-b", 2)
+	SetUserLimit("each-c", 3)
+
+	got := map[string]int{}
+	ForEachUser(func(userID string, limit int) bool {
+		got[userID] = limit
+		return true
+	})
+	if len(got) != 3 || got["each-a"] != 1 || got["each-b"] != 2 || got["each-c"] != 3 {
+		t.Fatalf("ForEachUser visited %v", got)
+	}
+
+	visited := 0
+	ForEachUser(func(string, int) bool {
+		visited++
+		return false
+	})
+	if visited != 1 {
+		t.Fatalf("returning false should stop the iteration, visited %d", visited)
+	}
+}
+
+func TestForEachUser_ConcurrentMutation(t *testing.T) {
+	resetLimiterState()
+	for i := 0; i < 100; i++ {
+		SetUserLimit("mut-"+strconv.Itoa(i), i+1)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			SetUserLimit("mut-"+strconv.Itoa(i%200), i+1)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		ForEachUser(func(userID string, limit int) bool {
+			if limit <= 0 || !strings.HasPrefix(userID, "mut-") {
+				t.Errorf("unexpected entry %q=%d", userID, limit)
+				return false
+			}
+			return true
+		})
+	}
+}
+
+func TestSliding_ConcurrentDifferingLimitsEnforceLower(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	user := "reload-user"
+	SetUserWindow(user, 200*time.Millisecond)
+
+	// a config reload races: some callers still pass 10, others already 3
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	RateLimit(user, 3)
+	allowed.Add(1)
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(limit int) {
+			defer wg.Done()
+			if RateLimit(user, limit) {
+				allowed.Add(1)
+			}
+		}([]int{10, 3}[i%2])
+	}
+	wg.Wait()
+	if allowed.Load() != 3 {
+		t.Fatalf("overlapping limits 10 and 3 should admit 3, admitted %d", allowed.Load())
+	}
+
+	// once a window has passed without the lower limit, the raise applies
+	time.Sleep(250 * time.Millisecond)
+	for i := 1; i <= 10; i++ {
+		if !RateLimit(user, 10) {
+			t.Fatalf("request %d under the raised limit should be allowed", i)
+		}
+	}
+}
+
+func TestSetUserLimitsBulk(t *testing.T) {
+	resetLimiterState()
+	SetUserRate("bulk-a", 0.5, time.Second)
+
+	SetUserLimitsBulk(map[string]int{"bulk-a": 3, "bulk-b": 7})
+	if got, _ := GetUserLimit("bulk-a"); got != 3 {
+		t.Fatalf("expected bulk-a limit 3, got %d", got)
+	}
+	if got, _ := GetUserLimit("bulk-b"); got != 7 {
+		t.Fatalf("expected bulk-b limit 7, got %d", got)
+	}
+	if _, _, ok := GetUserRate("bulk-a"); ok {
+		t.Fatal("a bulk limit must replace the user's fractional rate, as SetUserLimit does")
+	}
+}
+
+func TestCompactMode_InMemoryIsSliding(t *testing.T) {
+	resetLimiterState()
+	SetMode(compactMode)
+	if GetMode() != compactMode {
+		t.Fatalf("expected %q to be a valid mode, got %q", compactMode, GetMode())
+	}
+	if got := countAllowed("compact-mem", 3, 5); got != 3 {
+		t.Fatalf("without Redis the compact mode is the sliding window: expected 3, got %d", got)
+	}
+	if st := Stats("compact-mem", 3); st.Used != 3 {
+		t.Fatalf("expected 3 used, got %d", st.Used)
+	}
+}
@@ -0,0 +1,70 @@
+package limiter
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	// allow-list: users that bypass limiting entirely
+	allowList = sync.Map{} // map[userID]struct{}
+
+	// deny-list: hard-blocked users, consulted before anything else
+	denyList = sync.Map{} // map[userID]int64 (expiry in unix ms; 0 = permanent)
+)
+
+// AllowList marks users as unlimited: RateLimit always allows them without
+// consuming or creating any bucket state.
+func AllowList(userIDs ...string) {
+	for _, id := range userIDs {
+		allowList.Store(id, struct{}{})
+	}
+}
+
+// RemoveFromAllowList makes users subject to their normal limits again.
+func RemoveFromAllowList(userIDs ...string) {
+	for _, id := range userIDs {
+		allowList.Delete(id)
+	}
+}
+
+// IsAllowListed reports whether the user bypasses limiting.
+func IsAllowListed(userID string) bool {
+	_, ok := allowList.Load(userID)
+	return ok
+}
+
+// DenyList hard-blocks users: RateLimit always denies them without touching
+// Redis or in-memory buckets, until they are removed with RemoveFromDenyList.
+func DenyList(userIDs ...string) {
+	for _, id := range userIDs {
+		denyList.Store(id, int64(0))
+	}
+}
+
+// DenyListFor blocks a user for d, after which the block lifts automatically.
+func DenyListFor(userID string, d time.Duration) {
+	denyList.Store(userID, monoNowMs()+d.Milliseconds())
+}
+
+// RemoveFromDenyList lifts the block on users.
+func RemoveFromDenyList(userIDs ...string) {
+	for _, id := range userIDs {
+		denyList.Delete(id)
+	}
+}
+
+// IsDenyListed reports whether the user is currently blocked.
+// Expired temporary entries are removed on lookup.
+func IsDenyListed(userID string) bool {
+	v, ok := denyList.Load(userID)
+	if !ok {
+		return false
+	}
+	until := v.(int64)
+	if until == 0 || monoNowMs() < until {
+		return true
+	}
+	denyList.CompareAndDelete(userID, v)
+	return false
+}
@@ -0,0 +1,106 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllowList_NeverDenied(t *testing.T) {
+	for _, mode := range []string{"sliding", "leaky"} {
+		resetLimiterState()
+		SetMode(mode)
+
+		user := "vip"
+		AllowList(user)
+		for i := 0; i < 1000; i++ {
+			if !RateLimit(user, 1) {
+				t.Fatalf("%s: allow-listed request %d should never be denied", mode, i+1)
+			}
+		}
+		if _, ok := slidingStates.load(user); ok {
+			t.Fatalf("%s: allow-listed user should not accumulate sliding state", mode)
+		}
+		if _, ok := leakyBuckets.load(user); ok {
+			t.Fatalf("%s: allow-listed user should not accumulate leaky state", mode)
+		}
+
+		RemoveFromAllowList(user)
+		RateLimit(user, 1)
+		if RateLimit(user, 1) {
+			t.Fatalf("%s: removed user should be limited again", mode)
+		}
+	}
+}
+
+func TestAllowList_FromExtendedConfig(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	writeTempConfig(t, "test_users_unlimited.json", `{"svc":{"unlimited":true},"alice":1}`)
+	if err := LoadUserConfigFromJSON("test_users_unlimited.json"); err != nil {
+		t.Fatal(err)
+	}
+	if !IsAllowListed("svc") {
+		t.Fatal("unlimited entry should be allow-listed")
+	}
+	for i := 0; i < 50; i++ {
+		if !RateLimit("svc", 1) {
+			t.Fatal("unlimited user should never be denied")
+		}
+	}
+	assertAllowance(t, "alice", 1)
+
+	// a reload without the flag limits the user again
+	writeTempConfig(t, "test_users_unlimited.json", `{"svc":1,"alice":1}`)
+	if err := LoadUserConfigFromJSON("test_users_unlimited.json"); err != nil {
+		t.Fatal(err)
+	}
+	if IsAllowListed("svc") {
+		t.Fatal("a reload without the unlimited flag should remove the user from the allow-list")
+	}
+	assertAllowance(t, "svc", 1)
+}
+
+func TestDenyList_Permanent(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	user := "compromised"
+	DenyList(user)
+	if RateLimit(user, 100) {
+		t.Fatal("deny-listed user should be denied")
+	}
+	if _, ok := slidingStates.load(user); ok {
+		t.Fatal("deny-listed user should not touch bucket state")
+	}
+
+	// deny-list wins over allow-list
+	AllowList(user)
+	if RateLimit(user, 100) {
+		t.Fatal("deny-list should take precedence over allow-list")
+	}
+
+	RemoveFromDenyList(user)
+	RemoveFromAllowList(user)
+	if !RateLimit(user, 100) {
+		t.Fatal("user should be allowed after removal from deny-list")
+	}
+}
+
+func TestDenyListFor_LiftsAfterDuration(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	user := "timeout"
+	DenyListFor(user, 100*time.Millisecond)
+	if RateLimit(user, 100) {
+		t.Fatal("temporarily blocked user should be denied")
+	}
+	time.Sleep(150 * time.Millisecond)
+	if !RateLimit(user, 100) {
+		t.Fatal("block should lift after its duration")
+	}
+	if IsDenyListed(user) {
+		t.Fatal("expired entry should no longer be reported")
+	}
+}
@@ -0,0 +1,80 @@
+package limiter
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// max-users cap (in-memory): LRU of tracked user IDs
+	lruMu      sync.Mutex
+	lruEnabled atomic.Bool
+	maxUsers   int
+	lruList    = list.New()                 // front = most recently used
+	lruIndex   = map[string]*list.Element{} // userID -> element in lruList
+)
+
+// SetMaxUsers caps how many distinct users have in-memory state. When a new
+// user would exceed the cap, the least-recently-used user's sliding, leaky,
+// daily, rule and decay state is evicted; an evicted user simply starts fresh
+// on their next request.
+// n <= 0 removes the cap (the default).
+func SetMaxUsers(n int) {
+	lruMu.Lock()
+	defer lruMu.Unlock()
+	if n <= 0 {
+		maxUsers = 0
+		lruEnabled.Store(false)
+		lruList.Init()
+		lruIndex = map[string]*list.Element{}
+		return
+	}
+	maxUsers = n
+	lruEnabled.Store(true)
+	evictOverflowLocked()
+}
+
+// touchUser marks userID as most recently used, evicting the oldest user if
+// the cap is exceeded. It is a no-op unless SetMaxUsers is in effect.
+func touchUser(userID string) {
+	if !lruEnabled.Load() {
+		return
+	}
+	lruMu.Lock()
+	defer lruMu.Unlock()
+	if el, ok := lruIndex[userID]; ok {
+		lruList.MoveToFront(el)
+		return
+	}
+	lruIndex[userID] = lruList.PushFront(userID)
+	evictOverflowLocked()
+}
+
+// evictOverflowLocked drops users from the back of the LRU until within the cap.
+// Caller holds lruMu.
+func evictOverflowLocked() {
+	for maxUsers > 0 && lruList.Len() > maxUsers {
+		el := lruList.Back()
+		userID := el.Value.(string)
+		lruList.Remove(el)
+		delete(lruIndex, userID)
+		slidingStates.delete(userID)
+		leakyBuckets.delete(userID)
+		dailyStates.delete(userID)
+		ruleStates.delete(userID)
+		decayStates.delete(userID)
+	}
+}
+
+// forgetUsers drops the users satisfying match from the LRU.
+func forgetUsers(match func(userID string) bool) {
+	lruMu.Lock()
+	defer lruMu.Unlock()
+	for userID, el := range lruIndex {
+		if match(userID) {
+			lruList.Remove(el)
+			delete(lruIndex, userID)
+		}
+	}
+}
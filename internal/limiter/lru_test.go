@@ -0,0 +1,75 @@
+package limiter
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestSetMaxUsers_EvictsLeastRecentlyUsed(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	n := 3
+	SetMaxUsers(n)
+
+	// first user exhausts their limit of 1
+	if !RateLimit("user-0", 1) {
+		t.Fatal("user-0 first request should be allowed")
+	}
+	if RateLimit("user-0", 1) {
+		t.Fatal("user-0 second request should be denied")
+	}
+	for i := 1; i <= n; i++ {
+		RateLimit("user-"+strconv.Itoa(i), 1)
+	}
+
+	if _, ok := slidingStates.load("user-0"); ok {
+		t.Fatal("least-recently-used user should have been evicted")
+	}
+	for i := 1; i <= n; i++ {
+		if _, ok := slidingStates.load("user-" + strconv.Itoa(i)); !ok {
+			t.Fatalf("user-%d should still be tracked", i)
+		}
+	}
+	// evicted user starts fresh
+	if !RateLimit("user-0", 1) {
+		t.Fatal("evicted user should start with a fresh window")
+	}
+}
+
+func TestSetMaxUsers_EvictsDailyState(t *testing.T) {
+	resetLimiterState()
+	SetMode("daily")
+	SetMaxUsers(2)
+
+	RateLimit("a", 5)
+	RateLimit("b", 5)
+	RateLimit("c", 5)
+
+	if _, ok := dailyStates.load("a"); ok {
+		t.Fatal("least-recently-used daily user should be evicted")
+	}
+	for _, user := range []string{"b", "c"} {
+		if _, ok := dailyStates.load(user); !ok {
+			t.Fatalf("daily user %s should still be tracked", user)
+		}
+	}
+}
+
+func TestSetMaxUsers_RecentUseProtectsFromEviction(t *testing.T) {
+	resetLimiterState()
+	SetMode("leaky")
+	SetMaxUsers(2)
+
+	RateLimit("a", 5)
+	RateLimit("b", 5)
+	RateLimit("a", 5) // a is now most recently used
+	RateLimit("c", 5)
+
+	if _, ok := leakyBuckets.load("a"); !ok {
+		t.Fatal("recently used user should be kept")
+	}
+	if _, ok := leakyBuckets.load("b"); ok {
+		t.Fatal("least-recently-used user should be evicted")
+	}
+}
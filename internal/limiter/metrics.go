@@ -0,0 +1,71 @@
+package limiter
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// UserMetrics is one entry of MetricsJSON.
+type UserMetrics struct {
+	UserStats
+	ResetAfterMs int64 `json:"reset_after_ms"` // until usage drops back to zero
+}
+
+// MetricsJSON dumps the current usage of every known user as a JSON array of
+// UserMetrics, sorted by user. With SetKeyHashing on, users are reported by
+// their HashKey rather than their ID. Known users are those with a configured limit
+// plus those with in-memory state; with Redis, only configured users are
+// listed. Usage is read as in Stats, so nothing is consumed. Limits are
+// resolved as in RateLimit with no fallback, so a user with state but no
+// configured or tier limit reports a limit of 0.
+//
+// State maps are walked one shard at a time under read locks, so a dump of
+// many users never blocks the request path for long.
+func MetricsJSON() ([]byte, error) {
+	users := trackedUsers()
+	out := make([]UserMetrics, 0, len(users))
+	for _, u := range users {
+		st := stats(u, 0)
+		st.User = reportedUser(u)
+		reset := timeUntilDrained(u, st.Mode, st.Limit, GetUserWindow(u), st.Used)
+		out = append(out, UserMetrics{UserStats: st, ResetAfterMs: reset.Milliseconds()})
+	}
+	if IsKeyHashing() {
+		// keep the order from hinting at the IDs behind the hashes
+		sort.Slice(out, func(i, j int) bool { return out[i].User < out[j].User })
+	}
+	return json.Marshal(out)
+}
+
+// trackedUsers returns the sorted IDs of configured users and users with
+// in-memory state.
+func trackedUsers() []string {
+	seen := map[string]struct{}{}
+	ForEachUser(func(userID string, _ int) bool {
+		seen[userID] = struct{}{}
+		return true
+	})
+	slidingStates.rangeAll(func(k string, _ *slidingState) bool {
+		seen[k] = struct{}{}
+		return true
+	})
+	leakyBuckets.rangeAll(func(k string, _ *leakyState) bool {
+		seen[k] = struct{}{}
+		return true
+	})
+	dailyStates.rangeAll(func(k string, _ *dailyState) bool {
+		seen[k] = struct{}{}
+		return true
+	})
+	decayStates.rangeAll(func(k string, _ *decayState) bool {
+		seen[k] = struct{}{}
+		return true
+	})
+
+	users := make([]string, 0, len(seen))
+	for u := range seen {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	return users
+}
@@ -0,0 +1,64 @@
+package limiter
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestMetricsJSON_RoundTrip(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	SetUserLimit("alice", 3)
+	SetUserLimit("idle", 5)
+	RateLimit("alice", 100)
+	RateLimit("alice", 100)
+	RateLimit("bob", 4) // unconfigured, but has state
+
+	data, err := MetricsJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []UserMetrics
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("metrics are not a JSON array of stats: %v\n%s", err, data)
+	}
+	if len(got) != 3 || got[0].User != "alice" || got[1].User != "bob" || got[2].User != "idle" {
+		t.Fatalf("expected alice, bob, idle in order, got %+v", got)
+	}
+
+	alice := got[0]
+	if alice.Limit != 3 || alice.Mode != "sliding" || alice.Used != 2 || alice.Remaining != 1 {
+		t.Fatalf("unexpected alice metrics: %+v", alice)
+	}
+	if alice.ResetAfterMs <= 0 || alice.ResetAfterMs > time.Second.Milliseconds() {
+		t.Fatalf("alice should reset within the 1s window, got %dms", alice.ResetAfterMs)
+	}
+	if bob := got[1]; bob.Used != 1 || bob.Limit != 0 {
+		t.Fatalf("unexpected bob metrics: %+v", bob)
+	}
+	if idle := got[2]; idle.Used != 0 || idle.Remaining != 5 || idle.ResetAfterMs != 0 {
+		t.Fatalf("unexpected idle metrics: %+v", idle)
+	}
+
+	var raw []map[string]any
+	json.Unmarshal(data, &raw)
+	for _, field := range []string{"user", "limit", "mode", "used", "remaining", "reset_after_ms"} {
+		if _, ok := raw[0][field]; !ok {
+			t.Fatalf("missing field %q in %s", field, data)
+		}
+	}
+}
+
+func TestMetricsJSON_Empty(t *testing.T) {
+	resetLimiterState()
+
+	data, err := MetricsJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "[]" {
+		t.Fatalf("expected empty array, got %s", data)
+	}
+}
@@ -0,0 +1,338 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Result describes a single rate-limit decision.
+type Result struct {
+	Allowed    bool          // whether the request may proceed
+	Limit      int           // limit that was applied
+	Remaining  int           // requests left in the current window after this one
+	RetryAfter time.Duration // when denied, how long until a request could succeed
+	Grace      bool          // allowed past Limit thanks to the user's grace allowance
+	Err        error         // why the decision could not be made (see HTTPStatusFor)
+	Rule       string        // with SetUserLimits, the rule that denied the request, e.g. "100/m"
+	Reason     Reason        // when denied, why (e.g. ReasonQuota, ReasonDenyList)
+}
+
+// AllowResult is like RateLimit but also reports the applied limit, the
+// remaining allowance and, when denied, how long the caller should wait.
+// With a grace allowance (SetUserGrace), Limit and Remaining refer to the base
+// limit, and Grace marks requests allowed past it. With multi-window rules
+// (SetUserLimits), an allowed request reports the Limit and Remaining of the
+// rule with the fewest requests left; a denial names the binding rule in Rule:
+// of the full rules, the one freeing a slot last, whose Limit and reset
+// RetryAfter reports.
+func AllowResult(userID string, limit int) Result {
+	return allowResult(ctx, transformKey(userID), limit)
+}
+
+// AllowN is AllowResult for a request costing n units of the limit, e.g. a
+// batch of n operations. The request is allowed only if all n units fit, and
+// then consumes them all in the same step as the check (one script with
+// Redis), so concurrent requests cannot together overshoot the limit. Short
+// of strict mode (SetStrictMode), a denied request consumes nothing. The
+// sliding, leaky and daily algorithms charge n units; multi-window rules, the
+// compact and decay modes and a Store (SetStore) decide one request at a time
+// and charge one. Remaining counts units; n < 1 counts as 1.
+func AllowN(userID string, n, limit int) Result {
+	return allowResultN(ctx, transformKey(userID), n, limit)
+}
+
+// allowResult is AllowResult under the caller's context for a key already
+// transformed (see SetKeyTransformer).
+func allowResult(ctx context.Context, userID string, limit int) Result {
+	return allowResultN(ctx, userID, 1, limit)
+}
+
+// allowResultN is allowResult for a request costing n units.
+func allowResultN(ctx context.Context, userID string, n, limit int) Result {
+	allowed, rc := rateLimitN(ctx, userID, limit, n, time.Time{}, GetFailMode())
+	res := Result{Allowed: allowed, Limit: rc.limit, Grace: rc.grace}
+	if rc.err != nil {
+		res.Err = backendError(ctx, rc.err)
+	}
+	if !allowed {
+		res.Reason = denyReason(rc)
+	}
+	if res.Limit == 0 {
+		res.Limit = EffectiveLimit(userID, limit)
+	}
+	if rc.rule > 0 {
+		return explainRule(userID, res, rc)
+	}
+	hardLimit := res.Limit
+	if rc.mode != "" {
+		res.Limit -= GetUserGrace(userID)
+	}
+	if rc.mode == "" {
+		// decided without touching bucket state (e.g. allow- or deny-listed)
+		return res
+	}
+	n = costFor(rc.mode, n)
+	if rc.usageKnown {
+		// the sliding decision already reported the window
+		res.Remaining = max(res.Limit-rc.used, 0)
+		if !allowed {
+			res.RetryAfter = jitterRetryAfter(userID, retryFromOldest(userID, hardLimit, n, rc))
+		}
+		return res
+	}
+	res.Remaining = max(res.Limit-usedAfter(userID, rc.mode, hardLimit, rc), 0)
+	if !allowed && n > 1 {
+		res.RetryAfter = jitterRetryAfter(userID, timeUntilCost(userID, hardLimit, n))
+	} else if !allowed {
+		res.RetryAfter = jitterRetryAfter(userID, timeUntilAvailable(userID, hardLimit))
+	}
+	return res
+}
+
+// explainRule fills in a decision by the user's multi-window rules: the
+// limit and remaining count of the rule with the fewest requests left, or the
+// rule that denied the request and its reset.
+func explainRule(userID string, res Result, rc receipt) Result {
+	rules := GetUserLimits(userID)
+	if rc.rule > len(rules) {
+		return res // the rules changed meanwhile
+	}
+	r := rules[rc.rule-1]
+	res.Limit = r.Limit
+	if rc.used > 0 {
+		// allowed: only allowed decisions report the rule's usage
+		res.Remaining = max(r.Limit-rc.used, 0)
+		return res
+	}
+	res.Rule = r.String()
+	res.RetryAfter = jitterRetryAfter(userID, time.Duration(max(rc.retryMs, 0))*time.Millisecond)
+	return res
+}
+
+// retryFromOldest derives the sliding wait for a request costing n units
+// from the decision: the wait the Redis script computed, if it did, or else
+// from the usage reported, as a full window frees its first slot when the
+// oldest request expires. Windows over the limit (e.g. after lowering it),
+// and requests needing several slots, then need a lookup.
+func retryFromOldest(userID string, limit, n int, rc receipt) time.Duration {
+	if rc.retryKnown {
+		return time.Duration(rc.retryMs) * time.Millisecond
+	}
+	if n > 1 {
+		return timeUntilCost(userID, limit, n)
+	}
+	if rc.used > limit || rc.oldestMs == 0 {
+		return timeUntilAvailable(userID, limit)
+	}
+	wait := rc.oldestMs + GetUserWindow(userID).Milliseconds() - rc.decidedMs
+	if wait < 0 {
+		return 0
+	}
+	return time.Duration(wait) * time.Millisecond
+}
+
+// MiddlewareOptions configures Middleware.
+type MiddlewareOptions struct {
+	// KeyFunc identifies the caller (the userID). Requests for which it
+	// returns "" are rejected with 400.
+	KeyFunc func(r *http.Request) string
+	// Limit is the fallback limit for users without a configured limit.
+	Limit int
+	// OnReject writes the response for a throttled request. The Retry-After
+	// and X-RateLimit-* headers are already set when it is called.
+	// Defaults to a JSON 429 body (see WriteRateLimitedJSON).
+	OnReject func(w http.ResponseWriter, r *http.Request, res Result)
+	// OnError writes the response for a request denied because the limiter
+	// could not decide, e.g. while Redis is unreachable, so backend failures
+	// do not read as client throttling. Defaults to a JSON body with the
+	// status HTTPStatusFor picks (see WriteLimiterErrorJSON).
+	OnError func(w http.ResponseWriter, r *http.Request, res Result)
+	// Classes maps path prefixes to endpoint classes with their own limit,
+	// e.g. {"/admin/": 5}. A key may name a method first, as in "POST /admin/",
+	// to cover only that method. A request whose path starts with a prefix is
+	// limited per user and class, under EndpointClassKey, with the class's
+	// limit instead of Limit; the longest matching prefix wins, and a class
+	// naming the request's method beats one without at the same prefix.
+	// Other requests are limited under the user's key as usual.
+	Classes map[string]int
+	// CostHeader names a request header, e.g. "X-Cost", in which clients
+	// declare what a request costs: that many units of the limit are charged
+	// for it instead of one, through AllowN. A missing, malformed or
+	// non-positive cost counts as 1. A request costing more than the user has
+	// left is rejected without charging anything. Algorithms that AllowN
+	// charges one unit per request, such as multi-window rules, ignore the
+	// cost. Empty disables weighted costs.
+	CostHeader string
+	// MaxCost caps a declared cost; 0 leaves it uncapped.
+	MaxCost int
+}
+
+// endpointClass is a parsed MiddlewareOptions.Classes entry.
+type endpointClass struct {
+	method, prefix string
+	limit          int
+}
+
+// parseClasses parses a Classes table, ordering it so that the first class
+// matching a request is the one that applies.
+func parseClasses(table map[string]int) []endpointClass {
+	classes := make([]endpointClass, 0, len(table))
+	for pattern, limit := range table {
+		c := endpointClass{prefix: pattern, limit: limit}
+		if method, prefix, ok := strings.Cut(pattern, " "); ok {
+			c.method, c.prefix = method, strings.TrimSpace(prefix)
+		}
+		classes = append(classes, c)
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		a, b := classes[i], classes[j]
+		if len(a.prefix) != len(b.prefix) {
+			return len(a.prefix) > len(b.prefix)
+		}
+		return a.method > b.method
+	})
+	return classes
+}
+
+// classify returns the key and limit r is limited under.
+func classify(classes []endpointClass, key string, limit int, r *http.Request) (string, int) {
+	for _, c := range classes {
+		if strings.HasPrefix(r.URL.Path, c.prefix) && (c.method == "" || c.method == r.Method) {
+			return EndpointClassKey(key, c.method, c.prefix), c.limit
+		}
+	}
+	return key, limit
+}
+
+// requestCost returns the cost r declares in opts.CostHeader.
+func requestCost(r *http.Request, opts MiddlewareOptions) int {
+	if opts.CostHeader == "" {
+		return 1
+	}
+	cost, err := strconv.Atoi(strings.TrimSpace(r.Header.Get(opts.CostHeader)))
+	if err != nil || cost < 1 {
+		return 1
+	}
+	if opts.MaxCost > 0 {
+		cost = min(cost, opts.MaxCost)
+	}
+	return cost
+}
+
+// timeUntilCost returns how long until userID could be charged cost units
+// under limit.
+func timeUntilCost(userID string, limit, cost int) time.Duration {
+	if GetUserMode(userID) != "leaky" {
+		// as soon as usage drops below limit-cost+1
+		return timeUntilAvailable(userID, limit-cost+1)
+	}
+	window := GetUserWindow(userID)
+	missing := float64(cost) - peekLeakyTokens(userID, limit, window)
+	if missing <= 0 {
+		return 0
+	}
+	_, ratePerMs := leakyParams(userID, limit, window)
+	return time.Duration(math.Ceil(missing/ratePerMs)) * time.Millisecond
+}
+
+// Middleware rate-limits requests before they reach next.
+// Every response carries X-RateLimit-Limit and X-RateLimit-Remaining headers;
+// throttled responses also carry Retry-After (whole seconds, rounded up).
+// The request handed to OnReject or OnError carries the denial's Reason in its
+// context (see DenyReasonFromContext).
+func Middleware(opts MiddlewareOptions) func(http.Handler) http.Handler {
+	onReject := opts.OnReject
+	if onReject == nil {
+		onReject = WriteRateLimitedJSON
+	}
+	onError := opts.OnError
+	if onError == nil {
+		onError = WriteLimiterErrorJSON
+	}
+	classes := parseClasses(opts.Classes)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := opts.KeyFunc(r)
+			if key == "" {
+				http.Error(w, "missing rate limit key", http.StatusBadRequest)
+				return
+			}
+
+			key, limit := classify(classes, key, opts.Limit, r)
+			res := AllowN(key, requestCost(r, opts), limit)
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			if !res.Allowed {
+				r = r.WithContext(withDenyReason(r.Context(), res.Reason))
+			}
+			if !res.Allowed && res.Err != nil {
+				onError(w, r, res)
+				return
+			}
+			if !res.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(res.RetryAfter)))
+				onReject(w, r, res)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WriteRateLimitedJSON is the default rejection response:
+// 429 with {"error":"rate_limited","retry_after_seconds":N}.
+func WriteRateLimitedJSON(w http.ResponseWriter, r *http.Request, res Result) {
+	writeJSON(w, http.StatusTooManyRequests, struct {
+		Error             string `json:"error"`
+		RetryAfterSeconds int    `json:"retry_after_seconds"`
+	}{"rate_limited", retryAfterSeconds(res.RetryAfter)})
+}
+
+// WriteLimiterErrorJSON is the default response for a request the limiter
+// could not decide: the status HTTPStatusFor picks for res.Err, with
+// {"error":"rate_limiter_unavailable"} (or "rate_limiter_error" for other
+// failures).
+func WriteLimiterErrorJSON(w http.ResponseWriter, r *http.Request, res Result) {
+	status := HTTPStatusFor(res.Allowed, res.Err)
+	code := "rate_limiter_error"
+	if status == http.StatusServiceUnavailable {
+		code = "rate_limiter_unavailable"
+	}
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{code})
+}
+
+// HTTPStatusFor maps a decision and the error returned with it (by Allow, or
+// in Result.Err) to an HTTP status: 200 when allowed, 429 for a quota hit,
+// 503 when the backend is unavailable, the limiter is draining or the
+// caller's context ended first, and 500 for any other failure, such as an
+// invalid limit.
+func HTTPStatusFor(allowed bool, err error) int {
+	switch {
+	case allowed:
+		// e.g. let through despite a failure with enforcement off
+		return http.StatusOK
+	case errors.Is(err, ErrBackendUnavailable), errors.Is(err, ErrContextCanceled), errors.Is(err, ErrDraining):
+		return http.StatusServiceUnavailable
+	case err != nil:
+		return http.StatusInternalServerError
+	}
+	return http.StatusTooManyRequests
+}
+
+// retryAfterSeconds converts a wait to Retry-After seconds, rounding up and
+// never reporting less than one second.
+func retryAfterSeconds(d time.Duration) int {
+	secs := int(math.Ceil(d.Seconds()))
+	if secs < 1 {
+		return 1
+	}
+	return secs
+}
@@ -0,0 +1,413 @@
+package limiter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func userKey(r *http.Request) string {
+	return r.URL.Query().Get("user")
+}
+
+func serve(h http.Handler, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+func TestMiddleware_JSONRejection(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	h := Middleware(MiddlewareOptions{KeyFunc: userKey, Limit: 2})(okHandler)
+	for i := 1; i <= 2; i++ {
+		if rec := serve(h, "/api?user=alice"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, rec.Code)
+		}
+	}
+
+	rec := serve(h, "/api?user=alice")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected application/json, got %q", ct)
+	}
+	if ra := rec.Header().Get("Retry-After"); ra != "1" {
+		t.Fatalf("expected Retry-After of 1s, got %q", ra)
+	}
+	if rem := rec.Header().Get("X-RateLimit-Remaining"); rem != "0" {
+		t.Fatalf("expected no remaining requests, got %q", rem)
+	}
+	var body struct {
+		Error             string `json:"error"`
+		RetryAfterSeconds int    `json:"retry_after_seconds"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error != "rate_limited" || body.RetryAfterSeconds != 1 {
+		t.Fatalf("unexpected body: %+v", body)
+	}
+}
+
+func TestMiddleware_CustomOnReject(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	var got Result
+	h := Middleware(MiddlewareOptions{
+		KeyFunc: userKey,
+		Limit:   1,
+		OnReject: func(w http.ResponseWriter, r *http.Request, res Result) {
+			got = res
+			w.WriteHeader(http.StatusTeapot)
+		},
+	})(okHandler)
+
+	serve(h, "/api?user=bob")
+	rec := serve(h, "/api?user=bob")
+	if rec.Code != http.StatusTeapot {
+		t.Fatalf("expected custom status, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatal("Retry-After must be set even with a custom OnReject")
+	}
+	if got.Allowed || got.Limit != 1 || got.RetryAfter <= 0 || got.RetryAfter > time.Second {
+		t.Fatalf("unexpected result passed to OnReject: %+v", got)
+	}
+}
+
+func TestMiddleware_MissingKey(t *testing.T) {
+	resetLimiterState()
+
+	h := Middleware(MiddlewareOptions{KeyFunc: userKey, Limit: 1})(okHandler)
+	if rec := serve(h, "/api"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for missing key, got %d", rec.Code)
+	}
+}
+
+func TestAllowResult_RetryAfterLeaky(t *testing.T) {
+	resetLimiterState()
+	SetMode("leaky")
+
+	user := "leaky-result"
+	AllowResult(user, 2)
+	AllowResult(user, 2)
+	res := AllowResult(user, 2)
+	if res.Allowed {
+		t.Fatal("third request should be denied")
+	}
+	if res.RetryAfter <= 0 || res.RetryAfter > 500*time.Millisecond {
+		t.Fatalf("expected retry within one token interval (500ms), got %v", res.RetryAfter)
+	}
+}
+
+func TestHTTPStatusFor(t *testing.T) {
+	cases := []struct {
+		allowed bool
+		err     error
+		want    int
+	}{
+		{true, nil, http.StatusOK},
+		{false, nil, http.StatusTooManyRequests},
+		{false, backendError(context.Background(), errors.New("dial tcp: refused")), http.StatusServiceUnavailable},
+		{false, contextError(context.Canceled), http.StatusServiceUnavailable},
+		{false, fmt.Errorf("%w: 0", ErrInvalidLimit), http.StatusInternalServerError},
+		{true, backendError(context.Background(), errors.New("timeout")), http.StatusOK},
+	}
+	for _, c := range cases {
+		if got := HTTPStatusFor(c.allowed, c.err); got != c.want {
+			t.Errorf("HTTPStatusFor(%v, %v) = %d, want %d", c.allowed, c.err, got, c.want)
+		}
+	}
+}
+
+func TestMiddleware_BackendErrorIs503(t *testing.T) {
+	resetLimiterState()
+	InitRedis("127.0.0.1:1", "", 0, WithDialTimeout(100*time.Millisecond), func(o *redis.Options) { o.MaxRetries = -1 })
+	defer func() { rdb = nil }()
+
+	h := Middleware(MiddlewareOptions{KeyFunc: userKey, Limit: 2})(okHandler)
+	rec := serve(h, "/api?user=alice")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("an unreachable backend should yield 503, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "" {
+		t.Fatal("a backend failure is not throttling; no Retry-After expected")
+	}
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["error"] != "rate_limiter_unavailable" {
+		t.Fatalf("unexpected body %q (%v)", rec.Body.String(), err)
+	}
+
+	rdb = nil
+	for i := 0; i < 2; i++ {
+		serve(h, "/api?user=bob")
+	}
+	if rec := serve(h, "/api?user=bob"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("a quota hit should yield 429, got %d", rec.Code)
+	}
+}
+
+func TestMiddleware_EndpointClassesShareBucket(t *testing.T) {
+	resetLimiterState()
+
+	h := Middleware(MiddlewareOptions{
+		KeyFunc: userKey,
+		Limit:   10,
+		Classes: map[string]int{"/admin/": 2, "/admin/reports/": 5},
+	})(okHandler)
+
+	for i, path := range []string{"/admin/x", "/admin/y"} {
+		if rec := serve(h, path+"?user=alice"); rec.Code != http.StatusOK {
+			t.Fatalf("admin request %d: expected 200, got %d", i+1, rec.Code)
+		}
+	}
+	rec := serve(h, "/admin/x?user=alice")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("/admin/* share a bucket of 2: expected 429, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("X-RateLimit-Limit"); got != "2" {
+		t.Fatalf("expected the class limit in X-RateLimit-Limit, got %q", got)
+	}
+	if rec := serve(h, "/api/z?user=alice"); rec.Code != http.StatusOK {
+		t.Fatalf("/api/z has its own bucket: expected 200, got %d", rec.Code)
+	}
+	if rec := serve(h, "/admin/x?user=bob"); rec.Code != http.StatusOK {
+		t.Fatalf("classes are per user: expected 200, got %d", rec.Code)
+	}
+	// the longest prefix wins, so reports have a bucket of their own
+	if rec := serve(h, "/admin/reports/1?user=alice"); rec.Code != http.StatusOK {
+		t.Fatalf("/admin/reports/ is its own class: expected 200, got %d", rec.Code)
+	}
+	if got := serve(h, "/admin/reports/1?user=alice").Header().Get("X-RateLimit-Limit"); got != "5" {
+		t.Fatalf("expected the longest prefix's limit, got %q", got)
+	}
+	if st := Stats(EndpointClassKey("alice", "", "/admin/"), 2); st.Used != 2 {
+		t.Fatalf("expected the class bucket under EndpointClassKey to hold 2 requests, got %d", st.Used)
+	}
+}
+
+func TestMiddleware_EndpointClassByMethod(t *testing.T) {
+	resetLimiterState()
+
+	h := Middleware(MiddlewareOptions{
+		KeyFunc: userKey,
+		Limit:   10,
+		Classes: map[string]int{"/admin/": 5, "POST /admin/": 1},
+	})(okHandler)
+
+	post := func() int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/x?user=alice", nil))
+		return rec.Code
+	}
+	if code := post(); code != http.StatusOK {
+		t.Fatalf("first POST: expected 200, got %d", code)
+	}
+	if code := post(); code != http.StatusTooManyRequests {
+		t.Fatalf("POST /admin/ allows 1: expected 429, got %d", code)
+	}
+	if rec := serve(h, "/admin/x?user=alice"); rec.Code != http.StatusOK {
+		t.Fatalf("GET falls in the method-less class: expected 200, got %d", rec.Code)
+	}
+}
+
+func TestMiddleware_CostHeader(t *testing.T) {
+	resetLimiterState()
+	SetUserWindow("alice", time.Minute)
+	h := Middleware(MiddlewareOptions{KeyFunc: userKey, Limit: 10, CostHeader: "X-Cost", MaxCost: 50})(okHandler)
+	send := func(cost string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/?user=alice", nil)
+		if cost != "" {
+			r.Header.Set("X-Cost", cost)
+		}
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+
+	cases := []struct {
+		cost      string
+		code      int
+		remaining string
+	}{
+		{"1", http.StatusOK, "9"},
+		{"", http.StatusOK, "8"},    // absent: 1
+		{"abc", http.StatusOK, "7"}, // malformed: 1
+		{"0", http.StatusOK, "6"},   // non-positive: 1
+		{"5", http.StatusOK, "1"},
+		{"20", http.StatusTooManyRequests, "1"}, // more than is left: nothing charged
+		{"2", http.StatusTooManyRequests, "1"},
+		{"1", http.StatusOK, "0"},
+	}
+	for i, c := range cases {
+		rec := send(c.cost)
+		if rec.Code != c.code || rec.Header().Get("X-RateLimit-Remaining") != c.remaining {
+			t.Fatalf("request %d (cost %q): got %d with %s remaining, want %d with %s",
+				i+1, c.cost, rec.Code, rec.Header().Get("X-RateLimit-Remaining"), c.code, c.remaining)
+		}
+		if rec.Code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Fatalf("request %d: a denial must carry Retry-After", i+1)
+		}
+	}
+	if used := Stats("alice", 10).Used; used != 10 {
+		t.Fatalf("expected the window to hold 10 units, got %d", used)
+	}
+}
+
+func TestAllowN_ConcurrentCostsNeverOvershoot(t *testing.T) {
+	for _, mode := range []string{"sliding", "leaky", "daily"} {
+		t.Run(mode, func(t *testing.T) {
+			resetLimiterState()
+			SetMode(mode)
+			defer SetMode("sliding")
+			SetUserWindow("batch", time.Minute)
+
+			var wg sync.WaitGroup
+			var allowed atomic.Int32
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if AllowN("batch", 3, 10).Allowed {
+						allowed.Add(1)
+					}
+				}()
+			}
+			wg.Wait()
+			if n := allowed.Load(); n != 3 {
+				t.Fatalf("expected 3 requests costing 3 to fit a limit of 10, got %d", n)
+			}
+			res := AllowN("batch", 2, 10)
+			if res.Allowed || res.RetryAfter <= 0 {
+				t.Fatalf("a cost of 2 must not fit the 1 unit left, got %+v", res)
+			}
+			if !AllowN("batch", 1, 10).Allowed {
+				t.Fatal("the denied request must have left its units")
+			}
+		})
+	}
+}
+
+func TestAllowN_CapDenialGivesBackEveryUnit(t *testing.T) {
+	resetLimiterState()
+	SetAnonymousLimit(1, time.Minute)
+	SetUserWindow(CompositeKey("ip", "203.0.113.2"), time.Minute)
+
+	if !AllowN(CompositeKey("ip", "203.0.113.1"), 1, 10).Allowed {
+		t.Fatal("the first client should take the cap's only slot")
+	}
+	res := AllowN(CompositeKey("ip", "203.0.113.2"), 4, 10)
+	if res.Allowed || res.Reason != ReasonAnonymousCap {
+		t.Fatalf("expected the anonymous cap to deny, got %+v", res)
+	}
+	if used := Stats(CompositeKey("ip", "203.0.113.2"), 10).Used; used != 0 {
+		t.Fatalf("all 4 units must be given back, %d still used", used)
+	}
+}
+
+func TestMiddleware_CostHeaderCappedAtMaxCost(t *testing.T) {
+	resetLimiterState()
+	SetUserWindow("alice", time.Minute)
+	h := Middleware(MiddlewareOptions{KeyFunc: userKey, Limit: 10, CostHeader: "X-Cost", MaxCost: 3})(okHandler)
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/?user=alice", nil)
+	r.Header.Set("X-Cost", "1000")
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Remaining") != "7" {
+		t.Fatalf("expected a cost capped at 3, got %d with %s remaining", rec.Code, rec.Header().Get("X-RateLimit-Remaining"))
+	}
+}
+
+func TestTimeUntilCost_Sliding(t *testing.T) {
+	resetLimiterState()
+	SetUserWindow("alice", time.Second)
+	RateLimit("alice", 4)
+	time.Sleep(200 * time.Millisecond)
+	RateLimit("alice", 4)
+	RateLimit("alice", 4)
+
+	// a cost of 3 needs two of the three requests gone: the second at ~1s
+	if wait := timeUntilCost("alice", 4, 3); wait < 900*time.Millisecond || wait > time.Second {
+		t.Fatalf("expected ~1s, got %v", wait)
+	}
+	if wait := timeUntilCost("alice", 4, 1); wait != 0 {
+		t.Fatalf("a cost of 1 fits now, got %v", wait)
+	}
+}
+
+func TestMiddleware_DenyReasonInContext(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	DenyList("mallory")
+	DrainMatching(func(userID string) bool { return userID == "drained" })
+
+	var got Reason
+	var found bool
+	record := func(w http.ResponseWriter, r *http.Request, res Result) {
+		got, found = DenyReasonFromContext(r.Context())
+		w.WriteHeader(http.StatusTooManyRequests)
+	}
+	h := Middleware(MiddlewareOptions{
+		KeyFunc:  userKey,
+		Limit:    1,
+		OnReject: record,
+		OnError:  record,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, found = DenyReasonFromContext(r.Context())
+	}))
+
+	serve(h, "/api?user=bob")
+	if found {
+		t.Fatalf("an allowed request should carry no reason, got %q", got)
+	}
+	for _, tc := range []struct {
+		user string
+		want Reason
+	}{
+		{"bob", ReasonQuota},
+		{"mallory", ReasonDenyList},
+		{"drained", ReasonDraining},
+	} {
+		found = false
+		if rec := serve(h, "/api?user="+tc.user); rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("%s: expected a denial, got %d", tc.user, rec.Code)
+		}
+		if !found || got != tc.want {
+			t.Fatalf("%s: expected reason %q, got %q (found %v)", tc.user, tc.want, got, found)
+		}
+	}
+}
+
+func TestAllowResult_DenyReasons(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	SetAnonymousLimit(1, time.Minute)
+
+	if res := AllowResult("ip:10.0.0.1", 5); !res.Allowed || res.Reason != "" {
+		t.Fatalf("expected an allowed request without a reason, got %+v", res)
+	}
+	if res := AllowResult("ip:10.0.0.2", 5); res.Allowed || res.Reason != ReasonAnonymousCap {
+		t.Fatalf("expected the anonymous cap to deny, got %+v", res)
+	}
+	if res := AllowResult("nobody", 0); res.Allowed || res.Reason != ReasonZeroLimit {
+		t.Fatalf("expected a zero-limit denial, got %+v", res)
+	}
+}
@@ -0,0 +1,181 @@
+package limiter
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// modeEpoch is bumped whenever the global mode changes. In-memory sliding and
+// leaky states remember the epoch they were last reconciled at; a state from an
+// older epoch first absorbs the usage recorded under the other algorithm, so a
+// mode switch never hands a user a fresh allowance.
+var modeEpoch atomic.Uint64
+
+// SwitchMode sets the global algorithm mode like SetMode, and with resetState
+// also discards every user's in-memory sliding, leaky and daily state, under
+// the same lock. Use it when a switch should start everyone afresh, e.g. along
+// with new limits: each user's allowance restarts once, at the switch, and no
+// request decided by the new mode sees state from before it. A request already
+// being decided under the old mode may still be counted against the discarded
+// state, so across the switch a user is admitted at most twice their limit.
+//
+// Without resetState, in-memory usage carries over between "sliding" and
+// "leaky" as described for SetMode. Redis keys are per algorithm and are never
+// reconciled or deleted, so with Redis the new mode effectively starts from
+// fresh usage either way. It returns an error for an unknown mode, leaving the
+// mode and state unchanged.
+func SwitchMode(mode string, resetState bool) error {
+	if !validMode(mode) {
+		return fmt.Errorf("limiter: unknown mode %q", mode)
+	}
+	globalModeMu.Lock()
+	defer globalModeMu.Unlock()
+	if mode != globalMode {
+		globalMode = mode
+		modeEpoch.Add(1)
+	}
+	if resetState {
+		slidingStates.clear()
+		leakyBuckets.clear()
+		dailyStates.clear()
+		decayStates.clear()
+	}
+	return nil
+}
+
+// userModeMu serializes SetUserModePreservingUsage calls.
+var userModeMu sync.Mutex
+
+// SetUserModePreservingUsage overrides userID's algorithm like SetUserMode,
+// but first charges the user's current usage under their old mode to the new
+// one, so a mode change (say, on a config reload) never hands a user near
+// their limit a fresh allowance. Usage is what Stats reports: requests in the
+// window (sliding), missing tokens (leaky), today's count (daily) or the
+// whole decayed score (decay). It becomes as many requests at the current
+// time in a sliding window, daily count or decay score, or as many tokens
+// taken from a leaky bucket, less any usage the user already has under the
+// new mode. The carry is best-effort: a sliding window translated to leaky
+// refills at the bucket's rate rather than as the old requests expire, and
+// requests decided while the call runs may go uncounted in the new mode.
+//
+// The old mode's state is kept, as with SetUserMode. Users with multi-window
+// rules, or without a limit, just have their mode set. It returns an error
+// for an unknown or empty mode, leaving the mode unchanged.
+func SetUserModePreservingUsage(userID, mode string) error {
+	if !validMode(mode) {
+		return fmt.Errorf("limiter: unknown mode %q", mode)
+	}
+	userModeMu.Lock()
+	defer userModeMu.Unlock()
+	from := algorithmFor(userID)
+	if limit := EffectiveLimit(userID, 0); from != mode && from != "rules" && limit > 0 {
+		carryUsage(userID, from, mode, limit)
+	}
+	SetUserMode(userID, mode)
+	return nil
+}
+
+// carryUsage charges userID's usage under from to their state under to.
+func carryUsage(userID, from, to string, limit int) {
+	used := peekUsed(userID, from, limit)
+	if to == "leaky" {
+		window := GetUserWindow(userID)
+		capacity, _ := leakyParams(userID, limit, window)
+		if tokens := capacity - float64(used); tokens < peekLeakyTokens(userID, limit, window) {
+			warmLeaky(userID, tokens)
+		}
+		return
+	}
+	extra := used - peekUsed(userID, to, limit)
+	if extra <= 0 {
+		return
+	}
+	if rdb != nil {
+		settleRedis(userID, to, extra)
+		return
+	}
+	settleMemory(userID, to, extra)
+}
+
+// syncSlidingFromLeaky makes the sliding window reflect tokens the user
+// consumed under leaky mode. Consumed tokens are recorded as requests at now,
+// which is conservative: they leave the window only after a full window.
+func syncSlidingFromLeaky(userID string, st *slidingState, now int64, window time.Duration) {
+	epoch := modeEpoch.Load()
+	if st.epoch.Load() == epoch {
+		return
+	}
+	used := leakyUsage(userID, now)
+
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	if st.epoch.Load() == epoch {
+		return
+	}
+	st.epoch.Store(epoch)
+	cutoff := now - window.Milliseconds()
+	live := 0
+	for _, ts := range st.ts {
+		if ts > cutoff {
+			live++
+		}
+	}
+	for ; live < used; live++ {
+		st.ts = append(st.ts, now)
+	}
+}
+
+// syncLeakyFromSliding drains the bucket by the requests the user made under
+// sliding mode within the current window.
+func syncLeakyFromSliding(userID string, st *leakyState, now int64) {
+	epoch := modeEpoch.Load()
+	if st.epoch.Load() == epoch {
+		return
+	}
+	used := slidingUsage(userID, now, GetUserWindow(userID))
+
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	if st.epoch.Load() == epoch {
+		return
+	}
+	st.epoch.Store(epoch)
+	st.refill(now)
+	if avail := st.capacity - float64(used); st.tokens > avail {
+		st.tokens = math.Max(avail, 0)
+	}
+}
+
+// leakyUsage returns how many whole tokens the user's bucket is missing.
+func leakyUsage(userID string, now int64) int {
+	st, ok := leakyBuckets.load(userID)
+	if !ok {
+		return 0
+	}
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	elapsed := math.Max(0, float64(now-st.lastMillis))
+	tokens := math.Min(st.capacity, st.tokens+elapsed*st.ratePerMs)
+	return int(math.Ceil(st.capacity - tokens))
+}
+
+// slidingUsage returns how many requests the user has in the current window.
+func slidingUsage(userID string, now int64, window time.Duration) int {
+	st, ok := slidingStates.load(userID)
+	if !ok {
+		return 0
+	}
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	cutoff := now - window.Milliseconds()
+	n := 0
+	for _, ts := range st.ts {
+		if ts > cutoff {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,230 @@
+package limiter
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestModeSwitch_CarriesUsageOver(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	user := "switcher"
+	limit := 5
+	countAllowed(user, limit, limit)
+
+	SetMode("leaky")
+	if RateLimit(user, limit) {
+		t.Fatal("switching to leaky must not grant a fresh bucket")
+	}
+	SetMode("sliding")
+	if RateLimit(user, limit) {
+		t.Fatal("switching back to sliding must not grant a fresh window")
+	}
+}
+
+func TestModeSwitch_ConcurrentFlipsBounded(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	user := "flip-user"
+	limit := 10
+	const workers = 50
+	const duration = 300 * time.Millisecond
+
+	var allowed int64
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(workers + 1)
+	go func() {
+		defer wg.Done()
+		modes := []string{"sliding", "leaky"}
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				SetMode(modes[i%2])
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+	start := time.Now()
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					if RateLimit(user, limit) {
+						atomic.AddInt64(&allowed, 1)
+					}
+				}
+			}
+		}()
+	}
+	time.Sleep(duration)
+	close(stop)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	// the initial allowance plus what the leaky bucket can refill meanwhile
+	bound := int64(limit) + int64(float64(limit)*elapsed.Seconds()) + 1
+	if allowed > bound {
+		t.Fatalf("mode flips leaked allowance: %d allowed, bound %d", allowed, bound)
+	}
+}
+
+func TestSwitchMode_ResetStateStartsAfresh(t *testing.T) {
+	resetLimiterState()
+
+	user := "switch-reset"
+	limit := 5
+	countAllowed(user, limit, limit)
+
+	if err := SwitchMode("leaky", false); err != nil {
+		t.Fatal(err)
+	}
+	if RateLimit(user, limit) {
+		t.Fatal("without resetState usage must carry over")
+	}
+	if err := SwitchMode("sliding", true); err != nil {
+		t.Fatal(err)
+	}
+	if got := countAllowed(user, limit, 2*limit); got != limit {
+		t.Fatalf("after a reset expected a fresh allowance of %d, got %d", limit, got)
+	}
+
+	if err := SwitchMode("bogus", true); err == nil {
+		t.Fatal("expected an error for an unknown mode")
+	}
+	if GetMode() != "sliding" || RateLimit(user, limit) {
+		t.Fatal("an unknown mode must leave mode and state unchanged")
+	}
+}
+
+func TestSwitchMode_MidTrafficBounded(t *testing.T) {
+	resetLimiterState()
+
+	user := "switch-traffic"
+	limit := 10
+	const workers = 20
+
+	var allowed int64
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	start := time.Now()
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					if RateLimit(user, limit) {
+						atomic.AddInt64(&allowed, 1)
+					}
+				}
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := SwitchMode("leaky", true); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	// one allowance before the switch, one after it, plus leaky refills
+	bound := 2*int64(limit) + int64(float64(limit)*elapsed.Seconds()) + 1
+	if allowed > bound {
+		t.Fatalf("switch leaked allowance: %d allowed, bound %d", allowed, bound)
+	}
+	if allowed < 2*int64(limit) {
+		t.Fatalf("expected the reset to grant a fresh allowance, only %d allowed", allowed)
+	}
+}
+
+func TestSetUserModePreservingUsage_NoFreshAllowance(t *testing.T) {
+	for _, to := range []string{"leaky", "daily", decayMode} {
+		t.Run(to, func(t *testing.T) {
+			resetLimiterState()
+			SetMode("sliding")
+			SetDecayHalfLife(time.Hour)
+			user := "near-limit"
+			SetUserLimit(user, 5)
+			countAllowed(user, 5, 4)
+
+			if err := SetUserModePreservingUsage(user, to); err != nil {
+				t.Fatal(err)
+			}
+			if got := GetUserMode(user); got != to {
+				t.Fatalf("expected mode %q, got %q", to, got)
+			}
+			if to == decayMode {
+				// the score decays continuously, so check it rather than
+				// count requests that may squeeze into the fraction it lost
+				if got := peekDecayScore(user); got < 3.99 || got > 4 {
+					t.Fatalf("expected a carried score of 4, got %.4f", got)
+				}
+				return
+			}
+			if got := countAllowed(user, 5, 5); got != 1 {
+				t.Fatalf("expected 1 request left after switching to %s, got %d", to, got)
+			}
+		})
+	}
+}
+
+func TestSetUserModePreservingUsage_LeakyToSliding(t *testing.T) {
+	resetLimiterState()
+	SetMode("leaky")
+	user := "near-limit"
+	SetUserLimit(user, 5)
+	SetUserWindow(user, time.Hour)
+	countAllowed(user, 5, 5)
+
+	if err := SetUserModePreservingUsage(user, "sliding"); err != nil {
+		t.Fatal(err)
+	}
+	if RateLimit(user, 5) {
+		t.Fatal("an empty bucket must carry over as a full window")
+	}
+	if got := Stats(user, 0).Used; got != 5 {
+		t.Fatalf("expected 5 used in the window, got %d", got)
+	}
+}
+
+func TestSetUserModePreservingUsage_KeepsExistingUsage(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	user := "back-and-forth"
+	SetUserLimit(user, 5)
+	SetUserWindow(user, time.Hour)
+	countAllowed(user, 5, 3)
+
+	SetUserModePreservingUsage(user, "daily")
+	SetUserModePreservingUsage(user, "sliding")
+	if got := Stats(user, 0).Used; got != 3 {
+		t.Fatalf("switching back must not charge usage twice, got %d used", got)
+	}
+}
+
+func TestSetUserModePreservingUsage_UnknownMode(t *testing.T) {
+	resetLimiterState()
+	SetUserMode("alice", "leaky")
+	if err := SetUserModePreservingUsage("alice", "bogus"); err == nil {
+		t.Fatal("expected an error for an unknown mode")
+	}
+	if got := GetUserMode("alice"); got != "leaky" {
+		t.Fatalf("the mode should be unchanged, got %q", got)
+	}
+}
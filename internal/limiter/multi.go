@@ -0,0 +1,143 @@
+package limiter
+
+import (
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MultiKey is one limit checked by AllowMulti.
+type MultiKey struct {
+	Key   string // treated like a user ID: config, lists and mode apply
+	Limit int
+}
+
+// AllowMulti checks one request against several keys at once, e.g. global,
+// per-IP and per-user limits. The request is allowed only if every key allows
+// it; results reports each key's own decision, in order.
+//
+// With Redis, all keys are evaluated in a single pipelined round trip. Keys
+// are evaluated independently, so when a later key denies, earlier keys have
+// already consumed a slot; those slots are then refunded (in one more round
+// trip), so a denied request costs nothing on any key.
+//
+// The pipelined Redis check applies the lists, the unknown-user policy,
+// penalties, grace and draining (see Drain) like RateLimit, but bypasses
+// features that decide a single request differently: a Store set with
+// SetStore, sampling (SetSampleRate), the cap on Redis checks
+// (SetMaxRedisOpsPerSec), early throttling (SetSoftThreshold), shadowing
+// (SetShadowMode), the global and anonymous limits (SetGlobalLimitRedis,
+// SetAnonymousLimit) and the fail mode: it always fails closed. Without
+// Redis every key is checked as by RateLimit.
+func AllowMulti(checks []MultiKey) (allowed bool, results []bool) {
+	if keyTransformer.Load() != nil {
+		checks = append([]MultiKey(nil), checks...)
+		for i := range checks {
+			checks[i].Key = transformKey(checks[i].Key)
+		}
+	}
+	results = make([]bool, len(checks))
+	rcs := make([]receipt, len(checks))
+	if rdb != nil {
+		allowMultiRedis(checks, results, rcs)
+	} else {
+		for i, c := range checks {
+			results[i], rcs[i] = rateLimit(c.Key, c.Limit)
+		}
+	}
+
+	allowed = true
+	for _, ok := range results {
+		allowed = allowed && ok
+	}
+	if !allowed {
+		refundMulti(checks, results, rcs)
+	}
+	return allowed, results
+}
+
+// allowMultiRedis evaluates every key that needs Redis in one pipeline.
+func allowMultiRedis(checks []MultiKey, results []bool, rcs []receipt) {
+	nowMs := monoNowMs()
+	calls := make([]scriptCall, len(checks))
+	pending := make([]bool, len(checks))
+	pipe := rdb.Pipeline()
+	cmds := make([]*redis.Cmd, len(checks))
+	for i, c := range checks {
+		if IsDraining(c.Key) {
+			continue // denied regardless of enforcement, as by rateLimitFail
+		}
+		limit, decided, ok := admit(c.Key, c.Limit, nowMs)
+		if decided {
+			results[i] = enforce(c.Key, ok)
+			continue
+		}
+		mode := algorithmFor(c.Key)
+		limit += GetUserGrace(c.Key)
+		calls[i] = redisCall(c.Key, limit, 1, mode, time.Time{})
+		calls[i].rc.mode, calls[i].rc.limit, calls[i].rc.redis = mode, limit, true
+		cmds[i] = calls[i].script.EvalSha(ctx, pipe, calls[i].keys, calls[i].args...)
+		pending[i] = true
+	}
+	if pipe.Len() > 0 {
+		pipe.Exec(ctx)
+	}
+
+	for i, c := range checks {
+		if !pending[i] {
+			continue
+		}
+		results[i], rcs[i] = pipelinedResult(ctx, calls[i], cmds[i])
+		recordPenalty(c.Key, results[i], nowMs)
+		trackThrottled(c.Key, calls[i].rc.mode, calls[i].rc.limit, results[i], nowMs)
+		results[i] = enforce(c.Key, results[i])
+	}
+}
+
+// redisCall prepares the Redis check for mode of a request costing n units
+// (see costFor), as of at (zero for now).
+func redisCall(userID string, limit, n int, mode string, at time.Time) scriptCall {
+	switch mode {
+	case "rules":
+		return rulesCall(userID, GetUserLimits(userID))
+	case "leaky":
+		return leakyCall(userID, limit, n, GetUserWindow(userID), at)
+	case "daily":
+		return dailyCall(userID, limit, n, at)
+	case compactMode:
+		return compactCall(userID, limit, GetUserWindow(userID), at)
+	case decayMode:
+		return decayCall(userID, limit, at)
+	default:
+		return slidingCall(userID, limit, n, GetUserWindow(userID), at)
+	}
+}
+
+// refundMulti gives back the slots consumed by keys that allowed. Redis
+// refunds are pipelined into a single round trip.
+func refundMulti(checks []MultiKey, results []bool, rcs []receipt) {
+	var pipe redis.Pipeliner
+	cmds := make([]redis.Cmder, len(checks))
+	for i, c := range checks {
+		if !results[i] {
+			continue
+		}
+		if !rcs[i].redis || rdb == nil {
+			refundReceipt(c.Key, rcs[i])
+			continue
+		}
+		if pipe == nil {
+			pipe = rdb.Pipeline()
+		}
+		cmds[i] = queueRedisRefund(pipe, c.Key, rcs[i])
+	}
+	if pipe == nil {
+		return
+	}
+	pipe.Exec(ctx)
+	for i, cmd := range cmds {
+		if cmd != nil && redis.HasErrorPrefix(cmd.Err(), "NOSCRIPT") {
+			refundReceipt(checks[i].Key, rcs[i])
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package limiter
+
+import "testing"
+
+func TestAllowMulti_AllKeysMustAllow(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	checks := []MultiKey{{"global", 10}, {"ip:1.2.3.4", 10}, {"user:alice", 2}}
+	for i := 1; i <= 2; i++ {
+		if ok, _ := AllowMulti(checks); !ok {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+	ok, results := AllowMulti(checks)
+	if ok {
+		t.Fatal("per-user key is exhausted: request should be denied")
+	}
+	if !results[0] || !results[1] || results[2] {
+		t.Fatalf("unexpected per-key results: %v", results)
+	}
+}
+
+func TestAllowMulti_DenyRefundsEarlierKeys(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	for i := 0; i < 5; i++ {
+		AllowMulti([]MultiKey{{"shared", 3}, {"blocked", 0}})
+	}
+	// the shared key allowed each time, but every request was denied overall
+	for i := 1; i <= 3; i++ {
+		if !RateLimit("shared", 3) {
+			t.Fatalf("shared key request %d should be allowed after refunds", i)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package limiter
+
+// OpKey is the key under which RateLimitOp counts userID's requests of
+// operation class op, e.g. OpKey("alice", "write"). Each class has its own
+// window, bucket or quota, separate from the user's other classes and from
+// their plain RateLimit state. Per-class settings are made under it:
+// SetUserLimit(OpKey("alice", "write"), 10) gives alice's writes their own
+// limit. It is a CompositeKey, so classes of distinct users or ops never share
+// a key; it can still equal a raw userID with a separator, e.g. "alice:write",
+// so build every user key with CompositeKey when users choose their IDs.
+func OpKey(userID, op string) string {
+	return CompositeKey(userID, op)
+}
+
+// RateLimitOp is RateLimit for one operation class of userID, such as "read"
+// or "write", so each class draws on its own budget: exhausting a user's
+// writes leaves their reads untouched. limit is the fallback limit for the
+// class, as in RateLimit; the user's configured limit does not carry over to
+// their classes. With SetKeyTransformer, the transform applies to userID.
+func RateLimitOp(userID, op string, limit int) bool {
+	allowed, _ := rateLimit(OpKey(transformKey(userID), op), limit)
+	return allowed
+}
+
+// StatsOp is Stats for userID's operation class op.
+func StatsOp(userID, op string, fallback int) UserStats {
+	return stats(OpKey(transformKey(userID), op), fallback)
+}
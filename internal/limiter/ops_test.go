@@ -0,0 +1,40 @@
+package limiter
+
+import "testing"
+
+func TestRateLimitOp_ClassesHaveSeparateBudgets(t *testing.T) {
+	resetLimiterState()
+	SetUserLimit(OpKey("alice", "write"), 2)
+
+	for i := 0; i < 2; i++ {
+		if !RateLimitOp("alice", "write", 100) {
+			t.Fatalf("write %d should be allowed", i+1)
+		}
+	}
+	if RateLimitOp("alice", "write", 100) {
+		t.Fatal("the write budget of 2 is exhausted")
+	}
+	if got := countAllowedOp("alice", "read", 5, 10); got != 5 {
+		t.Fatalf("exhausted writes must not affect reads: expected 5 reads, got %d", got)
+	}
+	if !RateLimit("alice", 5) {
+		t.Fatal("classes must not touch the user's plain RateLimit state")
+	}
+
+	if st := StatsOp("alice", "write", 100); st.Limit != 2 || st.Used != 2 || st.Remaining != 0 {
+		t.Fatalf("unexpected write stats %+v", st)
+	}
+	if st := StatsOp("alice", "read", 5); st.Used != 5 || st.User != OpKey("alice", "read") {
+		t.Fatalf("unexpected read stats %+v", st)
+	}
+}
+
+func countAllowedOp(user, op string, limit, attempts int) int {
+	allowed := 0
+	for i := 0; i < attempts; i++ {
+		if RateLimitOp(user, op, limit) {
+			allowed++
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,127 @@
+package limiter
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	// penalty (opt-in): progressive backoff for repeat offenders
+	penaltyMu        sync.RWMutex
+	penaltyThreshold int           // consecutive denies before a backoff step; 0 = disabled
+	penaltyFactor    float64       // multiplier applied to the limit per backoff step
+	penaltyCooldown  time.Duration // how long a backoff step stays in effect
+
+	userPenalties = sync.Map{} // map[userID]*penaltyState
+)
+
+// penaltyState tracks consecutive denies and the active backoff level for a user
+type penaltyState struct {
+	mtx        sync.Mutex
+	denies     int   // consecutive denies since the last backoff step
+	level      int   // number of backoff steps currently applied
+	untilMs    int64 // backoff active until this timestamp (ms)
+	lastDenyMs int64 // timestamp of the most recent deny (ms)
+}
+
+// EnablePenalty turns on progressive throttling for repeat offenders.
+// Every `threshold` consecutive denies apply one more multiplicative backoff
+// step (limit * factor^steps, never below 1) that stays in effect for
+// `cooldown`. The penalty is cleared once the cooldown has passed and the
+// user has gone a full window without being denied.
+// A threshold <= 0 or a factor outside (0,1) disables the penalty.
+func EnablePenalty(threshold int, factor float64, cooldown time.Duration) {
+	penaltyMu.Lock()
+	defer penaltyMu.Unlock()
+	if threshold <= 0 || factor <= 0 || factor >= 1 {
+		penaltyThreshold = 0
+		return
+	}
+	penaltyThreshold = threshold
+	penaltyFactor = factor
+	penaltyCooldown = cooldown
+}
+
+// DisablePenalty turns off penalty mode and forgets all tracked offenders.
+func DisablePenalty() {
+	penaltyMu.Lock()
+	penaltyThreshold = 0
+	penaltyMu.Unlock()
+	userPenalties.Clear()
+}
+
+func penaltyConfig() (threshold int, factor float64, cooldown time.Duration) {
+	penaltyMu.RLock()
+	defer penaltyMu.RUnlock()
+	return penaltyThreshold, penaltyFactor, penaltyCooldown
+}
+
+// applyPenalty returns the limit reduced by the user's active backoff level.
+func applyPenalty(userID string, limit int, nowMs int64) int {
+	threshold, factor, _ := penaltyConfig()
+	if threshold <= 0 {
+		return limit
+	}
+	val, ok := userPenalties.Load(userID)
+	if !ok {
+		return limit
+	}
+	st := val.(*penaltyState)
+
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	if st.level == 0 {
+		return limit
+	}
+	// cooldown over and a full window without denies: forgive the user
+	if nowMs >= st.untilMs && nowMs-st.lastDenyMs >= GetUserWindow(userID).Milliseconds() {
+		st.level = 0
+		st.denies = 0
+		return limit
+	}
+	if nowMs >= st.untilMs {
+		return limit
+	}
+	effective := float64(limit)
+	for i := 0; i < st.level; i++ {
+		effective *= factor
+	}
+	if effective < 1 {
+		return 1
+	}
+	return int(effective)
+}
+
+// recordPenalty updates the user's consecutive-deny tracking after a decision.
+func recordPenalty(userID string, allowed bool, nowMs int64) {
+	threshold, _, cooldown := penaltyConfig()
+	if threshold <= 0 {
+		return
+	}
+	if allowed {
+		val, ok := userPenalties.Load(userID)
+		if !ok {
+			return
+		}
+		st := val.(*penaltyState)
+		st.mtx.Lock()
+		// a full window without being denied resets the streak
+		if nowMs-st.lastDenyMs >= GetUserWindow(userID).Milliseconds() {
+			st.denies = 0
+		}
+		st.mtx.Unlock()
+		return
+	}
+
+	val, _ := userPenalties.LoadOrStore(userID, &penaltyState{})
+	st := val.(*penaltyState)
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	st.denies++
+	st.lastDenyMs = nowMs
+	if st.denies >= threshold {
+		st.denies = 0
+		st.level++
+		st.untilMs = nowMs + cooldown.Milliseconds()
+	}
+}
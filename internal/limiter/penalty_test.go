@@ -0,0 +1,56 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func countAllowed(user string, limit, attempts int) int {
+	allowed := 0
+	for i := 0; i < attempts; i++ {
+		if RateLimit(user, limit) {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestPenalty_AbuserAllowanceShrinksAndRecovers(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	EnablePenalty(3, 0.5, 1500*time.Millisecond)
+
+	user := "abuser"
+	limit := 10
+
+	// exhaust the window and keep hammering: 3 denies trigger one backoff step
+	if got := countAllowed(user, limit, limit+3); got != limit {
+		t.Fatalf("first window: expected %d allowed, got %d", limit, got)
+	}
+
+	// next window, penalty still active: allowance halved
+	time.Sleep(1100 * time.Millisecond)
+	if got := countAllowed(user, limit, limit); got != limit/2 {
+		t.Fatalf("penalized window: expected %d allowed, got %d", limit/2, got)
+	}
+
+	// quiet for longer than cooldown and a full window: full allowance returns
+	time.Sleep(1600 * time.Millisecond)
+	if got := countAllowed(user, limit, limit+1); got != limit {
+		t.Fatalf("after recovery: expected %d allowed, got %d", limit, got)
+	}
+}
+
+func TestPenalty_DisabledByDefault(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	user := "no-penalty"
+	limit := 4
+	countAllowed(user, limit, 20)
+
+	time.Sleep(1100 * time.Millisecond)
+	if got := countAllowed(user, limit, limit); got != limit {
+		t.Fatalf("without penalty: expected %d allowed, got %d", limit, got)
+	}
+}
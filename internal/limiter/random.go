@@ -0,0 +1,38 @@
+package limiter
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	randMu  sync.Mutex
+	randSrc = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// SetDeterministic seeds the random source behind every randomized feature:
+// early throttling (SetSoftThreshold), sampling (SetSampleRate) and the
+// jitter on Redis key expiry. The same seed then reproduces the same sequence
+// of decisions, for tests and debugging, as long as requests arrive in the
+// same order. Retry jitter (SetRetryJitter) needs no seed: it is already a
+// function of the key and the window.
+func SetDeterministic(seed int64) {
+	randMu.Lock()
+	randSrc = rand.New(rand.NewSource(seed))
+	randMu.Unlock()
+}
+
+// randFloat64 returns a value in [0, 1) from the package's random source.
+func randFloat64() float64 {
+	randMu.Lock()
+	defer randMu.Unlock()
+	return randSrc.Float64()
+}
+
+// randInt63n returns a value in [0, n) from the package's random source.
+func randInt63n(n int64) int64 {
+	randMu.Lock()
+	defer randMu.Unlock()
+	return randSrc.Int63n(n)
+}
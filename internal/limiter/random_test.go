@@ -0,0 +1,43 @@
+package limiter
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSetDeterministic_SameSeedSameDecisions(t *testing.T) {
+	run := func(seed int64) ([]bool, []time.Duration) {
+		resetLimiterState()
+		SetUserWindow("soft-user", time.Minute)
+		SetSoftThreshold(0.3)
+		SetDeterministic(seed)
+		var decisions []bool
+		for i := 0; i < 100; i++ {
+			decisions = append(decisions, RateLimit("soft-user", 100))
+		}
+		var expiries []time.Duration
+		for i := 0; i < 10; i++ {
+			expiries = append(expiries, keyExpiry(10*time.Second))
+		}
+		return decisions, expiries
+	}
+	defer SetSoftThreshold(0)
+
+	d1, e1 := run(7)
+	d2, e2 := run(7)
+	if !slices.Equal(d1, d2) {
+		t.Fatalf("the same seed must reproduce the decisions:\n%v\n%v", d1, d2)
+	}
+	if !slices.Equal(e1, e2) {
+		t.Fatalf("the same seed must reproduce the key expiries:\n%v\n%v", e1, e2)
+	}
+	if !slices.Contains(d1, false) {
+		t.Fatal("expected early throttling to deny some requests")
+	}
+
+	d3, e3 := run(8)
+	if slices.Equal(d1, d3) && slices.Equal(e1, e3) {
+		t.Fatal("a different seed should give a different sequence")
+	}
+}
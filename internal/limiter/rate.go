@@ -0,0 +1,134 @@
+package limiter
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// userRate is a fractional rate: rate requests per per.
+type userRate struct {
+	rate float64
+	per  time.Duration
+}
+
+var (
+	userRates   = sync.Map{} // map[string]userRate
+	userRefills = sync.Map{} // map[string]time.Duration (time to leak back one token)
+
+	leakyDefaults atomic.Pointer[leakyDefault]
+)
+
+// leakyDefault holds the SetLeakyDefaults settings.
+type leakyDefault struct {
+	capacityMultiplier float64
+	refillPerSec       float64
+}
+
+// SetLeakyDefaults shapes the leaky buckets of users without a per-user rate
+// (SetUserRate) or refill (SetUserRefill), which by default hold limit tokens
+// and refill limit tokens per window. capacityMultiplier > 0 scales the
+// capacity to limit*capacityMultiplier (at least one token), so a multiplier
+// below 1 smooths bursts and one above 1 allows larger ones; refillPerSec > 0
+// refills every such bucket at that many tokens per second whatever its
+// limit. A value <= 0 keeps the default for that setting. Sliding-window and
+// daily modes are unaffected. Buckets already in memory keep their capacity
+// until they are recreated.
+func SetLeakyDefaults(capacityMultiplier, refillPerSec float64) {
+	if capacityMultiplier <= 0 && refillPerSec <= 0 {
+		leakyDefaults.Store(nil)
+		return
+	}
+	leakyDefaults.Store(&leakyDefault{capacityMultiplier, refillPerSec})
+}
+
+// SetUserRate sets a possibly fractional rate for userID, e.g. 2.5 per second
+// or 5 per 2 seconds. Leaky buckets use it exactly: capacity rate (at least
+// one token) refilled at rate per per. Sliding-window and daily modes count
+// whole requests, so they enforce ceil(rate) per per, which can let up to one
+// extra request through per window. SetUserLimit(u, n) is the integer case
+// and replaces any rate. A non-positive rate or per < 1ms removes the rate.
+func SetUserRate(userID string, rate float64, per time.Duration) {
+	if !(rate > 0) || math.IsInf(rate, 1) || per < time.Millisecond {
+		userRates.Delete(userID)
+		return
+	}
+	dropRefill(userID)
+	userConfig.Store(userID, int(math.Ceil(rate)))
+	SetUserWindow(userID, per)
+	userRates.Store(userID, userRate{rate: rate, per: per.Truncate(time.Millisecond)})
+}
+
+// GetUserRate returns the user's fractional rate, if one is set.
+func GetUserRate(userID string) (rate float64, per time.Duration, ok bool) {
+	v, ok := userRates.Load(userID)
+	if !ok {
+		return 0, 0, false
+	}
+	r := v.(userRate)
+	return r.rate, r.per, true
+}
+
+// SetUserRefill sizes userID's leaky bucket independently of its refill
+// speed: capacity tokens, refilled one token per interval, e.g. a burst of 20
+// refilled every 500ms. capacity becomes the user's limit and the window the
+// time to refill from empty (capacity*interval), so sliding-window and daily
+// modes enforce the same long-run rate. If a penalty or grace allowance
+// changes the enforced limit, the bucket is resized but keeps the interval.
+// SetUserLimit and SetUserRate replace it, restoring the default window
+// unless they set their own. capacity <= 0 or interval < 1ms removes it,
+// keeping the limit and window it set.
+func SetUserRefill(userID string, capacity int, interval time.Duration) {
+	if capacity <= 0 || interval < time.Millisecond {
+		userRefills.Delete(userID)
+		return
+	}
+	userRates.Delete(userID)
+	userConfig.Store(userID, capacity)
+	interval = interval.Truncate(time.Millisecond)
+	SetUserWindow(userID, time.Duration(capacity)*interval)
+	userRefills.Store(userID, interval)
+}
+
+// dropRefill removes userID's refill along with the window derived from it.
+func dropRefill(userID string) {
+	if _, ok := userRefills.LoadAndDelete(userID); ok {
+		SetUserWindow(userID, 0)
+	}
+}
+
+// GetUserRefill returns the user's explicit refill interval, if one is set.
+func GetUserRefill(userID string) (interval time.Duration, ok bool) {
+	v, ok := userRefills.Load(userID)
+	if !ok {
+		return 0, false
+	}
+	return v.(time.Duration), true
+}
+
+// leakyParams returns the bucket capacity and refill rate (tokens per ms) for
+// enforcing limit over window. A fractional rate set with SetUserRate is used
+// exactly while the enforced limit is its rounded-up value, i.e. unless a
+// penalty or grace allowance changed it. Otherwise SetLeakyDefaults applies.
+func leakyParams(userID string, limit int, window time.Duration) (capacity, ratePerMs float64) {
+	if interval, ok := GetUserRefill(userID); ok {
+		return float64(limit), 1 / float64(interval.Milliseconds())
+	}
+	if v, ok := userRates.Load(userID); ok {
+		r := v.(userRate)
+		if int(math.Ceil(r.rate)) == limit {
+			return math.Max(r.rate, 1), r.rate / float64(r.per.Milliseconds())
+		}
+	}
+	capacity, ratePerMs = float64(limit), float64(limit)/float64(window.Milliseconds())
+	if d := leakyDefaults.Load(); d != nil {
+		if d.capacityMultiplier > 0 {
+			capacity = math.Max(capacity*d.capacityMultiplier, 1)
+		}
+		if d.refillPerSec > 0 {
+			ratePerMs = d.refillPerSec / 1000
+		}
+	}
+	return capacity, ratePerMs
+}
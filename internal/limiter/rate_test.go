@@ -0,0 +1,131 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetUserRate_LeakyThroughputConverges(t *testing.T) {
+	resetLimiterState()
+	SetMode("leaky")
+
+	user := "fractional"
+	SetUserRate(user, 2.5, time.Second)
+
+	// drain the initial burst (capacity 2.5 -> 2 whole requests)
+	burst := 0
+	for RateLimit(user, 100) {
+		burst++
+	}
+	if burst != 2 {
+		t.Fatalf("expected an initial burst of 2, got %d", burst)
+	}
+
+	start := time.Now()
+	allowed := 0
+	for time.Since(start) < 4*time.Second {
+		if RateLimit(user, 100) {
+			allowed++
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	rate := float64(allowed) / time.Since(start).Seconds()
+	if rate < 2.2 || rate > 2.8 {
+		t.Fatalf("steady-state throughput %.2f/s should converge near 2.5/s (%d allowed)", rate, allowed)
+	}
+}
+
+func TestSetUserRate_SlidingRoundsUp(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	SetUserRate("frac-sliding", 2.5, time.Second)
+	if got := EffectiveLimit("frac-sliding", 100); got != 3 {
+		t.Fatalf("sliding limit should be ceil(2.5) = 3, got %d", got)
+	}
+	if got := GetUserWindow("frac-sliding"); got != time.Second {
+		t.Fatalf("window should follow per, got %v", got)
+	}
+	assertAllowance(t, "frac-sliding", 3)
+}
+
+func TestSetUserLimit_ReplacesRate(t *testing.T) {
+	resetLimiterState()
+
+	SetUserRate("u", 0.5, time.Second)
+	if r, per, ok := GetUserRate("u"); !ok || r != 0.5 || per != time.Second {
+		t.Fatalf("unexpected rate %v per %v (ok=%v)", r, per, ok)
+	}
+	SetUserLimit("u", 4)
+	if _, _, ok := GetUserRate("u"); ok {
+		t.Fatal("integer limit should replace the fractional rate")
+	}
+}
+
+func TestSetUserRefill_CapacityIndependentOfInterval(t *testing.T) {
+	resetLimiterState()
+	SetMode("leaky")
+	SetUserRefill("refill-user", 20, 500*time.Millisecond)
+
+	if got := EffectiveLimit("refill-user", 1); got != 20 {
+		t.Fatalf("capacity should become the limit, got %d", got)
+	}
+	capacity, ratePerMs := leakyParams("refill-user", 20, GetUserWindow("refill-user"))
+	if capacity != 20 || ratePerMs != 1.0/500 {
+		t.Fatalf("leakyParams = (%v, %v), want (20, 1/500)", capacity, ratePerMs)
+	}
+
+	allowed := 0
+	for i := 0; i < 25; i++ {
+		if RateLimit("refill-user", 1) {
+			allowed++
+		}
+	}
+	if allowed != 20 {
+		t.Fatalf("burst should be the full capacity of 20, got %d", allowed)
+	}
+	time.Sleep(550 * time.Millisecond)
+	if !RateLimit("refill-user", 1) || RateLimit("refill-user", 1) {
+		t.Fatal("one token should refill every 500ms")
+	}
+
+	SetUserLimit("refill-user", 5)
+	if _, ok := GetUserRefill("refill-user"); ok {
+		t.Fatal("SetUserLimit should replace the refill interval")
+	}
+	if got := GetUserWindow("refill-user"); got != time.Second {
+		t.Fatalf("SetUserLimit should restore the default window, got %v", got)
+	}
+}
+
+func TestSetLeakyDefaults_ShapesBurstAndRefill(t *testing.T) {
+	resetLimiterState()
+	SetMode("leaky")
+
+	SetLeakyDefaults(0.4, 0)
+	if got := countAllowed("smooth", 10, 20); got != 4 {
+		t.Fatalf("capacity multiplier 0.4 should cap the burst at 4, got %d", got)
+	}
+
+	SetLeakyDefaults(0, 100)
+	SetUserWindow("refill", 10*time.Second)
+	if got := countAllowed("refill", 5, 10); got != 5 {
+		t.Fatalf("the default capacity is the limit, expected 5, got %d", got)
+	}
+	time.Sleep(50 * time.Millisecond)
+	// 100/s refills ~5 tokens in 50ms, where 5 per 10s would refill none
+	if got := countAllowed("refill", 5, 10); got < 3 {
+		t.Fatalf("refill of 100/s should bring back tokens within 50ms, got %d", got)
+	}
+
+	SetUserRefill("explicit", 3, time.Hour)
+	if got := countAllowed("explicit", 3, 10); got != 3 {
+		t.Fatalf("per-user refill settings override the defaults, got %d", got)
+	}
+
+	SetMode("sliding")
+	SetLeakyDefaults(0.4, 100)
+	if got := countAllowed("sliding-user", 10, 20); got != 10 {
+		t.Fatalf("sliding mode ignores leaky defaults, got %d", got)
+	}
+}
@@ -0,0 +1,65 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+)
+
+// Reason is why a request was denied, as reported in Result.Reason and, by
+// Middleware, in the request context (see DenyReasonFromContext).
+type Reason string
+
+const (
+	// ReasonQuota: the user's own limit (or one of their rules) was reached.
+	ReasonQuota Reason = "quota"
+	// ReasonSoftThrottle: shed early as usage neared the limit (SetSoftThreshold).
+	ReasonSoftThrottle Reason = "soft_throttle"
+	// ReasonDenyList: the user is deny-listed.
+	ReasonDenyList Reason = "deny_list"
+	// ReasonGlobalCap: the global limit for all users was reached
+	// (SetGlobalLimitRedis).
+	ReasonGlobalCap Reason = "global_cap"
+	// ReasonAnonymousCap: the cap on anonymous clients combined was reached
+	// (SetAnonymousLimit).
+	ReasonAnonymousCap Reason = "anonymous_cap"
+	// ReasonDraining: the limiter is draining (Drain).
+	ReasonDraining Reason = "draining"
+	// ReasonUnknownUser: the user has no limit of their own, and the
+	// unknown-user policy denies them (SetUnknownUserPolicy).
+	ReasonUnknownUser Reason = "unknown_user"
+	// ReasonZeroLimit: no usable limit, and the zero-limit policy denies.
+	ReasonZeroLimit Reason = "zero_limit"
+	// ReasonBackendError: the limiter could not decide, e.g. Redis failed;
+	// Result.Err holds the error.
+	ReasonBackendError Reason = "backend_error"
+)
+
+// denyReason returns why a request decided with rc was denied.
+func denyReason(rc receipt) Reason {
+	switch {
+	case errors.Is(rc.err, ErrDraining):
+		return ReasonDraining
+	case rc.err != nil:
+		return ReasonBackendError
+	case rc.reason != "":
+		return rc.reason
+	}
+	return ReasonQuota
+}
+
+// denyReasonKey is the context key under which Middleware stores a Reason.
+type denyReasonKey struct{}
+
+// withDenyReason returns ctx carrying reason.
+func withDenyReason(ctx context.Context, reason Reason) context.Context {
+	return context.WithValue(ctx, denyReasonKey{}, reason)
+}
+
+// DenyReasonFromContext returns why Middleware denied the request whose
+// context is ctx. Middleware stores it in the request it passes to OnReject
+// and OnError, so those, and handlers they call, can log it; ok is false for
+// requests it let through.
+func DenyReasonFromContext(ctx context.Context) (Reason, bool) {
+	reason, ok := ctx.Value(denyReasonKey{}).(Reason)
+	return reason, ok
+}
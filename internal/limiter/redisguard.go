@@ -0,0 +1,60 @@
+package limiter
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// maxRedisOps is the cap set with SetMaxRedisOpsPerSec; 0 means none.
+	maxRedisOps atomic.Int64
+	// redisOps counts the Redis checks held to it.
+	redisOps windowCounter
+)
+
+// SetMaxRedisOpsPerSec caps the rate-limit checks this instance sends to
+// Redis at n per second, to protect Redis from a traffic spike. The cap is
+// kept in memory, apart from users' state, as a one-second sliding window
+// estimated from per-second counts. Requests past it are decided by the in-memory algorithm instead,
+// with the user's limit divided by the instance count (SetInstanceCount) if
+// one is set, as if Redis were not in use.
+//
+// This trades exactness for backend protection: a request decided in memory
+// is not seen by Redis or other instances, and the in-memory state knows
+// nothing of the usage recorded in Redis, so while the cap is reached a user
+// may be admitted up to their limit again on each instance. Refunds of such
+// requests go to the in-memory state, while Settle still adjusts Redis. Only
+// single decisions (RateLimit, Allow, AllowResult, ...) are capped;
+// AllowMulti, reads such as Stats and the global and anonymous limits are
+// not. n <= 0 removes the cap (the default).
+func SetMaxRedisOpsPerSec(n int) {
+	maxRedisOps.Store(int64(max(n, 0)))
+	if n <= 0 {
+		redisOps.reset()
+	}
+}
+
+// GetMaxRedisOpsPerSec returns the cap on Redis checks per second, or 0.
+func GetMaxRedisOpsPerSec() int {
+	return int(maxRedisOps.Load())
+}
+
+// redisOpAllowed takes a slot for one Redis check, reporting false when the
+// cap is reached.
+func redisOpAllowed() bool {
+	n := maxRedisOps.Load()
+	if n == 0 {
+		return true
+	}
+	return redisOps.take(int(n), time.Second, monoNowMs())
+}
+
+// redisShedLimit is the limit a request past the cap is decided with in
+// memory: the instance's share of limit.
+func redisShedLimit(limit int) int {
+	n := int(instanceCount.Load())
+	if n <= 1 {
+		return limit
+	}
+	return (limit + n - 1) / n
+}
@@ -0,0 +1,29 @@
+package limiter
+
+import "testing"
+
+func TestSetMaxRedisOpsPerSec(t *testing.T) {
+	resetLimiterState()
+	if got := GetMaxRedisOpsPerSec(); got != 0 {
+		t.Fatalf("expected no cap by default, got %d", got)
+	}
+	SetMaxRedisOpsPerSec(100)
+	if got := GetMaxRedisOpsPerSec(); got != 100 {
+		t.Fatalf("expected a cap of 100, got %d", got)
+	}
+	SetMaxRedisOpsPerSec(-1)
+	if got := GetMaxRedisOpsPerSec(); got != 0 {
+		t.Fatalf("a negative cap removes it, got %d", got)
+	}
+}
+
+func TestRedisShedLimit_InstanceShare(t *testing.T) {
+	resetLimiterState()
+	if got := redisShedLimit(10); got != 10 {
+		t.Fatalf("expected the whole limit on a single instance, got %d", got)
+	}
+	SetInstanceCount(3)
+	if got := redisShedLimit(10); got != 4 {
+		t.Fatalf("expected a third of 10, rounded up, got %d", got)
+	}
+}
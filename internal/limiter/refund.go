@@ -0,0 +1,179 @@
+package limiter
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitRefundable behaves like RateLimit but also returns a refund func
+// that gives back the slot consumed by an allowed request: the recorded
+// timestamp is removed (sliding) or the token is returned (leaky).
+//
+// refund is idempotent and safe to call at any time; it is a no-op if the
+// request was denied or its slot has already left the window.
+func RateLimitRefundable(userID string, limit int) (allowed bool, refund func()) {
+	userID = transformKey(userID)
+	allowed, rc := rateLimit(userID, limit)
+	if !allowed {
+		return false, func() {}
+	}
+	var once sync.Once
+	return true, func() {
+		once.Do(func() { refundReceipt(userID, rc) })
+	}
+}
+
+// refundReceipt returns the slot described by rc to the user's state.
+func refundReceipt(userID string, rc receipt) {
+	// nothing was consumed (e.g. allow-listed user), or a Store holds it
+	if rc.mode == "" || rc.store || rc.local {
+		return
+	}
+	if rc.redis {
+		if rdb == nil {
+			return
+		}
+		switch rc.mode {
+		case "leaky":
+			refundRedisLeaky(userID, float64(rc.charged()), rc.limit)
+			return
+		case "daily":
+			refundRedisDaily(rc.member, rc.charged())
+			return
+		case compactMode:
+			adjustRedisCompact(userID, -1)
+			return
+		case decayMode:
+			adjustRedisDecay(userID, -1)
+			return
+		case "rules":
+			for _, r := range GetUserLimits(userID) {
+				rdb.ZRem(ctx, ruleKey(userID, r.Window), rc.member)
+			}
+			return
+		}
+		rdb.ZRem(ctx, slidingKey(userID), slidingMembers(rc)...)
+		return
+	}
+
+	switch rc.mode {
+	case "leaky":
+		refundMemoryLeaky(userID, float64(rc.charged()))
+		return
+	case "daily":
+		refundMemoryDaily(userID, rc.member, rc.charged())
+		return
+	case "rules":
+		refundMemoryRules(userID, rc.tsMs)
+		return
+	case decayMode:
+		adjustMemoryDecay(userID, -1)
+		return
+	}
+	st, ok := slidingStates.load(userID)
+	if !ok {
+		return
+	}
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	for n := rc.charged(); n > 0; n-- {
+		i := slices.Index(st.ts, rc.tsMs)
+		if i < 0 {
+			return
+		}
+		st.ts = slices.Delete(st.ts, i, i+1)
+	}
+}
+
+// refundMemoryLeaky returns tokens to the user's in-memory bucket, capped at capacity.
+func refundMemoryLeaky(userID string, tokens float64) {
+	st, ok := leakyBuckets.load(userID)
+	if !ok {
+		return
+	}
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	st.refill(monoNowMs())
+	st.tokens += tokens
+	if st.tokens > st.capacity {
+		st.tokens = st.capacity
+	}
+}
+
+// refundLeakyScript adds ARGV[1] tokens to bucket KEYS[1], capped at the
+// capacity leakyScript last stored with it, or else at ARGV[2].
+var refundLeakyScript = newScript("refund-leaky", `
+	local data = redis.call("HMGET", KEYS[1], "tokens", "capacity")
+	local tokens = tonumber(data[1])
+	if tokens == nil then
+		return 0
+	end
+	tokens = tokens + tonumber(ARGV[1])
+	local capacity = tonumber(data[2]) or tonumber(ARGV[2])
+	if tokens > capacity then tokens = capacity end
+	redis.call("HSET", KEYS[1], "tokens", tostring(tokens))
+	return 1
+`)
+
+// RefundLeaky gives tokens back to userID's leaky bucket, e.g. for work that
+// turned out not to need them, never filling it past its capacity. With Redis
+// the refund is one script, atomic with the requests consuming the bucket:
+// the tokens land on the bucket as the last request left it, capped at the
+// capacity that request enforced. A bucket that does not exist (or has
+// expired) is full, so nothing is written. tokens <= 0 is a no-op.
+func RefundLeaky(userID string, tokens float64) error {
+	if !(tokens > 0) {
+		return nil
+	}
+	userID = transformKey(userID)
+	if rdb == nil {
+		refundMemoryLeaky(userID, tokens)
+		return nil
+	}
+	return refundRedisLeaky(userID, tokens, EffectiveLimit(userID, 0))
+}
+
+// refundRedisLeaky atomically returns tokens to a Redis bucket, capped at its
+// capacity (that of limit if the bucket predates stored capacities). A
+// missing (expired) bucket is already full, so nothing is written.
+func refundRedisLeaky(userID string, tokens float64, limit int) error {
+	capacity, _ := leakyParams(userID, limit, GetUserWindow(userID))
+	return refundLeakyScript.Run(ctx, rdb, []string{leakyKey(userID)},
+		strconv.FormatFloat(tokens, 'f', -1, 64),
+		strconv.FormatFloat(capacity, 'f', -1, 64),
+	).Err()
+}
+
+// queueRedisRefund queues the refund of rc on pipe. Scripts are sent by SHA
+// only, so callers must retry NOSCRIPT failures with refundReceipt.
+func queueRedisRefund(pipe redis.Pipeliner, userID string, rc receipt) redis.Cmder {
+	switch rc.mode {
+	case "leaky":
+		capacity, _ := leakyParams(userID, rc.limit, GetUserWindow(userID))
+		return refundLeakyScript.EvalSha(ctx, pipe, []string{leakyKey(userID)}, rc.charged(), strconv.FormatFloat(capacity, 'f', -1, 64))
+	case "daily":
+		return refundDailyScript.EvalSha(ctx, pipe, []string{rc.member}, rc.charged())
+	case compactMode:
+		window := GetUserWindow(userID)
+		return compactAdjustScript.EvalSha(ctx, pipe, []string{compactKey(userID)}, "-1",
+			strconv.FormatInt(compactSubWindow(window), 10),
+			strconv.FormatInt(keyExpiry(window).Milliseconds(), 10),
+		)
+	case decayMode:
+		halfLife := decayHalfLife(userID)
+		return decayAdjustScript.EvalSha(ctx, pipe, []string{decayKey(userID)}, "-1",
+			strconv.FormatInt(halfLife.Milliseconds(), 10),
+			strconv.FormatInt(decayExpiry(halfLife).Milliseconds(), 10),
+		)
+	case "rules":
+		var cmd redis.Cmder
+		for _, r := range GetUserLimits(userID) {
+			cmd = pipe.ZRem(ctx, ruleKey(userID, r.Window), rc.member)
+		}
+		return cmd
+	}
+	return pipe.ZRem(ctx, slidingKey(userID), slidingMembers(rc)...)
+}
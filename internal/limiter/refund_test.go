@@ -0,0 +1,94 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimitRefundable_SlidingRefundRestoresCapacity(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	user := "refund-sliding"
+	limit := 2
+
+	RateLimit(user, limit)
+	allowed, refund := RateLimitRefundable(user, limit)
+	if !allowed {
+		t.Fatal("second request should be allowed")
+	}
+	if RateLimit(user, limit) {
+		t.Fatal("window should be full before refund")
+	}
+
+	refund()
+	refund() // double refund must be harmless
+
+	if !RateLimit(user, limit) {
+		t.Fatal("refunded slot should be available again")
+	}
+	if RateLimit(user, limit) {
+		t.Fatal("double refund must not free more than one slot")
+	}
+}
+
+func TestRateLimitRefundable_LeakyRefundRestoresToken(t *testing.T) {
+	resetLimiterState()
+	SetMode("leaky")
+
+	user := "refund-leaky"
+	limit := 2
+
+	RateLimit(user, limit)
+	_, refund := RateLimitRefundable(user, limit)
+	if RateLimit(user, limit) {
+		t.Fatal("bucket should be empty before refund")
+	}
+
+	refund()
+	refund()
+
+	if !RateLimit(user, limit) {
+		t.Fatal("refunded token should be available again")
+	}
+	if RateLimit(user, limit) {
+		t.Fatal("double refund must not return more than one token")
+	}
+}
+
+func TestRateLimitRefundable_DeniedRefundIsNoop(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	user := "refund-denied"
+	RateLimit(user, 1)
+	allowed, refund := RateLimitRefundable(user, 1)
+	if allowed {
+		t.Fatal("request over the limit should be denied")
+	}
+	refund()
+	if RateLimit(user, 1) {
+		t.Fatal("refunding a denied request must not free a slot")
+	}
+}
+
+func TestRefundLeaky_Memory(t *testing.T) {
+	resetLimiterState()
+	SetUserMode("alice", "leaky")
+	SetUserWindow("alice", time.Minute)
+
+	RateLimit("alice", 3)
+	RateLimit("alice", 3)
+	RateLimit("alice", 3)
+	if RateLimit("alice", 3) {
+		t.Fatal("the bucket should be empty")
+	}
+	RefundLeaky("alice", 2)
+	if got := countAllowed("alice", 3, 5); got != 2 {
+		t.Fatalf("expected the 2 refunded tokens to be usable, got %d", got)
+	}
+	RefundLeaky("alice", 100)
+	if tokens, _, _, _ := InspectLeaky("alice"); tokens != 3 {
+		t.Fatalf("a refund must not fill the bucket past its capacity, got %v", tokens)
+	}
+}
@@ -0,0 +1,47 @@
+package limiter
+
+import (
+	"strconv"
+	"time"
+)
+
+// AllowAt is RateLimit deciding as if the request arrived at now rather than
+// at the current time, for replaying captured traffic deterministically. now is
+// used by the sliding window, leaky bucket and daily quota math, in memory and
+// in Redis (where it replaces the server clock). Replays need not be in order:
+// a request older than ones already seen is counted where it belongs in the
+// sliding window and leaks nothing into the bucket, and one from an earlier
+// day than the in-memory quota tracks is allowed without being counted.
+//
+// Penalties are timed by now as well. Multi-window rules and deny-list expiry
+// still follow the current time, and early throttling (SetSoftThreshold) is
+// not applied to replays.
+func AllowAt(userID string, limit int, now time.Time) bool {
+	allowed, _ := rateLimitAt(ctx, transformKey(userID), limit, now)
+	return allowed
+}
+
+// nowMsAt returns at in unix ms, or the current time if at is zero.
+func nowMsAt(at time.Time) int64 {
+	if at.IsZero() {
+		return monoNowMs()
+	}
+	return at.UnixMilli()
+}
+
+// clockAt returns at, or timeNow() if at is zero.
+func clockAt(at time.Time) time.Time {
+	if at.IsZero() {
+		return timeNow()
+	}
+	return at
+}
+
+// atArg encodes at for scripts built with luaNowMsArg: "" (use the server
+// clock) if at is zero, otherwise at in unix ms.
+func atArg(at time.Time) string {
+	if at.IsZero() {
+		return ""
+	}
+	return strconv.FormatInt(at.UnixMilli(), 10)
+}
@@ -0,0 +1,89 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+// replay feeds offsets (ms from base) through AllowAt and returns the decisions.
+func replay(user string, limit int, base time.Time, offsets []int64) []bool {
+	out := make([]bool, len(offsets))
+	for i, off := range offsets {
+		out[i] = AllowAt(user, limit, base.Add(time.Duration(off)*time.Millisecond))
+	}
+	return out
+}
+
+func TestAllowAt_ReproducibleReplay(t *testing.T) {
+	base := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	offsets := []int64{0, 100, 200, 300, 950, 1001, 1050, 1150, 1999, 2500}
+
+	for _, mode := range []string{"sliding", "leaky"} {
+		var first []bool
+		for run := 0; run < 3; run++ {
+			resetLimiterState()
+			SetMode(mode)
+			got := replay("replay-user", 3, base, offsets)
+			if run == 0 {
+				first = got
+				continue
+			}
+			for i := range got {
+				if got[i] != first[i] {
+					t.Fatalf("%s run %d: decision %d differs (%v vs %v)", mode, run, i, got, first)
+				}
+			}
+		}
+		if mode == "sliding" {
+			want := []bool{true, true, true, false, false, true, false, true, true, true}
+			for i := range want {
+				if first[i] != want[i] {
+					t.Fatalf("sliding replay = %v, want %v", first, want)
+				}
+			}
+		}
+	}
+}
+
+func TestAllowAt_OutOfOrder(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	base := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// a late arrival still counts within its own window
+	got := replay("ooo-sliding", 2, base, []int64{500, 0, 400, 1300, 1450})
+	want := []bool{true, true, false, true, false}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("out-of-order sliding replay = %v, want %v", got, want)
+		}
+	}
+
+	resetLimiterState()
+	SetMode("leaky")
+	// going back in time must not refill the bucket twice
+	got = replay("ooo-leaky", 2, base, []int64{1000, 1000, 0, 1000, 1500, 1500})
+	want = []bool{true, true, false, false, true, false}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("out-of-order leaky replay = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestAllowAt_DailyUsesSuppliedDay(t *testing.T) {
+	resetLimiterState()
+	SetMode("daily")
+	day1 := time.Date(2020, 3, 1, 23, 0, 0, 0, time.UTC)
+	day2 := day1.Add(2 * time.Hour)
+
+	if !AllowAt("replay-daily", 1, day1) || AllowAt("replay-daily", 1, day1) {
+		t.Fatal("quota of 1 should allow exactly one request on the replayed day")
+	}
+	if !AllowAt("replay-daily", 1, day2) {
+		t.Fatal("the next replayed day should start a fresh quota")
+	}
+	if !AllowAt("replay-daily", 1, day1) || AllowAt("replay-daily", 1, day2) {
+		t.Fatal("a late request from an earlier day must not reset the current day")
+	}
+}
@@ -0,0 +1,261 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ReserveLeaky reserves one token from the user's in-memory leaky bucket.
+//
+// If a token is available it is consumed and wait is 0. If the bucket is empty,
+// the token is reserved against future refill (the bucket goes into debt, as
+// with golang.org/x/time/rate reservations) and wait is the delay until that
+// token accrues; the caller should wait before proceeding. Later requests are
+// deferred by outstanding reservations.
+//
+// ok is false, and nothing is reserved, when limit <= 0 or when the wait would
+// exceed one full refill of the bucket.
+func ReserveLeaky(userID string, limit int) (ok bool, wait time.Duration) {
+	return reserveLeaky(transformKey(userID), limit)
+}
+
+// reserveLeaky is ReserveLeaky for a key already transformed (see
+// SetKeyTransformer).
+func reserveLeaky(userID string, limit int) (ok bool, wait time.Duration) {
+	_, _, ok, wait = reserveToken(userID, limit)
+	return ok, wait
+}
+
+// reserveToken implements reserveLeaky, also returning the bucket and the
+// instant (ms) the reservation is due, 0 if none was needed, so the caller
+// can cancel exactly its own reservation with cancelQueued.
+func reserveToken(userID string, limit int) (st *leakyState, due int64, ok bool, wait time.Duration) {
+	if limit <= 0 {
+		return nil, 0, false, 0
+	}
+	limit = EffectiveLimit(userID, limit)
+	now := monoNowMs()
+	st = getLeakyState(userID, limit, GetUserWindow(userID), now)
+
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+
+	st.refill(now)
+	st.pruneReservations(now)
+
+	if st.tokens >= 1.0 {
+		st.tokens -= 1.0
+		return st, 0, true, 0
+	}
+	// debt never exceeds one full bucket, i.e. waits are bounded by one refill period
+	if st.tokens-1.0 < -st.capacity {
+		return st, 0, false, 0
+	}
+	st.tokens -= 1.0
+	waitMs := math.Ceil(-st.tokens / st.ratePerMs)
+	due = now + int64(waitMs)
+	st.reservations = append(st.reservations, due)
+	return st, due, true, time.Duration(waitMs) * time.Millisecond
+}
+
+// Wait blocks until the user may proceed under limit, pacing callers through
+// the user's in-memory leaky bucket (see ReserveLeaky): once the bucket's
+// initial burst is spent, callers are released one per window/limit. If ctx
+// ends first, Wait returns ErrContextCanceled (wrapping the context's error)
+// and gives its own reserved token back, leaving other waiters' reservations
+// in place. While draining (see Drain) it returns
+// ErrDraining instead of waiting.
+func Wait(ctx context.Context, userID string, limit int) error {
+	if limit <= 0 {
+		return fmt.Errorf("wait: %w: %d", ErrInvalidLimit, limit)
+	}
+	userID = transformKey(userID)
+	for {
+		if err := ctx.Err(); err != nil {
+			return contextError(err)
+		}
+		if IsDraining(userID) {
+			return ErrDraining
+		}
+		st, due, ok, wait := reserveToken(userID, limit)
+		if ok && wait == 0 {
+			return nil
+		}
+		if !ok {
+			// too deep in debt to reserve; retry after roughly one token
+			wait = GetUserWindow(userID) / time.Duration(EffectiveLimit(userID, limit))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if ok {
+				st.cancelQueued(due)
+			}
+			return contextError(ctx.Err())
+		case <-timer.C:
+			if ok {
+				return nil
+			}
+		}
+	}
+}
+
+// WaitCtx blocks until a request by userID under limit is allowed by the
+// user's own algorithm, retrying after each denial once its RetryAfter has
+// passed. Unlike Wait it works with Redis, so waiters on every instance share
+// the user's window: the wait comes from the decision itself (for the sliding
+// window, from the Redis script), not from a separate lookup. Waiters that
+// wake together and lose the race for the freed slots simply wait again;
+// SetRetryJitter spreads their wake-ups. Every denied attempt counts as a
+// denial (DeniedTotal, OnDeny).
+//
+// If ctx ends first WaitCtx returns ErrContextCanceled, wrapping the
+// context's error. It returns ErrBackendUnavailable if Redis fails, and
+// ErrDraining while draining (see Drain), rather than waiting.
+func WaitCtx(ctx context.Context, userID string, limit int) error {
+	if limit <= 0 {
+		return fmt.Errorf("wait: %w: %d", ErrInvalidLimit, limit)
+	}
+	userID = transformKey(userID)
+	for {
+		if err := ctx.Err(); err != nil {
+			return contextError(err)
+		}
+		res := allowResult(ctx, userID, limit)
+		if res.Allowed {
+			return nil
+		}
+		if res.Err != nil {
+			return res.Err
+		}
+		wait := res.RetryAfter
+		if wait <= 0 {
+			// no reset reported (e.g. deny-listed); retry after roughly one slot
+			wait = GetUserWindow(userID) / time.Duration(max(EffectiveLimit(userID, limit), 1))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return contextError(ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// EnqueueLeaky treats the user's in-memory leaky bucket as a queue: a
+// request that finds a token goes through at once; one that finds the bucket
+// empty waits in the user's queue until a token leaks back, at the bucket's
+// rate, and then returns true, unless maxQueue requests are already waiting,
+// in which case it returns false at once. Waiting requests are reservations
+// (see ReserveLeaky), so they share the queue with ReserveLeaky and Wait
+// callers, and are released in order one per window/limit. If ctx ends
+// first, the request leaves the queue, gives its token back and gets false;
+// so does every request while draining (see Drain), or with limit <= 0.
+func EnqueueLeaky(ctx context.Context, userID string, limit, maxQueue int) (ok bool) {
+	userID = transformKey(userID)
+	if limit <= 0 || ctx.Err() != nil || IsDraining(userID) {
+		return false
+	}
+	limit = EffectiveLimit(userID, limit)
+	now := monoNowMs()
+	st := getLeakyState(userID, limit, GetUserWindow(userID), now)
+
+	st.mtx.Lock()
+	st.refill(now)
+	st.pruneReservations(now)
+	if st.tokens >= 1.0 {
+		st.tokens -= 1.0
+		st.mtx.Unlock()
+		return true
+	}
+	if len(st.reservations) >= maxQueue {
+		st.mtx.Unlock()
+		return false
+	}
+	st.tokens -= 1.0
+	due := now + int64(math.Ceil(-st.tokens/st.ratePerMs))
+	st.reservations = append(st.reservations, due)
+	st.mtx.Unlock()
+
+	timer := time.NewTimer(time.Duration(due-now) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		st.cancelQueued(due)
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// LeakyQueueLen returns how many requests are waiting in the user's leaky
+// bucket queue (EnqueueLeaky) or hold future reservations (ReserveLeaky).
+func LeakyQueueLen(userID string) int {
+	st, ok := leakyBuckets.load(transformKey(userID))
+	if !ok {
+		return 0
+	}
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	st.pruneReservations(monoNowMs())
+	return len(st.reservations)
+}
+
+// cancelQueued removes the pending reservation due at due, refunding its
+// token. It is a no-op if that reservation has already come due.
+func (st *leakyState) cancelQueued(due int64) {
+	now := monoNowMs()
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	st.refill(now)
+	st.pruneReservations(now)
+	for i := len(st.reservations) - 1; i >= 0; i-- {
+		if st.reservations[i] == due {
+			st.reservations = append(st.reservations[:i], st.reservations[i+1:]...)
+			st.tokens = math.Min(st.tokens+1.0, st.capacity)
+			return
+		}
+	}
+}
+
+// CancelReservation cancels the user's most recent outstanding future
+// reservation made by ReserveLeaky, refunding its token. It is a no-op if the
+// user has no reservation that is still pending. With several callers
+// reserving for the same user, the reservation cancelled may be another
+// caller's.
+func CancelReservation(userID string) {
+	st, ok := leakyBuckets.load(transformKey(userID))
+	if !ok {
+		return
+	}
+
+	now := monoNowMs()
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+
+	st.refill(now)
+	st.pruneReservations(now)
+	if len(st.reservations) == 0 {
+		return
+	}
+	st.reservations = st.reservations[:len(st.reservations)-1]
+	st.tokens += 1.0
+	if st.tokens > st.capacity {
+		st.tokens = st.capacity
+	}
+}
+
+// pruneReservations drops reservations whose wait has elapsed. Caller holds st.mtx.
+func (st *leakyState) pruneReservations(now int64) {
+	pending := st.reservations[:0]
+	for _, due := range st.reservations {
+		if due > now {
+			pending = append(pending, due)
+		}
+	}
+	st.reservations = pending
+}
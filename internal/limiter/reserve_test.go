@@ -0,0 +1,211 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReserveLeaky_DefersFutureRequests(t *testing.T) {
+	resetLimiterState()
+	SetMode("leaky")
+
+	user := "reserver"
+	limit := 2 // one token every 500ms
+
+	for i := 0; i < limit; i++ {
+		if ok, wait := ReserveLeaky(user, limit); !ok || wait != 0 {
+			t.Fatalf("reservation %d: expected immediate token, got ok=%v wait=%v", i+1, ok, wait)
+		}
+	}
+	ok, wait := ReserveLeaky(user, limit)
+	if !ok {
+		t.Fatal("reservation against future refill should succeed")
+	}
+	if wait < 400*time.Millisecond || wait > 500*time.Millisecond {
+		t.Fatalf("expected wait of ~500ms, got %v", wait)
+	}
+
+	// the token accruing at ~500ms belongs to the reservation
+	time.Sleep(600 * time.Millisecond)
+	if RateLimit(user, limit) {
+		t.Fatal("request should be deferred by the outstanding reservation")
+	}
+}
+
+func TestReserveLeaky_CancelRestoresCapacity(t *testing.T) {
+	resetLimiterState()
+	SetMode("leaky")
+
+	user := "canceller"
+	limit := 2
+
+	ReserveLeaky(user, limit)
+	ReserveLeaky(user, limit)
+	if ok, wait := ReserveLeaky(user, limit); !ok || wait == 0 {
+		t.Fatalf("expected a future reservation, got ok=%v wait=%v", ok, wait)
+	}
+	CancelReservation(user)
+	// nothing left to cancel: must not refund again
+	CancelReservation(user)
+
+	time.Sleep(600 * time.Millisecond)
+	if !RateLimit(user, limit) {
+		t.Fatal("cancelled reservation should return its token")
+	}
+	if RateLimit(user, limit) {
+		t.Fatal("double cancel must not refund more than one token")
+	}
+}
+
+func TestWait_CanceledWaiterGivesBackItsOwnReservation(t *testing.T) {
+	resetLimiterState()
+	SetMode("leaky")
+	user := "waiters"
+	SetUserWindow(user, 10*time.Second) // one token every 5s
+	countAllowed(user, 2, 2)            // empty the bucket
+
+	waitQueued := func(n int) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second); LeakyQueueLen(user) != n; {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d queued, got %d", n, LeakyQueueLen(user))
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	early, cancelEarly := context.WithCancel(context.Background())
+	late, cancelLate := context.WithCancel(context.Background())
+	defer cancelLate()
+	earlyDone := make(chan error, 1)
+	go func() { earlyDone <- Wait(early, user, 2) }()
+	waitQueued(1)
+	go Wait(late, user, 2)
+	waitQueued(2)
+
+	cancelEarly()
+	if err := <-earlyDone; !errors.Is(err, ErrContextCanceled) {
+		t.Fatalf("expected ErrContextCanceled, got %v", err)
+	}
+	st, _ := leakyBuckets.load(user)
+	st.mtx.Lock()
+	left := st.reservations
+	st.mtx.Unlock()
+	// the early waiter's slot, due in ~5s, is the one given back
+	if len(left) != 1 || left[0]-monoNowMs() < 7000 {
+		t.Fatalf("expected only the late waiter's reservation (~10s out) left, got %v", left)
+	}
+}
+
+func TestReserveLeaky_BoundedDebt(t *testing.T) {
+	resetLimiterState()
+
+	user := "greedy"
+	limit := 2
+	for i := 0; i < 2*limit; i++ {
+		if ok, _ := ReserveLeaky(user, limit); !ok {
+			t.Fatalf("reservation %d should succeed", i+1)
+		}
+	}
+	if ok, _ := ReserveLeaky(user, limit); ok {
+		t.Fatal("reservation beyond one refill period should fail")
+	}
+}
+
+func TestWaitCtx_WaitsForTheUsersWindow(t *testing.T) {
+	resetLimiterState()
+	SetUserWindow("alice", 200*time.Millisecond)
+
+	RateLimit("alice", 1)
+	start := time.Now()
+	if err := WaitCtx(context.Background(), "alice", 1); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("expected to wait for the window to free a slot, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := WaitCtx(ctx, "alice", 1); !errors.Is(err, ErrContextCanceled) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to end the wait, got %v", err)
+	}
+
+	Drain()
+	if err := WaitCtx(context.Background(), "alice", 1); !errors.Is(err, ErrDraining) {
+		t.Fatalf("expected ErrDraining, got %v", err)
+	}
+	if err := WaitCtx(context.Background(), "alice", 0); !errors.Is(err, ErrInvalidLimit) {
+		t.Fatalf("expected ErrInvalidLimit, got %v", err)
+	}
+}
+
+func TestEnqueueLeaky_QueuesUpToMaxAndReleasesAtLeakRate(t *testing.T) {
+	resetLimiterState()
+	SetMode("leaky")
+	user := "queued"
+	SetUserWindow(user, 400*time.Millisecond) // one token every 100ms
+	const limit, maxQueue = 4, 3
+
+	for i := 0; i < limit; i++ {
+		if !EnqueueLeaky(context.Background(), user, limit, maxQueue) {
+			t.Fatalf("request %d should take a token from the full bucket", i+1)
+		}
+	}
+
+	start := time.Now()
+	released := make(chan time.Duration, maxQueue)
+	for i := 0; i < maxQueue; i++ {
+		go func() {
+			if EnqueueLeaky(context.Background(), user, limit, maxQueue) {
+				released <- time.Since(start)
+			} else {
+				released <- -1
+			}
+		}()
+	}
+	for LeakyQueueLen(user) < maxQueue {
+		time.Sleep(time.Millisecond)
+	}
+	if EnqueueLeaky(context.Background(), user, limit, maxQueue) {
+		t.Fatal("a request beyond the queue depth should be rejected")
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("a full queue should reject at once, took %v", elapsed)
+	}
+
+	for i := 1; i <= maxQueue; i++ {
+		got := <-released
+		if got < 0 {
+			t.Fatalf("queued request %d was rejected", i)
+		}
+		want := time.Duration(i) * 100 * time.Millisecond
+		if got < want-20*time.Millisecond || got > want+60*time.Millisecond {
+			t.Fatalf("queued request %d released after %v, want about %v", i, got, want)
+		}
+	}
+	if n := LeakyQueueLen(user); n != 0 {
+		t.Fatalf("expected an empty queue, got %d", n)
+	}
+}
+
+func TestEnqueueLeaky_CanceledLeavesQueue(t *testing.T) {
+	resetLimiterState()
+	SetMode("leaky")
+	user := "queued"
+	SetUserWindow(user, 10*time.Second)
+	countAllowed(user, 1, 1) // empty the bucket
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if EnqueueLeaky(ctx, user, 1, 1) {
+		t.Fatal("expected the queued request to give up with its context")
+	}
+	if n := LeakyQueueLen(user); n != 0 {
+		t.Fatalf("a canceled request should leave the queue, got %d queued", n)
+	}
+	if EnqueueLeaky(context.Background(), user, 1, 0) {
+		t.Fatal("with no queue allowed an empty bucket should reject at once")
+	}
+}
@@ -0,0 +1,96 @@
+package limiter
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// resetScanCount is the COUNT hint for each SCAN, and so roughly the number
+// of keys deleted per DEL.
+const resetScanCount = 500
+
+// resetKeyPrefixes are the Redis key prefixes holding users' usage.
+var resetKeyPrefixes = []string{"rate:", "ratelimits:", "bucket:", "quota:", "quotacredit:", "crate:", "decay:", "rules:"}
+
+// ResetByPrefix discards the usage of every user whose ID starts with
+// prefix, e.g. "tenant:" when offboarding a tenant keyed with CompositeKey,
+// so they start afresh: their sliding windows, buckets, daily counts, rule
+// windows, decay scores and penalties. Their settings (limits, modes, lists,
+// tiers) are kept. With Redis, the keys are found with SCAN over each key
+// prefix (rate:<prefix>*, bucket:<prefix>*, ...) and deleted in batches, so
+// Redis is never blocked as with KEYS; requests decided meanwhile may
+// recreate some of them. Users whose IDs are hashed in Redis keys (see
+// SetKeyHashing) cannot be found by prefix there. A prefix is transformed
+// like a user ID (see SetKeyTransformer), except that an empty prefix matches
+// every user. It returns the first Redis error, after which the remaining
+// keys are left in place.
+func ResetByPrefix(prefix string) error {
+	if prefix != "" {
+		prefix = transformKey(prefix)
+	}
+	match := func(userID string) bool { return strings.HasPrefix(userID, prefix) }
+	slidingStates.deleteMatching(match)
+	leakyBuckets.deleteMatching(match)
+	dailyStates.deleteMatching(match)
+	ruleStates.deleteMatching(match)
+	decayStates.deleteMatching(match)
+	for _, m := range []*sync.Map{&userPenalties, &throttled, &sampledVerdict} {
+		m.Range(func(k, _ any) bool {
+			if match(k.(string)) {
+				m.Delete(k)
+			}
+			return true
+		})
+	}
+	forgetUsers(match)
+
+	if rdb == nil {
+		return nil
+	}
+	for _, p := range resetKeyPrefixes {
+		idPrefix := prefix
+		if p == "rules:" {
+			// rule keys embed the ID escaped as by CompositeKey
+			idPrefix = CompositeKey(prefix)
+		}
+		if err := deleteScanned(ctx, p+escapeGlob(idPrefix)+"*"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteScanned deletes the Redis keys matching pattern, a batch per SCAN
+// reply.
+func deleteScanned(ctx context.Context, pattern string) error {
+	var cursor uint64
+	for {
+		keys, next, err := rdb.Scan(ctx, cursor, pattern, resetScanCount).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := rdb.Del(ctx, keys...).Err(); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// escapeGlob escapes the characters special in a Redis MATCH pattern.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
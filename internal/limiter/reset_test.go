@@ -0,0 +1,57 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResetByPrefix_OnlyTargetTenant(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	SetUserMode(CompositeKey("acme", "bob"), "leaky")
+	SetUserMode(CompositeKey("globex", "bob"), "leaky")
+
+	users := []string{
+		CompositeKey("acme", "alice"), CompositeKey("acme", "bob"),
+		CompositeKey("globex", "alice"), CompositeKey("globex", "bob"),
+	}
+	for _, u := range users {
+		countAllowed(u, 3, 3)
+	}
+
+	if err := ResetByPrefix("acme:"); err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range users[:2] {
+		if got := countAllowed(u, 3, 3); got != 3 {
+			t.Fatalf("%s: expected a fresh allowance after the reset, got %d", u, got)
+		}
+	}
+	for _, u := range users[2:] {
+		if RateLimit(u, 3) {
+			t.Fatalf("%s: another tenant's usage must be kept", u)
+		}
+	}
+	if got := GetUserMode(CompositeKey("acme", "bob")); got != "leaky" {
+		t.Fatalf("settings must be kept, got mode %q", got)
+	}
+}
+
+func TestResetByPrefix_DecayAndDaily(t *testing.T) {
+	resetLimiterState()
+	SetDecayHalfLife(time.Hour)
+	SetUserMode("t1:decay", decayMode)
+	SetUserMode("t1:daily", "daily")
+	SetUserMode("t2:daily", "daily")
+	for _, u := range []string{"t1:decay", "t1:daily", "t2:daily"} {
+		countAllowed(u, 2, 2)
+	}
+
+	ResetByPrefix("t1:")
+	if peekDecayScore("t1:decay") != 0 || peekDailyCount("t1:daily") != 0 {
+		t.Fatal("expected the targeted tenant's decay and daily state to be gone")
+	}
+	if got := peekDailyCount("t2:daily"); got != 2 {
+		t.Fatalf("expected the other tenant's daily count to be kept, got %d", got)
+	}
+}
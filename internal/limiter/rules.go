@@ -0,0 +1,221 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Rule is one sliding-window limit: at most Limit requests per Window.
+type Rule struct {
+	Limit  int
+	Window time.Duration
+}
+
+// String names the rule in the config shorthand, e.g. "10/s", "100/m" or
+// "5/10s".
+func (r Rule) String() string {
+	per := r.Window.String()
+	switch r.Window {
+	case time.Second:
+		per = "s"
+	case time.Minute:
+		per = "m"
+	case time.Hour:
+		per = "h"
+	}
+	return strconv.Itoa(r.Limit) + "/" + per
+}
+
+var (
+	userRules  = sync.Map{} // map[string][]Rule
+	ruleStates = newShardedMap[*rulesState](defaultShardCount)
+)
+
+// rulesState holds one in-memory sliding window per rule.
+type rulesState struct {
+	mtx sync.Mutex
+	ts  [][]int64 // ts[i] = request timestamps (ms) within rule i's window
+}
+
+// SetUserLimits makes every request from userID pass all rules, e.g.
+// 10 per second AND 100 per minute. An allowed request counts against every
+// rule; a denied one against none. Rules replace the user's single limit and
+// algorithm mode, and always use sliding windows. Nil or empty rules remove
+// them. Windows have millisecond resolution.
+func SetUserLimits(userID string, rules []Rule) error {
+	if len(rules) == 0 {
+		userRules.Delete(userID)
+		return nil
+	}
+	cp := make([]Rule, len(rules))
+	for i, r := range rules {
+		if r.Limit <= 0 {
+			return fmt.Errorf("rule %d: limit must be positive, got %d", i, r.Limit)
+		}
+		if r.Window < time.Millisecond {
+			return fmt.Errorf("rule %d: window must be at least 1ms, got %v", i, r.Window)
+		}
+		cp[i] = Rule{Limit: r.Limit, Window: r.Window.Truncate(time.Millisecond)}
+	}
+	userRules.Store(userID, cp)
+	return nil
+}
+
+// SetSpikeAndSustained gives userID a two-tier limit: at most spike requests
+// per spikeWindow to absorb bursts, and at most sustained per the longer
+// sustainedWindow as an overall quota. Both are checked and recorded in one
+// atomic evaluation (one Lua script with Redis), as rules set with
+// SetUserLimits, which this replaces.
+func SetSpikeAndSustained(userID string, spike int, spikeWindow time.Duration, sustained int, sustainedWindow time.Duration) error {
+	if spikeWindow >= sustainedWindow {
+		return fmt.Errorf("spike window %v must be shorter than sustained window %v", spikeWindow, sustainedWindow)
+	}
+	return SetUserLimits(userID, []Rule{
+		{Limit: spike, Window: spikeWindow},
+		{Limit: sustained, Window: sustainedWindow},
+	})
+}
+
+// GetUserLimits returns the user's rules, or nil if none are set.
+func GetUserLimits(userID string) []Rule {
+	v, ok := userRules.Load(userID)
+	if !ok {
+		return nil
+	}
+	return v.([]Rule)
+}
+
+// ---------- Multi-rule (in-memory) ----------
+func rateLimitMemoryRules(userID string, rules []Rule) (bool, receipt) {
+	touchUser(userID)
+	st := ruleStates.loadOrStore(userID, func() *rulesState { return &rulesState{} })
+
+	now := monoNowMs()
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	if len(st.ts) != len(rules) {
+		st.ts = make([][]int64, len(rules)) // rules changed: start fresh
+	}
+
+	var denied receipt
+	allowed := receipt{tsMs: now} // names the rule with the fewest requests left
+	left := 0
+	for i, r := range rules {
+		cutoff := now - r.Window.Milliseconds()
+		expired := 0
+		for expired < len(st.ts[i]) && st.ts[i][expired] <= cutoff {
+			expired++
+		}
+		if expired > 0 {
+			n := copy(st.ts[i], st.ts[i][expired:])
+			st.ts[i] = st.ts[i][:n]
+		}
+		n := len(st.ts[i])
+		if allowed.rule == 0 || r.Limit-n-1 < left {
+			allowed.rule, allowed.used, left = i+1, n+1, r.Limit-n-1
+		}
+		if n >= r.Limit {
+			// a slot frees up once the request limit places from the end expires
+			wait := st.ts[i][n-r.Limit] + r.Window.Milliseconds() - now
+			if denied.rule == 0 || wait > denied.retryMs {
+				denied = receipt{rule: i + 1, retryMs: wait}
+			}
+		}
+	}
+	if denied.rule != 0 {
+		return false, denied
+	}
+	for i := range rules {
+		st.ts[i] = append(st.ts[i], now)
+	}
+	return true, allowed
+}
+
+// refundMemoryRules removes the timestamp recorded at tsMs from every rule.
+func refundMemoryRules(userID string, tsMs int64) {
+	st, ok := ruleStates.load(userID)
+	if !ok {
+		return
+	}
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	for i, ts := range st.ts {
+		for j := len(ts) - 1; j >= 0; j-- {
+			if ts[j] == tsMs {
+				st.ts[i] = append(ts[:j], ts[j+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// ---------- Multi-rule (Redis) ----------
+// rulesScript checks every rule before recording the request in any of them,
+// so a denied request consumes nothing. Timestamps use the Redis server clock.
+// KEYS[i]      = one sorted set per rule
+// ARGV[1]      = unique member (caller's nowNs)
+// ARGV[3i - 1] = rule i limit
+// ARGV[3i]     = rule i window (ms)
+// ARGV[3i + 1] = rule i key expiry (ms)
+// Returns {1, i, used} naming the rule i with the fewest requests left and
+// the requests it holds after this one, or {0, i, wait} naming the full rule i
+// whose slot frees up last, wait ms from now.
+var rulesScript = newScript("rules", luaNowMs+luaExpire+`
+	local deny, wait = 0, -1
+	local bind, left, used = 0, 0, 0
+	for i, key in ipairs(KEYS) do
+		local limit = tonumber(ARGV[3 * i - 1])
+		local window = tonumber(ARGV[3 * i])
+		redis.call("ZREMRANGEBYSCORE", key, 0, now - window)
+		local count = redis.call("ZCARD", key)
+		if bind == 0 or limit - count - 1 < left then
+			bind, left, used = i, limit - count - 1, count + 1
+		end
+		if count >= limit then
+			local pivot = redis.call("ZRANGE", key, count - limit, count - limit, "WITHSCORES")
+			local w = tonumber(pivot[2]) + window - now
+			if w > wait then deny, wait = i, w end
+		end
+	end
+	if deny > 0 then
+		return {0, deny, wait}
+	end
+	for i, key in ipairs(KEYS) do
+		redis.call("ZADD", key, now, ARGV[1])
+		pexpire(key, ARGV[3 * i + 1])
+	end
+	return {1, bind, used}
+`)
+
+// ruleKey is the sorted set for one of the user's rules. Its "rules:" prefix
+// keeps it apart from every user's sliding key: under "rate:" the rule key of
+// "alice" would be the sliding key of "alice:1000ms".
+func ruleKey(userID string, window time.Duration) string {
+	return "rules:" + CompositeKey(redisUserPart(userID), strconv.FormatInt(window.Milliseconds(), 10)+"ms")
+}
+
+func rateLimitRedisRules(ctx context.Context, userID string, rules []Rule) (bool, receipt) {
+	if rdb == nil {
+		return false, receipt{}
+	}
+	return rulesCall(userID, rules).runCtx(ctx)
+}
+
+func rulesCall(userID string, rules []Rule) scriptCall {
+	member := strconv.FormatInt(timeNow().UnixNano(), 10)
+
+	keys := make([]string, len(rules))
+	args := []any{member}
+	for i, r := range rules {
+		keys[i] = ruleKey(userID, r.Window)
+		args = append(args,
+			strconv.Itoa(r.Limit),
+			strconv.FormatInt(r.Window.Milliseconds(), 10),
+			strconv.FormatInt(keyExpiry(r.Window).Milliseconds(), 10),
+		)
+	}
+	return scriptCall{script: rulesScript, keys: keys, args: args, rc: receipt{member: member}}
+}
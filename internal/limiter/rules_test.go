@@ -0,0 +1,179 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetUserLimits_ShortWindowBinds(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	user := "burst-user"
+	if err := SetUserLimits(user, []Rule{
+		{Limit: 2, Window: 100 * time.Millisecond},
+		{Limit: 10, Window: 5 * time.Second},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertAllowance(t, user, 2)
+	time.Sleep(120 * time.Millisecond)
+	assertAllowance(t, user, 2) // short window recovered, long one still has room
+}
+
+func TestSetUserLimits_LongWindowBinds(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	user := "steady-user"
+	SetUserLimits(user, []Rule{
+		{Limit: 2, Window: 50 * time.Millisecond},
+		{Limit: 3, Window: 5 * time.Second},
+	})
+
+	assertAllowance(t, user, 2)
+	time.Sleep(70 * time.Millisecond)
+	// the short window has room again, but only one request remains in the long one
+	assertAllowance(t, user, 1)
+	time.Sleep(70 * time.Millisecond)
+	if RateLimit(user, 100) {
+		t.Fatal("long window exhausted: request should be denied")
+	}
+}
+
+func TestSetUserLimits_DeniedRequestConsumesNothing(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	user := "no-leak"
+	SetUserLimits(user, []Rule{
+		{Limit: 1, Window: 50 * time.Millisecond},
+		{Limit: 3, Window: 5 * time.Second},
+	})
+	RateLimit(user, 100)
+	for i := 0; i < 5; i++ {
+		RateLimit(user, 100) // denied by the short rule
+	}
+	st, _ := ruleStates.load(user)
+	if n := len(st.ts[1]); n != 1 {
+		t.Fatalf("denied requests must not count against the long rule, got %d", n)
+	}
+}
+
+func TestSetUserLimits_Validation(t *testing.T) {
+	resetLimiterState()
+
+	if err := SetUserLimits("u", []Rule{{Limit: 0, Window: time.Second}}); err == nil {
+		t.Fatal("zero limit should be rejected")
+	}
+	if err := SetUserLimits("u", []Rule{{Limit: 1, Window: 0}}); err == nil {
+		t.Fatal("zero window should be rejected")
+	}
+	SetUserLimits("u", []Rule{{Limit: 1, Window: time.Second}})
+	SetUserLimits("u", nil)
+	if GetUserLimits("u") != nil {
+		t.Fatal("nil rules should remove them")
+	}
+}
+
+func TestSetSpikeAndSustained_Validation(t *testing.T) {
+	resetLimiterState()
+
+	if err := SetSpikeAndSustained("two-tier", 5, time.Minute, 100, time.Second); err == nil {
+		t.Fatal("a spike window longer than the sustained one should be rejected")
+	}
+	if err := SetSpikeAndSustained("two-tier", 0, time.Second, 100, time.Minute); err == nil {
+		t.Fatal("a zero spike limit should be rejected")
+	}
+	if err := SetSpikeAndSustained("two-tier", 5, time.Second, 100, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	want := []Rule{{Limit: 5, Window: time.Second}, {Limit: 100, Window: time.Minute}}
+	if got := GetUserLimits("two-tier"); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("expected rules %v, got %v", want, got)
+	}
+}
+
+func TestRule_String(t *testing.T) {
+	cases := map[Rule]string{
+		{Limit: 10, Window: time.Second}:           "10/s",
+		{Limit: 100, Window: time.Minute}:          "100/m",
+		{Limit: 1000, Window: time.Hour}:           "1000/h",
+		{Limit: 5, Window: 10 * time.Second}:       "5/10s",
+		{Limit: 2, Window: 250 * time.Millisecond}: "2/250ms",
+	}
+	for r, want := range cases {
+		if got := r.String(); got != want {
+			t.Errorf("%+v: got %q, want %q", r, got, want)
+		}
+	}
+}
+
+func TestAllowResult_ExplainsBindingRule(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	short := Rule{Limit: 2, Window: 100 * time.Millisecond}
+	long := Rule{Limit: 3, Window: 5 * time.Second}
+	SetUserLimits("explain", []Rule{short, long})
+
+	AllowResult("explain", 100)
+	AllowResult("explain", 100)
+	res := AllowResult("explain", 100)
+	if res.Allowed || res.Rule != short.String() || res.Limit != 2 {
+		t.Fatalf("a burst should be denied by %s, got %+v", short, res)
+	}
+	if res.RetryAfter <= 0 || res.RetryAfter > 100*time.Millisecond {
+		t.Fatalf("Retry-After should reflect the short window's reset, got %v", res.RetryAfter)
+	}
+
+	time.Sleep(120 * time.Millisecond)
+	if res := AllowResult("explain", 100); !res.Allowed || res.Rule != "" {
+		t.Fatalf("the short window recovered, got %+v", res)
+	}
+	res = AllowResult("explain", 100)
+	if res.Allowed || res.Rule != long.String() || res.Limit != 3 {
+		t.Fatalf("the long rule should deny now, got %+v", res)
+	}
+	if res.RetryAfter < 4*time.Second || res.RetryAfter > 5*time.Second {
+		t.Fatalf("Retry-After should reflect the long window's reset, got %v", res.RetryAfter)
+	}
+}
+
+// assertRuleRemaining checks that allowed requests under rules 3/s and 5/m
+// report the rule with the fewest requests left, and a denial the full one.
+func assertRuleRemaining(t *testing.T, user string) {
+	t.Helper()
+	if err := SetUserLimits(user, []Rule{{Limit: 3, Window: time.Second}, {Limit: 5, Window: time.Minute}}); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int{2, 1, 0} {
+		res := AllowResult(user, 10)
+		if !res.Allowed || res.Limit != 3 || res.Remaining != want || res.Rule != "" {
+			t.Fatalf("request %d: expected Limit 3 Remaining %d, got %+v", i+1, want, res)
+		}
+	}
+	if res := AllowResult(user, 10); res.Allowed || res.Limit != 3 || res.Remaining != 0 {
+		t.Fatalf("expected the 3/s rule to deny, got %+v", res)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	// 3 of 5 used: the minute rule now has fewer requests left
+	if res := AllowResult(user, 10); !res.Allowed || res.Limit != 5 || res.Remaining != 1 {
+		t.Fatalf("expected the 5/m rule to bind, got %+v", res)
+	}
+}
+
+func TestAllowResult_RulesReportBindingRule(t *testing.T) {
+	resetLimiterState()
+	assertRuleRemaining(t, "rules-remaining")
+
+	h := Middleware(MiddlewareOptions{KeyFunc: userKey, Limit: 10})(okHandler)
+	SetUserLimits("rules-headers", []Rule{{Limit: 3, Window: time.Second}})
+	rec := serve(h, "/api?user=rules-headers")
+	if rec.Header().Get("X-RateLimit-Limit") != "3" || rec.Header().Get("X-RateLimit-Remaining") != "2" {
+		t.Fatalf("expected the rule in the headers, got limit %s remaining %s",
+			rec.Header().Get("X-RateLimit-Limit"), rec.Header().Get("X-RateLimit-Remaining"))
+	}
+}
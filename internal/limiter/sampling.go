@@ -0,0 +1,66 @@
+package limiter
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	sampleRate     atomic.Uint64 // float64 bits; 0 means every request hits Redis
+	sampledVerdict = sync.Map{}  // map[string]bool, the user's last Redis decision
+)
+
+// SetSampleRate makes only about fraction of requests consult Redis, for
+// endpoints where throughput matters more than exactness. Each sampled
+// request stands for 1/fraction requests: Redis enforces the limit scaled by
+// fraction (rounded, at least 1), so a window admits fraction of its limit in
+// sampled requests. The other requests repeat the user's last sampled
+// decision without a round trip; a user without one is always sampled.
+//
+// The enforced rate is only approximately the limit. Over a window, the
+// number of requests allowed varies by about sqrt(limit/fraction); bursts
+// shorter than 1/fraction requests can slip past a full window; and rounding
+// the scaled limit skews small limits (with fraction 0.1, limits 5 and 14
+// both become 1, in effect 10). Usage read back from Redis (Stats,
+// TimeUntilReset) is in sampled units. Refunds give back a sampled request's
+// whole share, or nothing for one decided locally. Sampling applies to the
+// sliding, leaky and daily algorithms with Redis, and is skipped while a
+// global limit (SetGlobalLimitRedis) is set. fraction <= 0 or >= 1 turns it
+// off.
+func SetSampleRate(fraction float64) {
+	if !(fraction > 0) || fraction >= 1 {
+		fraction = 0
+	}
+	sampleRate.Store(math.Float64bits(fraction))
+}
+
+// GetSampleRate returns the fraction of requests sent to Redis, or 1 when
+// sampling is off.
+func GetSampleRate() float64 {
+	if f := math.Float64frombits(sampleRate.Load()); f > 0 {
+		return f
+	}
+	return 1
+}
+
+// sampled decides whether userID's request goes to Redis under a limit of
+// limit. If so, it returns the scaled limit to enforce there; if not, the
+// decision to repeat.
+func sampled(userID, mode string, limit int) (scaled int, consult bool, verdict bool) {
+	f := math.Float64frombits(sampleRate.Load())
+	if f == 0 || mode == "rules" || globalRedisLimit.Load() != nil {
+		return limit, true, false
+	}
+	if v, ok := sampledVerdict.Load(userID); ok && randFloat64() >= f {
+		return 0, false, v.(bool)
+	}
+	return max(int(math.Round(float64(limit)*f)), 1), true, false
+}
+
+// recordSample remembers a sampled decision for the requests that follow.
+func recordSample(userID string, allowed bool) {
+	if sampleRate.Load() != 0 {
+		sampledVerdict.Store(userID, allowed)
+	}
+}
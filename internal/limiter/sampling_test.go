@@ -0,0 +1,51 @@
+package limiter
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSampled_ScalesLimitAndRepeatsVerdict(t *testing.T) {
+	resetLimiterState()
+
+	if scaled, consult, _ := sampled("s-user", "sliding", 100); !consult || scaled != 100 {
+		t.Fatalf("sampling off: every request consults Redis at full limit, got %d %v", scaled, consult)
+	}
+
+	SetSampleRate(0.1)
+	defer SetSampleRate(0)
+	if got := GetSampleRate(); got != 0.1 {
+		t.Fatalf("expected sample rate 0.1, got %v", got)
+	}
+	if scaled, consult, _ := sampled("s-user", "sliding", 100); !consult || scaled != 10 {
+		t.Fatalf("a user without a verdict is always sampled at limit*fraction, got %d %v", scaled, consult)
+	}
+	if scaled, _, _ := sampled("s-user", "sliding", 3); scaled != 1 {
+		t.Fatalf("the scaled limit is at least 1, got %d", scaled)
+	}
+	if _, consult, _ := sampled("s-user", "rules", 3); !consult {
+		t.Fatal("rules are never sampled")
+	}
+
+	recordSample("s-user", false)
+	local, denied := 0, 0
+	for i := 0; i < 10000; i++ {
+		if _, consult, verdict := sampled("s-user", "sliding", 100); !consult {
+			local++
+			if !verdict {
+				denied++
+			}
+		}
+	}
+	if frac := float64(local) / 10000; math.Abs(frac-0.9) > 0.02 {
+		t.Fatalf("expected ~90%% of requests decided locally, got %.3f", frac)
+	}
+	if denied != local {
+		t.Fatal("local decisions must repeat the last sampled verdict")
+	}
+
+	SetSampleRate(1)
+	if got := GetSampleRate(); got != 1 {
+		t.Fatalf("fraction 1 turns sampling off, got %v", got)
+	}
+}
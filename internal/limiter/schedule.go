@@ -0,0 +1,78 @@
+package limiter
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var scheduledRefills = sync.Map{} // map[string]*time.Timer, pending in-memory refills
+
+// scheduleRefillScript records in bucket KEYS[1] the instant ARGV[1] (ms) at
+// which leakyScript refills it. A bucket that expires first is full anyway, so
+// the key only needs an expiry if scheduling created it and keys expire
+// (ARGV[2] = "1").
+var scheduleRefillScript = newScript("schedule-refill", luaNowMs+`
+	redis.call("HSET", KEYS[1], "refill_at", ARGV[1])
+	if ARGV[2] == "1" and redis.call("PTTL", KEYS[1]) < 0 then
+		redis.call("PEXPIRE", KEYS[1], math.max(tonumber(ARGV[1]) - now, 0) + 1000)
+	end
+	return 1
+`)
+
+// ScheduleRefill refills userID's leaky bucket to capacity at the wall-clock
+// time at, e.g. for contracts where the limit "resets at the top of each
+// hour". A user has at most one pending refill; scheduling another replaces
+// it. A time in the past refills on the next request (Redis) or at once
+// (memory).
+//
+// With Redis the instant is stored in the bucket and the refill happens on
+// the first request at or after it, judged by the Redis server clock, so it
+// survives restarts and applies on every instance; reads that do not go
+// through a request (Stats, Settle) see the bucket as it was until then. In
+// memory a timer refills an existing bucket in place.
+func ScheduleRefill(userID string, at time.Time) {
+	userID = transformKey(userID)
+	if rdb != nil {
+		expiring := "1"
+		if persistentKeys() {
+			expiring = "0"
+		}
+		scheduleRefillScript.Run(ctx, rdb, []string{leakyKey(userID)}, strconv.FormatInt(at.UnixMilli(), 10), expiring)
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(math.MaxInt64, func() {
+		// a replaced timer that already fired must not refill
+		if scheduledRefills.CompareAndDelete(userID, t) {
+			refillLeaky(userID)
+		}
+	})
+	if old, loaded := scheduledRefills.Swap(userID, t); loaded {
+		old.(*time.Timer).Stop()
+	}
+	t.Reset(time.Until(at))
+}
+
+// CancelRefill drops userID's pending in-memory refill, if any. Refills
+// scheduled in Redis stay until due.
+func CancelRefill(userID string) {
+	if t, ok := scheduledRefills.LoadAndDelete(transformKey(userID)); ok {
+		t.(*time.Timer).Stop()
+	}
+}
+
+// refillLeaky fills userID's in-memory bucket to capacity; a user without a
+// bucket is full already.
+func refillLeaky(userID string) {
+	st, _ := leakyBuckets.load(userID)
+	if st == nil {
+		return
+	}
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	st.tokens = st.capacity
+	st.lastMillis = monoNowMs()
+	st.reservations = nil
+}
@@ -0,0 +1,58 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleRefill_RestoresCapacityAtInstant(t *testing.T) {
+	resetLimiterState()
+	SetMode("leaky")
+	defer SetMode("sliding")
+	SetUserWindow("hourly", time.Hour) // natural refill is negligible here
+
+	for i := 0; i < 3; i++ {
+		RateLimit("hourly", 3)
+	}
+	if RateLimit("hourly", 3) {
+		t.Fatal("bucket should be empty")
+	}
+
+	ScheduleRefill("hourly", time.Now().Add(60*time.Millisecond))
+	time.Sleep(20 * time.Millisecond)
+	if RateLimit("hourly", 3) {
+		t.Fatal("the bucket must not refill before the scheduled time")
+	}
+
+	time.Sleep(80 * time.Millisecond)
+	for i := 1; i <= 3; i++ {
+		if !RateLimit("hourly", 3) {
+			t.Fatalf("request %d should be allowed after the scheduled refill", i)
+		}
+	}
+	if RateLimit("hourly", 3) {
+		t.Fatal("the refill restores capacity, no more")
+	}
+}
+
+func TestScheduleRefill_ReplaceAndCancel(t *testing.T) {
+	resetLimiterState()
+	SetMode("leaky")
+	defer SetMode("sliding")
+	SetUserWindow("sched", time.Hour)
+
+	RateLimit("sched", 1)
+	ScheduleRefill("sched", time.Now().Add(30*time.Millisecond))
+	ScheduleRefill("sched", time.Now().Add(time.Hour)) // replaces the first
+	time.Sleep(60 * time.Millisecond)
+	if RateLimit("sched", 1) {
+		t.Fatal("a replaced refill must not fire")
+	}
+
+	ScheduleRefill("sched", time.Now().Add(30*time.Millisecond))
+	CancelRefill("sched")
+	time.Sleep(60 * time.Millisecond)
+	if RateLimit("sched", 1) {
+		t.Fatal("a cancelled refill must not fire")
+	}
+}
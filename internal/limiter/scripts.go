@@ -0,0 +1,69 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Script is one of the Lua scripts the limiter runs in Redis.
+type Script struct {
+	Name   string // e.g. "sliding", "leaky"
+	Source string
+	SHA    string // SHA1 of Source, as EVALSHA and SCRIPT LOAD use it
+}
+
+// scriptRegistry holds every script declared with newScript, by name.
+var scriptRegistry = map[string]Script{}
+
+// newScript declares a script the limiter runs, registering it for Scripts
+// and PreloadScripts.
+func newScript(name, src string) *redis.Script {
+	if _, dup := scriptRegistry[name]; dup {
+		panic("limiter: duplicate script name " + name)
+	}
+	s := redis.NewScript(src)
+	scriptRegistry[name] = Script{Name: name, Source: src, SHA: s.Hash()}
+	return s
+}
+
+// Scripts returns every Lua script the limiter may run in Redis, sorted by
+// name, e.g. for operators who load them with their own tooling.
+func Scripts() []Script {
+	out := make([]Script, 0, len(scriptRegistry))
+	for _, s := range scriptRegistry {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// SlidingScriptSHA returns the SHA1 the sliding-window script is run by.
+func SlidingScriptSHA() string { return slidingScript.Hash() }
+
+// LeakyScriptSHA returns the SHA1 the leaky-bucket script is run by.
+func LeakyScriptSHA() string { return leakyScript.Hash() }
+
+// PreloadScripts loads every script (see Scripts) into Redis with SCRIPT
+// LOAD, e.g. during a deploy, so first requests do not pay for a NOSCRIPT
+// reply and a resend of the source. Scripts are always run by SHA first, so
+// preloading changes no behavior; after a SCRIPT FLUSH or a failover to a
+// replica without them, scripts are loaded again on first use either way.
+// It requires InitRedis.
+func PreloadScripts(ctx context.Context) error {
+	if rdb == nil {
+		return fmt.Errorf("preload scripts: %w: redis not initialized", ErrBackendUnavailable)
+	}
+	for _, s := range Scripts() {
+		sha, err := rdb.ScriptLoad(ctx, s.Source).Result()
+		if err != nil {
+			return fmt.Errorf("preload scripts: %s: %w", s.Name, err)
+		}
+		if sha != s.SHA {
+			return fmt.Errorf("preload scripts: %s: redis returned SHA %s, want %s", s.Name, sha, s.SHA)
+		}
+	}
+	return nil
+}
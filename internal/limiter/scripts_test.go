@@ -0,0 +1,27 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestScripts(t *testing.T) {
+	byName := map[string]Script{}
+	for _, s := range Scripts() {
+		byName[s.Name] = s
+	}
+	if byName["sliding"].SHA != SlidingScriptSHA() || byName["leaky"].SHA != LeakyScriptSHA() {
+		t.Fatalf("accessors disagree with Scripts: %v", byName)
+	}
+	if byName["sliding"].Source == "" || len(SlidingScriptSHA()) != 40 {
+		t.Fatalf("unexpected sliding script %+v", byName["sliding"])
+	}
+}
+
+func TestPreloadScripts_RequiresRedis(t *testing.T) {
+	resetLimiterState()
+	if err := PreloadScripts(context.Background()); !errors.Is(err, ErrBackendUnavailable) {
+		t.Fatalf("expected ErrBackendUnavailable without Redis, got %v", err)
+	}
+}
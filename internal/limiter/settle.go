@@ -0,0 +1,156 @@
+package limiter
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Settle adjusts the cost of a request whose real cost is only known after
+// handling it (e.g. bytes returned). The request is assumed to have reserved
+// one unit up front through RateLimit or RateLimitRefundable; Settle charges
+// the difference when actualCost > 1 (extra timestamps in sliding mode,
+// drained tokens in leaky mode, a higher counter in daily mode) and gives the
+// reserved unit back when actualCost is 0. Charges may push usage past the
+// limit, which simply keeps the user blocked for longer.
+//
+// Settle works against the user's current state, not the original request:
+// if the window rolled (or the day ended, or the state expired) in between,
+// extra cost is charged to the current window and a refund returns the most
+// recent unit, or nothing if none remains. Users with multi-window rules
+// (SetUserLimits) are not supported.
+func Settle(userID string, actualCost int) error {
+	if actualCost < 0 {
+		return fmt.Errorf("settle: negative cost %d", actualCost)
+	}
+	userID = transformKey(userID)
+	if actualCost == 1 || IsDenyListed(userID) || IsAllowListed(userID) {
+		return nil
+	}
+	mode := algorithmFor(userID)
+	if mode == "rules" {
+		return fmt.Errorf("settle: user %q has multi-window rules", userID)
+	}
+	extra := actualCost - 1 // -1 refunds the reservation
+	if rdb != nil {
+		return settleRedis(userID, mode, extra)
+	}
+	settleMemory(userID, mode, extra)
+	return nil
+}
+
+func settleMemory(userID, mode string, extra int) {
+	switch mode {
+	case "leaky":
+		if extra < 0 {
+			refundMemoryLeaky(userID, 1)
+			return
+		}
+		st, ok := leakyBuckets.load(userID)
+		if !ok {
+			return
+		}
+		st.mtx.Lock()
+		defer st.mtx.Unlock()
+		st.refill(monoNowMs())
+		st.tokens -= float64(extra)
+	case "daily":
+		day := dayKey(timeNow())
+		if extra < 0 {
+			refundMemoryDaily(userID, day, 1)
+			return
+		}
+		st := dailyStates.loadOrStore(userID, func() *dailyState { return &dailyState{} })
+		st.mtx.Lock()
+		defer st.mtx.Unlock()
+		if st.day != day {
+			st.roll(day, EffectiveLimit(userID, 0))
+		}
+		st.count += extra
+	case decayMode:
+		adjustMemoryDecay(userID, float64(extra))
+	default:
+		st, ok := slidingStates.load(userID)
+		if !ok && extra < 0 {
+			return
+		}
+		if !ok {
+			st = slidingStates.loadOrStore(userID, newSlidingState)
+		}
+		st.mtx.Lock()
+		defer st.mtx.Unlock()
+		if extra < 0 {
+			if len(st.ts) > 0 {
+				st.ts = st.ts[:len(st.ts)-1]
+			}
+			return
+		}
+		now := monoNowMs()
+		for i := 0; i < extra; i++ {
+			st.ts = append(st.ts, now)
+		}
+	}
+}
+
+// settleSlidingScript records ARGV[1] extra requests at the server time.
+// KEYS[1] = key
+// ARGV[1] = count
+// ARGV[2] = member prefix (unique per call)
+// ARGV[3] = key expiry (ms)
+var settleSlidingScript = newScript("settle-sliding", luaNowMs+luaExpire+`
+	for i = 1, tonumber(ARGV[1]) do
+		redis.call("ZADD", KEYS[1], now, ARGV[2] .. ":" .. i)
+	end
+	pexpire(KEYS[1], ARGV[3])
+	return 0
+`)
+
+// settleLeakyScript adds ARGV[1] tokens (negative to charge) to an existing
+// bucket. A missing bucket has expired and is full, so it is left alone; an
+// over-full bucket is clamped to capacity by the next leakyScript call.
+var settleLeakyScript = newScript("settle-leaky", `
+	if redis.call("EXISTS", KEYS[1]) == 0 then
+		return 0
+	end
+	redis.call("HINCRBYFLOAT", KEYS[1], "tokens", ARGV[1])
+	return 1
+`)
+
+func settleRedis(userID, mode string, extra int) error {
+	switch mode {
+	case "leaky":
+		return settleLeakyScript.Run(ctx, rdb, []string{leakyKey(userID)},
+			strconv.Itoa(-extra),
+		).Err()
+	case "daily":
+		now := timeNow()
+		key := dailyKey(userID, now)
+		if extra < 0 {
+			return refundDailyScript.Run(ctx, rdb, []string{key}).Err()
+		}
+		_, end := dayBounds(now)
+		_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.IncrBy(ctx, key, int64(extra))
+			if !persistentKeys() {
+				pipe.PExpireAt(ctx, key, end)
+			}
+			return nil
+		})
+		return err
+	case compactMode:
+		return adjustRedisCompact(userID, extra)
+	case decayMode:
+		return adjustRedisDecay(userID, float64(extra))
+	default:
+		key := slidingKey(userID)
+		if extra < 0 {
+			return rdb.ZPopMax(ctx, key, 1).Err()
+		}
+		return settleSlidingScript.Run(ctx, rdb, []string{key},
+			strconv.Itoa(extra),
+			strconv.FormatInt(timeNow().UnixNano(), 10),
+			strconv.FormatInt(keyExpiry(GetUserWindow(userID)).Milliseconds(), 10),
+		).Err()
+	}
+}
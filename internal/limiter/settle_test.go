@@ -0,0 +1,76 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSettle_ChargesExtraCost(t *testing.T) {
+	for _, mode := range []string{"sliding", "leaky", "daily"} {
+		resetLimiterState()
+		SetMode(mode)
+
+		user := "settle-" + mode
+		if !RateLimit(user, 5) {
+			t.Fatalf("%s: reservation should be allowed", mode)
+		}
+		if err := Settle(user, 3); err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		// 1 reserved + 2 settled leaves 2 of 5
+		for i := 1; i <= 2; i++ {
+			if !RateLimit(user, 5) {
+				t.Fatalf("%s: request %d after settle should be allowed", mode, i)
+			}
+		}
+		if RateLimit(user, 5) {
+			t.Fatalf("%s: settled cost should have been charged", mode)
+		}
+	}
+}
+
+func TestSettle_ZeroCostRefundsReservation(t *testing.T) {
+	for _, mode := range []string{"sliding", "leaky", "daily"} {
+		resetLimiterState()
+		SetMode(mode)
+
+		user := "settle-free-" + mode
+		RateLimit(user, 1)
+		if err := Settle(user, 0); err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		if !RateLimit(user, 1) {
+			t.Fatalf("%s: zero-cost request should have been refunded", mode)
+		}
+	}
+}
+
+func TestSettle_CostBeyondLimitProlongsBlock(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	user := "settle-heavy"
+	SetUserWindow(user, 100*time.Millisecond)
+	RateLimit(user, 2)
+	time.Sleep(50 * time.Millisecond)
+	Settle(user, 4) // 3 extra, recorded 50ms after the reservation
+	time.Sleep(70 * time.Millisecond)
+	if RateLimit(user, 2) {
+		t.Fatal("extra cost recorded at settle time should still fill the window")
+	}
+}
+
+func TestSettle_Errors(t *testing.T) {
+	resetLimiterState()
+
+	if err := Settle("u", -1); err == nil {
+		t.Fatal("negative cost should be rejected")
+	}
+	SetUserLimits("ruled", []Rule{{Limit: 1, Window: time.Second}})
+	if err := Settle("ruled", 2); err == nil {
+		t.Fatal("multi-rule users should be rejected")
+	}
+	if err := Settle("never-seen", 0); err != nil {
+		t.Fatalf("refund without state should be a no-op, got %v", err)
+	}
+}
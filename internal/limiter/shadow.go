@@ -0,0 +1,193 @@
+package limiter
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// shadowState is one user's state under the shadow algorithm, kept apart from
+// the enforced state.
+type shadowState struct {
+	mtx sync.Mutex
+	ts  []int64 // sliding: admitted request times (ms) within the window
+
+	tokens     float64 // leaky: tokens in the bucket
+	lastMillis int64   // leaky: last refill time (ms)
+	primed     bool    // leaky: the bucket has been filled
+
+	day   string // daily: the day count refers to
+	count int    // daily: requests admitted on day
+
+	score float64 // decay: decayed request count as of lastMillis
+}
+
+var (
+	shadowMode   atomic.Pointer[string]
+	shadowStates = sync.Map{} // map[string]*shadowState
+
+	shadowAgreed      atomic.Int64
+	shadowOnlyAllowed atomic.Int64
+	shadowOnlyDenied  atomic.Int64
+	onShadowDiverge   atomic.Pointer[func(userID string, enforced, shadow bool)]
+)
+
+// ShadowStats counts shadow decisions since SetShadowMode was last called.
+type ShadowStats struct {
+	Agreed      int64 `json:"agreed"`       // shadow decided as the enforced algorithm did
+	ShadowAllow int64 `json:"shadow_allow"` // enforced denied, shadow would have allowed
+	ShadowDeny  int64 `json:"shadow_deny"`  // enforced allowed, shadow would have denied
+}
+
+// SetShadowMode runs mode ("sliding", "leaky", "daily" or "decay") as a shadow next to
+// the enforced algorithm, to compare algorithms on real traffic: every request
+// that reaches an algorithm is also decided by the shadow, with the same
+// limit, and the two decisions are counted (GetShadowStats) and reported to
+// the OnShadowDivergence callback when they differ. The shadow never affects
+// the decision returned.
+//
+// The shadow keeps its own in-memory state, separate from the enforced state,
+// as if it had been enforced all along: it counts only requests it would have
+// admitted. With Redis the shadow still runs in memory, so each instance
+// shadows only its own share of the traffic. Its state grows with the number
+// of users seen and is not evicted by SetMaxUsers; an empty or unknown mode
+// stops shadowing and discards the state. Calling it resets the counts.
+func SetShadowMode(mode string) {
+	shadowStates.Clear()
+	shadowAgreed.Store(0)
+	shadowOnlyAllowed.Store(0)
+	shadowOnlyDenied.Store(0)
+	if !validMode(mode) {
+		shadowMode.Store(nil)
+		return
+	}
+	shadowMode.Store(&mode)
+}
+
+// GetShadowMode returns the shadow algorithm, or "" when none runs.
+func GetShadowMode() string {
+	if m := shadowMode.Load(); m != nil {
+		return *m
+	}
+	return ""
+}
+
+// GetShadowStats returns how often the shadow agreed with the enforced
+// algorithm, and how it diverged.
+func GetShadowStats() ShadowStats {
+	return ShadowStats{
+		Agreed:      shadowAgreed.Load(),
+		ShadowAllow: shadowOnlyAllowed.Load(),
+		ShadowDeny:  shadowOnlyDenied.Load(),
+	}
+}
+
+// OnShadowDivergence registers fn to be called whenever the shadow decides a
+// request differently from the enforced algorithm, with both decisions. It
+// runs synchronously on the request path, so it should be fast. nil removes
+// the callback.
+func OnShadowDivergence(fn func(userID string, enforced, shadow bool)) {
+	if fn == nil {
+		onShadowDiverge.Store(nil)
+		return
+	}
+	onShadowDiverge.Store(&fn)
+}
+
+// shadowDecide runs the shadow algorithm, if any, on a request the enforced
+// algorithm decided (allowed), and records how the two compare.
+func shadowDecide(userID string, limit int, allowed bool, nowMs int64, at time.Time) {
+	m := shadowMode.Load()
+	if m == nil {
+		return
+	}
+	v, _ := shadowStates.LoadOrStore(userID, &shadowState{})
+	st := v.(*shadowState)
+	st.mtx.Lock()
+	var shadow bool
+	switch *m {
+	case "leaky":
+		shadow = st.leaky(userID, limit, nowMs)
+	case "daily":
+		shadow = st.daily(limit, dayKey(clockAt(at)))
+	case decayMode:
+		shadow = st.decay(limit, nowMs, decayHalfLife(userID))
+	default:
+		shadow = st.sliding(limit, nowMs, GetUserWindow(userID))
+	}
+	st.mtx.Unlock()
+
+	switch {
+	case shadow == allowed:
+		shadowAgreed.Add(1)
+		return
+	case shadow:
+		shadowOnlyAllowed.Add(1)
+	default:
+		shadowOnlyDenied.Add(1)
+	}
+	if fn := onShadowDiverge.Load(); fn != nil {
+		(*fn)(userID, allowed, shadow)
+	}
+}
+
+// sliding admits a request if fewer than limit were admitted within window.
+// Caller holds st.mtx.
+func (st *shadowState) sliding(limit int, now int64, window time.Duration) bool {
+	cutoff := now - window.Milliseconds()
+	expired := 0
+	for expired < len(st.ts) && st.ts[expired] <= cutoff {
+		expired++
+	}
+	st.ts = st.ts[:copy(st.ts, st.ts[expired:])]
+	if len(st.ts) >= limit {
+		return false
+	}
+	st.ts = insertTimestamp(st.ts, now)
+	return true
+}
+
+// leaky takes a token from a bucket sized and refilled as the enforced
+// bucket would be. Caller holds st.mtx.
+func (st *shadowState) leaky(userID string, limit int, now int64) bool {
+	capacity, ratePerMs := leakyParams(userID, limit, GetUserWindow(userID))
+	if !st.primed {
+		st.tokens, st.lastMillis, st.primed = capacity, now, true
+	}
+	if now > st.lastMillis {
+		st.tokens = min(st.tokens+float64(now-st.lastMillis)*ratePerMs, capacity)
+		st.lastMillis = now
+	}
+	if st.tokens < 1 {
+		return false
+	}
+	st.tokens--
+	return true
+}
+
+// daily admits a request if fewer than limit were admitted on day. Caller
+// holds st.mtx.
+func (st *shadowState) daily(limit int, day string) bool {
+	if st.day != day {
+		st.day, st.count = day, 0
+	}
+	if st.count >= limit {
+		return false
+	}
+	st.count++
+	return true
+}
+
+// decay admits a request if the decayed score is below limit. Caller holds
+// st.mtx.
+func (st *shadowState) decay(limit int, now int64, halfLife time.Duration) bool {
+	if now > st.lastMillis {
+		st.score = decayed(st.score, now-st.lastMillis, halfLife)
+		st.lastMillis = now
+	}
+	if st.score >= float64(limit) {
+		return false
+	}
+	st.score++
+	return true
+}
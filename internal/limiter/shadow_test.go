@@ -0,0 +1,73 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShadowMode_RecordsDivergence(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	SetShadowMode("leaky")
+	SetUserWindow("shadowed", 100*time.Millisecond)
+
+	type divergence struct{ enforced, shadow bool }
+	var seen []divergence
+	OnShadowDivergence(func(userID string, enforced, shadow bool) {
+		seen = append(seen, divergence{enforced, shadow})
+	})
+
+	// both algorithms admit a fresh user's first 5 and deny the rest
+	if got := countAllowed("shadowed", 5, 8); got != 5 {
+		t.Fatalf("expected 5 allowed, got %d", got)
+	}
+	if st := GetShadowStats(); st.Agreed != 8 || len(seen) != 0 {
+		t.Fatalf("expected 8 agreements and no divergence, got %+v, %v", st, seen)
+	}
+
+	// after half a window the bucket has leaked back tokens; the window has not
+	time.Sleep(60 * time.Millisecond)
+	if RateLimit("shadowed", 5) {
+		t.Fatal("the enforced sliding window is still full")
+	}
+	st := GetShadowStats()
+	if st.ShadowAllow != 1 || len(seen) != 1 || seen[0] != (divergence{false, true}) {
+		t.Fatalf("expected the shadow to report it would have allowed, got %+v, %v", st, seen)
+	}
+}
+
+func TestShadowMode_DoesNotAffectEnforcedDecision(t *testing.T) {
+	resetLimiterState()
+	SetMode("leaky")
+
+	run := func(user string) []bool {
+		var got []bool
+		for i := 0; i < 12; i++ {
+			got = append(got, RateLimit(user, 4))
+		}
+		return got
+	}
+	want := run("unshadowed")
+
+	SetShadowMode("sliding")
+	if got := run("shadowed"); len(got) != len(want) {
+		t.Fatal("unexpected decision count")
+	} else {
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("decision %d changed under a shadow: got %v, want %v", i+1, got, want)
+			}
+		}
+	}
+	if _, ok := slidingStates.load("shadowed"); ok {
+		t.Fatal("the shadow must not touch the enforced algorithms' state")
+	}
+	if st := GetShadowStats(); st.Agreed+st.ShadowAllow+st.ShadowDeny != 12 {
+		t.Fatalf("expected every request shadowed, got %+v", st)
+	}
+
+	SetShadowMode("")
+	if GetShadowMode() != "" || GetShadowStats() != (ShadowStats{}) {
+		t.Fatal("an empty mode stops shadowing and resets the counts")
+	}
+}
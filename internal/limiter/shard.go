@@ -0,0 +1,126 @@
+package limiter
+
+import "sync"
+
+// defaultShardCount is the number of shards used by the in-memory state maps.
+const defaultShardCount = 64
+
+// shardedMap is an N-way sharded map. Each key hashes to one shard guarded by
+// its own RWMutex, so unrelated users rarely contend on the same lock.
+type shardedMap[V any] struct {
+	shards []mapShard[V]
+}
+
+type mapShard[V any] struct {
+	mu sync.RWMutex
+	m  map[string]V
+}
+
+func newShardedMap[V any](n int) *shardedMap[V] {
+	if n <= 0 {
+		n = defaultShardCount
+	}
+	s := &shardedMap[V]{shards: make([]mapShard[V], n)}
+	for i := range s.shards {
+		s.shards[i].m = make(map[string]V)
+	}
+	return s
+}
+
+// shard returns the shard owning key (FNV-1a, inlined to avoid allocations).
+func (s *shardedMap[V]) shard(key string) *mapShard[V] {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return &s.shards[h%uint32(len(s.shards))]
+}
+
+func (s *shardedMap[V]) load(key string) (V, bool) {
+	sh := s.shard(key)
+	sh.mu.RLock()
+	v, ok := sh.m[key]
+	sh.mu.RUnlock()
+	return v, ok
+}
+
+// loadOrStore returns the value for key, storing newFn() if absent.
+// newFn is only called when the key is missing.
+func (s *shardedMap[V]) loadOrStore(key string, newFn func() V) V {
+	sh := s.shard(key)
+	sh.mu.RLock()
+	v, ok := sh.m[key]
+	sh.mu.RUnlock()
+	if ok {
+		return v
+	}
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if v, ok := sh.m[key]; ok {
+		return v
+	}
+	v = newFn()
+	sh.m[key] = v
+	return v
+}
+
+func (s *shardedMap[V]) delete(key string) {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	delete(sh.m, key)
+	sh.mu.Unlock()
+}
+
+// clear removes every entry, locking one shard at a time.
+func (s *shardedMap[V]) clear() {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		clear(sh.m)
+		sh.mu.Unlock()
+	}
+}
+
+// deleteMatching removes every entry whose key satisfies match, locking one
+// shard at a time.
+func (s *shardedMap[V]) deleteMatching(match func(key string) bool) {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		for k := range sh.m {
+			if match(k) {
+				delete(sh.m, k)
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// rangeAll calls fn for every entry until fn returns false.
+// Each shard is read-locked only while it is being visited.
+func (s *shardedMap[V]) rangeAll(fn func(key string, v V) bool) {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		for k, v := range sh.m {
+			if !fn(k, v) {
+				sh.mu.RUnlock()
+				return
+			}
+		}
+		sh.mu.RUnlock()
+	}
+}
+
+// SetShardCount rebuilds the in-memory state maps with n shards (default 64).
+// Existing in-memory state is discarded, so call it during setup, before
+// serving traffic.
+func SetShardCount(n int) {
+	slidingStates = newShardedMap[*slidingState](n)
+	leakyBuckets = newShardedMap[*leakyState](n)
+	dailyStates = newShardedMap[*dailyState](n)
+	ruleStates = newShardedMap[*rulesState](n)
+	decayStates = newShardedMap[*decayState](n)
+}
@@ -0,0 +1,128 @@
+package limiter
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// snapshotVersion is bumped when the snapshot format changes incompatibly.
+const snapshotVersion = 1
+
+// snapshot is the serialized form of the in-memory limiter state.
+type snapshot struct {
+	Version int                      `json:"version"`
+	Sliding map[string][]int64       `json:"sliding"` // user -> timestamps (ms)
+	Leaky   map[string]leakySnapshot `json:"leaky"`
+	Daily   map[string]dailySnapshot `json:"daily"`
+}
+
+type leakySnapshot struct {
+	Tokens    float64 `json:"tokens"`
+	LastMs    int64   `json:"last_ms"`
+	Capacity  float64 `json:"capacity"`
+	RatePerMs float64 `json:"rate_per_ms"`
+}
+
+type dailySnapshot struct {
+	Day    string `json:"day"`
+	Count  int    `json:"count"`
+	Credit int    `json:"credit,omitempty"`
+}
+
+// Snapshot serializes the in-memory sliding windows, leaky buckets and daily
+// counters so they can be reloaded with Restore after a restart. It is
+// best-effort and meant for graceful shutdown: requests served after the
+// snapshot is taken are lost, and Redis-backed state (which already survives
+// restarts) is not included.
+func Snapshot() ([]byte, error) {
+	snap := snapshot{
+		Version: snapshotVersion,
+		Sliding: map[string][]int64{},
+		Leaky:   map[string]leakySnapshot{},
+		Daily:   map[string]dailySnapshot{},
+	}
+	slidingStates.rangeAll(func(user string, st *slidingState) bool {
+		st.mtx.Lock()
+		if len(st.ts) > 0 {
+			snap.Sliding[user] = append([]int64(nil), st.ts...)
+		}
+		st.mtx.Unlock()
+		return true
+	})
+	leakyBuckets.rangeAll(func(user string, st *leakyState) bool {
+		st.mtx.Lock()
+		snap.Leaky[user] = leakySnapshot{
+			Tokens:    st.tokens,
+			LastMs:    st.lastMillis,
+			Capacity:  st.capacity,
+			RatePerMs: st.ratePerMs,
+		}
+		st.mtx.Unlock()
+		return true
+	})
+	dailyStates.rangeAll(func(user string, st *dailyState) bool {
+		st.mtx.Lock()
+		snap.Daily[user] = dailySnapshot{Day: st.day, Count: st.count, Credit: st.credit}
+		st.mtx.Unlock()
+		return true
+	})
+	return json.Marshal(snap)
+}
+
+// Restore loads state produced by Snapshot, replacing any in-memory state of
+// the users it contains. Sliding timestamps that have left the user's window
+// are dropped, leaky buckets refill for the time the process was down, and
+// daily counters from a previous day are ignored.
+func Restore(data []byte) error {
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("restore: unsupported snapshot version %d", snap.Version)
+	}
+
+	now := monoNowMs()
+	epoch := modeEpoch.Load()
+	for user, ts := range snap.Sliding {
+		cutoff := now - GetUserWindow(user).Milliseconds()
+		live := make([]int64, 0, len(ts))
+		for _, t := range ts {
+			if t > cutoff {
+				live = append(live, t)
+			}
+		}
+		if len(live) == 0 {
+			continue
+		}
+		touchUser(user)
+		st := slidingStates.loadOrStore(user, newSlidingState)
+		st.mtx.Lock()
+		st.ts = live
+		st.epoch.Store(epoch)
+		st.mtx.Unlock()
+	}
+	for user, ls := range snap.Leaky {
+		touchUser(user)
+		st := leakyBuckets.loadOrStore(user, func() *leakyState { return &leakyState{} })
+		st.mtx.Lock()
+		st.tokens, st.lastMillis = ls.Tokens, ls.LastMs
+		st.capacity, st.ratePerMs = ls.Capacity, ls.RatePerMs
+		st.reservations = nil
+		st.refill(now)
+		st.epoch.Store(epoch)
+		st.mtx.Unlock()
+	}
+	today := dayKey(timeNow())
+	for user, ds := range snap.Daily {
+		if ds.Day != today {
+			continue
+		}
+		touchUser(user)
+		st := dailyStates.loadOrStore(user, func() *dailyState { return &dailyState{} })
+		st.mtx.Lock()
+		st.day, st.count, st.credit = ds.Day, ds.Count, ds.Credit
+		st.mtx.Unlock()
+	}
+	return nil
+}
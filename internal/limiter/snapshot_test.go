@@ -0,0 +1,68 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSnapshotRestore_KeepsUserThrottled(t *testing.T) {
+	for _, mode := range []string{"sliding", "leaky", "daily"} {
+		resetLimiterState()
+		SetMode(mode)
+
+		user := "snap-" + mode
+		for i := 0; i < 3; i++ {
+			RateLimit(user, 3)
+		}
+		if RateLimit(user, 3) {
+			t.Fatalf("%s: user should be exhausted before the snapshot", mode)
+		}
+		data, err := Snapshot()
+		if err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+
+		// a fresh process
+		resetLimiterState()
+		SetMode(mode)
+		if err := Restore(data); err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		if RateLimit(user, 3) {
+			t.Fatalf("%s: restored user should still be throttled", mode)
+		}
+	}
+}
+
+func TestRestore_PrunesStaleTimestamps(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	user := "snap-stale"
+	SetUserWindow(user, 50*time.Millisecond)
+	RateLimit(user, 1)
+	data, _ := Snapshot()
+
+	time.Sleep(70 * time.Millisecond)
+	slidingStates.delete(user)
+	if err := Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := slidingStates.load(user); ok {
+		t.Fatal("timestamps outside the window should not be restored")
+	}
+	if !RateLimit(user, 1) {
+		t.Fatal("user whose window expired during the restart should be allowed")
+	}
+}
+
+func TestRestore_RejectsBadInput(t *testing.T) {
+	resetLimiterState()
+
+	if err := Restore([]byte("not json")); err == nil {
+		t.Fatal("invalid JSON should be rejected")
+	}
+	if err := Restore([]byte(`{"version":99}`)); err == nil {
+		t.Fatal("unknown version should be rejected")
+	}
+}
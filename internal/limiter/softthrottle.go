@@ -0,0 +1,71 @@
+package limiter
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// softThreshold holds the threshold fraction as float64 bits; 0 disables early throttling.
+var softThreshold atomic.Uint64
+
+// SetSoftThreshold enables probabilistic early throttling, shedding load
+// before users hit the cliff edge of their limit (as RED does for queues).
+// Once a user's usage passes fraction*limit, requests are denied at random
+// with a probability that rises linearly from 0 at the threshold to 1 at the
+// limit. Early denials do not count towards penalties. Multi-window rules are
+// not affected. fraction <= 0 or >= 1 disables early throttling (the default).
+func SetSoftThreshold(fraction float64) {
+	if !(fraction > 0 && fraction < 1) {
+		fraction = 0
+	}
+	softThreshold.Store(math.Float64bits(fraction))
+}
+
+// GetSoftThreshold returns the early throttling threshold (0 if disabled).
+func GetSoftThreshold() float64 {
+	return math.Float64frombits(softThreshold.Load())
+}
+
+// SetSoftThrottleSeed reseeds the random source behind early throttling, so
+// tests can reproduce a sequence of decisions. It is SetDeterministic, which
+// seeds the package's other randomized features too.
+func SetSoftThrottleSeed(seed int64) {
+	SetDeterministic(seed)
+}
+
+// shedEarly reports whether a request should be denied before reaching the
+// algorithm, given the user's current usage of limit.
+func shedEarly(userID, mode string, limit int) bool {
+	fraction := GetSoftThreshold()
+	if fraction == 0 || mode == "rules" {
+		return false
+	}
+	p := softDenyProbability(peekUsed(userID, mode, limit), limit, fraction)
+	if p == 0 {
+		return false
+	}
+	return randFloat64() < p
+}
+
+// softDenyProbability ramps linearly from 0 at fraction*limit to 1 at limit.
+func softDenyProbability(used, limit int, fraction float64) float64 {
+	soft := fraction * float64(limit)
+	if float64(used) <= soft {
+		return 0
+	}
+	return math.Min(1, (float64(used)-soft)/(float64(limit)-soft))
+}
+
+// peekUsed returns how much of limit the user has used, without consuming anything.
+func peekUsed(userID, mode string, limit int) int {
+	window := GetUserWindow(userID)
+	switch mode {
+	case "leaky":
+		return limit - int(math.Floor(peekLeakyTokens(userID, limit, window)))
+	case "daily":
+		return peekDailyCount(userID)
+	case decayMode:
+		return int(math.Floor(peekDecayScore(userID)))
+	}
+	return peekSlidingCount(userID, window)
+}
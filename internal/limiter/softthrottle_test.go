@@ -0,0 +1,78 @@
+package limiter
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSoftThreshold_RejectionRisesWithUsage(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	SetSoftThrottleSeed(1)
+
+	limit := 100
+	trials := 4000
+	prev := -1.0
+	for _, used := range []int{40, 60, 75, 90} {
+		user := "soft-" + strconv.Itoa(used)
+		for i := 0; i < used; i++ {
+			RateLimit(user, limit)
+		}
+
+		SetSoftThreshold(0.5)
+		denied := 0
+		for i := 0; i < trials; i++ {
+			if shedEarly(user, "sliding", limit) {
+				denied++
+			}
+		}
+		SetSoftThreshold(0)
+
+		rate := float64(denied) / float64(trials)
+		want := softDenyProbability(used, limit, 0.5)
+		if rate < want-0.03 || rate > want+0.03 {
+			t.Fatalf("usage %d: rejection rate %.3f, want ~%.2f", used, rate, want)
+		}
+		if rate < prev || (want > 0 && rate <= prev) {
+			t.Fatalf("usage %d: rejection rate %.3f should rise above %.3f", used, rate, prev)
+		}
+		prev = rate
+	}
+}
+
+func TestSoftThreshold_ShedsBeforeHardLimit(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	SetUserWindow("soft-user", time.Minute)
+	SetSoftThreshold(0.8)
+	defer SetSoftThreshold(0)
+	SetSoftThrottleSeed(42)
+
+	limit := 100
+	allowed := 0
+	for i := 0; i < limit; i++ {
+		if RateLimit("soft-user", limit) {
+			allowed++
+		} else if i < 80 {
+			t.Fatalf("request %d denied below the soft threshold", i+1)
+		}
+	}
+	if allowed < 80 || allowed == limit {
+		t.Fatalf("expected early shedding between the threshold and the limit, allowed %d", allowed)
+	}
+}
+
+func TestSoftThreshold_Disabled(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	SetSoftThreshold(1.5)
+	if GetSoftThreshold() != 0 {
+		t.Fatal("a threshold >= 1 should disable early throttling")
+	}
+	for i := 0; i < 10; i++ {
+		if !RateLimit("soft-off", 10) {
+			t.Fatalf("request %d should be allowed without early throttling", i+1)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package limiter
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// UserStats is a read-only view of a user's current usage.
+type UserStats struct {
+	User      string `json:"user"`
+	Limit     int    `json:"limit"`
+	Mode      string `json:"mode"`
+	WindowMs  int64  `json:"window_ms"`
+	Used      int    `json:"used"`
+	Remaining int    `json:"remaining"`
+}
+
+// Stats reports the user's current usage without consuming anything.
+// The limit is resolved as in RateLimit (configured limit, else fallback).
+func Stats(userID string, fallback int) UserStats {
+	return stats(transformKey(userID), fallback)
+}
+
+// stats is Stats for a key already transformed (see SetKeyTransformer).
+func stats(userID string, fallback int) UserStats {
+	limit := EffectiveLimit(userID, fallback)
+	mode := GetUserMode(userID)
+	window := GetUserWindow(userID)
+
+	var used int
+	switch mode {
+	case "leaky":
+		tokens := peekLeakyTokens(userID, limit, window)
+		used = limit - int(math.Floor(tokens))
+	case "daily":
+		used = peekDailyCount(userID)
+		limit += bankedCredit(userID)
+		start, end := dayBounds(timeNow())
+		window = end.Sub(start)
+	case decayMode:
+		used = int(math.Floor(peekDecayScore(userID)))
+		window = decayHalfLife(userID)
+	default:
+		used = peekSlidingCount(userID, window)
+	}
+	if used < 0 {
+		used = 0
+	}
+	remaining := limit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	return UserStats{
+		User:      userID,
+		Limit:     limit,
+		Mode:      mode,
+		WindowMs:  window.Milliseconds(),
+		Used:      used,
+		Remaining: remaining,
+	}
+}
+
+// TimeUntilReset returns how long until the user's next request could be
+// allowed, without consuming anything: zero while under their limit; for a
+// full sliding window, until enough in-window requests expire (the oldest one,
+// at exactly the limit); for leaky, until the next whole token accrues; for
+// decay, until the score decays below the limit; for daily, until the day
+// ends. The limit is resolved as in RateLimit with no fallback. Multi-window
+// rules are not covered; AllowResult reports their reset when it denies a
+// request.
+func TimeUntilReset(userID string) time.Duration {
+	userID = transformKey(userID)
+	return timeUntilAvailable(userID, EffectiveLimit(userID, 0))
+}
+
+// timeUntilDrained returns how long until the user's usage drops back to zero:
+// the newest request leaves the window (sliding), the bucket refills
+// completely (leaky) or the day ends (daily).
+func timeUntilDrained(userID, mode string, limit int, window time.Duration, used int) time.Duration {
+	if used == 0 || limit <= 0 {
+		return 0
+	}
+	switch mode {
+	case "leaky":
+		capacity, ratePerMs := leakyParams(userID, limit, window)
+		missing := capacity - peekLeakyTokens(userID, limit, window)
+		return time.Duration(math.Ceil(missing/ratePerMs)) * time.Millisecond
+	case "daily":
+		now := timeNow()
+		_, end := dayBounds(now)
+		return end.Sub(now)
+	case decayMode:
+		return decayTimeUntilBelow(userID, 1)
+	default:
+		if compactRedis(userID) {
+			return compactTimeUntil(userID, 1, window)
+		}
+		wait := newestSlidingTimestamp(userID) + window.Milliseconds() - stateNowMs()
+		if wait < 0 {
+			return 0
+		}
+		return time.Duration(wait) * time.Millisecond
+	}
+}
+
+// newestSlidingTimestamp returns the user's most recent recorded request (ms),
+// or 0 if there is none.
+func newestSlidingTimestamp(userID string) int64 {
+	if rdb != nil {
+		zs, err := rdb.ZRevRangeWithScores(ctx, slidingKey(userID), 0, 0).Result()
+		if err != nil || len(zs) == 0 {
+			return 0
+		}
+		return int64(zs[0].Score)
+	}
+	st, ok := slidingStates.load(userID)
+	if !ok {
+		return 0
+	}
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	if len(st.ts) == 0 {
+		return 0
+	}
+	return st.ts[len(st.ts)-1]
+}
+
+// peekSlidingCount returns how many requests are in the user's current window.
+func peekSlidingCount(userID string, window time.Duration) int {
+	if compactRedis(userID) {
+		return compactCount(userID, window)
+	}
+	windowStartMs := stateNowMs() - window.Milliseconds()
+	if rdb != nil {
+		n, err := rdb.ZCount(ctx, slidingKey(userID), "("+strconv.FormatInt(windowStartMs, 10), "+inf").Result()
+		if err != nil {
+			return 0
+		}
+		return int(n)
+	}
+
+	st, ok := slidingStates.load(userID)
+	if !ok {
+		return 0
+	}
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	n := 0
+	for _, ts := range st.ts {
+		if ts > windowStartMs {
+			n++
+		}
+	}
+	return n
+}
+
+// peekLeakyTokens returns the tokens currently available to the user,
+// including refill since the last update, without writing anything back.
+func peekLeakyTokens(userID string, limit int, window time.Duration) float64 {
+	now := stateNowMs()
+	capacity, ratePerMs := leakyParams(userID, limit, window)
+	if rdb != nil {
+		vals, err := rdb.HMGet(ctx, leakyKey(userID), "tokens", "last").Result()
+		if err != nil || vals[0] == nil || vals[1] == nil {
+			return capacity
+		}
+		tokens, _ := strconv.ParseFloat(vals[0].(string), 64)
+		last, _ := strconv.ParseFloat(vals[1].(string), 64)
+		return math.Min(capacity, tokens+math.Max(0, float64(now)-last)*ratePerMs)
+	}
+
+	st, ok := leakyBuckets.load(userID)
+	if !ok {
+		return capacity
+	}
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	elapsed := math.Max(0, float64(now-st.lastMillis))
+	return math.Min(st.capacity, st.tokens+elapsed*st.ratePerMs)
+}
+
+// InspectLeaky returns userID's leaky bucket as last stored, without
+// consuming anything or writing back: the tokens it held at its last update,
+// the time of that update, and the capacity the user's current configuration
+// gives it. Refill since last is not added, so an operator can check it
+// against the configured rate. With Redis it reads the "bucket:<user>" hash
+// in one HGETALL, otherwise the in-memory bucket. A user with no bucket yet
+// reports a full one and a zero last.
+func InspectLeaky(userID string) (tokens float64, last time.Time, capacity float64, err error) {
+	userID = transformKey(userID)
+	capacity, _ = leakyParams(userID, EffectiveLimit(userID, 0), GetUserWindow(userID))
+	if rdb == nil {
+		st, ok := leakyBuckets.load(userID)
+		if !ok {
+			return capacity, time.Time{}, capacity, nil
+		}
+		st.mtx.Lock()
+		defer st.mtx.Unlock()
+		return st.tokens, time.UnixMilli(st.lastMillis), capacity, nil
+	}
+	vals, err := rdb.HGetAll(ctx, leakyKey(userID)).Result()
+	if err != nil {
+		return 0, time.Time{}, capacity, err
+	}
+	if len(vals) == 0 {
+		return capacity, time.Time{}, capacity, nil
+	}
+	tokens, err = strconv.ParseFloat(vals["tokens"], 64)
+	if err != nil {
+		return 0, time.Time{}, capacity, fmt.Errorf("limiter: bucket tokens: %w", err)
+	}
+	lastMs, err := strconv.ParseFloat(vals["last"], 64)
+	if err != nil {
+		return 0, time.Time{}, capacity, fmt.Errorf("limiter: bucket last update: %w", err)
+	}
+	return tokens, time.UnixMilli(int64(lastMs)), capacity, nil
+}
+
+// timeUntilAvailable returns how long until the user could next be allowed
+// under limit (0 if a request would be allowed now). Nothing is consumed.
+func timeUntilAvailable(userID string, limit int) time.Duration {
+	if limit <= 0 {
+		return 0
+	}
+	window := GetUserWindow(userID)
+	switch GetUserMode(userID) {
+	case "leaky":
+		tokens := peekLeakyTokens(userID, limit, window)
+		if tokens >= 1 {
+			return 0
+		}
+		_, ratePerMs := leakyParams(userID, limit, window)
+		return time.Duration(math.Ceil((1-tokens)/ratePerMs)) * time.Millisecond
+	case "daily":
+		if peekDailyCount(userID) < limit+bankedCredit(userID) {
+			return 0
+		}
+		now := timeNow()
+		_, end := dayBounds(now)
+		return end.Sub(now)
+	case decayMode:
+		return decayTimeUntilBelow(userID, float64(limit))
+	default:
+		return slidingTimeUntilAvailable(userID, limit, window)
+	}
+}
+
+// slidingTimeUntilAvailable returns when enough in-window timestamps will have
+// expired for the count to drop below limit.
+func slidingTimeUntilAvailable(userID string, limit int, window time.Duration) time.Duration {
+	if compactRedis(userID) {
+		return compactTimeUntil(userID, limit, window)
+	}
+	nowMs := stateNowMs()
+	windowStartMs := nowMs - window.Milliseconds()
+
+	var pivotMs int64 // timestamp whose expiry frees a slot
+	if rdb != nil {
+		count := peekSlidingCount(userID, window)
+		if count < limit {
+			return 0
+		}
+		zs, err := rdb.ZRangeByScoreWithScores(ctx, slidingKey(userID), &redis.ZRangeBy{
+			Min:    "(" + strconv.FormatInt(windowStartMs, 10),
+			Max:    "+inf",
+			Offset: int64(count - limit),
+			Count:  1,
+		}).Result()
+		if err != nil || len(zs) == 0 {
+			return 0
+		}
+		pivotMs = int64(zs[0].Score)
+	} else {
+		st, ok := slidingStates.load(userID)
+		if !ok {
+			return 0
+		}
+		st.mtx.Lock()
+		live := 0
+		for _, ts := range st.ts {
+			if ts > windowStartMs {
+				live++
+			}
+		}
+		if live < limit {
+			st.mtx.Unlock()
+			return 0
+		}
+		pivotMs = st.ts[len(st.ts)-live+(live-limit)]
+		st.mtx.Unlock()
+	}
+
+	wait := pivotMs + window.Milliseconds() - nowMs
+	if wait < 0 {
+		return 0
+	}
+	return time.Duration(wait) * time.Millisecond
+}
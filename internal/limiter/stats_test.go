@@ -0,0 +1,80 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeUntilReset_Sliding(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	SetUserLimit("reset-user", 2)
+	SetUserWindow("reset-user", 200*time.Millisecond)
+
+	RateLimit("reset-user", 100)
+	if d := TimeUntilReset("reset-user"); d != 0 {
+		t.Fatalf("under the limit the reset should be 0, got %v", d)
+	}
+	start := time.Now()
+	RateLimit("reset-user", 100)
+
+	first := TimeUntilReset("reset-user")
+	if first <= 0 || first > 200*time.Millisecond {
+		t.Fatalf("a full window should reset within 200ms, got %v", first)
+	}
+	time.Sleep(50 * time.Millisecond)
+	second := TimeUntilReset("reset-user")
+	if second >= first || second <= 0 {
+		t.Fatalf("the reset should shrink as time passes: %v then %v", first, second)
+	}
+	if RateLimit("reset-user", 100) {
+		t.Fatal("the window is still full")
+	}
+
+	time.Sleep(second + 10*time.Millisecond)
+	if d := TimeUntilReset("reset-user"); d != 0 {
+		t.Fatalf("the window cleared after %v, but reset is %v", time.Since(start), d)
+	}
+	if !RateLimit("reset-user", 100) {
+		t.Fatal("a request should be allowed once the reset reaches zero")
+	}
+}
+
+func TestTimeUntilReset_LeakyNextToken(t *testing.T) {
+	resetLimiterState()
+	SetMode("leaky")
+	defer SetMode("sliding")
+	SetUserLimit("reset-leaky", 2)
+	SetUserWindow("reset-leaky", 400*time.Millisecond) // a token every 200ms
+
+	RateLimit("reset-leaky", 100)
+	RateLimit("reset-leaky", 100)
+	d := TimeUntilReset("reset-leaky")
+	if d <= 0 || d > 200*time.Millisecond {
+		t.Fatalf("expected the next token within 200ms, got %v", d)
+	}
+	time.Sleep(d + 10*time.Millisecond)
+	if d := TimeUntilReset("reset-leaky"); d != 0 {
+		t.Fatalf("a whole token should have accrued, got %v", d)
+	}
+}
+
+func TestInspectLeaky_Memory(t *testing.T) {
+	resetLimiterState()
+	SetUserMode("alice", "leaky")
+	SetUserLimit("alice", 5)
+	SetUserWindow("alice", 10*time.Second)
+
+	if tokens, last, capacity, err := InspectLeaky("alice"); err != nil || tokens != 5 || !last.IsZero() || capacity != 5 {
+		t.Fatalf("a new bucket should be full: %v, %v, %v, %v", tokens, last, capacity, err)
+	}
+	RateLimit("alice", 5)
+	RateLimit("alice", 5)
+	tokens, last, _, err := InspectLeaky("alice")
+	if err != nil || tokens < 3 || tokens > 3.01 {
+		t.Fatalf("expected ~3 tokens after two requests, got %v (%v)", tokens, err)
+	}
+	if d := time.Since(last); d < 0 || d > time.Second {
+		t.Fatalf("last update should be just now, got %v ago", d)
+	}
+}
@@ -0,0 +1,123 @@
+package limiter
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Store is a backend that decides rate-limit checks atomically, one method per
+// algorithm. Each method records the request and reports true if it fits,
+// and records nothing and reports false otherwise. An error means the store
+// could not decide; the request is then denied with ErrBackendUnavailable (see
+// Allow). Implementations must be safe for concurrent use and, to limit
+// across instances, make each check atomic in the shared store.
+type Store interface {
+	// Sliding admits a request if fewer than limit were admitted for userID
+	// within the past window.
+	Sliding(ctx context.Context, userID string, limit int, window time.Duration) (bool, error)
+	// Leaky takes a token from userID's bucket of limit tokens, which refills
+	// at limit tokens per window, starting full.
+	Leaky(ctx context.Context, userID string, limit int, window time.Duration) (bool, error)
+	// Daily admits a request if fewer than limit were admitted for userID
+	// today, a calendar day in the quota timezone (see GetTimezone).
+	Daily(ctx context.Context, userID string, limit int) (bool, error)
+}
+
+var store atomic.Pointer[Store]
+
+// SetStore makes s decide every rate-limit check, in place of the built-in
+// choice of Redis (after InitRedis) or memory; nil restores that choice.
+// MemoryStore and RedisStore are the built-in backends as Stores, and other
+// backends (memcached, a custom shared store) can be plugged in the same way.
+//
+// A Store only decides requests, each as of now: the time passed to AllowAt
+// does not reach it. Multi-window rules, and features that read or adjust
+// limiter state directly (Stats, refunds, Settle, Snapshot, warming,
+// reservations), keep using the built-in backend.
+func SetStore(s Store) {
+	if s == nil {
+		store.Store(nil)
+		return
+	}
+	store.Store(&s)
+}
+
+// storeFor returns the Store set with SetStore that decides requests of mode.
+func storeFor(mode string) (Store, bool) {
+	s := store.Load()
+	if s == nil || mode == "rules" || mode == decayMode {
+		return nil, false
+	}
+	return *s, true
+}
+
+// rateLimitStore decides a request through s.
+func rateLimitStore(ctx context.Context, s Store, userID string, limit int, mode string) (bool, receipt) {
+	var allowed bool
+	var err error
+	switch mode {
+	case "leaky":
+		allowed, err = s.Leaky(ctx, userID, limit, GetUserWindow(userID))
+	case "daily":
+		allowed, err = s.Daily(ctx, userID, limit)
+	default:
+		allowed, err = s.Sliding(ctx, userID, limit, GetUserWindow(userID))
+	}
+	if err != nil {
+		return false, receipt{err: err}
+	}
+	return allowed, receipt{}
+}
+
+// MemoryStore returns the in-memory backend as a Store. Its state is the one
+// the limiter uses without Redis, local to this process. Like RedisStore, it
+// sizes a bucket when creating it, and a user's rate (SetUserRate) or refill
+// (SetUserRefill) still shapes it as for RateLimit.
+func MemoryStore() Store { return memoryStore{} }
+
+type memoryStore struct{}
+
+func (memoryStore) Sliding(_ context.Context, userID string, limit int, window time.Duration) (bool, error) {
+	allowed, _ := rateLimitMemorySliding(userID, limit, 1, window, monoNowMs())
+	return allowed, nil
+}
+
+func (memoryStore) Leaky(_ context.Context, userID string, limit int, window time.Duration) (bool, error) {
+	return rateLimitMemoryLeaky(userID, limit, 1, window, monoNowMs()), nil
+}
+
+func (memoryStore) Daily(_ context.Context, userID string, limit int) (bool, error) {
+	allowed, _ := rateLimitMemoryDaily(userID, limit, 1, time.Time{})
+	return allowed, nil
+}
+
+// RedisStore returns the Redis backend set up by InitRedis as a Store. Until
+// InitRedis is called its checks fail with ErrBackendUnavailable.
+func RedisStore() Store { return redisStore{} }
+
+type redisStore struct{}
+
+func (redisStore) Sliding(ctx context.Context, userID string, limit int, window time.Duration) (bool, error) {
+	if rdb == nil {
+		return false, ErrBackendUnavailable
+	}
+	allowed, rc := rateLimitRedisSliding(ctx, userID, limit, 1, window, time.Time{})
+	return allowed, rc.err
+}
+
+func (redisStore) Leaky(ctx context.Context, userID string, limit int, window time.Duration) (bool, error) {
+	if rdb == nil {
+		return false, ErrBackendUnavailable
+	}
+	allowed, rc := rateLimitRedisLeaky(ctx, userID, limit, 1, window, time.Time{})
+	return allowed, rc.err
+}
+
+func (redisStore) Daily(ctx context.Context, userID string, limit int) (bool, error) {
+	if rdb == nil {
+		return false, ErrBackendUnavailable
+	}
+	allowed, rc := rateLimitRedisDaily(ctx, userID, limit, 1, time.Time{})
+	return allowed, rc.err
+}
@@ -0,0 +1,155 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingStore is a minimal custom Store: fixed counts per user, never
+// expiring, enough to observe that requests reach it.
+type countingStore struct {
+	mu    sync.Mutex
+	calls map[string]int
+	used  map[string]int
+	err   error
+}
+
+func newCountingStore() *countingStore {
+	return &countingStore{calls: map[string]int{}, used: map[string]int{}}
+}
+
+func (s *countingStore) take(method, userID string, limit int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[method]++
+	if s.err != nil {
+		return false, s.err
+	}
+	if s.used[userID] >= limit {
+		return false, nil
+	}
+	s.used[userID]++
+	return true, nil
+}
+
+func (s *countingStore) Sliding(_ context.Context, userID string, limit int, _ time.Duration) (bool, error) {
+	return s.take("sliding", userID, limit)
+}
+
+func (s *countingStore) Leaky(_ context.Context, userID string, limit int, _ time.Duration) (bool, error) {
+	return s.take("leaky", userID, limit)
+}
+
+func (s *countingStore) Daily(_ context.Context, userID string, limit int) (bool, error) {
+	return s.take("daily", userID, limit)
+}
+
+// decisions runs the same burst for a fresh user in every mode.
+func decisions(user string) []bool {
+	var out []bool
+	for _, mode := range []string{"sliding", "leaky", "daily"} {
+		SetMode(mode)
+		for i := 0; i < 5; i++ {
+			out = append(out, RateLimit(user+"-"+mode, 3))
+		}
+	}
+	SetMode("sliding")
+	return out
+}
+
+func TestSetStore_SameBehaviorAcrossStores(t *testing.T) {
+	resetLimiterState()
+	want := decisions("builtin")
+
+	stores := map[string]Store{"memory": MemoryStore(), "custom": newCountingStore()}
+	for name, s := range stores {
+		resetLimiterState()
+		SetStore(s)
+		got := decisions(name)
+		SetStore(nil)
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %d decisions, want %d", name, len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("%s store: decisions %v, built-in %v", name, got, want)
+			}
+		}
+	}
+}
+
+// assertStoreHonorsWindow checks that s limits by the window passed to it,
+// not the user's (default 1s) window.
+func assertStoreHonorsWindow(t *testing.T, s Store, user string) {
+	t.Helper()
+	checks := map[string]func(string) (bool, error){
+		"sliding": func(u string) (bool, error) { return s.Sliding(context.Background(), u, 2, 50*time.Millisecond) },
+		"leaky":   func(u string) (bool, error) { return s.Leaky(context.Background(), u, 2, 50*time.Millisecond) },
+	}
+	for name, check := range checks {
+		u := user + "-" + name
+		for i := 1; i <= 3; i++ {
+			if ok, err := check(u); err != nil || ok != (i <= 2) {
+				t.Fatalf("%s request %d: got %v %v, want allowed=%v", name, i, ok, err, i <= 2)
+			}
+		}
+		time.Sleep(60 * time.Millisecond)
+		if ok, err := check(u); err != nil || !ok {
+			t.Fatalf("%s: a request after the 50ms window should be allowed, got %v %v", name, ok, err)
+		}
+	}
+}
+
+func TestMemoryStore_HonorsWindow(t *testing.T) {
+	resetLimiterState()
+	assertStoreHonorsWindow(t, MemoryStore(), "store-window")
+}
+
+func TestSetStore_RoutesRequestsToStore(t *testing.T) {
+	resetLimiterState()
+	s := newCountingStore()
+	SetStore(s)
+	defer SetStore(nil)
+
+	RateLimit("routed", 3)
+	SetMode("leaky")
+	RateLimit("routed", 3)
+	SetMode("sliding")
+	if s.calls["sliding"] != 1 || s.calls["leaky"] != 1 {
+		t.Fatalf("requests should reach the store, got %v", s.calls)
+	}
+	if _, ok := slidingStates.load("routed"); ok {
+		t.Fatal("the built-in memory state must not be touched")
+	}
+
+	// a refund has nothing to give back in the built-in state
+	ok, refund := RateLimitRefundable("routed", 3)
+	if !ok {
+		t.Fatal("expected an allowed request")
+	}
+	refund()
+
+	s.err = errors.New("store down")
+	if ok, err := Allow(context.Background(), "routed", 3); ok || !errors.Is(err, ErrBackendUnavailable) {
+		t.Fatalf("a failing store should deny with ErrBackendUnavailable, got %v %v", ok, err)
+	}
+
+	SetStore(nil)
+	RateLimit("routed", 3)
+	if _, ok := slidingStates.load("routed"); !ok {
+		t.Fatal("SetStore(nil) should restore the built-in backend")
+	}
+}
+
+func TestRedisStore_FailsWithoutRedis(t *testing.T) {
+	resetLimiterState()
+	SetStore(RedisStore())
+	defer SetStore(nil)
+
+	if ok, err := Allow(context.Background(), "no-redis", 3); ok || !errors.Is(err, ErrBackendUnavailable) {
+		t.Fatalf("RedisStore without InitRedis should fail, got %v %v", ok, err)
+	}
+}
@@ -0,0 +1,29 @@
+package limiter
+
+import "sync/atomic"
+
+// strictMode makes denied requests count against the user too.
+var strictMode atomic.Bool
+
+// SetStrictMode toggles strict counting for abuse mitigation. When enabled, a
+// denied request is charged like an allowed one: the sliding window records
+// its timestamp (keeping only the newest limit entries, so memory stays
+// bounded), and the leaky bucket drains whatever has leaked back, never below
+// zero. A user who keeps requesting at or above their limit therefore stays
+// blocked until the traffic stops or slows below the limit. Off by default.
+func SetStrictMode(enabled bool) {
+	strictMode.Store(enabled)
+}
+
+// IsStrictMode reports whether strict counting is enabled.
+func IsStrictMode() bool {
+	return strictMode.Load()
+}
+
+// strictArg encodes the strict flag for the Lua scripts.
+func strictArg() string {
+	if strictMode.Load() {
+		return "1"
+	}
+	return "0"
+}
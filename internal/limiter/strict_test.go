@@ -0,0 +1,85 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+// hammer sends a request every 10ms for d and counts how many were allowed.
+func hammer(user string, limit int, d time.Duration) int {
+	allowed := 0
+	for end := time.Now().Add(d); time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+		if RateLimit(user, limit) {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestStrictMode_SlidingHammeringNeverRecovers(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		resetLimiterState()
+		SetMode("sliding")
+		SetStrictMode(strict)
+
+		user := "hammer-sliding"
+		SetUserWindow(user, 100*time.Millisecond)
+		RateLimit(user, 2)
+		RateLimit(user, 2)
+
+		got := hammer(user, 2, 350*time.Millisecond)
+		if !strict && got == 0 {
+			t.Fatal("default mode: window should recover while hammering")
+		}
+		if strict && got != 0 {
+			t.Fatalf("strict mode: expected no recovery while hammering, %d allowed", got)
+		}
+
+		// once traffic stops, a full window later the user is allowed again
+		time.Sleep(120 * time.Millisecond)
+		if !RateLimit(user, 2) {
+			t.Fatalf("strict=%v: request after traffic stopped should be allowed", strict)
+		}
+	}
+}
+
+func TestStrictMode_LeakyHammeringNeverRecovers(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		resetLimiterState()
+		SetMode("leaky")
+		SetStrictMode(strict)
+
+		user := "hammer-leaky"
+		SetUserWindow(user, 100*time.Millisecond) // one token per 50ms
+		RateLimit(user, 2)
+		RateLimit(user, 2)
+
+		got := hammer(user, 2, 350*time.Millisecond)
+		if !strict && got == 0 {
+			t.Fatal("default mode: bucket should refill while hammering")
+		}
+		if strict && got != 0 {
+			t.Fatalf("strict mode: expected no recovery while hammering, %d allowed", got)
+		}
+
+		time.Sleep(80 * time.Millisecond)
+		if !RateLimit(user, 2) {
+			t.Fatalf("strict=%v: request after traffic stopped should be allowed", strict)
+		}
+	}
+}
+
+func TestStrictMode_SlidingStateBounded(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	SetStrictMode(true)
+	defer SetStrictMode(false)
+
+	for i := 0; i < 1000; i++ {
+		RateLimit("bounded", 5)
+	}
+	st, _ := slidingStates.load("bounded")
+	if n := len(st.ts); n != 5 {
+		t.Fatalf("strict window should hold at most limit entries, got %d", n)
+	}
+}
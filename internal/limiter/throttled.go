@@ -0,0 +1,62 @@
+package limiter
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// throttledEntry records a user's latest denial.
+type throttledEntry struct {
+	mode       string
+	limit      int
+	deadlineMs int64 // by then usage has certainly dropped below limit
+}
+
+var throttled = sync.Map{} // map[string]throttledEntry, users whose last request was denied
+
+// trackThrottled keeps the throttled set up to date after a decision: a
+// denial adds the user until their usage must have recovered, an allowed
+// request removes them.
+func trackThrottled(userID, mode string, limit int, allowed bool, nowMs int64) {
+	if allowed {
+		throttled.Delete(userID)
+		return
+	}
+	deadline := nowMs + GetUserWindow(userID).Milliseconds()
+	switch mode {
+	case "daily":
+		_, end := dayBounds(time.UnixMilli(nowMs))
+		deadline = max(deadline, end.UnixMilli())
+	case "rules":
+		for _, r := range GetUserLimits(userID) {
+			deadline = max(deadline, nowMs+r.Window.Milliseconds())
+		}
+	}
+	throttled.Store(userID, throttledEntry{mode: mode, limit: limit, deadlineMs: deadline})
+}
+
+// ThrottledUsers returns, sorted, the users currently at or above their limit:
+// sliding-window or daily users whose count has reached it and leaky users
+// with less than one token. Only users who were denied recently are
+// considered; they are tracked as requests are decided, so no key scan is
+// needed. Each candidate's usage is re-checked, which costs one Redis read per
+// candidate when Redis is in use. Multi-window rules users are listed until
+// their longest window has passed since their last denial.
+func ThrottledUsers() []string {
+	now := monoNowMs()
+	var users []string
+	throttled.Range(func(k, v any) bool {
+		userID, e := k.(string), v.(throttledEntry)
+		if now >= e.deadlineMs {
+			throttled.CompareAndDelete(k, v)
+			return true
+		}
+		if e.mode == "rules" || peekUsed(userID, e.mode, e.limit) >= e.limit {
+			users = append(users, userID)
+		}
+		return true
+	})
+	sort.Strings(users)
+	return users
+}
@@ -0,0 +1,52 @@
+package limiter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestThrottledUsers_ListsUsersOverLimit(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	SetUserWindow("thr-a", 200*time.Millisecond)
+	SetUserWindow("thr-b", 200*time.Millisecond)
+	SetUserMode("thr-b", "leaky")
+
+	for _, u := range []string{"thr-a", "thr-b", "thr-ok"} {
+		limit := 2
+		if u == "thr-ok" {
+			limit = 10
+		}
+		for i := 0; i < 3; i++ {
+			RateLimit(u, limit)
+		}
+	}
+	if got := ThrottledUsers(); !reflect.DeepEqual(got, []string{"thr-a", "thr-b"}) {
+		t.Fatalf("ThrottledUsers() = %v, want [thr-a thr-b]", got)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if got := ThrottledUsers(); len(got) != 0 {
+		t.Fatalf("users should clear after the window, got %v", got)
+	}
+}
+
+func TestThrottledUsers_AllowedRequestClears(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	SetUserWindow("thr-c", 100*time.Millisecond)
+
+	RateLimit("thr-c", 1)
+	RateLimit("thr-c", 1)
+	if got := ThrottledUsers(); !reflect.DeepEqual(got, []string{"thr-c"}) {
+		t.Fatalf("ThrottledUsers() = %v, want [thr-c]", got)
+	}
+	time.Sleep(120 * time.Millisecond)
+	if !RateLimit("thr-c", 1) {
+		t.Fatal("request after the window should be allowed")
+	}
+	if _, ok := throttled.Load("thr-c"); ok {
+		t.Fatal("an allowed request should drop the user from the throttled set")
+	}
+}
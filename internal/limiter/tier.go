@@ -0,0 +1,71 @@
+package limiter
+
+import (
+	"fmt"
+	"sync"
+)
+
+// tier is a named plan shared by many users.
+type tier struct {
+	limit int    // requests per window; 0 leaves the caller's limit in effect
+	mode  string // algorithm override; "" leaves the global mode in effect
+}
+
+var (
+	tiers     = sync.Map{} // map[string]tier
+	userTiers = sync.Map{} // map[string]string (user -> tier name)
+)
+
+// DefineTier creates or replaces a tier. Users assigned to it pick up the new
+// limit and mode on their next request. An empty mode keeps the global mode.
+func DefineTier(name string, limit int, mode string) error {
+	if name == "" {
+		return fmt.Errorf("tier: empty name")
+	}
+	if limit < 0 {
+		return fmt.Errorf("tier %q: negative limit %d", name, limit)
+	}
+	if mode != "" && !validMode(mode) {
+		return fmt.Errorf("tier %q: invalid mode %q", name, mode)
+	}
+	tiers.Store(name, tier{limit: limit, mode: mode})
+	return nil
+}
+
+// RemoveTier deletes a tier. Its users keep their assignment but fall back to
+// their own limit and mode until the tier is defined again.
+func RemoveTier(name string) {
+	tiers.Delete(name)
+}
+
+// AssignTier puts userID on the named tier. The tier need not be defined yet.
+// An empty name removes the assignment.
+func AssignTier(userID, name string) {
+	if name == "" {
+		userTiers.Delete(userID)
+		return
+	}
+	userTiers.Store(userID, name)
+}
+
+// GetUserTier returns the tier userID is assigned to.
+func GetUserTier(userID string) (string, bool) {
+	v, ok := userTiers.Load(userID)
+	if !ok {
+		return "", false
+	}
+	return v.(string), true
+}
+
+// userTier returns the definition of the user's tier, if assigned and defined.
+func userTier(userID string) (tier, bool) {
+	name, ok := GetUserTier(userID)
+	if !ok {
+		return tier{}, false
+	}
+	v, ok := tiers.Load(name)
+	if !ok {
+		return tier{}, false
+	}
+	return v.(tier), true
+}
@@ -0,0 +1,120 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTier_LimitChangeReachesAllMembers(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	if err := DefineTier("pro", 2, ""); err != nil {
+		t.Fatal(err)
+	}
+	users := []string{"p1", "p2", "p3"}
+	for _, u := range users {
+		AssignTier(u, "pro")
+		SetUserWindow(u, 100*time.Millisecond)
+	}
+	for _, u := range users {
+		assertAllowance(t, u, 2)
+	}
+
+	if err := DefineTier("pro", 4, ""); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(120 * time.Millisecond)
+	for _, u := range users {
+		if got := EffectiveLimit(u, 100); got != 4 {
+			t.Fatalf("%s: effective limit = %d, want 4", u, got)
+		}
+		assertAllowance(t, u, 4)
+	}
+}
+
+func TestTier_ResolutionOrder(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+
+	DefineTier("free", 1, "leaky")
+	AssignTier("u", "free")
+	if got := EffectiveLimit("u", 9); got != 1 {
+		t.Fatalf("tier limit should apply, got %d", got)
+	}
+	if got := GetUserMode("u"); got != "leaky" {
+		t.Fatalf("tier mode should apply, got %q", got)
+	}
+
+	// per-user settings beat the tier
+	SetUserLimit("u", 5)
+	SetUserMode("u", "daily")
+	if got := EffectiveLimit("u", 9); got != 5 {
+		t.Fatalf("per-user limit should win over tier, got %d", got)
+	}
+	if got := GetUserMode("u"); got != "daily" {
+		t.Fatalf("per-user mode should win over tier, got %q", got)
+	}
+
+	// an undefined tier falls through to the caller's limit
+	AssignTier("v", "missing")
+	if got := EffectiveLimit("v", 9); got != 9 {
+		t.Fatalf("undefined tier should fall back, got %d", got)
+	}
+	AssignTier("u", "")
+	if _, ok := GetUserTier("u"); ok {
+		t.Fatal("empty tier name should remove the assignment")
+	}
+}
+
+func TestDefineTier_Validation(t *testing.T) {
+	resetLimiterState()
+
+	if err := DefineTier("", 1, ""); err == nil {
+		t.Fatal("empty name should be rejected")
+	}
+	if err := DefineTier("x", -1, ""); err == nil {
+		t.Fatal("negative limit should be rejected")
+	}
+	if err := DefineTier("x", 1, "bogus"); err == nil {
+		t.Fatal("invalid mode should be rejected")
+	}
+}
+
+func TestLoadUserConfig_TierEntries(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	DefineTier("pro", 3, "")
+
+	writeTempConfig(t, "test_users_tier.json", `{"alice":{"tier":"pro"},"bob":{"tier":"pro","limit":1}}`)
+	if err := LoadUserConfigFromJSON("test_users_tier.json"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := GetUserTier("alice"); got != "pro" {
+		t.Fatalf("alice tier = %q, want pro", got)
+	}
+	assertAllowance(t, "alice", 3)
+	assertAllowance(t, "bob", 1) // explicit limit overrides the tier
+}
+
+func TestLoadUserConfig_ReloadedTierEntryDropsOwnLimit(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	DefineTier("pro", 3, "")
+
+	writeTempConfig(t, "test_users_tier_reload.json", `{"alice":"1/10s"}`)
+	if err := LoadUserConfigFromJSON("test_users_tier_reload.json"); err != nil {
+		t.Fatal(err)
+	}
+	writeTempConfig(t, "test_users_tier_reload.json", `{"alice":{"tier":"pro"}}`)
+	if err := LoadUserConfigFromJSON("test_users_tier_reload.json"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := GetUserLimit("alice"); ok {
+		t.Fatal("a tier-only entry should drop the user's own limit")
+	}
+	if got := GetUserWindow("alice"); got != time.Second {
+		t.Fatalf("a tier-only entry should restore the default window, got %v", got)
+	}
+	assertAllowance(t, "alice", 3)
+}
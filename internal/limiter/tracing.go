@@ -0,0 +1,67 @@
+package limiter
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Tracer starts spans around the limiter's Redis calls. It mirrors the part
+// of OpenTelemetry's trace.Tracer the limiter needs, so the limiter does not
+// depend on OpenTelemetry; an adapter is a few lines:
+//
+//	type otelTracer struct{ t trace.Tracer }
+//
+//	func (o otelTracer) Start(ctx context.Context, name string) (context.Context, limiter.Span) {
+//		ctx, span := o.t.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient))
+//		return ctx, otelSpan{span}
+//	}
+//
+// with otelSpan.SetAttribute mapping values to attribute.KeyValue.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a started span; the limiter sets attributes on it and ends it.
+type Span interface {
+	SetAttribute(key string, value any)
+	End()
+}
+
+// Span name and attribute keys used for Redis calls.
+const (
+	RedisSpanName = "ratelimiter.redis.eval"
+	AttrUser      = "ratelimiter.user" // HashKey of the user ID (first 16 chars)
+	AttrMode      = "ratelimiter.mode"
+	AttrAllowed   = "ratelimiter.allowed"
+)
+
+const tracedUserHashLen = 16
+
+var tracer atomic.Pointer[Tracer]
+
+// SetTracer traces every Redis rate-limit call with t (nil disables tracing,
+// the default). Spans are children of the context passed to RateLimitCtx and
+// record the hashed user, mode and outcome. In-memory decisions and
+// AllowMulti's pipelined batches are not traced.
+func SetTracer(t Tracer) {
+	if t == nil {
+		tracer.Store(nil)
+		return
+	}
+	tracer.Store(&t)
+}
+
+// traceRedis runs call inside a span when a tracer is set.
+func traceRedis(ctx context.Context, userID, mode string, call func(context.Context) (bool, receipt)) (bool, receipt) {
+	t := tracer.Load()
+	if t == nil {
+		return call(ctx)
+	}
+	ctx, span := (*t).Start(ctx, RedisSpanName)
+	defer span.End()
+	span.SetAttribute(AttrUser, HashKey(userID)[:tracedUserHashLen])
+	span.SetAttribute(AttrMode, mode)
+	allowed, rc := call(ctx)
+	span.SetAttribute(AttrAllowed, allowed)
+	return allowed, rc
+}
@@ -0,0 +1,45 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+type ctxKey struct{}
+
+// recordingTracer keeps finished spans in memory, like an in-memory exporter.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	name   string
+	parent any
+	attrs  map[string]any
+	ended  bool
+}
+
+func (r *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	s := &recordedSpan{name: name, parent: ctx.Value(ctxKey{}), attrs: map[string]any{}}
+	r.mu.Lock()
+	r.spans = append(r.spans, s)
+	r.mu.Unlock()
+	return context.WithValue(ctx, ctxKey{}, s), s
+}
+
+func (s *recordedSpan) SetAttribute(key string, value any) { s.attrs[key] = value }
+func (s *recordedSpan) End()                               { s.ended = true }
+
+func TestTracer_InMemoryNotTraced(t *testing.T) {
+	resetLimiterState()
+	rec := &recordingTracer{}
+	SetTracer(rec)
+	defer SetTracer(nil)
+
+	RateLimitCtx(context.Background(), "trace-mem", 5)
+	if len(rec.spans) != 0 {
+		t.Fatalf("in-memory decisions should not be traced, got %d spans", len(rec.spans))
+	}
+}
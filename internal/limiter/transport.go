@@ -0,0 +1,33 @@
+package limiter
+
+import "net/http"
+
+// throttledTransport paces outbound requests under a client-side limit.
+type throttledTransport struct {
+	base  http.RoundTripper
+	key   string
+	limit int
+}
+
+// NewThrottledTransport wraps base (http.DefaultTransport if nil) so that
+// requests are paced to at most limit per window of key, e.g. to stay under a
+// third-party API's rate limit. Each request blocks in Wait before being sent;
+// the first limit requests may go out at once, after which they are spaced
+// window/limit apart. A request whose context ends while waiting fails with
+// the context's error and is never sent.
+func NewThrottledTransport(base http.RoundTripper, key string, limit int) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &throttledTransport{base: base, key: key, limit: limit}
+}
+
+func (t *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := Wait(req.Context(), t.key, t.limit); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,68 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestThrottledTransport_PacesRequests(t *testing.T) {
+	resetLimiterState()
+
+	var mu sync.Mutex
+	var arrivals []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		arrivals = append(arrivals, time.Now())
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	limit := 10 // one request per 100ms once the burst is spent
+	client := &http.Client{Transport: NewThrottledTransport(nil, "third-party", limit)}
+	for i := 0; i < limit+5; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	for i := limit + 1; i < len(arrivals); i++ {
+		gap := arrivals[i].Sub(arrivals[i-1])
+		if gap < 70*time.Millisecond || gap > 160*time.Millisecond {
+			t.Fatalf("request %d arrived %v after the previous one, want ~100ms", i+1, gap)
+		}
+	}
+}
+
+func TestThrottledTransport_HonorsContext(t *testing.T) {
+	resetLimiterState()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: NewThrottledTransport(nil, "slow-api", 1)}
+	SetUserWindow("slow-api", 10*time.Second)
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	start := time.Now()
+	_, err = client.Do(req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded while waiting, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("cancelled request should not wait for the full interval")
+	}
+}
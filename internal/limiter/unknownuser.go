@@ -0,0 +1,56 @@
+package limiter
+
+import "sync/atomic"
+
+// UnknownUserPolicy decides requests from users without a limit of their own.
+type UnknownUserPolicy int32
+
+const (
+	// AllowWithDefault limits unknown users with the limit passed to
+	// RateLimit, or the default limit (the default).
+	AllowWithDefault UnknownUserPolicy = iota
+	// DenyUnknown denies users with no configured limit (SetUserLimit or a
+	// config file), tier or multi-window rules.
+	DenyUnknown
+	// RequireConfig denies users with no configured limit, even if a tier or
+	// multi-window rules would limit them: only users listed by SetUserLimit
+	// or a config file get through.
+	RequireConfig
+)
+
+var unknownUserPolicy atomic.Int32
+
+// SetUnknownUserPolicy sets how RateLimit (and every variant built on it)
+// treats users the limiter has no limit for, e.g. DenyUnknown for an API open
+// only to known clients. Denied users never touch bucket state, as with the
+// deny list; allow-listed users are let through regardless. Unknown policies
+// are ignored.
+func SetUnknownUserPolicy(policy UnknownUserPolicy) {
+	if policy >= AllowWithDefault && policy <= RequireConfig {
+		unknownUserPolicy.Store(int32(policy))
+	}
+}
+
+// GetUnknownUserPolicy returns the current unknown-user policy.
+func GetUnknownUserPolicy() UnknownUserPolicy {
+	return UnknownUserPolicy(unknownUserPolicy.Load())
+}
+
+// rejectUnknown reports whether the unknown-user policy denies userID.
+func rejectUnknown(userID string) bool {
+	policy := GetUnknownUserPolicy()
+	if policy == AllowWithDefault {
+		return false
+	}
+	if cfg, ok := GetUserLimit(userID); ok && cfg > 0 {
+		return false
+	}
+	if policy == RequireConfig {
+		return true
+	}
+	if t, ok := userTier(userID); ok && t.limit > 0 {
+		return false
+	}
+	_, ok := userRules.Load(userID)
+	return !ok
+}
@@ -0,0 +1,61 @@
+package limiter
+
+import "testing"
+
+func TestUnknownUserPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		policy                      UnknownUserPolicy
+		configured, unknown, tiered bool // whether each user gets a first request through
+	}{
+		{AllowWithDefault, true, true, true},
+		{DenyUnknown, true, false, true},
+		{RequireConfig, true, false, false},
+	} {
+		resetLimiterState()
+		SetMode("sliding")
+		SetUnknownUserPolicy(tc.policy)
+		SetUserLimit("configured", 2)
+		if err := DefineTier("gold", 2, ""); err != nil {
+			t.Fatal(err)
+		}
+		defer RemoveTier("gold")
+		AssignTier("tiered", "gold")
+
+		for user, want := range map[string]bool{"configured": tc.configured, "unknown": tc.unknown, "tiered": tc.tiered} {
+			if got := RateLimit(user, 2); got != want {
+				t.Fatalf("policy %d: %s allowed=%v, want %v", tc.policy, user, got, want)
+			}
+		}
+		if tc.configured {
+			RateLimit("configured", 2)
+			if RateLimit("configured", 2) {
+				t.Fatalf("policy %d: a configured user must still be limited", tc.policy)
+			}
+		}
+	}
+}
+
+func TestUnknownUserPolicy_ReasonAndAllowList(t *testing.T) {
+	resetLimiterState()
+	SetUnknownUserPolicy(RequireConfig)
+
+	if res := AllowResult("stranger", 5); res.Allowed || res.Reason != ReasonUnknownUser {
+		t.Fatalf("expected an unknown-user denial, got %+v", res)
+	}
+	if _, ok := slidingStates.load("stranger"); ok {
+		t.Fatal("a rejected unknown user should not create bucket state")
+	}
+	AllowList("stranger")
+	if !RateLimit("stranger", 5) {
+		t.Fatal("allow-listed users bypass the policy")
+	}
+}
+
+func TestSetUnknownUserPolicy_IgnoresUnknownPolicy(t *testing.T) {
+	resetLimiterState()
+	SetUnknownUserPolicy(DenyUnknown)
+	SetUnknownUserPolicy(UnknownUserPolicy(42))
+	if got := GetUnknownUserPolicy(); got != DenyUnknown {
+		t.Fatalf("expected the policy to be unchanged, got %d", got)
+	}
+}
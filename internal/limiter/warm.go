@@ -0,0 +1,65 @@
+package limiter
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+// warmLeakyScript sets bucket KEYS[1] to ARGV[1] tokens as of the server time.
+// ARGV[2] = key expiry (ms)
+var warmLeakyScript = newScript("warm-leaky", luaNowMs+luaExpire+`
+	redis.call("HSET", KEYS[1], "tokens", ARGV[1], "last", tostring(now))
+	pexpire(KEYS[1], ARGV[2])
+	return 1
+`)
+
+// WarmLeaky sets userID's leaky bucket to tokens, clamped to [0, capacity],
+// e.g. so instances brought up by a deploy do not hand every user a full
+// burst. The bucket refills normally from there. It complements Restore for
+// levels derived outside the limiter. The capacity comes from the user's
+// limit (configured, tier or default limit); with none, an existing in-memory
+// bucket keeps its capacity and a Redis bucket is only clamped at zero.
+// The bucket is written in Redis if InitRedis has been called, in memory
+// otherwise.
+func WarmLeaky(userID string, tokens float64) {
+	warmLeaky(transformKey(userID), tokens)
+}
+
+// warmLeaky is WarmLeaky for a key already transformed (see
+// SetKeyTransformer).
+func warmLeaky(userID string, tokens float64) {
+	if math.IsNaN(tokens) {
+		return
+	}
+	tokens = math.Max(tokens, 0)
+	limit := EffectiveLimit(userID, 0)
+	window := GetUserWindow(userID)
+
+	if rdb != nil {
+		if limit > 0 {
+			capacity, ratePerMs := leakyParams(userID, limit, window)
+			tokens = math.Min(tokens, capacity)
+			window = time.Duration(math.Ceil(capacity/ratePerMs)) * time.Millisecond
+		}
+		warmLeakyScript.Run(ctx, rdb, []string{leakyKey(userID)},
+			strconv.FormatFloat(tokens, 'f', -1, 64),
+			strconv.FormatInt(keyExpiry(window).Milliseconds(), 10),
+		)
+		return
+	}
+
+	now := monoNowMs()
+	var st *leakyState
+	if limit > 0 {
+		st = getLeakyState(userID, limit, GetUserWindow(userID), now)
+	} else if st, _ = leakyBuckets.load(userID); st == nil {
+		return
+	}
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	st.tokens = math.Min(tokens, st.capacity)
+	st.lastMillis = now
+	st.reservations = nil
+	st.epoch.Store(modeEpoch.Load())
+}
@@ -0,0 +1,39 @@
+package limiter
+
+import "testing"
+
+func TestWarmLeaky_Empty(t *testing.T) {
+	resetLimiterState()
+	SetMode("leaky")
+	SetUserLimit("warm-empty", 5)
+
+	WarmLeaky("warm-empty", 0)
+	if RateLimit("warm-empty", 5) {
+		t.Fatal("a bucket warmed to 0 tokens should deny the first request")
+	}
+}
+
+func TestWarmLeaky_FullAndClamped(t *testing.T) {
+	resetLimiterState()
+	SetMode("leaky")
+	SetUserLimit("warm-full", 5)
+
+	WarmLeaky("warm-full", 0)
+	WarmLeaky("warm-full", 100) // clamped to the capacity of 5
+	for i := 1; i <= 5; i++ {
+		if !RateLimit("warm-full", 5) {
+			t.Fatalf("request %d of a full burst should be allowed", i)
+		}
+	}
+	if RateLimit("warm-full", 5) {
+		t.Fatal("tokens above capacity must be clamped")
+	}
+}
+
+func TestWarmLeaky_UnknownLimit(t *testing.T) {
+	resetLimiterState()
+	WarmLeaky("warm-unknown", 3)
+	if _, ok := leakyBuckets.load("warm-unknown"); ok {
+		t.Fatal("without a limit or an existing bucket, there is no capacity to warm to")
+	}
+}
@@ -0,0 +1,39 @@
+package limiter
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// warmupFloor is the fraction of a limit allowed when warmup begins.
+const warmupFloor = 0.1
+
+// warmup is the ramp duration set with SetWarmup (ns); 0 disables it.
+var warmup atomic.Int64
+
+// SetWarmup ramps limits up after a cold start, so users of a fresh instance
+// cannot each burst their full limit at a downstream that has just started
+// too. For d after the process started, every user's effective limit rises
+// linearly from a tenth of it (at least 1) to all of it. The ramp applies
+// only while limiting in memory, where a fresh instance really does start
+// from empty state; with Redis or a Store the state outlives the instance, so
+// it does not apply. d <= 0 disables warmup (the default).
+func SetWarmup(d time.Duration) {
+	warmup.Store(int64(max(d, 0)))
+}
+
+// warmedUp returns the share of limit the warmup ramp allows now.
+func warmedUp(limit int) int {
+	d := time.Duration(warmup.Load())
+	if d == 0 || rdb != nil || store.Load() != nil {
+		return limit
+	}
+	elapsed := time.Since(clockBase)
+	if elapsed >= d {
+		return limit
+	}
+	floor := max(math.Ceil(float64(limit)*warmupFloor), 1)
+	ramped := floor + (float64(limit)-floor)*float64(elapsed)/float64(d)
+	return min(int(ramped), limit)
+}
@@ -0,0 +1,47 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetWarmup_RampsLimitAfterStart(t *testing.T) {
+	resetLimiterState()
+	saved := clockBase
+	defer func() { clockBase = saved }()
+	clockBase = time.Now() // the process has just started
+	SetWarmup(time.Second)
+	SetUserWindow("alice", time.Minute)
+
+	if got := EffectiveLimit("alice", 100); got < 10 || got > 12 {
+		t.Fatalf("expected about a tenth of the limit right after start, got %d", got)
+	}
+	if got := countAllowed("alice", 100, 100); got < 10 || got > 12 {
+		t.Fatalf("expected the cold instance to admit about 10, got %d", got)
+	}
+	if got := EffectiveLimit("bob", 5); got != 1 {
+		t.Fatalf("the floor is at least 1, got %d", got)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	if got := EffectiveLimit("alice", 100); got < 50 || got > 65 {
+		t.Fatalf("expected about half the ramp halfway through, got %d", got)
+	}
+
+	time.Sleep(600 * time.Millisecond)
+	if got := EffectiveLimit("alice", 100); got != 100 {
+		t.Fatalf("expected the full limit after warmup, got %d", got)
+	}
+}
+
+func TestSetWarmup_OverLongAgo(t *testing.T) {
+	resetLimiterState()
+	saved := clockBase
+	defer func() { clockBase = saved }()
+	clockBase = time.Now().Add(-time.Hour)
+	SetWarmup(time.Minute)
+
+	if got := EffectiveLimit("alice", 100); got != 100 {
+		t.Fatalf("warmup measured from process start should be over, got %d", got)
+	}
+}
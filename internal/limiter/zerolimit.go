@@ -0,0 +1,47 @@
+package limiter
+
+import "sync/atomic"
+
+// ZeroLimitPolicy decides requests made with a non-positive limit.
+type ZeroLimitPolicy int32
+
+const (
+	// DenyAll denies every request with limit <= 0 (the default).
+	DenyAll ZeroLimitPolicy = iota
+	// AllowAll treats limit <= 0 as unlimited: requests are allowed without
+	// touching bucket state.
+	AllowAll
+)
+
+var (
+	zeroLimitPolicy atomic.Int32
+	defaultLimit    atomic.Int64
+)
+
+// SetZeroLimitPolicy sets how RateLimit (and every variant built on it)
+// treats a non-positive limit, on both the in-memory and Redis backends, when
+// no default limit is set (see SetDefaultLimit). Unknown policies are ignored.
+func SetZeroLimitPolicy(policy ZeroLimitPolicy) {
+	if policy == DenyAll || policy == AllowAll {
+		zeroLimitPolicy.Store(int32(policy))
+	}
+}
+
+// GetZeroLimitPolicy returns the current zero-limit policy.
+func GetZeroLimitPolicy() ZeroLimitPolicy {
+	return ZeroLimitPolicy(zeroLimitPolicy.Load())
+}
+
+// SetDefaultLimit sets the limit used when RateLimit (or any variant) is
+// called with a non-positive limit, so callers can rely on one configured
+// default. Per-user and tier limits still take precedence. n <= 0 removes the
+// default (the initial state), leaving non-positive limits to the zero-limit
+// policy.
+func SetDefaultLimit(n int) {
+	defaultLimit.Store(int64(max(n, 0)))
+}
+
+// DefaultLimit returns the default limit (0 if none is set).
+func DefaultLimit() int {
+	return int(defaultLimit.Load())
+}
@@ -0,0 +1,92 @@
+package limiter
+
+import "testing"
+
+func TestZeroLimitPolicy_DenyAllByDefault(t *testing.T) {
+	resetLimiterState()
+
+	if GetZeroLimitPolicy() != DenyAll {
+		t.Fatal("default policy should be DenyAll")
+	}
+	for _, limit := range []int{0, -3} {
+		if RateLimit("zero-user", limit) {
+			t.Fatalf("limit %d should deny under DenyAll", limit)
+		}
+	}
+}
+
+func TestZeroLimitPolicy_AllowAll(t *testing.T) {
+	for _, mode := range []string{"sliding", "leaky", "daily"} {
+		resetLimiterState()
+		SetMode(mode)
+		SetZeroLimitPolicy(AllowAll)
+		defer SetZeroLimitPolicy(DenyAll)
+
+		for _, limit := range []int{0, -3} {
+			for i := 0; i < 10; i++ {
+				if !RateLimit("zero-user", limit) {
+					t.Fatalf("%s: limit %d request %d should be allowed under AllowAll", mode, limit, i+1)
+				}
+			}
+		}
+		if _, ok := slidingStates.load("zero-user"); ok {
+			t.Fatalf("%s: unlimited requests should not create bucket state", mode)
+		}
+	}
+}
+
+func TestZeroLimitPolicy_PositiveLimitsUnaffected(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	SetZeroLimitPolicy(AllowAll)
+	defer SetZeroLimitPolicy(DenyAll)
+
+	assertAllowance(t, "positive-user", 100)
+}
+
+func TestDefaultLimit_ResolvesNonPositiveLimits(t *testing.T) {
+	resetLimiterState()
+	SetMode("sliding")
+	SetDefaultLimit(3)
+	defer SetDefaultLimit(0)
+
+	if DefaultLimit() != 3 {
+		t.Fatalf("DefaultLimit() = %d, want 3", DefaultLimit())
+	}
+	if got := EffectiveLimit("default-user", 0); got != 3 {
+		t.Fatalf("EffectiveLimit with no fallback = %d, want the default 3", got)
+	}
+	if got := EffectiveLimit("default-user", 7); got != 7 {
+		t.Fatalf("a positive fallback should win over the default, got %d", got)
+	}
+	for i := 1; i <= 3; i++ {
+		if !RateLimit("default-user", 0) {
+			t.Fatalf("request %d should be allowed under the default limit", i)
+		}
+	}
+	if RateLimit("default-user", 0) {
+		t.Fatal("request 4 should be denied by the default limit of 3")
+	}
+
+	SetUserLimit("configured-user", 5)
+	if got := EffectiveLimit("configured-user", 0); got != 5 {
+		t.Fatalf("a configured limit should win over the default, got %d", got)
+	}
+}
+
+func TestDefaultLimit_UnsetFallsBackToPolicy(t *testing.T) {
+	resetLimiterState()
+	SetDefaultLimit(3)
+	SetDefaultLimit(-1)
+
+	if DefaultLimit() != 0 {
+		t.Fatal("a non-positive default should remove it")
+	}
+	SetZeroLimitPolicy(AllowAll)
+	defer SetZeroLimitPolicy(DenyAll)
+	for i := 0; i < 10; i++ {
+		if !RateLimit("no-default", 0) {
+			t.Fatal("without a default, limit 0 should follow the AllowAll policy")
+		}
+	}
+}